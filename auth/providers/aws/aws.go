@@ -0,0 +1,265 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package aws authenticates the presigned sts:GetCallerIdentity tokens that
+// commands/login mints for EKS, so non-EKS clusters can use IAM identities.
+// The token is validated structurally, then sent to STS with the configured
+// cluster id in the signed x-k8s-aws-id header; STS only answers when the
+// signature covers that exact header, so tokens cannot be replayed against
+// another cluster. The reported ARN is mapped to a username and groups
+// through a mapping file in aws-iam-authenticator's config format.
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/util/httpclient"
+
+	"github.com/pkg/errors"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+const (
+	OrgType = "aws"
+
+	v1Prefix        = "k8s-aws-v1."
+	clusterIDHeader = "x-k8s-aws-id"
+	// STS caps presigned URL validity at 15 minutes
+	maxExpires  = 15 * 60
+	allowedSkew = 5 * time.Minute
+	stsTimeout  = 10 * time.Second
+)
+
+func init() {
+	auth.SupportedOrgs = append(auth.SupportedOrgs, OrgType)
+}
+
+// stsHostPattern matches the public STS endpoints: global, regional, FIPS
+// and China partition hosts.
+var stsHostPattern = regexp.MustCompile(`^sts(-fips)?(\.[a-z0-9-]+)?\.amazonaws\.com(\.cn)?$`)
+
+// signedQueryParams are the only query parameters a presigned
+// GetCallerIdentity URL may carry.
+var signedQueryParams = map[string]bool{
+	"Action":               true,
+	"Version":              true,
+	"X-Amz-Algorithm":      true,
+	"X-Amz-Credential":     true,
+	"X-Amz-Date":           true,
+	"X-Amz-Expires":        true,
+	"X-Amz-Security-Token": true,
+	"X-Amz-Signature":      true,
+	"X-Amz-SignedHeaders":  true,
+}
+
+type Authenticator struct {
+	opts    Options
+	mapping *mapping
+	client  *http.Client
+	// stsEndpoint is the parsed aws.sts-endpoint override, nil when the
+	// public endpoints are accepted
+	stsEndpoint *url.URL
+}
+
+func New(opts Options) (auth.Interface, error) {
+	m, err := loadMapping(opts.MappingFile)
+	if err != nil {
+		return nil, err
+	}
+	client, err := httpclient.NewClient(opts.Proxy, opts.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	a := &Authenticator{
+		opts:    opts,
+		mapping: m,
+		client:  client,
+	}
+	if opts.STSEndpoint != "" {
+		a.stsEndpoint, err = url.Parse(opts.STSEndpoint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse aws.sts-endpoint %s", opts.STSEndpoint)
+		}
+	}
+	return a, nil
+}
+
+func (a Authenticator) UID() string {
+	return OrgType
+}
+
+func (a Authenticator) Check(ctx context.Context, token string) (*authv1.UserInfo, error) {
+	presignedURL, err := a.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := a.callSTS(ctx, presignedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	username, groups, err := a.mapping.Map(*id)
+	if err != nil {
+		return nil, err
+	}
+	return &authv1.UserInfo{
+		Username: username,
+		Groups:   groups,
+		UID:      id.UserID,
+		Extra: map[string]authv1.ExtraValue{
+			"arn": {id.CanonicalARN},
+		},
+	}, nil
+}
+
+// parseToken decodes the token and checks the presigned URL is a plausible
+// GetCallerIdentity call before anything is sent to it.
+func (a Authenticator) parseToken(token string) (*url.URL, error) {
+	if !strings.HasPrefix(token, v1Prefix) {
+		return nil, errors.Errorf("token is missing the %s prefix", v1Prefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, v1Prefix))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode token")
+	}
+
+	u, err := url.Parse(string(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "token does not hold a valid URL")
+	}
+	if a.stsEndpoint != nil {
+		if u.Scheme != a.stsEndpoint.Scheme || u.Host != a.stsEndpoint.Host {
+			return nil, errors.Errorf("token is not presigned for the configured STS endpoint, got %s://%s", u.Scheme, u.Host)
+		}
+	} else {
+		if u.Scheme != "https" {
+			return nil, errors.Errorf("token URL scheme is %s, expected https", u.Scheme)
+		}
+		if !stsHostPattern.MatchString(u.Host) {
+			return nil, errors.Errorf("%s is not an STS host", u.Host)
+		}
+	}
+	if u.Path != "/" {
+		return nil, errors.Errorf("token URL path is %s, expected /", u.Path)
+	}
+
+	q := u.Query()
+	for param := range q {
+		if !signedQueryParams[param] {
+			return nil, errors.Errorf("token URL carries unexpected parameter %s", param)
+		}
+	}
+	if q.Get("Action") != "GetCallerIdentity" {
+		return nil, errors.Errorf("token action is %s, expected GetCallerIdentity", q.Get("Action"))
+	}
+	if q.Get("X-Amz-Algorithm") != "AWS4-HMAC-SHA256" {
+		return nil, errors.Errorf("unexpected signing algorithm %s", q.Get("X-Amz-Algorithm"))
+	}
+
+	signedHeaders := strings.Split(q.Get("X-Amz-SignedHeaders"), ";")
+	clusterIDSigned := false
+	for _, h := range signedHeaders {
+		if h == clusterIDHeader {
+			clusterIDSigned = true
+			break
+		}
+	}
+	if !clusterIDSigned {
+		return nil, errors.Errorf("token signature does not cover the %s header", clusterIDHeader)
+	}
+
+	expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || expires > maxExpires {
+		return nil, errors.Errorf("invalid X-Amz-Expires %s", q.Get("X-Amz-Expires"))
+	}
+	date, err := time.Parse("20060102T150405Z", q.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid X-Amz-Date %s", q.Get("X-Amz-Date"))
+	}
+	now := time.Now()
+	if now.Add(allowedSkew).Before(date) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if now.Add(-allowedSkew).After(date.Add(time.Duration(expires) * time.Second)) {
+		return nil, errors.New("token is expired")
+	}
+
+	return u, nil
+}
+
+type getCallerIdentityResponse struct {
+	XMLName xml.Name `xml:"GetCallerIdentityResponse"`
+	Result  struct {
+		Arn     string `xml:"Arn"`
+		UserID  string `xml:"UserId"`
+		Account string `xml:"Account"`
+	} `xml:"GetCallerIdentityResult"`
+}
+
+// callSTS executes the presigned call with guard's own cluster id in the
+// signed header; a token minted for another cluster fails STS's signature
+// check.
+func (a Authenticator) callSTS(ctx context.Context, presignedURL *url.URL) (*identity, error) {
+	ctx, cancel := context.WithTimeout(ctx, stsTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, presignedURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(clusterIDHeader, a.opts.ClusterID)
+
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to call sts")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read sts response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("sts rejected the token with status %d", resp.StatusCode)
+	}
+
+	ci := getCallerIdentityResponse{}
+	if err := xml.Unmarshal(body, &ci); err != nil {
+		return nil, errors.Wrap(err, "failed to parse sts response")
+	}
+
+	canonicalARN, sessionName, err := canonicalizeARN(ci.Result.Arn)
+	if err != nil {
+		return nil, err
+	}
+	return &identity{
+		CanonicalARN: canonicalARN,
+		AccountID:    ci.Result.Account,
+		SessionName:  sessionName,
+		UserID:       ci.Result.UserID,
+	}, nil
+}
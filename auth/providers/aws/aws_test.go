@@ -0,0 +1,244 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testClusterID = "test-cluster"
+
+	testMapping = `
+mapRoles:
+- rolearn: arn:aws:iam::000000000000:role/KubernetesAdmin
+  username: admin:{{SessionName}}
+  groups:
+  - system:masters
+mapUsers:
+- userarn: arn:aws:iam::000000000000:user/alice
+  username: alice
+  groups:
+  - dev
+`
+)
+
+// newSTSServer fakes STS: the request is accepted when the cluster id header
+// matches, standing in for the signature check real STS performs.
+func newSTSServer(t *testing.T, arn, account, userID string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(clusterIDHeader) != testClusterID {
+			http.Error(w, "signature does not match", http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>%s</Arn>
+    <UserId>%s</UserId>
+    <Account>%s</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`, arn, userID, account)
+	}))
+}
+
+func newTestAuthenticator(t *testing.T, server *httptest.Server) Authenticator {
+	dir, err := ioutil.TempDir("", "aws-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	mappingFile := filepath.Join(dir, "mapping.yaml")
+	if err := ioutil.WriteFile(mappingFile, []byte(testMapping), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.ClusterID = testClusterID
+	opts.MappingFile = mappingFile
+	opts.STSEndpoint = server.URL
+
+	client, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *client.(*Authenticator)
+}
+
+func testToken(host string) string {
+	u := fmt.Sprintf("http://%s/?Action=GetCallerIdentity&Version=2011-06-15&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKID%%2F20060102%%2Fus-east-1%%2Fsts%%2Faws4_request&X-Amz-Date=%s&X-Amz-Expires=60&X-Amz-SignedHeaders=host%%3Bx-k8s-aws-id&X-Amz-Signature=deadbeef",
+		host, time.Now().UTC().Format("20060102T150405Z"))
+	return v1Prefix + base64.RawURLEncoding.EncodeToString([]byte(u))
+}
+
+func TestCheckAssumedRole(t *testing.T) {
+	server := newSTSServer(t, "arn:aws:sts::000000000000:assumed-role/KubernetesAdmin/jane", "000000000000", "AROAEXAMPLE:jane")
+	defer server.Close()
+	a := newTestAuthenticator(t, server)
+
+	resp, err := a.Check(context.Background(), testToken(server.Listener.Addr().String()))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "admin:jane", resp.Username)
+		assert.Equal(t, []string{"system:masters"}, resp.Groups)
+		assert.Equal(t, "AROAEXAMPLE:jane", resp.UID)
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	server := newSTSServer(t, "arn:aws:iam::000000000000:user/alice", "000000000000", "AIDAEXAMPLE")
+	defer server.Close()
+	a := newTestAuthenticator(t, server)
+
+	resp, err := a.Check(context.Background(), testToken(server.Listener.Addr().String()))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "alice", resp.Username)
+		assert.Equal(t, []string{"dev"}, resp.Groups)
+	}
+}
+
+func TestCheckUnmappedARN(t *testing.T) {
+	server := newSTSServer(t, "arn:aws:iam::999999999999:user/mallory", "999999999999", "AIDAEXAMPLE")
+	defer server.Close()
+	a := newTestAuthenticator(t, server)
+
+	_, err := a.Check(context.Background(), testToken(server.Listener.Addr().String()))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "not mapped")
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	server := newSTSServer(t, "", "", "")
+	defer server.Close()
+	a := newTestAuthenticator(t, server)
+	host := server.Listener.Addr().String()
+
+	encode := func(u string) string {
+		return v1Prefix + base64.RawURLEncoding.EncodeToString([]byte(u))
+	}
+	date := time.Now().UTC().Format("20060102T150405Z")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			"missing prefix",
+			base64.RawURLEncoding.EncodeToString([]byte("http://" + host + "/")),
+		},
+		{
+			"wrong host",
+			encode(fmt.Sprintf("http://evil.example.com/?Action=GetCallerIdentity&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=%s&X-Amz-Expires=60&X-Amz-SignedHeaders=host%%3Bx-k8s-aws-id", date)),
+		},
+		{
+			"wrong action",
+			encode(fmt.Sprintf("http://%s/?Action=AssumeRole&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=%s&X-Amz-Expires=60&X-Amz-SignedHeaders=host%%3Bx-k8s-aws-id", host, date)),
+		},
+		{
+			"unexpected parameter",
+			encode(fmt.Sprintf("http://%s/?Action=GetCallerIdentity&Evil=1&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=%s&X-Amz-Expires=60&X-Amz-SignedHeaders=host%%3Bx-k8s-aws-id", host, date)),
+		},
+		{
+			"cluster id header not signed",
+			encode(fmt.Sprintf("http://%s/?Action=GetCallerIdentity&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=%s&X-Amz-Expires=60&X-Amz-SignedHeaders=host", host, date)),
+		},
+		{
+			"expired",
+			encode(fmt.Sprintf("http://%s/?Action=GetCallerIdentity&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=%s&X-Amz-Expires=60&X-Amz-SignedHeaders=host%%3Bx-k8s-aws-id", host, time.Now().UTC().Add(-time.Hour).Format("20060102T150405Z"))),
+		},
+		{
+			"expires too long",
+			encode(fmt.Sprintf("http://%s/?Action=GetCallerIdentity&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Date=%s&X-Amz-Expires=86400&X-Amz-SignedHeaders=host%%3Bx-k8s-aws-id", host, date)),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := a.parseToken(test.token)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSTSHostPattern(t *testing.T) {
+	valid := []string{"sts.amazonaws.com", "sts.us-east-1.amazonaws.com", "sts-fips.us-east-1.amazonaws.com", "sts.cn-north-1.amazonaws.com.cn"}
+	for _, h := range valid {
+		assert.True(t, stsHostPattern.MatchString(h), h)
+	}
+	invalid := []string{"evil.example.com", "sts.amazonaws.com.evil.example.com", "notsts.amazonaws.com"}
+	for _, h := range invalid {
+		assert.False(t, stsHostPattern.MatchString(h), h)
+	}
+}
+
+func TestCanonicalizeARN(t *testing.T) {
+	arn, session, err := canonicalizeARN("arn:aws:sts::000000000000:assumed-role/KubernetesAdmin/jane")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "arn:aws:iam::000000000000:role/KubernetesAdmin", arn)
+		assert.Equal(t, "jane", session)
+	}
+
+	arn, session, err = canonicalizeARN("arn:aws:iam::000000000000:user/alice")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "arn:aws:iam::000000000000:user/alice", arn)
+		assert.Equal(t, "", session)
+	}
+
+	_, _, err = canonicalizeARN("not-an-arn")
+	assert.Error(t, err)
+}
+
+func TestLoadMappingValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-mapping-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "mapping.yaml")
+	if err := ioutil.WriteFile(path, []byte("mapRoles:\n- rolearn: arn:aws:iam::0:role/x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadMapping(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "username")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("unknownField: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadMapping(path)
+	assert.Error(t, err)
+}
+
+func TestMapAccounts(t *testing.T) {
+	m := &mapping{MapAccounts: []string{"123456789012"}}
+	username, groups, err := m.Map(identity{CanonicalARN: "arn:aws:iam::123456789012:user/bob", AccountID: "123456789012"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "arn:aws:iam::123456789012:user/bob", username)
+		assert.Nil(t, groups)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// mapping follows aws-iam-authenticator's config format, so existing mapping
+// files carry over unchanged.
+type mapping struct {
+	// MapRoles maps IAM roles; assumed-role session ARNs are canonicalized
+	// to the role ARN before matching
+	MapRoles []roleMapping `json:"mapRoles,omitempty"`
+	// MapUsers maps IAM users by their exact ARN
+	MapUsers []userMapping `json:"mapUsers,omitempty"`
+	// MapAccounts lists account ids whose identities are accepted with the
+	// canonical ARN as username and no groups
+	MapAccounts []string `json:"mapAccounts,omitempty"`
+}
+
+type roleMapping struct {
+	RoleARN  string   `json:"rolearn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+type userMapping struct {
+	UserARN  string   `json:"userarn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+func loadMapping(mappingFile string) (*mapping, error) {
+	data, err := ioutil.ReadFile(mappingFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load aws mapping file %s", mappingFile)
+	}
+
+	m := mapping{}
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse aws mapping file %s", mappingFile)
+	}
+
+	for i, r := range m.MapRoles {
+		if r.RoleARN == "" || r.Username == "" {
+			return nil, errors.Errorf("aws mapping file %s: mapRoles[%d] needs both rolearn and username", mappingFile, i)
+		}
+	}
+	for i, u := range m.MapUsers {
+		if u.UserARN == "" || u.Username == "" {
+			return nil, errors.Errorf("aws mapping file %s: mapUsers[%d] needs both userarn and username", mappingFile, i)
+		}
+	}
+	return &m, nil
+}
+
+// identity is the caller STS reported, canonicalized for mapping.
+type identity struct {
+	// CanonicalARN has assumed-role session ARNs rewritten to the IAM role
+	// ARN, so roles are mapped regardless of the session
+	CanonicalARN string
+	AccountID    string
+	// SessionName is the assumed-role session name, empty for IAM users
+	SessionName string
+	UserID      string
+}
+
+// Map resolves the identity to a username and groups. The username template
+// may reference {{AccountID}} and {{SessionName}}.
+func (m *mapping) Map(id identity) (string, []string, error) {
+	arn := strings.ToLower(id.CanonicalARN)
+	for _, r := range m.MapRoles {
+		if strings.ToLower(r.RoleARN) == arn {
+			return id.expand(r.Username), id.expandAll(r.Groups), nil
+		}
+	}
+	for _, u := range m.MapUsers {
+		if strings.ToLower(u.UserARN) == arn {
+			return id.expand(u.Username), id.expandAll(u.Groups), nil
+		}
+	}
+	for _, account := range m.MapAccounts {
+		if account == id.AccountID {
+			return id.CanonicalARN, nil, nil
+		}
+	}
+	return "", nil, errors.Errorf("ARN is not mapped: %s", id.CanonicalARN)
+}
+
+func (id identity) expand(tpl string) string {
+	tpl = strings.Replace(tpl, "{{AccountID}}", id.AccountID, -1)
+	tpl = strings.Replace(tpl, "{{SessionName}}", strings.Replace(id.SessionName, "@", "-", -1), -1)
+	return tpl
+}
+
+func (id identity) expandAll(tpls []string) []string {
+	if tpls == nil {
+		return nil
+	}
+	out := make([]string, 0, len(tpls))
+	for _, t := range tpls {
+		out = append(out, id.expand(t))
+	}
+	return out
+}
+
+// canonicalizeARN rewrites an assumed-role session ARN
+// (arn:aws:sts::ACCOUNT:assumed-role/ROLE/SESSION) to the IAM role ARN and
+// returns the session name; other ARNs pass through unchanged.
+func canonicalizeARN(arn string) (string, string, error) {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" {
+		return "", "", errors.Errorf("malformed ARN %s", arn)
+	}
+	partition, service, accountID, resource := parts[1], parts[2], parts[4], parts[5]
+
+	if service == "sts" && strings.HasPrefix(resource, "assumed-role/") {
+		fields := strings.Split(resource, "/")
+		if len(fields) != 3 {
+			return "", "", errors.Errorf("malformed assumed-role ARN %s", arn)
+		}
+		return "arn:" + partition + ":iam::" + accountID + ":role/" + fields[1], fields[2], nil
+	}
+	return arn, "", nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/appscode/go/types"
+	"github.com/appscode/guard/util/httpclient"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type Options struct {
+	// ClusterID is the value clients sign into the x-k8s-aws-id header, so
+	// tokens minted for one cluster cannot be replayed against another
+	ClusterID string
+	// MappingFile maps IAM ARNs to usernames and groups, in
+	// aws-iam-authenticator's config format (mapRoles/mapUsers/mapAccounts)
+	MappingFile string
+	// STSEndpoint overrides the STS endpoint tokens must be presigned for,
+	// for private (VPC) endpoints; keep empty to accept the public
+	// sts.amazonaws.com endpoints
+	STSEndpoint string
+	Proxy       httpclient.ProxyConfig
+	CABundle    httpclient.CABundleConfig
+}
+
+func NewOptions() Options {
+	return Options{}
+}
+
+func (o *Options) Configure() error {
+	return nil
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ClusterID, "aws.cluster-id", o.ClusterID, "Cluster id tokens must be signed for, matched against the x-k8s-aws-id header")
+	fs.StringVar(&o.MappingFile, "aws.mapping-file", o.MappingFile, "Path to the YAML file mapping IAM ARNs to usernames and groups (aws-iam-authenticator config format)")
+	fs.StringVar(&o.STSEndpoint, "aws.sts-endpoint", o.STSEndpoint, "STS endpoint tokens must be presigned for, keep empty to accept the public STS endpoints")
+	o.Proxy.AddFlagsWithPrefix(fs, "aws.")
+	o.CABundle.AddFlagsWithPrefix(fs, "aws.")
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	if o.ClusterID == "" {
+		errs = append(errs, errors.New("aws.cluster-id must be non-empty"))
+	}
+	if o.MappingFile == "" {
+		errs = append(errs, errors.New("aws.mapping-file must be non-empty"))
+	} else if _, err := loadMapping(o.MappingFile); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, o.Proxy.Validate("aws.")...)
+	errs = append(errs, o.CABundle.Validate("aws.")...)
+	return errs
+}
+
+func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
+	container := d.Spec.Template.Spec.Containers[0]
+
+	// create auth secret
+	mapping, err := ioutil.ReadFile(o.MappingFile)
+	if err != nil {
+		return nil, err
+	}
+	authSecret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "guard-aws-auth",
+			Namespace: d.Namespace,
+			Labels:    d.Labels,
+		},
+		Data: map[string][]byte{
+			"mapping.yaml": mapping,
+		},
+	}
+	extraObjs = append(extraObjs, authSecret)
+
+	// mount auth secret into deployment
+	volMount := core.VolumeMount{
+		Name:      authSecret.Name,
+		MountPath: "/etc/guard/auth/aws",
+	}
+	container.VolumeMounts = append(container.VolumeMounts, volMount)
+
+	vol := core.Volume{
+		Name: authSecret.Name,
+		VolumeSource: core.VolumeSource{
+			Secret: &core.SecretVolumeSource{
+				SecretName:  authSecret.Name,
+				DefaultMode: types.Int32P(0444),
+			},
+		},
+	}
+	d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, vol)
+
+	// use auth secret in container[0] args
+	args := container.Args
+	if o.ClusterID != "" {
+		args = append(args, fmt.Sprintf("--aws.cluster-id=%s", o.ClusterID))
+	}
+	args = append(args, "--aws.mapping-file=/etc/guard/auth/aws/mapping.yaml")
+	if o.STSEndpoint != "" {
+		args = append(args, fmt.Sprintf("--aws.sts-endpoint=%s", o.STSEndpoint))
+	}
+
+	container.Args = args
+	d.Spec.Template.Spec.Containers[0] = container
+
+	return extraObjs, nil
+}
@@ -49,6 +49,10 @@ const (
 	OrgType            = "azure"
 	azureUsernameClaim = "upn"
 	azureObjectIDClaim = "oid"
+	azureTenantIDClaim = "tid"
+	// guestUPNMarker is embedded in the userPrincipalName of guest (B2B)
+	// accounts, e.g. jane.doe_example.com#EXT#@tenant.onmicrosoft.com
+	guestUPNMarker = "#EXT#"
 )
 
 func init() {
@@ -81,6 +85,13 @@ func New(opts Options) (auth.Interface, error) {
 		Options: opts,
 		ctx:     context.Background(),
 	}
+	if c.ClientSecretFile != "" {
+		secret, err := ioutil.ReadFile(c.ClientSecretFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read client secret file %s", c.ClientSecretFile)
+		}
+		c.ClientSecret = strings.TrimSpace(string(secret))
+	}
 	authInfoVal, err := getAuthInfo(c.Environment, c.TenantID, getMetadata)
 	if err != nil {
 		return nil, err
@@ -88,6 +99,14 @@ func New(opts Options) (auth.Interface, error) {
 
 	glog.V(3).Infof("Using issuer url: %v", authInfoVal.Issuer)
 
+	httpClient, err := opts.GraphClientCert.NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	// oidc discovery and key fetches use the same client, so they honor the
+	// configured proxy and CA bundle as well
+	c.ctx = oidc.ClientContext(c.ctx, httpClient)
+
 	provider, err := oidc.NewProvider(c.ctx, authInfoVal.Issuer)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create provider for azure")
@@ -97,15 +116,22 @@ func New(opts Options) (auth.Interface, error) {
 
 	switch opts.AuthMode {
 	case ClientCredentialAuthMode:
-		c.graphClient, err = graph.New(c.ClientID, c.ClientSecret, c.TenantID, c.UseGroupUID, authInfoVal.AADEndpoint, authInfoVal.MSGraphHost)
+		if c.ClientAssertionCertFile != "" {
+			c.graphClient, err = graph.NewWithClientCert(c.ClientID, c.ClientAssertionCertFile, c.ClientAssertionKeyFile, c.TenantID, c.UseGroupUID, authInfoVal.AADEndpoint, authInfoVal.MSGraphHost, httpClient)
+		} else {
+			c.graphClient, err = graph.New(c.ClientID, c.ClientSecret, c.TenantID, c.UseGroupUID, authInfoVal.AADEndpoint, authInfoVal.MSGraphHost, httpClient)
+		}
 	case OBOAuthMode:
-		c.graphClient, err = graph.NewWithOBO(c.ClientID, c.ClientSecret, c.TenantID, authInfoVal.AADEndpoint, authInfoVal.MSGraphHost)
+		c.graphClient, err = graph.NewWithOBO(c.ClientID, c.ClientSecret, c.TenantID, authInfoVal.AADEndpoint, authInfoVal.MSGraphHost, httpClient)
 	case AKSAuthMode:
-		c.graphClient, err = graph.NewWithAKS(c.AKSTokenURL, c.TenantID, authInfoVal.MSGraphHost)
+		c.graphClient, err = graph.NewWithAKS(c.AKSTokenURL, c.TenantID, authInfoVal.MSGraphHost, httpClient)
+	case WorkloadIdentityAuthMode:
+		c.graphClient, err = graph.NewWithWorkloadIdentity(c.ClientID, c.FederatedTokenFile, c.TenantID, c.UseGroupUID, authInfoVal.AADEndpoint, authInfoVal.MSGraphHost, httpClient)
 	}
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create ms graph client")
 	}
+	c.graphClient.SetUseTransitiveGroups(opts.ResolveTransitiveGroups)
 	return c, nil
 }
 
@@ -147,8 +173,8 @@ func (s Authenticator) UID() string {
 	return OrgType
 }
 
-func (s Authenticator) Check(token string) (*authv1.UserInfo, error) {
-	idToken, err := s.verifier.Verify(s.ctx, token)
+func (s Authenticator) Check(ctx context.Context, token string) (*authv1.UserInfo, error) {
+	idToken, err := s.verifier.Verify(ctx, token)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to verify token for azure")
 	}
@@ -158,11 +184,34 @@ func (s Authenticator) Check(token string) (*authv1.UserInfo, error) {
 		return nil, errors.Wrap(err, "error parsing claims")
 	}
 
-	resp, err := claims.getUserInfo(azureUsernameClaim, azureObjectIDClaim)
+	// multi-tenant app registrations pass audience checks for any tenant;
+	// the issuing tenant must be validated explicitly
+	if len(s.Options.AllowedTenantIDs) > 0 {
+		tid, err := claims.string(azureTenantIDClaim)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get tid claim")
+		}
+		if !s.Options.tenantAllowed(tid) {
+			return nil, errors.Errorf("token is issued by tenant %s which is not in the allowed tenant list", tid)
+		}
+	}
+
+	resp, err := claims.getUserInfo(s.Options.usernameClaimOrder(), azureObjectIDClaim)
 	if err != nil {
 		return nil, err
 	}
 
+	// guests must be resolved against graph by their mangled UPN; the
+	// normalized address is only known to the home tenant
+	graphUsername := resp.Username
+	if isGuestUPN(resp.Username) {
+		if s.Options.DenyGuestUsers {
+			return nil, errors.Errorf("guest (B2B) user %s is not allowed", resp.Username)
+		}
+		resp.Username = normalizeGuestUPN(resp.Username)
+		resp.Extra["guest"] = authv1.ExtraValue{"true"}
+	}
+
 	if s.Options.ResolveGroupMembershipOnlyOnOverageClaim {
 		groups, skipGraphAPI, err := getGroupsAndCheckOverage(claims)
 		if err != nil {
@@ -173,13 +222,24 @@ func (s Authenticator) Check(token string) (*authv1.UserInfo, error) {
 			return resp, nil
 		}
 	}
-	if err := s.graphClient.RefreshToken(token); err != nil {
+	if err := s.graphClient.RefreshToken(s.ctx, token); err != nil {
 		return nil, err
 	}
-	resp.Groups, err = s.graphClient.GetGroups(resp.Username)
+	groups, err := s.graphClient.GetGroups(graphUsername)
 	if err != nil {
+		if s.Options.AllowPartialGroups && len(groups) > 0 && graph.IsTransient(err) {
+			// graph dropped out mid-resolution; authenticating with the groups
+			// obtained beats locking the whole tenant out, and RBAC can only
+			// come out narrower than with full resolution, never wider
+			glog.Warningf("authenticating %s with %d partially resolved groups: %v", resp.Username, len(groups), err)
+			partialGroupResolutions.Inc()
+			resp.Groups = groups
+			resp.Extra["partial-groups"] = authv1.ExtraValue{"true"}
+			return resp, nil
+		}
 		return nil, errors.Wrap(err, "failed to get groups")
 	}
+	resp.Groups = groups
 	return resp, nil
 }
 
@@ -191,6 +251,9 @@ func (s Authenticator) Check(token string) (*authv1.UserInfo, error) {
 // overage indicator:
 // https://docs.microsoft.com/en-us/azure/active-directory/develop/access-tokens#payload-claims
 //
+// v1.0 tokens carry the _claim_names/_claim_sources pair below; v2.0 tokens
+// emitted through the implicit flow carry a boolean hasgroups claim instead
+//
 // ...
 // "_claim_names": {
 //   "groups": "src1"
@@ -209,6 +272,21 @@ func getGroupsAndCheckOverage(claims claims) ([]string, bool, error) {
 		}
 		return groups, true, nil
 	}
+	// v2.0 tokens signal overage with a hasgroups claim instead of the
+	// _claim_names/_claim_sources pair
+	if hasGroups, ok := claims["hasgroups"]; ok {
+		var overage bool
+		switch v := hasGroups.(type) {
+		case bool:
+			overage = v
+		case string:
+			overage = strings.EqualFold(v, "true")
+		}
+		if overage {
+			// proceed to call graph api
+			return nil, false, nil
+		}
+	}
 	// we will short circuit when overage indicator is not present
 	claimNames, ok := claims["_claim_names"]
 	if !ok {
@@ -254,6 +332,29 @@ func getGroupsAndCheckOverage(claims claims) ([]string, bool, error) {
 	return nil, false, nil
 }
 
+// isGuestUPN reports whether the username is a mangled guest (B2B) UPN.
+func isGuestUPN(upn string) bool {
+	return strings.Contains(strings.ToUpper(upn), guestUPNMarker)
+}
+
+// normalizeGuestUPN recovers the original email address from a mangled guest
+// UPN. The part before #EXT# encodes the email with its last @ replaced by _,
+// so jane.doe_example.com#EXT#@tenant.onmicrosoft.com becomes
+// jane.doe@example.com. The UPN is returned unchanged when it does not look
+// like a guest UPN.
+func normalizeGuestUPN(upn string) string {
+	idx := strings.Index(strings.ToUpper(upn), guestUPNMarker)
+	if idx < 0 {
+		return upn
+	}
+	local := upn[:idx]
+	at := strings.LastIndex(local, "_")
+	if at < 0 {
+		return upn
+	}
+	return local[:at] + "@" + local[at+1:]
+}
+
 func marshalGenericTo(src interface{}, dst interface{}) error {
 	b, err := json.Marshal(src)
 	if err != nil {
@@ -272,25 +373,38 @@ func getClaims(token *oidc.IDToken) (claims, error) {
 	return c, nil
 }
 
-// ReviewFromClaims creates a new TokenReview object from the claims object
-// the claims object
-func (c claims) getUserInfo(usernameClaim, userObjectIDClaim string) (*authv1.UserInfo, error) {
-	username, err := c.string(usernameClaim)
-	if err != nil && err == ErrClaimNotFound {
-		username, err = c.string(userObjectIDClaim)
-	}
-	if err != nil {
+// ReviewFromClaims creates a new TokenReview object from the claims object.
+// The username is taken from the first claim in usernameClaims that is
+// present with a non-empty string value.
+func (c claims) getUserInfo(usernameClaims []string, userObjectIDClaim string) (*authv1.UserInfo, error) {
+	var username string
+	for _, claim := range usernameClaims {
+		v, err := c.string(claim)
 		if err == ErrClaimNotFound {
-			return nil, errors.Errorf("username: %s and objectID: %s claims not found", usernameClaim, userObjectIDClaim)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get username claim %s", claim)
+		}
+		if v == "" {
+			continue
 		}
-		return nil, errors.Wrap(err, "unable to get username claim")
+		username = v
+		break
+	}
+	if username == "" {
+		return nil, errors.Errorf("none of the username claims (%s) found", strings.Join(usernameClaims, ", "))
 	}
 
 	useroid, _ := c.string(userObjectIDClaim)
 
-	return &authv1.UserInfo{
+	info := &authv1.UserInfo{
 		Username: username,
-		Extra:    map[string]authv1.ExtraValue{"oid": {useroid}}}, nil
+		Extra:    map[string]authv1.ExtraValue{"oid": {useroid}}}
+	if auth.PopulateUID() {
+		info.UID = useroid
+	}
+	return info, nil
 }
 
 // String gets a string value from claims given a key. Returns error if
@@ -28,6 +28,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/appscode/guard/auth"
 	"github.com/appscode/guard/auth/providers/azure/graph"
 	"github.com/appscode/pat"
 
@@ -53,6 +54,9 @@ const (
 	emptyGroupOid                  = `{	"aud": "client_id","iss" : "%v","oid": "abc-123d4" }`
 	accessTokenWithOverageClaim    = `{ "aud": "client_id", "iss" : "%v", "upn": "nahid", "_claim_names": {"groups": "src1"}, "_claim_sources": {"src1": {"endpoint": "https://foobar" }} }`
 	accessTokenWithNoGroups        = `{ "aud": "client_id", "iss" : "%v", "oid": "abc-123d4" }`
+	accessTokenWithHasGroups       = `{ "aud": "client_id", "iss" : "%v", "upn": "nahid", "hasgroups": true }`
+	accessTokenWithGuestUpn        = `{ "aud": "client_id", "iss" : "%v", "upn": "jane.doe_example.com#EXT#@tenant.onmicrosoft.com", "oid": "abc-123d4", "groups": [ "1", "2"] }`
+	accessTokenWithTenantID        = `{ "aud": "client_id", "iss" : "%v", "upn": "nahid", "tid": "tenant_id", "groups": [ "1", "2"] }`
 	accessTokenWithoutOverageClaim = `{ "aud": "client_id", "iss" : "%v", "upn": "nahid", "_claim_names": {"foo": "src1"}, "_claim_sources": {"src1": {"endpoint": "https://foobar" }} }`
 	badToken                       = "bad_token"
 )
@@ -317,7 +321,7 @@ func TestCheckAzureAuthenticationSuccess(t *testing.T) {
 				t.Fatalf("Error when signing token. reason: %v", err)
 			}
 
-			resp, err := client.Check(token)
+			resp, err := client.Check(context.Background(), token)
 			assert.Nil(t, err)
 			assertUserInfo(t, resp, test.groupSize, client.UseGroupUID)
 		})
@@ -335,7 +339,7 @@ func TestCheckAzureAuthenticationSuccess(t *testing.T) {
 				t.Fatalf("Error when signing token. reason: %v", err)
 			}
 
-			resp, err := client.Check(token)
+			resp, err := client.Check(context.Background(), token)
 			assert.Nil(t, err)
 			assertUserInfo(t, resp, test.groupSize, client.UseGroupUID)
 		})
@@ -354,6 +358,7 @@ func TestCheckAzureAuthenticationWithOverageCheckOption(t *testing.T) {
 	}{
 		{3, accessToken},
 		{3, accessTokenWithOverageClaim},
+		{3, accessTokenWithHasGroups},
 		{0, accessTokenWithNoGroups},
 		{0, accessTokenWithoutOverageClaim},
 	}
@@ -373,7 +378,7 @@ func TestCheckAzureAuthenticationWithOverageCheckOption(t *testing.T) {
 					t.Fatalf("Error when signing token. reason: %v", err)
 				}
 
-				resp, err := client.Check(token)
+				resp, err := client.Check(context.Background(), token)
 				assert.Nil(t, err)
 				assertUserInfo(t, resp, test.groupSize, client.UseGroupUID)
 			})
@@ -430,13 +435,101 @@ func TestCheckAzureAuthenticationFailed(t *testing.T) {
 				token = test.token
 			}
 
-			resp, err := client.Check(token)
+			resp, err := client.Check(context.Background(), token)
 			assert.NotNil(t, err)
 			assert.Nil(t, resp)
 		})
 	}
 }
 
+func TestCheckTenantAllowlist(t *testing.T) {
+	signKey, err := newRSAKey()
+	if err != nil {
+		t.Fatalf("Error when creating signing key. reason : %v", err)
+	}
+
+	srv, client := getServerAndClient(t, signKey, loginResp, 2, true, false)
+	client.Options.ResolveGroupMembershipOnlyOnOverageClaim = true
+	defer srv.Close()
+
+	token, err := signKey.sign([]byte(fmt.Sprintf(accessTokenWithTenantID, srv.URL)))
+	if err != nil {
+		t.Fatalf("Error when signing token. reason: %v", err)
+	}
+
+	t.Run("tenant in allowlist", func(t *testing.T) {
+		client.Options.AllowedTenantIDs = []string{"other_tenant", "tenant_id"}
+		resp, err := client.Check(context.Background(), token)
+		assert.Nil(t, err)
+		assert.Equal(t, username, resp.Username)
+	})
+
+	t.Run("tenant not in allowlist", func(t *testing.T) {
+		client.Options.AllowedTenantIDs = []string{"other_tenant"}
+		resp, err := client.Check(context.Background(), token)
+		assert.NotNil(t, err)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("missing tid claim with allowlist", func(t *testing.T) {
+		client.Options.AllowedTenantIDs = []string{"tenant_id"}
+		tokenWithoutTid, err := signKey.sign([]byte(fmt.Sprintf(accessToken, srv.URL)))
+		if err != nil {
+			t.Fatalf("Error when signing token. reason: %v", err)
+		}
+		resp, err := client.Check(context.Background(), tokenWithoutTid)
+		assert.NotNil(t, err)
+		assert.Nil(t, resp)
+	})
+}
+
+func TestCheckGuestUser(t *testing.T) {
+	signKey, err := newRSAKey()
+	if err != nil {
+		t.Fatalf("Error when creating signing key. reason : %v", err)
+	}
+
+	srv, client := getServerAndClient(t, signKey, loginResp, 2, true, false)
+	// groups come from the token claim, so graph is not called with the
+	// mangled UPN
+	client.Options.ResolveGroupMembershipOnlyOnOverageClaim = true
+	defer srv.Close()
+
+	token, err := signKey.sign([]byte(fmt.Sprintf(accessTokenWithGuestUpn, srv.URL)))
+	if err != nil {
+		t.Fatalf("Error when signing token. reason: %v", err)
+	}
+
+	t.Run("guest UPN is normalized and flagged", func(t *testing.T) {
+		resp, err := client.Check(context.Background(), token)
+		assert.Nil(t, err)
+		assert.Equal(t, "jane.doe@example.com", resp.Username)
+		assert.Equal(t, authv1.ExtraValue{"true"}, resp.Extra["guest"])
+	})
+
+	t.Run("guest rejected when denied", func(t *testing.T) {
+		client.Options.DenyGuestUsers = true
+		resp, err := client.Check(context.Background(), token)
+		assert.NotNil(t, err)
+		assert.Nil(t, resp)
+	})
+}
+
+func TestNormalizeGuestUPN(t *testing.T) {
+	dataset := []struct {
+		upn      string
+		expected string
+	}{
+		{"jane.doe_example.com#EXT#@tenant.onmicrosoft.com", "jane.doe@example.com"},
+		{"jane_doe_example.com#EXT#@tenant.onmicrosoft.com", "jane_doe@example.com"},
+		{"nahid@appscode.com", "nahid@appscode.com"},
+	}
+
+	for _, test := range dataset {
+		assert.Equal(t, test.expected, normalizeGuestUPN(test.upn))
+	}
+}
+
 var testClaims = claims{
 	"upn":     username,
 	"oid":     objectID,
@@ -451,14 +544,42 @@ func TestReviewFromClaims(t *testing.T) {
 			Extra:    map[string]authv1.ExtraValue{"oid": {"abc-123d4"}},
 		}
 
-		resp, err := testClaims.getUserInfo("upn", "oid")
+		resp, err := testClaims.getUserInfo([]string{"upn", "oid"}, "oid")
 		assert.Nil(t, err)
 		assert.Equal(t, validUserInfo, resp)
 	})
 
+	// uid is set from the oid claim only when enabled
+	t.Run("uid populated when enabled", func(t *testing.T) {
+		auth.SetPopulateUID(true)
+		defer auth.SetPopulateUID(false)
+
+		resp, err := testClaims.getUserInfo([]string{"upn", "oid"}, "oid")
+		assert.Nil(t, err)
+		assert.Equal(t, objectID, resp.UID)
+	})
+
+	// fallback claim used when primary is missing
+	t.Run("fallback claim used when primary is missing", func(t *testing.T) {
+		guestClaims := claims{
+			"preferred_username": username,
+			"oid":                objectID,
+		}
+		resp, err := guestClaims.getUserInfo([]string{"upn", "preferred_username", "oid"}, "oid")
+		assert.Nil(t, err)
+		assert.Equal(t, username, resp.Username)
+	})
+
+	// no username claim present should error
+	t.Run("no username claim present should error", func(t *testing.T) {
+		resp, err := claims{}.getUserInfo([]string{"upn", "oid"}, "oid")
+		assert.NotNil(t, err)
+		assert.Nil(t, resp)
+	})
+
 	// invalid claim should error
 	t.Run("invalid claim should error", func(t *testing.T) {
-		resp, err := testClaims.getUserInfo("bad_upn", "")
+		resp, err := testClaims.getUserInfo([]string{"bad_upn"}, "")
 		assert.NotNil(t, err)
 		assert.Nil(t, resp)
 	})
@@ -18,6 +18,7 @@ package graph
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
 	"net/http"
 
@@ -31,11 +32,15 @@ type aksTokenProvider struct {
 	tenantID string
 }
 
-// NewAKSTokenProvider returns a TokenProvider that implements On-Behalf-Of flow using AKS first party service
-func NewAKSTokenProvider(tokenURL, tenantID string) TokenProvider {
+// NewAKSTokenProvider returns a TokenProvider that implements On-Behalf-Of flow using AKS first party service.
+// A nil httpClient falls back to a default client without client certificates.
+func NewAKSTokenProvider(tokenURL, tenantID string, httpClient *http.Client) TokenProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
 	return &aksTokenProvider{
 		name:     "AKSTokenProvider",
-		client:   &http.Client{},
+		client:   httpClient,
 		tokenURL: tokenURL,
 		tenantID: tenantID,
 	}
@@ -43,7 +48,7 @@ func NewAKSTokenProvider(tokenURL, tenantID string) TokenProvider {
 
 func (u *aksTokenProvider) Name() string { return u.name }
 
-func (u *aksTokenProvider) Acquire(token string) (AuthResponse, error) {
+func (u *aksTokenProvider) Acquire(ctx context.Context, token string) (AuthResponse, error) {
 	var authResp = AuthResponse{}
 	tokenReq := struct {
 		TenantID    string `json:"tenantID,omitempty"`
@@ -57,7 +62,7 @@ func (u *aksTokenProvider) Acquire(token string) (AuthResponse, error) {
 	if err := json.NewEncoder(buf).Encode(tokenReq); err != nil {
 		return authResp, errors.Wrap(err, "failed to decode token request")
 	}
-	req, err := http.NewRequest(http.MethodPost, u.tokenURL, buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.tokenURL, buf)
 	if err != nil {
 		return authResp, errors.Wrap(err, "failed to create request")
 	}
@@ -65,13 +70,13 @@ func (u *aksTokenProvider) Acquire(token string) (AuthResponse, error) {
 
 	resp, err := u.client.Do(req)
 	if err != nil {
-		return authResp, errors.Wrap(err, "failed to send request")
+		return authResp, &NetworkError{Op: "failed to send request", Err: err}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		data, _ := ioutil.ReadAll(resp.Body)
-		return authResp, errors.Errorf("request failed with status code: %d and response: %s", resp.StatusCode, string(data))
+		return authResp, errorFromResponse(req.URL.Path, resp, data)
 	}
 	err = json.NewDecoder(resp.Body).Decode(&authResp)
 	if err != nil {
@@ -17,6 +17,7 @@ limitations under the License.
 package graph
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"testing"
@@ -59,8 +60,8 @@ func TestAKSTokenProvider(t *testing.T) {
 
 		defer stopTestServer(t, s)
 
-		r := NewAKSTokenProvider(s.URL, tenantID)
-		resp, err := r.Acquire(inputAccessToken)
+		r := NewAKSTokenProvider(s.URL, tenantID, nil)
+		resp, err := r.Acquire(context.Background(), inputAccessToken)
 		if err != nil {
 			t.Fatalf("refresh should not return error: %s", err)
 		}
@@ -102,8 +103,8 @@ func TestAKSTokenProvider(t *testing.T) {
 
 		defer stopTestServer(t, s)
 
-		r := NewAKSTokenProvider(s.URL, tenantID)
-		resp, err := r.Acquire(inputAccessToken)
+		r := NewAKSTokenProvider(s.URL, tenantID, nil)
+		resp, err := r.Acquire(context.Background(), inputAccessToken)
 		if err == nil {
 			t.Error("refresh should return error")
 		}
@@ -0,0 +1,173 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/moul/http2curl"
+	"github.com/pkg/errors"
+)
+
+// assertionLifetime bounds the validity of a single client assertion; a fresh
+// one is minted for every token request.
+const assertionLifetime = 10 * time.Minute
+
+type clientAssertionTokenProvider struct {
+	name     string
+	client   *http.Client
+	clientID string
+	scope    string
+	loginURL string
+
+	key *rsa.PrivateKey
+	// base64url SHA-1 of the certificate DER, carried in the x5t header so
+	// AAD can pick the matching registered certificate
+	thumbprint string
+}
+
+// NewClientAssertionTokenProvider returns a TokenProvider that authenticates
+// the confidential client with a certificate instead of a client secret. Each
+// token request carries a short-lived JWT signed with the certificate key.
+// https://docs.microsoft.com/en-us/azure/active-directory/develop/active-directory-certificate-credentials
+// keyFile may be empty when the PEM certFile also contains the private key.
+// A nil httpClient falls back to a default client without client certificates.
+func NewClientAssertionTokenProvider(clientID, certFile, keyFile, loginURL, scope string, httpClient *http.Client) (TokenProvider, error) {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load client assertion certificate")
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("client assertion certificate must use an RSA private key")
+	}
+	sum := sha1.Sum(pair.Certificate[0])
+
+	return &clientAssertionTokenProvider{
+		name:       "ClientAssertionTokenProvider",
+		client:     httpClient,
+		clientID:   clientID,
+		scope:      scope,
+		loginURL:   loginURL,
+		key:        key,
+		thumbprint: base64.RawURLEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+func (u *clientAssertionTokenProvider) Name() string { return u.name }
+
+// assertion mints the signed JWT presented as client_assertion.
+func (u *clientAssertionTokenProvider) assertion() (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", errors.Wrap(err, "failed to generate assertion ID")
+	}
+
+	now := time.Now()
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": u.thumbprint,
+	}
+	claims := map[string]interface{}{
+		"aud": u.loginURL,
+		"iss": u.clientID,
+		"sub": u.clientID,
+		"jti": hex.EncodeToString(jti),
+		"nbf": now.Unix(),
+		"exp": now.Add(assertionLifetime).Unix(),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode assertion header")
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode assertion claims")
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, u.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign assertion")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func (u *clientAssertionTokenProvider) Acquire(ctx context.Context, token string) (AuthResponse, error) {
+	var authResp = AuthResponse{}
+	assertion, err := u.assertion()
+	if err != nil {
+		return authResp, err
+	}
+
+	form := url.Values{}
+	form.Set("client_id", u.clientID)
+	form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	form.Set("client_assertion", assertion)
+	form.Set("scope", u.scope)
+	form.Set("grant_type", "client_credentials")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.loginURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return authResp, errors.Wrap(err, "fail to create request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if glog.V(10) {
+		cmd, _ := http2curl.GetCurlCommand(req)
+		glog.V(10).Infoln(cmd)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return authResp, &NetworkError{Op: "fail to send request", Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return authResp, errorFromResponse(req.URL.Path, resp, data)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&authResp)
+	if err != nil {
+		return authResp, errors.Wrapf(err, "failed to decode response for request %s", req.URL.Path)
+	}
+
+	return authResp, nil
+}
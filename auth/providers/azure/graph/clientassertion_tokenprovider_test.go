@@ -0,0 +1,168 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed RSA certificate and its key into
+// a single PEM file and returns the file path, the key and a cleanup func.
+func writeTestCertificate(t *testing.T) (string, *rsa.PrivateKey, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "guard-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "guard-client-assertion")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	certFile := filepath.Join(dir, "client.pem")
+	pemData := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+	if err := ioutil.WriteFile(certFile, pemData, 0600); err != nil {
+		t.Fatalf("failed to write cert file: %s", err)
+	}
+	return certFile, key, func() { os.RemoveAll(dir) }
+}
+
+func TestClientAssertionTokenProvider(t *testing.T) {
+	const (
+		clientID              = "fakeID"
+		scope                 = "https://graph.microsoft.com/.default"
+		tokenResponse         = `{"token_type":"Bearer","expires_in":3599,"access_token":"%s"}`
+		accessToken           = "assertedAccessToken"
+		expectedAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	)
+
+	certFile, key, cleanup := writeTestCertificate(t)
+	defer cleanup()
+
+	t.Run("Upon Success Response", func(t *testing.T) {
+		s := startTestServer(t, func(rw http.ResponseWriter, req *http.Request) {
+			if req.FormValue("client_id") != clientID {
+				t.Errorf("expected client_id: %s, actual: %s", clientID, req.FormValue("client_id"))
+			}
+			if req.FormValue("grant_type") != "client_credentials" {
+				t.Errorf("expected grant_type: client_credentials, actual: %s", req.FormValue("grant_type"))
+			}
+			if req.FormValue("client_assertion_type") != expectedAssertionType {
+				t.Errorf("expected client_assertion_type: %s, actual: %s", expectedAssertionType, req.FormValue("client_assertion_type"))
+			}
+			if req.FormValue("client_secret") != "" {
+				t.Error("client_secret should not be sent")
+			}
+
+			// the assertion must verify against the certificate key
+			assertion := req.FormValue("client_assertion")
+			parts := strings.Split(assertion, ".")
+			if len(parts) != 3 {
+				t.Fatalf("expected a compact JWT, got %q", assertion)
+			}
+			hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+			if err != nil {
+				t.Fatalf("failed to decode signature: %s", err)
+			}
+			if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hashed[:], sig); err != nil {
+				t.Errorf("assertion signature should verify: %s", err)
+			}
+			claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+			if err != nil {
+				t.Fatalf("failed to decode claims: %s", err)
+			}
+			var claims map[string]interface{}
+			if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+				t.Fatalf("failed to unmarshal claims: %s", err)
+			}
+			if claims["iss"] != clientID || claims["sub"] != clientID {
+				t.Errorf("expected iss and sub to be %s, got iss: %v, sub: %v", clientID, claims["iss"], claims["sub"])
+			}
+
+			_, _ = rw.Write([]byte(fmt.Sprintf(tokenResponse, accessToken)))
+		})
+		defer stopTestServer(t, s)
+
+		r, err := NewClientAssertionTokenProvider(clientID, certFile, "", s.URL, scope, nil)
+		if err != nil {
+			t.Fatalf("provider creation should not return error: %s", err)
+		}
+		resp, err := r.Acquire(context.Background(), "")
+		if err != nil {
+			t.Fatalf("refresh should not return error: %s", err)
+		}
+		if resp.Token != accessToken {
+			t.Errorf("returned token '%s' doesn't match expected '%s'", resp.Token, accessToken)
+		}
+	})
+
+	t.Run("Upon Error Response", func(t *testing.T) {
+		s := startTestServer(t, func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"invalid_client"}`))
+		})
+		defer stopTestServer(t, s)
+
+		r, err := NewClientAssertionTokenProvider(clientID, certFile, "", s.URL, scope, nil)
+		if err != nil {
+			t.Fatalf("provider creation should not return error: %s", err)
+		}
+		resp, err := r.Acquire(context.Background(), "")
+		if err == nil {
+			t.Error("refresh should return error")
+		}
+		if resp.Token != "" {
+			t.Errorf("returned token '%s' should be empty", resp.Token)
+		}
+	})
+
+	t.Run("Missing certificate file", func(t *testing.T) {
+		_, err := NewClientAssertionTokenProvider(clientID, "/does/not/exist.pem", "", "http://localhost", scope, nil)
+		if err == nil {
+			t.Error("provider creation should return error")
+		}
+	})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package graph
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -38,10 +39,14 @@ type clientCredentialTokenProvider struct {
 
 // NewClientCredentialTokenProvider returns a TokenProvider that implements OAuth client credential flow on Azure Active Directory
 // https://docs.microsoft.com/en-us/azure/active-directory/develop/v2-oauth2-client-creds-grant-flow#get-a-token
-func NewClientCredentialTokenProvider(clientID, clientSecret, loginURL, scope string) TokenProvider {
+// A nil httpClient falls back to a default client without client certificates.
+func NewClientCredentialTokenProvider(clientID, clientSecret, loginURL, scope string, httpClient *http.Client) TokenProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
 	return &clientCredentialTokenProvider{
 		name:         "ClientCredentialTokenProvider",
-		client:       &http.Client{},
+		client:       httpClient,
 		clientID:     clientID,
 		clientSecret: clientSecret,
 		scope:        scope,
@@ -51,7 +56,7 @@ func NewClientCredentialTokenProvider(clientID, clientSecret, loginURL, scope st
 
 func (u *clientCredentialTokenProvider) Name() string { return u.name }
 
-func (u *clientCredentialTokenProvider) Acquire(token string) (AuthResponse, error) {
+func (u *clientCredentialTokenProvider) Acquire(ctx context.Context, token string) (AuthResponse, error) {
 	var authResp = AuthResponse{}
 	form := url.Values{}
 	form.Set("client_id", u.clientID)
@@ -59,7 +64,7 @@ func (u *clientCredentialTokenProvider) Acquire(token string) (AuthResponse, err
 	form.Set("scope", u.scope)
 	form.Set("grant_type", "client_credentials")
 
-	req, err := http.NewRequest(http.MethodPost, u.loginURL, strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.loginURL, strings.NewReader(form.Encode()))
 	if err != nil {
 		return authResp, errors.Wrap(err, "fail to create request")
 	}
@@ -71,13 +76,13 @@ func (u *clientCredentialTokenProvider) Acquire(token string) (AuthResponse, err
 
 	resp, err := u.client.Do(req)
 	if err != nil {
-		return authResp, errors.Wrap(err, "fail to send request")
+		return authResp, &NetworkError{Op: "fail to send request", Err: err}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		data, _ := ioutil.ReadAll(resp.Body)
-		return authResp, errors.Errorf("request %s failed with status code: %d and response: %s", req.URL.Path, resp.StatusCode, string(data))
+		return authResp, errorFromResponse(req.URL.Path, resp, data)
 	}
 	err = json.NewDecoder(resp.Body).Decode(&authResp)
 	if err != nil {
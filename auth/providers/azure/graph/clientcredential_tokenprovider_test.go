@@ -17,6 +17,7 @@ limitations under the License.
 package graph
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"testing"
@@ -60,8 +61,8 @@ func TestClientCredentialTokenProvider(t *testing.T) {
 
 		defer stopTestServer(t, s)
 
-		r := NewClientCredentialTokenProvider(clientID, clientSecret, s.URL, scope)
-		resp, err := r.Acquire(inputAccessToken)
+		r := NewClientCredentialTokenProvider(clientID, clientSecret, s.URL, scope, nil)
+		resp, err := r.Acquire(context.Background(), inputAccessToken)
 		if err != nil {
 			t.Fatalf("refresh should not return error: %s", err)
 		}
@@ -101,8 +102,8 @@ func TestClientCredentialTokenProvider(t *testing.T) {
 
 		defer stopTestServer(t, s)
 
-		r := NewClientCredentialTokenProvider(clientID, clientSecret, s.URL, scope)
-		resp, err := r.Acquire(inputAccessToken)
+		r := NewClientCredentialTokenProvider(clientID, clientSecret, s.URL, scope, nil)
+		resp, err := r.Acquire(context.Background(), inputAccessToken)
 		if err == nil {
 			t.Error("refresh should return error")
 		}
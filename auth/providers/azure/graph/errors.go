@@ -0,0 +1,162 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// AADSTS error codes that indicate a consent or permission problem with the
+// guard application registration rather than a bad user token.
+var consentErrorCodes = map[string]bool{
+	"50105": true, // user is not assigned to a role for the application
+	"65001": true, // user or administrator has not consented
+	"65004": true, // user declined to consent
+	"90094": true, // grant requires admin permission
+}
+
+var aadstsCodeRegexp = regexp.MustCompile(`AADSTS(\d+)`)
+
+// ThrottledError reports that AAD or MS Graph throttled the request.
+// RetryAfter is zero when the service did not send a Retry-After header.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("request throttled by directory service, retry after %s", e.RetryAfter)
+	}
+	return "request throttled by directory service"
+}
+
+// ConsentError reports an AADSTS consent or permission failure. These are
+// configuration problems with the guard application registration and cannot
+// be fixed by retrying.
+type ConsentError struct {
+	Code    string
+	Message string
+}
+
+func (e *ConsentError) Error() string {
+	return fmt.Sprintf("directory service denied consent or permission (AADSTS%s): %s", e.Code, e.Message)
+}
+
+// NetworkError reports a transport level failure reaching AAD or MS Graph.
+type NetworkError struct {
+	Op  string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Op, e.Err.Error())
+}
+
+func (e *NetworkError) Cause() error { return e.Err }
+
+// errorFromResponse converts a non-200 AAD or MS Graph response into a typed
+// error when the failure class is recognized, so callers can map throttling
+// and consent problems to distinct failure reasons.
+func errorFromResponse(requestPath string, resp *http.Response, body []byte) error {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &ThrottledError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if match := aadstsCodeRegexp.FindSubmatch(body); match != nil && consentErrorCodes[string(match[1])] {
+		return &ConsentError{Code: string(match[1]), Message: string(body)}
+	}
+	// MS Graph reports missing application permissions with this code
+	if resp.StatusCode == http.StatusForbidden {
+		return &ConsentError{Code: "0", Message: string(body)}
+	}
+	return fmt.Errorf("request %s failed with status code: %d and response: %s", requestPath, resp.StatusCode, string(body))
+}
+
+// parseRetryAfter parses a Retry-After header, either delay seconds or an
+// HTTP date. It returns 0 when the header is absent or malformed.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+type causer interface {
+	Cause() error
+}
+
+// IsThrottled reports whether any error in the cause chain is a ThrottledError.
+func IsThrottled(err error) bool {
+	for err != nil {
+		if _, ok := err.(*ThrottledError); ok {
+			return true
+		}
+		cause, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
+}
+
+// IsConsentError reports whether any error in the cause chain is a ConsentError.
+func IsConsentError(err error) bool {
+	for err != nil {
+		if _, ok := err.(*ConsentError); ok {
+			return true
+		}
+		cause, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
+}
+
+// IsTransient reports whether the error is a throttling or network failure
+// that may succeed on retry, as opposed to a consent or token problem.
+func IsTransient(err error) bool {
+	return IsThrottled(err) || IsNetworkError(err)
+}
+
+// IsNetworkError reports whether any error in the cause chain is a NetworkError.
+func IsNetworkError(err error) bool {
+	for err != nil {
+		if _, ok := err.(*NetworkError); ok {
+			return true
+		}
+		cause, ok := err.(causer)
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestErrorFromResponse(t *testing.T) {
+	t.Run("throttled response", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": []string{"7"}},
+		}
+		err := errorFromResponse("/token", resp, nil)
+		throttled, ok := err.(*ThrottledError)
+		if !ok {
+			t.Fatalf("expected *ThrottledError, actual %T", err)
+		}
+		if throttled.RetryAfter != 7*time.Second {
+			t.Errorf("expected retry after 7s, actual %s", throttled.RetryAfter)
+		}
+	})
+
+	t.Run("consent error", func(t *testing.T) {
+		body := []byte(`{"error":"invalid_grant","error_description":"AADSTS65001: The user or administrator has not consented to use the application."}`)
+		resp := &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}}
+		err := errorFromResponse("/token", resp, body)
+		consent, ok := err.(*ConsentError)
+		if !ok {
+			t.Fatalf("expected *ConsentError, actual %T", err)
+		}
+		if consent.Code != "65001" {
+			t.Errorf("expected code 65001, actual %s", consent.Code)
+		}
+	})
+
+	t.Run("forbidden graph response", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+		err := errorFromResponse("/users", resp, []byte(`{"error":{"code":"Authorization_RequestDenied"}}`))
+		if _, ok := err.(*ConsentError); !ok {
+			t.Fatalf("expected *ConsentError, actual %T", err)
+		}
+	})
+
+	t.Run("generic failure", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}}
+		err := errorFromResponse("/users", resp, []byte("boom"))
+		if IsThrottled(err) || IsConsentError(err) || IsNetworkError(err) {
+			t.Errorf("expected untyped error, actual %v", err)
+		}
+	})
+}
+
+func TestErrorClassifiersFollowCauseChain(t *testing.T) {
+	throttled := errors.Wrap(&ThrottledError{}, "failed to get groups")
+	if !IsThrottled(throttled) {
+		t.Error("expected wrapped ThrottledError to be detected")
+	}
+	network := errors.Wrap(&NetworkError{Op: "error listing users", Err: errors.New("connection refused")}, "failed to get groups")
+	if !IsNetworkError(network) {
+		t.Error("expected wrapped NetworkError to be detected")
+	}
+	if IsConsentError(network) {
+		t.Error("expected NetworkError to not be a ConsentError")
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/moul/http2curl"
+	"github.com/pkg/errors"
+)
+
+type federatedTokenProvider struct {
+	name      string
+	client    *http.Client
+	clientID  string
+	scope     string
+	loginURL  string
+	tokenFile string
+}
+
+// NewFederatedTokenProvider returns a TokenProvider that exchanges the
+// projected Kubernetes service account token for an AAD token via a federated
+// credential (Azure Workload Identity), so no client secret or certificate is
+// needed. The token file is re-read on every request because kubelet rotates
+// the projected token.
+// https://azure.github.io/azure-workload-identity/docs/
+// A nil httpClient falls back to a default client without client certificates.
+func NewFederatedTokenProvider(clientID, tokenFile, loginURL, scope string, httpClient *http.Client) TokenProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &federatedTokenProvider{
+		name:      "FederatedTokenProvider",
+		client:    httpClient,
+		clientID:  clientID,
+		scope:     scope,
+		loginURL:  loginURL,
+		tokenFile: tokenFile,
+	}
+}
+
+func (u *federatedTokenProvider) Name() string { return u.name }
+
+func (u *federatedTokenProvider) Acquire(ctx context.Context, token string) (AuthResponse, error) {
+	var authResp = AuthResponse{}
+	assertion, err := ioutil.ReadFile(u.tokenFile)
+	if err != nil {
+		return authResp, errors.Wrap(err, "failed to read federated token file")
+	}
+	saToken := strings.TrimSpace(string(assertion))
+	if saToken == "" {
+		return authResp, errors.Errorf("federated token file %s is empty", u.tokenFile)
+	}
+
+	form := url.Values{}
+	form.Set("client_id", u.clientID)
+	form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	form.Set("client_assertion", saToken)
+	form.Set("scope", u.scope)
+	form.Set("grant_type", "client_credentials")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.loginURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return authResp, errors.Wrap(err, "fail to create request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if glog.V(10) {
+		cmd, _ := http2curl.GetCurlCommand(req)
+		glog.V(10).Infoln(cmd)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return authResp, &NetworkError{Op: "fail to send request", Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return authResp, errorFromResponse(req.URL.Path, resp, data)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&authResp)
+	if err != nil {
+		return authResp, errors.Wrapf(err, "failed to decode response for request %s", req.URL.Path)
+	}
+
+	return authResp, nil
+}
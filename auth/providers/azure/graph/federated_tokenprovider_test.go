@@ -0,0 +1,130 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFederatedTokenProvider(t *testing.T) {
+	const (
+		clientID              = "fakeID"
+		scope                 = "https://graph.microsoft.com/.default"
+		tokenResponse         = `{"token_type":"Bearer","expires_in":3599,"access_token":"%s"}`
+		accessToken           = "federatedAccessToken"
+		saToken               = "projected-service-account-token"
+		rotatedSAToken        = "rotated-service-account-token"
+		expectedAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	)
+
+	dir, err := ioutil.TempDir("", "guard-federated-token")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte(saToken+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %s", err)
+	}
+
+	t.Run("Upon Success Response", func(t *testing.T) {
+		expectedAssertion := saToken
+		s := startTestServer(t, func(rw http.ResponseWriter, req *http.Request) {
+			if req.FormValue("client_id") != clientID {
+				t.Errorf("expected client_id: %s, actual: %s", clientID, req.FormValue("client_id"))
+			}
+			if req.FormValue("client_assertion_type") != expectedAssertionType {
+				t.Errorf("expected client_assertion_type: %s, actual: %s", expectedAssertionType, req.FormValue("client_assertion_type"))
+			}
+			if req.FormValue("client_assertion") != expectedAssertion {
+				t.Errorf("expected client_assertion: %s, actual: %s", expectedAssertion, req.FormValue("client_assertion"))
+			}
+			if req.FormValue("client_secret") != "" {
+				t.Error("client_secret should not be sent")
+			}
+			if req.FormValue("grant_type") != "client_credentials" {
+				t.Errorf("expected grant_type: client_credentials, actual: %s", req.FormValue("grant_type"))
+			}
+			_, _ = rw.Write([]byte(fmt.Sprintf(tokenResponse, accessToken)))
+		})
+		defer stopTestServer(t, s)
+
+		r := NewFederatedTokenProvider(clientID, tokenFile, s.URL, scope, nil)
+		resp, err := r.Acquire(context.Background(), "")
+		if err != nil {
+			t.Fatalf("refresh should not return error: %s", err)
+		}
+		if resp.Token != accessToken {
+			t.Errorf("returned token '%s' doesn't match expected '%s'", resp.Token, accessToken)
+		}
+
+		// kubelet rotates the projected token; the provider must pick up the
+		// new content on the next request
+		if err := ioutil.WriteFile(tokenFile, []byte(rotatedSAToken), 0600); err != nil {
+			t.Fatalf("failed to rotate token file: %s", err)
+		}
+		expectedAssertion = rotatedSAToken
+		if _, err := r.Acquire(context.Background(), ""); err != nil {
+			t.Fatalf("refresh after rotation should not return error: %s", err)
+		}
+		// restore for other subtests
+		if err := ioutil.WriteFile(tokenFile, []byte(saToken+"\n"), 0600); err != nil {
+			t.Fatalf("failed to restore token file: %s", err)
+		}
+	})
+
+	t.Run("Upon Error Response", func(t *testing.T) {
+		s := startTestServer(t, func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusBadRequest)
+			_, _ = rw.Write([]byte(`{"error":"invalid_client"}`))
+		})
+		defer stopTestServer(t, s)
+
+		r := NewFederatedTokenProvider(clientID, tokenFile, s.URL, scope, nil)
+		resp, err := r.Acquire(context.Background(), "")
+		if err == nil {
+			t.Error("refresh should return error")
+		}
+		if resp.Token != "" {
+			t.Errorf("returned token '%s' should be empty", resp.Token)
+		}
+	})
+
+	t.Run("Missing token file", func(t *testing.T) {
+		r := NewFederatedTokenProvider(clientID, filepath.Join(dir, "does-not-exist"), "http://localhost", scope, nil)
+		if _, err := r.Acquire(context.Background(), ""); err == nil {
+			t.Error("refresh should return error")
+		}
+	})
+
+	t.Run("Empty token file", func(t *testing.T) {
+		emptyFile := filepath.Join(dir, "empty")
+		if err := ioutil.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+			t.Fatalf("failed to write token file: %s", err)
+		}
+		r := NewFederatedTokenProvider(clientID, emptyFile, "http://localhost", scope, nil)
+		if _, err := r.Acquire(context.Background(), ""); err == nil {
+			t.Error("refresh should return error")
+		}
+	})
+}
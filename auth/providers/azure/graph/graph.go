@@ -18,6 +18,7 @@ package graph
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -51,10 +52,19 @@ type UserInfo struct {
 	// These allow us to mock out the URL for testing
 	apiURL *url.URL
 
-	groupsPerCall int
-	useGroupUID   bool
+	groupsPerCall       int
+	useGroupUID         bool
+	useTransitiveGroups bool
 
 	tokenProvider TokenProvider
+	refreshGroup  flightGroup
+}
+
+// SetUseTransitiveGroups toggles group resolution through the
+// /transitiveMemberOf endpoint, which includes groups a user is only a
+// member of through nesting.
+func (u *UserInfo) SetUseTransitiveGroups(useTransitiveGroups bool) {
+	u.useTransitiveGroups = useTransitiveGroups
 }
 
 func (u *UserInfo) getGroupIDs(userPrincipal string) ([]string, error) {
@@ -79,13 +89,13 @@ func (u *UserInfo) getGroupIDs(userPrincipal string) ([]string, error) {
 
 	resp, err := u.client.Do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "error listing users")
+		return nil, &NetworkError{Op: "error listing users", Err: err}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		data, _ := ioutil.ReadAll(resp.Body)
-		return nil, errors.Errorf("request %s failed with status code: %d and response: %s", req.URL.Path, resp.StatusCode, string(data))
+		return nil, errorFromResponse(req.URL.Path, resp, data)
 	}
 
 	// Decode the group response
@@ -97,6 +107,64 @@ func (u *UserInfo) getGroupIDs(userPrincipal string) ([]string, error) {
 	return objects.Value, nil
 }
 
+// getTransitiveGroups returns all groups the user belongs to, directly or
+// through nesting. The endpoint returns full group objects, so no separate
+// expansion call is needed; pages are followed via @odata.nextLink. When a
+// later page fails, the groups of the pages already fetched are returned
+// alongside the error.
+func (u *UserInfo) getTransitiveGroups(userPrincipal string) ([]string, error) {
+	// Shallow copy of the base API URL
+	memberOfURL := *u.apiURL
+	memberOfURL.Path = path.Join(memberOfURL.Path, fmt.Sprintf("/users/%s/transitiveMemberOf/microsoft.graph.group", userPrincipal))
+	q := memberOfURL.Query()
+	q.Set("$select", "id,displayName")
+	memberOfURL.RawQuery = q.Encode()
+
+	var groups []string
+	nextURL := memberOfURL.String()
+	for nextURL != "" {
+		req, err := http.NewRequest(http.MethodGet, nextURL, nil)
+		if err != nil {
+			return groups, errors.Wrap(err, "error creating transitive group request")
+		}
+		// Set the auth headers for the request
+		req.Header = u.headers
+
+		if glog.V(10) {
+			cmd, _ := http2curl.GetCurlCommand(req)
+			glog.V(10).Infoln(cmd)
+		}
+
+		resp, err := u.client.Do(req)
+		if err != nil {
+			return groups, &NetworkError{Op: "error listing transitive groups", Err: err}
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			data, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return groups, errorFromResponse(req.URL.Path, resp, data)
+		}
+
+		// Decode the page of groups
+		var page = GroupMembershipList{}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return groups, errors.Wrapf(err, "failed to decode response for request %s", req.URL.Path)
+		}
+		for _, group := range page.Value {
+			if u.useGroupUID {
+				groups = append(groups, group.ID)
+			} else {
+				groups = append(groups, group.Name)
+			}
+		}
+		nextURL = page.NextLink
+	}
+	return groups, nil
+}
+
 func (u *UserInfo) getExpandedGroups(ids []string) (*GroupList, error) {
 	// Encode the ids into the request body
 	body := &bytes.Buffer{}
@@ -127,13 +195,13 @@ func (u *UserInfo) getExpandedGroups(ids []string) (*GroupList, error) {
 
 	resp, err := u.client.Do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "error expanding groups")
+		return nil, &NetworkError{Op: "error expanding groups", Err: err}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		data, _ := ioutil.ReadAll(resp.Body)
-		return nil, errors.Errorf("request %s failed with status code: %d and response: %s", req.URL.Path, resp.StatusCode, string(data))
+		return nil, errorFromResponse(req.URL.Path, resp, data)
 	}
 
 	// Decode the response
@@ -145,23 +213,42 @@ func (u *UserInfo) getExpandedGroups(ids []string) (*GroupList, error) {
 	return groups, nil
 }
 
-func (u *UserInfo) RefreshToken(token string) error {
-	resp, err := u.tokenProvider.Acquire(token)
-	if err != nil {
-		return errors.Errorf("%s: failed to refresh token: %s", u.tokenProvider.Name(), err)
-	}
+// RefreshToken acquires a fresh graph token. Concurrent refreshes for the
+// same incoming token are collapsed into a single call to the token
+// provider, so a burst of requests hitting an expired token does not fan
+// out into parallel acquisitions.
+func (u *UserInfo) RefreshToken(ctx context.Context, token string) error {
+	return u.refreshGroup.Do(token, func() error {
+		resp, err := u.tokenProvider.Acquire(ctx, token)
+		if err != nil {
+			// keep typed throttling/consent/network errors intact, so callers
+			// can map them to distinct failure reasons
+			switch err.(type) {
+			case *ThrottledError, *ConsentError, *NetworkError:
+				return err
+			}
+			return errors.Errorf("%s: failed to refresh token: %s", u.tokenProvider.Name(), err)
+		}
 
-	// Set the authorization headers for future requests
-	u.headers.Set("Authorization", fmt.Sprintf("Bearer %s", resp.Token))
-	expIn := time.Duration(resp.Expires) * time.Second
-	u.expires = time.Now().Add(expIn - expiryDelta)
+		// Set the authorization headers for future requests
+		u.headers.Set("Authorization", fmt.Sprintf("Bearer %s", resp.Token))
+		expIn := time.Duration(resp.Expires) * time.Second
+		u.expires = time.Now().Add(expIn - expiryDelta)
 
-	return nil
+		return nil
+	})
 }
 
 // GetGroups gets a list of all groups that the given user principal is part of
-// Generally in federated directories the email address is the userPrincipalName
+// Generally in federated directories the email address is the userPrincipalName.
+// When resolution fails midway (throttling during pagination or expansion),
+// the groups resolved so far are returned alongside the error, so callers can
+// choose to degrade instead of failing the whole authentication.
 func (u *UserInfo) GetGroups(userPrincipal string) ([]string, error) {
+	if u.useTransitiveGroups {
+		return u.getTransitiveGroups(userPrincipal)
+	}
+
 	// Get the group IDs for the user
 	groupIDs, err := u.getGroupIDs(userPrincipal)
 	if err != nil {
@@ -184,7 +271,7 @@ func (u *UserInfo) GetGroups(userPrincipal string) ([]string, error) {
 		// Expand the group IDs
 		groups, err := u.getExpandedGroups(groupIDs[startIndex:endIndex])
 		if err != nil {
-			return nil, err
+			return groupNames, err
 		}
 		// Extract out the Group objects into a list of strings
 		for i := 0; i < len(groups.Value); i++ {
@@ -208,9 +295,12 @@ func (u *UserInfo) Name() string {
 }
 
 // newUserInfo returns a UserInfo object
-func newUserInfo(tokenProvider TokenProvider, graphURL *url.URL, useGroupUID bool) (*UserInfo, error) {
+func newUserInfo(tokenProvider TokenProvider, graphURL *url.URL, useGroupUID bool, httpClient *http.Client) (*UserInfo, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
 	u := &UserInfo{
-		client: http.DefaultClient,
+		client: httpClient,
 		headers: http.Header{
 			"Content-Type": []string{"application/json"},
 		},
@@ -223,38 +313,71 @@ func newUserInfo(tokenProvider TokenProvider, graphURL *url.URL, useGroupUID boo
 	return u, nil
 }
 
-// New returns a new UserInfo object
-func New(clientID, clientSecret, tenantID string, useGroupUID bool, aadEndpoint, msgraphHost string) (*UserInfo, error) {
+// New returns a new UserInfo object. The given http client is used for both
+// graph and login calls, so guard can present a client certificate to private
+// Graph/AAD endpoints; pass nil for the default client.
+func New(clientID, clientSecret, tenantID string, useGroupUID bool, aadEndpoint, msgraphHost string, httpClient *http.Client) (*UserInfo, error) {
 	graphEndpoint := "https://" + msgraphHost + "/"
 	graphURL, _ := url.Parse(graphEndpoint + "v1.0")
 
 	tokenProvider := NewClientCredentialTokenProvider(clientID, clientSecret,
 		fmt.Sprintf("%s%s/oauth2/v2.0/token", aadEndpoint, tenantID),
-		fmt.Sprintf("https://%s/.default", msgraphHost))
+		fmt.Sprintf("https://%s/.default", msgraphHost), httpClient)
+
+	return newUserInfo(tokenProvider, graphURL, useGroupUID, httpClient)
+}
+
+// NewWithClientCert returns a new UserInfo object whose confidential client
+// authenticates with a certificate-signed client assertion instead of a
+// client secret.
+func NewWithClientCert(clientID, certFile, keyFile, tenantID string, useGroupUID bool, aadEndpoint, msgraphHost string, httpClient *http.Client) (*UserInfo, error) {
+	graphEndpoint := "https://" + msgraphHost + "/"
+	graphURL, _ := url.Parse(graphEndpoint + "v1.0")
+
+	tokenProvider, err := NewClientAssertionTokenProvider(clientID, certFile, keyFile,
+		fmt.Sprintf("%s%s/oauth2/v2.0/token", aadEndpoint, tenantID),
+		fmt.Sprintf("https://%s/.default", msgraphHost), httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUserInfo(tokenProvider, graphURL, useGroupUID, httpClient)
+}
+
+// NewWithWorkloadIdentity returns a new UserInfo object whose confidential
+// client exchanges the projected service account token for graph tokens via a
+// federated credential (Azure Workload Identity).
+func NewWithWorkloadIdentity(clientID, tokenFile, tenantID string, useGroupUID bool, aadEndpoint, msgraphHost string, httpClient *http.Client) (*UserInfo, error) {
+	graphEndpoint := "https://" + msgraphHost + "/"
+	graphURL, _ := url.Parse(graphEndpoint + "v1.0")
+
+	tokenProvider := NewFederatedTokenProvider(clientID, tokenFile,
+		fmt.Sprintf("%s%s/oauth2/v2.0/token", aadEndpoint, tenantID),
+		fmt.Sprintf("https://%s/.default", msgraphHost), httpClient)
 
-	return newUserInfo(tokenProvider, graphURL, useGroupUID)
+	return newUserInfo(tokenProvider, graphURL, useGroupUID, httpClient)
 }
 
 // NewWithOBO returns a new UserInfo object
-func NewWithOBO(clientID, clientSecret, tenantID string, aadEndpoint, msgraphHost string) (*UserInfo, error) {
+func NewWithOBO(clientID, clientSecret, tenantID string, aadEndpoint, msgraphHost string, httpClient *http.Client) (*UserInfo, error) {
 	graphEndpoint := "https://" + msgraphHost + "/"
 	graphURL, _ := url.Parse(graphEndpoint + "v1.0")
 
 	tokenProvider := NewOBOTokenProvider(clientID, clientSecret,
 		fmt.Sprintf("%s%s/oauth2/v2.0/token", aadEndpoint, tenantID),
-		fmt.Sprintf("https://%s/.default", msgraphHost))
+		fmt.Sprintf("https://%s/.default", msgraphHost), httpClient)
 
-	return newUserInfo(tokenProvider, graphURL, true)
+	return newUserInfo(tokenProvider, graphURL, true, httpClient)
 }
 
 // NewWithAKS returns a new UserInfo object used in AKS
-func NewWithAKS(tokenURL, tenantID, msgraphHost string) (*UserInfo, error) {
+func NewWithAKS(tokenURL, tenantID, msgraphHost string, httpClient *http.Client) (*UserInfo, error) {
 	graphEndpoint := "https://" + msgraphHost + "/"
 	graphURL, _ := url.Parse(graphEndpoint + "v1.0")
 
-	tokenProvider := NewAKSTokenProvider(tokenURL, tenantID)
+	tokenProvider := NewAKSTokenProvider(tokenURL, tenantID, httpClient)
 
-	return newUserInfo(tokenProvider, graphURL, true)
+	return newUserInfo(tokenProvider, graphURL, true, httpClient)
 }
 
 func TestUserInfo(clientID, clientSecret, loginUrl, apiUrl string, useGroupUID bool) (*UserInfo, error) {
@@ -271,7 +394,7 @@ func TestUserInfo(clientID, clientSecret, loginUrl, apiUrl string, useGroupUID b
 		groupsPerCall: expandedGroupsPerCall,
 		useGroupUID:   useGroupUID,
 	}
-	u.tokenProvider = NewClientCredentialTokenProvider(clientID, clientSecret, loginUrl, "")
+	u.tokenProvider = NewClientCredentialTokenProvider(clientID, clientSecret, loginUrl, "", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -17,10 +17,13 @@ limitations under the License.
 package graph
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -35,7 +38,7 @@ func getAuthServerAndUserInfo(returnCode int, body, clientID, clientSecret strin
 		headers:       http.Header{},
 		groupsPerCall: expandedGroupsPerCall,
 	}
-	u.tokenProvider = NewClientCredentialTokenProvider(clientID, clientSecret, ts.URL, "")
+	u.tokenProvider = NewClientCredentialTokenProvider(clientID, clientSecret, ts.URL, "", nil)
 	return ts, u
 }
 
@@ -50,7 +53,7 @@ func TestLogin(t *testing.T) {
 		ts, u := getAuthServerAndUserInfo(http.StatusOK, fmt.Sprintf(validBody, validToken), "jason", "bourne")
 		defer ts.Close()
 
-		err := u.RefreshToken("")
+		err := u.RefreshToken(context.Background(), "")
 		if err != nil {
 			t.Errorf("Error when trying to log in: %s", err)
 		}
@@ -66,7 +69,7 @@ func TestLogin(t *testing.T) {
 		ts, u := getAuthServerAndUserInfo(http.StatusUnauthorized, "Unauthorized", "CIA", "treadstone")
 		defer ts.Close()
 
-		err := u.RefreshToken("")
+		err := u.RefreshToken(context.Background(), "")
 		if err == nil {
 			t.Error("Should have gotten error")
 		}
@@ -79,9 +82,9 @@ func TestLogin(t *testing.T) {
 			headers:       http.Header{},
 			groupsPerCall: expandedGroupsPerCall,
 		}
-		u.tokenProvider = NewClientCredentialTokenProvider("CIA", "outcome", badURL, "")
+		u.tokenProvider = NewClientCredentialTokenProvider("CIA", "outcome", badURL, "", nil)
 
-		err := u.RefreshToken("")
+		err := u.RefreshToken(context.Background(), "")
 		if err == nil {
 			t.Error("Should have gotten error")
 		}
@@ -91,13 +94,61 @@ func TestLogin(t *testing.T) {
 		ts, u := getAuthServerAndUserInfo(http.StatusOK, "{bad_json", "CIA", "treadstone")
 		defer ts.Close()
 
-		err := u.RefreshToken("")
+		err := u.RefreshToken(context.Background(), "")
 		if err == nil {
 			t.Error("Should have gotten error")
 		}
 	})
 }
 
+func TestRefreshTokenSingleFlight(t *testing.T) {
+	const workers = 5
+
+	var tokenCalls int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenCalls, 1)
+		// hold the first request open so the other callers pile up behind it
+		<-release
+		_, _ = w.Write([]byte(`{"token_type":"Bearer","expires_in":3599,"access_token":"shared"}`))
+	}))
+	defer ts.Close()
+
+	u := &UserInfo{
+		client:        http.DefaultClient,
+		headers:       http.Header{},
+		groupsPerCall: expandedGroupsPerCall,
+	}
+	u.tokenProvider = NewClientCredentialTokenProvider("jason", "bourne", ts.URL, "", nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- u.RefreshToken(context.Background(), "sameUserToken")
+		}()
+	}
+	// give the callers time to reach the in-flight refresh before finishing it
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("refresh should not return error: %s", err)
+		}
+	}
+	if got := atomic.LoadInt32(&tokenCalls); got != 1 {
+		t.Errorf("expected concurrent refreshes to collapse into 1 token call, actual: %d", got)
+	}
+	if u.headers.Get("Authorization") != "Bearer shared" {
+		t.Errorf("Authorization header should carry the shared token. Got: %q", u.headers.Get("Authorization"))
+	}
+}
+
 func getAPIServerAndUserInfo(returnCode int, body string) (*httptest.Server, *UserInfo) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(returnCode)
@@ -312,6 +363,78 @@ func TestGetGroups(t *testing.T) {
 	}
 }
 
+func TestGetTransitiveGroups(t *testing.T) {
+	var page2 = `{
+	"value": [
+		{
+		    "id": "f16ec2c5-fa5t-4f05-b87f-deadbeef",
+		    "displayName": "Treadstone.Assets.Nested"
+		}
+	]
+}`
+
+	mux := http.NewServeMux()
+	mux.Handle("/users/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		if r.URL.Query().Get("page") == "2" {
+			_, _ = w.Write([]byte(page2))
+			return
+		}
+		// first page links to the second one
+		page1 := fmt.Sprintf(`{
+	"@odata.nextLink": "http://%s%s?page=2",
+	"value": [
+		{
+		    "id": "f36ec2c5-fa5t-4f05-b87f-deadbeef",
+		    "displayName": "Treadstone.Assets.All"
+		}
+	]
+}`, r.Host, r.URL.Path)
+		_, _ = w.Write([]byte(page1))
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	apiURL, _ := url.Parse(ts.URL)
+
+	u := &UserInfo{
+		client:              http.DefaultClient,
+		apiURL:              apiURL,
+		headers:             http.Header{},
+		expires:             time.Now().Add(time.Hour),
+		groupsPerCall:       expandedGroupsPerCall,
+		useTransitiveGroups: true,
+	}
+
+	groups, err := u.GetGroups("blackbriar@cia.gov")
+	if err != nil {
+		t.Errorf("Should not have gotten error: %s", err)
+	}
+	if len(groups) != 2 {
+		t.Errorf("Should have gotten a list of groups with 2 entries. Got: %d", len(groups))
+	}
+	if len(groups) == 2 && groups[1] != "Treadstone.Assets.Nested" {
+		t.Errorf("Should have gotten the nested group name. Got: %s", groups[1])
+	}
+
+	uWithGroupID := &UserInfo{
+		client:              http.DefaultClient,
+		apiURL:              apiURL,
+		headers:             http.Header{},
+		expires:             time.Now().Add(time.Hour),
+		groupsPerCall:       expandedGroupsPerCall,
+		useGroupUID:         true,
+		useTransitiveGroups: true,
+	}
+
+	groups, err = uWithGroupID.GetGroups("blackbriar@cia.gov")
+	if err != nil {
+		t.Errorf("Should not have gotten error: %s", err)
+	}
+	if len(groups) == 2 && groups[0] != "f36ec2c5-fa5t-4f05-b87f-deadbeef" {
+		t.Errorf("Should have gotten group IDs in the list. Got: %s", groups[0])
+	}
+}
+
 func TestGetGroupsPaging(t *testing.T) {
 	var validBody1 = `
 {
@@ -384,3 +507,104 @@ func TestGetGroupsPaging(t *testing.T) {
 		t.Errorf("Should have gotten a list of groups with 3 entries. Got: %d", len(groups))
 	}
 }
+
+func TestGetGroupsPartialOnThrottle(t *testing.T) {
+	var validBody1 = `
+{
+    "value": [
+		"f36ec2c5-fa5t-4f05-b87f-deadbeef",
+		"f26ec2c5-fa5t-4f05-b87f-deadbeef",
+		"f16ec2c5-fa5t-4f05-b87f-deadbeef"
+    ]
+}`
+	var validBody2 = `{
+	"value": [
+		{
+		    "@odata.type": "#microsoft.graph.group",
+		    "displayName": "Treadstone.Assets.All"
+		},
+		{
+		    "@odata.type": "#microsoft.graph.group",
+		    "displayName": "Treadstone.Assets.Finance"
+		}
+	]
+}`
+
+	mux := http.NewServeMux()
+	mux.Handle("/users/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(validBody1))
+	}))
+	expansionCalls := 0
+	mux.Handle("/directoryObjects/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expansionCalls++
+		// the second expansion batch gets throttled mid-resolution
+		if expansionCalls > 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(validBody2))
+	}))
+	ts := httptest.NewServer(mux)
+	apiURL, _ := url.Parse(ts.URL)
+
+	u := &UserInfo{
+		client:        http.DefaultClient,
+		apiURL:        apiURL,
+		headers:       http.Header{},
+		expires:       time.Now().Add(time.Hour),
+		groupsPerCall: 2,
+	}
+	defer ts.Close()
+
+	groups, err := u.GetGroups("blackbriar@cia.gov")
+	if !IsThrottled(err) {
+		t.Errorf("Should have gotten a throttled error. Got: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Errorf("Should have gotten the 2 groups of the first batch alongside the error. Got: %d", len(groups))
+	}
+}
+
+func TestGetTransitiveGroupsPartialOnThrottle(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/users/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the second page gets throttled mid-pagination
+		if r.URL.Query().Get("page") == "2" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(200)
+		page1 := fmt.Sprintf(`{
+	"@odata.nextLink": "http://%s%s?page=2",
+	"value": [
+		{
+		    "id": "f36ec2c5-fa5t-4f05-b87f-deadbeef",
+		    "displayName": "Treadstone.Assets.All"
+		}
+	]
+}`, r.Host, r.URL.Path)
+		_, _ = w.Write([]byte(page1))
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	apiURL, _ := url.Parse(ts.URL)
+
+	u := &UserInfo{
+		client:              http.DefaultClient,
+		apiURL:              apiURL,
+		headers:             http.Header{},
+		expires:             time.Now().Add(time.Hour),
+		groupsPerCall:       expandedGroupsPerCall,
+		useTransitiveGroups: true,
+	}
+
+	groups, err := u.GetGroups("blackbriar@cia.gov")
+	if !IsThrottled(err) {
+		t.Errorf("Should have gotten a throttled error. Got: %v", err)
+	}
+	if len(groups) != 1 {
+		t.Errorf("Should have gotten the group of the first page alongside the error. Got: %d", len(groups))
+	}
+}
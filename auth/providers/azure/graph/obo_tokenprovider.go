@@ -17,6 +17,7 @@ limitations under the License.
 package graph
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -38,10 +39,13 @@ type oboTokenProvider struct {
 
 // NewOBOTokenProvider returns a TokenProvider that implements OAuth On-Behalf-Of flow on Azure Active Directory
 // https://docs.microsoft.com/en-us/azure/active-directory/develop/v2-oauth2-on-behalf-of-flow
-func NewOBOTokenProvider(clientID, clientSecret, loginURL, scope string) TokenProvider {
+func NewOBOTokenProvider(clientID, clientSecret, loginURL, scope string, httpClient *http.Client) TokenProvider {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
 	return &oboTokenProvider{
 		name:         "OBOTokenProvider",
-		client:       &http.Client{},
+		client:       httpClient,
 		clientID:     clientID,
 		clientSecret: clientSecret,
 		scope:        scope,
@@ -51,7 +55,7 @@ func NewOBOTokenProvider(clientID, clientSecret, loginURL, scope string) TokenPr
 
 func (u *oboTokenProvider) Name() string { return u.name }
 
-func (u *oboTokenProvider) Acquire(token string) (AuthResponse, error) {
+func (u *oboTokenProvider) Acquire(ctx context.Context, token string) (AuthResponse, error) {
 	var authResp = AuthResponse{}
 	form := url.Values{}
 	form.Set("client_id", u.clientID)
@@ -61,7 +65,7 @@ func (u *oboTokenProvider) Acquire(token string) (AuthResponse, error) {
 	form.Set("scope", u.scope)
 	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
 
-	req, err := http.NewRequest(http.MethodPost, u.loginURL, strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.loginURL, strings.NewReader(form.Encode()))
 	if err != nil {
 		return authResp, errors.Wrap(err, "failed to create request")
 	}
@@ -73,13 +77,13 @@ func (u *oboTokenProvider) Acquire(token string) (AuthResponse, error) {
 
 	resp, err := u.client.Do(req)
 	if err != nil {
-		return authResp, errors.Wrap(err, "failed to send request")
+		return authResp, &NetworkError{Op: "failed to send request", Err: err}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		data, _ := ioutil.ReadAll(resp.Body)
-		return authResp, errors.Errorf("request %s failed with status code: %d and response: %s", req.URL.Path, resp.StatusCode, string(data))
+		return authResp, errorFromResponse(req.URL.Path, resp, data)
 	}
 	err = json.NewDecoder(resp.Body).Decode(&authResp)
 	if err != nil {
@@ -17,6 +17,7 @@ limitations under the License.
 package graph
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -68,8 +69,8 @@ func TestOBOTokenProvider(t *testing.T) {
 
 		defer stopTestServer(t, s)
 
-		r := NewOBOTokenProvider(clientID, clientSecret, s.URL, scope)
-		resp, err := r.Acquire(inputAccessToken)
+		r := NewOBOTokenProvider(clientID, clientSecret, s.URL, scope, nil)
+		resp, err := r.Acquire(context.Background(), inputAccessToken)
 		if err != nil {
 			t.Fatalf("refresh should not return error: %s", err)
 		}
@@ -115,8 +116,8 @@ func TestOBOTokenProvider(t *testing.T) {
 
 		defer stopTestServer(t, s)
 
-		r := NewOBOTokenProvider(clientID, clientSecret, s.URL, scope)
-		resp, err := r.Acquire(inputAccessToken)
+		r := NewOBOTokenProvider(clientID, clientSecret, s.URL, scope, nil)
+		resp, err := r.Acquire(context.Background(), inputAccessToken)
 		if err == nil {
 			t.Error("refresh should return error")
 		}
@@ -16,8 +16,12 @@ limitations under the License.
 
 package graph
 
-// TokenProvider is an interface to obtain token for MS Graph api
+import "context"
+
+// TokenProvider is an interface to obtain token for MS Graph api. The
+// context carries the deadline of the request that triggered the
+// acquisition.
 type TokenProvider interface {
 	Name() string
-	Acquire(token string) (AuthResponse, error)
+	Acquire(ctx context.Context, token string) (AuthResponse, error)
 }
@@ -37,6 +37,13 @@ type ObjectQuery struct {
 	Types []string `json:"types"`
 }
 
+// GroupMembershipList represents a page of group objects returned from the
+// transitiveMemberOf endpoint, with a link to the next page when present
+type GroupMembershipList struct {
+	NextLink string  `json:"@odata.nextLink"`
+	Value    []Group `json:"value"`
+}
+
 // GroupList represents a list of groups returned from the MS Graph API
 type GroupList struct {
 	Value []Group `json:"value"`
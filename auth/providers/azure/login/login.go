@@ -0,0 +1,242 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package login implements the kubectl credential plugin side of the azure
+// provider: it acquires AAD tokens through the device code flow, caches the
+// refresh token, and emits ExecCredential objects for kubectl.
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultClientID is the Azure CLI public client application; its
+	// configuration permits the device code flow in any tenant.
+	DefaultClientID = "04b07795-8ddb-461a-bbee-02f9e1bf7b46"
+	// DefaultScope requests tokens for the AKS AAD server application, the
+	// audience AKS and arc clusters validate.
+	DefaultScope = "6dae42f8-4368-4678-94ff-3960e28e3630/.default"
+
+	loginBaseURL = "https://login.microsoftonline.com"
+	// offlineAccessScope must be requested or AAD returns no refresh token.
+	offlineAccessScope = "offline_access"
+)
+
+type Options struct {
+	TenantID string
+	ClientID string
+	Scope    string
+	// CacheDir holds the refresh token cache; empty disables caching
+	CacheDir string
+}
+
+type tokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	RefreshToken     string      `json:"refresh_token"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+type deviceCodeResponse struct {
+	DeviceCode      string      `json:"device_code"`
+	UserCode        string      `json:"user_code"`
+	VerificationURI string      `json:"verification_uri"`
+	ExpiresIn       json.Number `json:"expires_in"`
+	Interval        json.Number `json:"interval"`
+	Message         string      `json:"message"`
+	Error           string      `json:"error"`
+}
+
+// Get returns an AAD access token and its expiry for the configured tenant.
+// A cached refresh token is tried first, so repeated kubectl invocations do
+// not prompt; only when that is missing or rejected does the interactive
+// device code flow run.
+func Get(opts Options) (string, time.Time, error) {
+	if opts.TenantID == "" {
+		return "", time.Time{}, errors.New("tenant id must be non-empty")
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = DefaultClientID
+	}
+	if opts.Scope == "" {
+		opts.Scope = DefaultScope
+	}
+	if !strings.Contains(opts.Scope, offlineAccessScope) {
+		opts.Scope = opts.Scope + " " + offlineAccessScope
+	}
+
+	if refreshToken := loadRefreshToken(opts); refreshToken != "" {
+		token, expiry, err := redeemRefreshToken(opts, refreshToken)
+		if err == nil {
+			return token, expiry, nil
+		}
+		glog.V(3).Infof("cached refresh token rejected, falling back to device code flow: %v", err)
+	}
+
+	return deviceCodeFlow(opts)
+}
+
+func tokenURL(opts Options) string {
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", loginBaseURL, opts.TenantID)
+}
+
+func postForm(endpoint string, form url.Values) (*tokenResponse, error) {
+	resp, err := http.PostForm(endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, errors.Wrap(err, "failed to decode token response")
+	}
+	return &token, nil
+}
+
+// redeemRefreshToken exchanges a refresh token for a fresh access token and
+// rotates the cached refresh token.
+func redeemRefreshToken(opts Options, refreshToken string) (string, time.Time, error) {
+	token, err := postForm(tokenURL(opts), url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {opts.ClientID},
+		"scope":         {opts.Scope},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if token.Error != "" {
+		return "", time.Time{}, errors.Errorf("%s: %s", token.Error, token.ErrorDescription)
+	}
+	saveRefreshToken(opts, token.RefreshToken)
+	return token.AccessToken, expiryOf(token), nil
+}
+
+// deviceCodeFlow prompts the user on stderr to authenticate in a browser and
+// polls the token endpoint until AAD reports completion.
+func deviceCodeFlow(opts Options) (string, time.Time, error) {
+	var code deviceCodeResponse
+	{
+		resp, err := http.PostForm(fmt.Sprintf("%s/%s/oauth2/v2.0/devicecode", loginBaseURL, opts.TenantID), url.Values{
+			"client_id": {opts.ClientID},
+			"scope":     {opts.Scope},
+		})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if err := json.Unmarshal(body, &code); err != nil {
+			return "", time.Time{}, errors.Wrap(err, "failed to decode device code response")
+		}
+		if code.Error != "" || code.DeviceCode == "" {
+			return "", time.Time{}, errors.Errorf("device code request failed: %s", code.Error)
+		}
+	}
+
+	// the ExecCredential protocol reserves stdout for the credential object
+	fmt.Fprintln(os.Stderr, code.Message)
+
+	interval, _ := code.Interval.Int64()
+	if interval <= 0 {
+		interval = 5
+	}
+	expiresIn, _ := code.ExpiresIn.Int64()
+	deadline := time.Now().Add(time.Duration(expiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		token, err := postForm(tokenURL(opts), url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"client_id":   {opts.ClientID},
+			"device_code": {code.DeviceCode},
+		})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		switch token.Error {
+		case "":
+			saveRefreshToken(opts, token.RefreshToken)
+			return token.AccessToken, expiryOf(token), nil
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5
+		default:
+			return "", time.Time{}, errors.Errorf("%s: %s", token.Error, token.ErrorDescription)
+		}
+	}
+	return "", time.Time{}, errors.New("device code expired before the login completed")
+}
+
+func expiryOf(token *tokenResponse) time.Time {
+	expiresIn, _ := token.ExpiresIn.Int64()
+	return time.Now().Add(time.Duration(expiresIn) * time.Second)
+}
+
+// cachePath returns the refresh token cache file, keyed by tenant and client
+// so logins against different tenants do not clobber each other.
+func cachePath(opts Options) string {
+	return filepath.Join(opts.CacheDir, fmt.Sprintf("azure-refresh-token-%s-%s", opts.TenantID, opts.ClientID))
+}
+
+func loadRefreshToken(opts Options) string {
+	if opts.CacheDir == "" {
+		return ""
+	}
+	data, err := ioutil.ReadFile(cachePath(opts))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// saveRefreshToken caches the refresh token with owner-only permissions; a
+// failed write only costs a re-prompt on the next login, so it is logged and
+// otherwise ignored.
+func saveRefreshToken(opts Options, refreshToken string) {
+	if opts.CacheDir == "" || refreshToken == "" {
+		return
+	}
+	if err := os.MkdirAll(opts.CacheDir, 0700); err != nil {
+		glog.Warningf("failed to create token cache dir: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(cachePath(opts), []byte(refreshToken), 0600); err != nil {
+		glog.Warningf("failed to cache refresh token: %v", err)
+	}
+}
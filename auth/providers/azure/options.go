@@ -17,12 +17,19 @@ limitations under the License.
 package azure
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/appscode/go/types"
+	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/auth/providers/azure/graph"
+	"github.com/appscode/guard/util/httpclient"
 
+	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	apps "k8s.io/api/apps/v1"
@@ -35,37 +42,118 @@ const (
 	AKSAuthMode              = "aks"
 	OBOAuthMode              = "obo"
 	ClientCredentialAuthMode = "client-credential"
+	WorkloadIdentityAuthMode = "workload-identity"
 )
 
 type Options struct {
-	Environment                              string
-	ClientID                                 string
-	ClientSecret                             string
+	Environment  string
+	ClientID     string
+	ClientSecret string
+	// ClientSecretFile is the path of a file holding the client secret, e.g.
+	// mounted through the Secrets Store CSI driver; it takes precedence over
+	// azure.client-secret
+	ClientSecretFile                         string
 	TenantID                                 string
 	UseGroupUID                              bool
 	AuthMode                                 string
 	AKSTokenURL                              string
 	ResolveGroupMembershipOnlyOnOverageClaim bool
 	VerifyClientID                           bool
+	// resolve group membership through the transitiveMemberOf graph
+	// endpoint, so groups inherited via nested groups are included
+	ResolveTransitiveGroups bool
+	// reject guest (B2B) accounts instead of normalizing their mangled UPN
+	DenyGuestUsers bool
+	// authenticate users with the groups resolved so far when graph throttles
+	// or drops out mid-resolution, instead of failing the token review
+	AllowPartialGroups bool
+	// certificate credential for the confidential client; when set, token
+	// requests carry a certificate-signed client assertion instead of
+	// azure.client-secret
+	ClientAssertionCertFile string
+	ClientAssertionKeyFile  string
+	// tenant IDs allowed to authenticate; tokens issued by any other tenant
+	// are rejected. Keep empty to skip the check
+	AllowedTenantIDs []string
+	// projected service account token exchanged for AAD tokens in
+	// workload-identity auth mode; no secret or certificate is involved
+	FederatedTokenFile string
+	// claim used as the username, with an ordered list of fallbacks tried
+	// when it is missing or empty. Guest (B2B) accounts carry mangled UPNs,
+	// so such tenants typically prefer preferred_username or email
+	UsernameClaim          string
+	UsernameClaimFallbacks []string
+	// client certificate presented to Graph/AAD endpoints, for private
+	// endpoints that require mutual TLS
+	GraphClientCert httpclient.ClientCertConfig
+	// ExistingSecret is the name of a pre-created Secret holding the key
+	// client-secret (and graph-client.crt/graph-client.key or
+	// client-assertion.crt/client-assertion.key when those credentials are
+	// used); the installer mounts it instead of reading the credential files
+	// and embedding them into the generated manifest
+	ExistingSecret string
+	// SecretsStoreProviderClass is the name of a SecretProviderClass whose
+	// objects include client-secret (and the certificate files above when
+	// used); the installer mounts it through the Secrets Store CSI driver, so
+	// the credentials never exist as a Kubernetes Secret
+	SecretsStoreProviderClass string
 }
 
+// supportedUsernameClaims are the claims the username may be taken from.
+var supportedUsernameClaims = []string{azureUsernameClaim, "preferred_username", "email", azureObjectIDClaim}
+
 func NewOptions() Options {
 	return Options{
-		ClientSecret: os.Getenv("AZURE_CLIENT_SECRET"),
-		UseGroupUID:  true,
+		ClientSecret:           os.Getenv("AZURE_CLIENT_SECRET"),
+		FederatedTokenFile:     os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
+		UseGroupUID:            true,
+		UsernameClaim:          azureUsernameClaim,
+		UsernameClaimFallbacks: []string{azureObjectIDClaim},
+	}
+}
+
+// tenantAllowed reports whether the issuing tenant is in the allowlist.
+func (o Options) tenantAllowed(tid string) bool {
+	for _, t := range o.AllowedTenantIDs {
+		if strings.EqualFold(t, tid) {
+			return true
+		}
 	}
+	return false
+}
+
+// usernameClaimOrder returns the username claim followed by its fallbacks,
+// defaulting to the historical upn -> oid selection when unconfigured.
+func (o Options) usernameClaimOrder() []string {
+	if o.UsernameClaim == "" {
+		return []string{azureUsernameClaim, azureObjectIDClaim}
+	}
+	return append([]string{o.UsernameClaim}, o.UsernameClaimFallbacks...)
 }
 
 func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.Environment, "azure.environment", o.Environment, "Azure cloud environment")
 	fs.StringVar(&o.ClientID, "azure.client-id", o.ClientID, "MS Graph application client ID to use")
 	fs.StringVar(&o.ClientSecret, "azure.client-secret", o.ClientSecret, "MS Graph application client secret to use")
+	fs.StringVar(&o.ClientSecretFile, "azure.client-secret-file", o.ClientSecretFile, "path of a file holding the MS Graph application client secret, e.g. mounted via the Secrets Store CSI driver. Takes precedence over azure.client-secret")
 	fs.StringVar(&o.TenantID, "azure.tenant-id", o.TenantID, "MS Graph application tenant id to use")
 	fs.BoolVar(&o.UseGroupUID, "azure.use-group-uid", o.UseGroupUID, "Use group UID for authentication instead of group display name")
-	fs.StringVar(&o.AuthMode, "azure.auth-mode", "client-credential", "auth mode to call graph api, valid value is either aks, obo, or client-credential")
+	fs.StringVar(&o.AuthMode, "azure.auth-mode", "client-credential", "auth mode to call graph api, valid value is either aks, obo, client-credential, or workload-identity")
+	fs.StringVar(&o.FederatedTokenFile, "azure.federated-token-file", o.FederatedTokenFile, "path of the projected service account token exchanged for AAD tokens in workload-identity auth mode. Defaults to the AZURE_FEDERATED_TOKEN_FILE environment variable set by the workload identity webhook")
 	fs.StringVar(&o.AKSTokenURL, "azure.aks-token-url", "", "url to call for AKS OBO flow")
 	fs.BoolVar(&o.ResolveGroupMembershipOnlyOnOverageClaim, "azure.graph-call-on-overage-claim", o.ResolveGroupMembershipOnlyOnOverageClaim, "set to true to resolve group membership only when overage claim is present. setting to false will always call graph api to resolve group membership")
 	fs.BoolVar(&o.VerifyClientID, "azure.verify-clientID", o.VerifyClientID, "set to true to validate token's audience claim matches clientID")
+	fs.StringSliceVar(&o.AllowedTenantIDs, "azure.allowed-tenant-ids", o.AllowedTenantIDs, "comma separated list of tenant IDs allowed to authenticate. Tokens issued by any other tenant are rejected, which matters for multi-tenant app registrations. Keep empty to skip the check")
+	fs.StringVar(&o.ClientAssertionCertFile, "azure.client-assertion-cert-file", o.ClientAssertionCertFile, "PEM certificate used to sign client assertions instead of using azure.client-secret")
+	fs.StringVar(&o.ClientAssertionKeyFile, "azure.client-assertion-key-file", o.ClientAssertionKeyFile, "PEM private key matching azure.client-assertion-cert-file. If empty, the key is read from the cert file")
+	fs.BoolVar(&o.DenyGuestUsers, "azure.deny-guest-users", o.DenyGuestUsers, "set to true to reject guest (B2B) accounts entirely. setting to false normalizes their mangled UPN to the original email address and flags them with the guest extra attribute")
+	fs.BoolVar(&o.AllowPartialGroups, "azure.allow-partial-groups", o.AllowPartialGroups, "set to true to authenticate users with the groups resolved before graph throttled or dropped out mid-resolution, flagged with the partial-groups extra attribute. setting to false fails authentication instead")
+	fs.BoolVar(&o.ResolveTransitiveGroups, "azure.resolve-transitive-groups", o.ResolveTransitiveGroups, "set to true to resolve group membership through the transitiveMemberOf graph endpoint, so groups inherited via nested groups are included. setting to false resolves direct memberships only")
+	fs.StringVar(&o.UsernameClaim, "azure.username-claim", o.UsernameClaim, "claim used as the username, valid value is either upn, preferred_username, email, or oid")
+	fs.StringSliceVar(&o.UsernameClaimFallbacks, "azure.username-claim-fallbacks", o.UsernameClaimFallbacks, "ordered list of claims tried when the username claim is missing or empty, e.g. for guest (B2B) accounts with mangled UPNs")
+	fs.StringVar(&o.ExistingSecret, "azure.existing-secret", o.ExistingSecret, "Name of a pre-created Secret with key client-secret (and graph-client.crt/graph-client.key or client-assertion.crt/client-assertion.key when used), mounted by the installer instead of embedding the credentials into the generated manifest")
+	fs.StringVar(&o.SecretsStoreProviderClass, "azure.secrets-store-provider-class", o.SecretsStoreProviderClass, "Name of a SecretProviderClass with an object aliased client-secret (and the certificate files when used), mounted by the installer through the Secrets Store CSI driver instead of a Kubernetes Secret")
+	o.GraphClientCert.AddFlagsWithPrefix(fs, "azure.graph-")
 }
 
 func (o *Options) Validate() []error {
@@ -75,15 +163,30 @@ func (o *Options) Validate() []error {
 	case AKSAuthMode:
 	case OBOAuthMode:
 	case ClientCredentialAuthMode:
+	case WorkloadIdentityAuthMode:
 	default:
-		errs = append(errs, errors.New("invalid azure.auth-mode. valid value is either aks, obo, or client-credential"))
+		errs = append(errs, errors.New("invalid azure.auth-mode. valid value is either aks, obo, client-credential, or workload-identity"))
 	}
 
-	if o.AuthMode != AKSAuthMode {
-		if o.ClientSecret == "" {
-			errs = append(errs, errors.New("azure.client-secret must be non-empty"))
+	if o.AuthMode != AKSAuthMode && o.AuthMode != WorkloadIdentityAuthMode {
+		if o.ClientSecret == "" && o.ClientSecretFile == "" && o.ClientAssertionCertFile == "" && o.ExistingSecret == "" && o.SecretsStoreProviderClass == "" {
+			errs = append(errs, errors.New("azure.client-secret must be non-empty when azure.client-assertion-cert-file is not set"))
 		}
 	}
+	if o.ExistingSecret != "" && o.SecretsStoreProviderClass != "" {
+		errs = append(errs, errors.New("azure.existing-secret and azure.secrets-store-provider-class are mutually exclusive"))
+	}
+	if o.AuthMode == WorkloadIdentityAuthMode {
+		if o.FederatedTokenFile == "" {
+			errs = append(errs, errors.New("azure.federated-token-file must be non-empty for workload-identity auth mode"))
+		}
+		if o.ClientID == "" {
+			errs = append(errs, errors.New("azure.client-id must be non-empty for workload-identity auth mode"))
+		}
+	}
+	if o.ClientAssertionKeyFile != "" && o.ClientAssertionCertFile == "" {
+		errs = append(errs, errors.New("azure.client-assertion-key-file must be used with azure.client-assertion-cert-file"))
+	}
 	if o.AuthMode == AKSAuthMode && o.AKSTokenURL == "" {
 		errs = append(errs, errors.New("azure.aks-token-url must be non-empty"))
 	}
@@ -93,55 +196,149 @@ func (o *Options) Validate() []error {
 	if o.VerifyClientID && o.ClientID == "" {
 		errs = append(errs, errors.New("azure.client-id must be non-empty when azure.verify-clientID is set"))
 	}
+	for _, claim := range o.usernameClaimOrder() {
+		supported := false
+		for _, s := range supportedUsernameClaims {
+			if claim == s {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			errs = append(errs, errors.Errorf("invalid username claim %s. valid value is either upn, preferred_username, email, or oid", claim))
+		}
+	}
+	errs = append(errs, o.GraphClientCert.Validate("azure.graph-")...)
 	return errs
 }
 
-func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
-	container := d.Spec.Template.Spec.Containers[0]
+// ValidateCredentials acquires a graph token with the configured confidential
+// client credentials, so a bad secret or certificate is caught before the
+// generated objects hit the cluster. Auth modes whose credentials only exist
+// inside the cluster (aks, obo, workload-identity) are skipped.
+func (o Options) ValidateCredentials() error {
+	if o.AuthMode != ClientCredentialAuthMode {
+		glog.V(3).Infof("skipping credential validation for azure auth mode %s", o.AuthMode)
+		return nil
+	}
 
-	// create auth secret
-	authSecret := &core.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "guard-azure-auth",
-			Namespace: d.Namespace,
-			Labels:    d.Labels,
-		},
-		Data: map[string][]byte{
-			"client-secret": []byte(o.ClientSecret),
-		},
+	authInfoVal, err := getAuthInfo(o.Environment, o.TenantID, getMetadata)
+	if err != nil {
+		return err
+	}
+	httpClient, err := o.GraphClientCert.NewHTTPClient()
+	if err != nil {
+		return err
 	}
-	extraObjs = append(extraObjs, authSecret)
 
-	// mount auth secret into deployment
-	volMount := core.VolumeMount{
-		Name:      authSecret.Name,
-		MountPath: "/etc/guard/auth/azure",
+	loginURL := fmt.Sprintf("%s%s/oauth2/v2.0/token", authInfoVal.AADEndpoint, o.TenantID)
+	scope := fmt.Sprintf("https://%s/.default", authInfoVal.MSGraphHost)
+	var tokenProvider graph.TokenProvider
+	if o.ClientAssertionCertFile != "" {
+		tokenProvider, err = graph.NewClientAssertionTokenProvider(o.ClientID, o.ClientAssertionCertFile, o.ClientAssertionKeyFile, loginURL, scope, httpClient)
+		if err != nil {
+			return err
+		}
+	} else {
+		tokenProvider = graph.NewClientCredentialTokenProvider(o.ClientID, o.ClientSecret, loginURL, scope, httpClient)
 	}
-	container.VolumeMounts = append(container.VolumeMounts, volMount)
 
-	vol := core.Volume{
-		Name: authSecret.Name,
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := tokenProvider.Acquire(ctx, ""); err != nil {
+		return errors.Wrap(err, "failed to acquire token with the configured client credentials")
+	}
+	return nil
+}
+
+// secretVolume builds the Secret-backed auth volume of the Deployment.
+func secretVolume(secretName string) core.Volume {
+	return core.Volume{
+		Name: secretName,
 		VolumeSource: core.VolumeSource{
 			Secret: &core.SecretVolumeSource{
-				SecretName:  authSecret.Name,
+				SecretName:  secretName,
 				DefaultMode: types.Int32P(0555),
 			},
 		},
 	}
+}
+
+func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
+	container := d.Spec.Template.Spec.Containers[0]
+
+	var vol core.Volume
+	if o.SecretsStoreProviderClass != "" {
+		// the CSI driver pulls the credentials from the external vault at pod
+		// start; the client secret is consumed as a mounted file instead of
+		// the AZURE_CLIENT_SECRET environment variable
+		vol = auth.SecretsStoreVolume("guard-azure-auth", o.SecretsStoreProviderClass)
+	} else if o.ExistingSecret != "" {
+		vol = secretVolume(o.ExistingSecret)
+	} else {
+		// create auth secret
+		authSecret := &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "guard-azure-auth",
+				Namespace: d.Namespace,
+				Labels:    d.Labels,
+			},
+			Data: map[string][]byte{
+				"client-secret": []byte(o.ClientSecret),
+			},
+		}
+		if o.GraphClientCert.Enabled() {
+			cert, err := ioutil.ReadFile(o.GraphClientCert.CertFile)
+			if err != nil {
+				return nil, err
+			}
+			key, err := ioutil.ReadFile(o.GraphClientCert.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			authSecret.Data["graph-client.crt"] = cert
+			authSecret.Data["graph-client.key"] = key
+		}
+		if o.ClientAssertionCertFile != "" {
+			cert, err := ioutil.ReadFile(o.ClientAssertionCertFile)
+			if err != nil {
+				return nil, err
+			}
+			authSecret.Data["client-assertion.crt"] = cert
+			if o.ClientAssertionKeyFile != "" {
+				key, err := ioutil.ReadFile(o.ClientAssertionKeyFile)
+				if err != nil {
+					return nil, err
+				}
+				authSecret.Data["client-assertion.key"] = key
+			}
+		}
+		extraObjs = append(extraObjs, authSecret)
+		vol = secretVolume(authSecret.Name)
+	}
+
+	// mount auth secret into deployment
+	volMount := core.VolumeMount{
+		Name:      vol.Name,
+		MountPath: "/etc/guard/auth/azure",
+	}
+	container.VolumeMounts = append(container.VolumeMounts, volMount)
 	d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, vol)
 
 	// use auth secret in container[0] args
-	container.Env = append(container.Env, core.EnvVar{
-		Name: "AZURE_CLIENT_SECRET",
-		ValueFrom: &core.EnvVarSource{
-			SecretKeyRef: &core.SecretKeySelector{
-				LocalObjectReference: core.LocalObjectReference{
-					Name: authSecret.Name,
+	if o.SecretsStoreProviderClass == "" {
+		container.Env = append(container.Env, core.EnvVar{
+			Name: "AZURE_CLIENT_SECRET",
+			ValueFrom: &core.EnvVarSource{
+				SecretKeyRef: &core.SecretKeySelector{
+					LocalObjectReference: core.LocalObjectReference{
+						Name: vol.Name,
+					},
+					Key: "client-secret",
 				},
-				Key: "client-secret",
 			},
-		},
-	})
+		})
+	}
 
 	args := container.Args
 	if o.Environment != "" {
@@ -153,12 +350,17 @@ func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err erro
 	if o.TenantID != "" {
 		args = append(args, fmt.Sprintf("--azure.tenant-id=%s", o.TenantID))
 	}
+	if o.SecretsStoreProviderClass != "" {
+		args = append(args, "--azure.client-secret-file=/etc/guard/auth/azure/client-secret")
+	}
 
 	switch o.AuthMode {
 	case AKSAuthMode:
 		fallthrough
 	case OBOAuthMode:
 		fallthrough
+	case WorkloadIdentityAuthMode:
+		fallthrough
 	case ClientCredentialAuthMode:
 		args = append(args, fmt.Sprintf("--azure.auth-mode=%s", o.AuthMode))
 	default:
@@ -169,12 +371,43 @@ func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err erro
 		args = append(args, fmt.Sprintf("--azure.aks-token-url=%s", o.AKSTokenURL))
 	}
 
+	if o.FederatedTokenFile != "" {
+		args = append(args, fmt.Sprintf("--azure.federated-token-file=%s", o.FederatedTokenFile))
+	}
+
 	args = append(args, fmt.Sprintf("--azure.use-group-uid=%t", o.UseGroupUID))
 
 	args = append(args, fmt.Sprintf("--azure.graph-call-on-overage-claim=%t", o.ResolveGroupMembershipOnlyOnOverageClaim))
 
 	args = append(args, fmt.Sprintf("--azure.verify-clientID=%t", o.VerifyClientID))
 
+	args = append(args, fmt.Sprintf("--azure.resolve-transitive-groups=%t", o.ResolveTransitiveGroups))
+
+	args = append(args, fmt.Sprintf("--azure.deny-guest-users=%t", o.DenyGuestUsers))
+
+	if len(o.AllowedTenantIDs) > 0 {
+		args = append(args, fmt.Sprintf("--azure.allowed-tenant-ids=%s", strings.Join(o.AllowedTenantIDs, ",")))
+	}
+
+	if o.UsernameClaim != "" {
+		args = append(args, fmt.Sprintf("--azure.username-claim=%s", o.UsernameClaim))
+	}
+	if len(o.UsernameClaimFallbacks) > 0 {
+		args = append(args, fmt.Sprintf("--azure.username-claim-fallbacks=%s", strings.Join(o.UsernameClaimFallbacks, ",")))
+	}
+
+	if o.GraphClientCert.Enabled() {
+		args = append(args, "--azure.graph-client-cert-file=/etc/guard/auth/azure/graph-client.crt")
+		args = append(args, "--azure.graph-client-key-file=/etc/guard/auth/azure/graph-client.key")
+	}
+
+	if o.ClientAssertionCertFile != "" {
+		args = append(args, "--azure.client-assertion-cert-file=/etc/guard/auth/azure/client-assertion.crt")
+		if o.ClientAssertionKeyFile != "" {
+			args = append(args, "--azure.client-assertion-key-file=/etc/guard/auth/azure/client-assertion.key")
+		}
+	}
+
 	container.Args = args
 	d.Spec.Template.Spec.Containers[0] = container
 
@@ -51,7 +51,7 @@ var (
 				o.AuthMode = empty
 				return o
 			},
-			errors.New("invalid azure.auth-mode. valid value is either aks, obo, or client-credential"),
+			errors.New("invalid azure.auth-mode. valid value is either aks, obo, client-credential, or workload-identity"),
 			true,
 		},
 		{
@@ -60,7 +60,7 @@ var (
 				o.ClientSecret = empty
 				return o
 			},
-			errors.New("azure.client-secret must be non-empty"),
+			errors.New("azure.client-secret must be non-empty when azure.client-assertion-cert-file is not set"),
 			true,
 		},
 		{
@@ -82,6 +82,15 @@ var (
 			errors.New("azure.tenant-id must be non-empty"),
 			true,
 		},
+		{
+			"azure.username-claim is invalid",
+			func(o Options) Options {
+				o.UsernameClaim = "unix_name"
+				return o
+			},
+			errors.New("invalid username claim unix_name. valid value is either upn, preferred_username, email, or oid"),
+			false,
+		},
 		{
 			"azure.aks-token-url is empty for aks auth mode",
 			func(o Options) Options {
@@ -159,3 +168,13 @@ func TestOptionsValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateCredentialsSkipsNonClientCredentialModes(t *testing.T) {
+	for _, mode := range []string{AKSAuthMode, OBOAuthMode, WorkloadIdentityAuthMode} {
+		t.Run(mode, func(t *testing.T) {
+			opts := getNonEmptyOptions()
+			opts.AuthMode = mode
+			assert.Nil(t, opts.ValidateCredentials())
+		})
+	}
+}
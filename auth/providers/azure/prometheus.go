@@ -0,0 +1,37 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// partialGroupResolutions counts authentications answered with partially
+	// resolved group memberships, so operators notice graph degrading even
+	// though users keep authenticating.
+	partialGroupResolutions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "azure_partial_group_resolutions_total",
+			Help: "A counter of authentications answered with partially resolved group memberships because graph failed mid-resolution.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(partialGroupResolutions)
+}
@@ -31,6 +31,7 @@ const (
 	OrgType         = "eks"
 	v1Prefix        = "k8s-aws-v1."
 	clusterIDHeader = "x-k8s-aws-id"
+	presignedURLTTL = 60 * time.Second
 )
 
 // "EKS" provider is not supported like other ones.
@@ -38,14 +39,16 @@ const (
 //	auth.SupportedOrgs = append(auth.SupportedOrgs, OrgType)
 //}
 
+// Get returns a presigned STS token for the cluster and the time the
+// presigned URL lapses, so kubectl knows when to re-invoke the plugin.
 // https://github.com/heptio/aws-iam-authenticator/blob/master/pkg/token/token.go#L196
-func Get(clusterID string) (string, error) {
+func Get(clusterID string) (string, time.Time, error) {
 	sess, err := session.NewSessionWithOptions(session.Options{
 		AssumeRoleTokenProvider: StdinStderrTokenProvider,
 		SharedConfigState:       session.SharedConfigEnable,
 	})
 	if err != nil {
-		return "", errors.Errorf("could not create session: %v", err)
+		return "", time.Time{}, errors.Errorf("could not create session: %v", err)
 	}
 
 	stsAPI := sts.New(sess)
@@ -53,12 +56,13 @@ func Get(clusterID string) (string, error) {
 	request, _ := stsAPI.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
 	request.HTTPRequest.Header.Add(clusterIDHeader, clusterID)
 
-	presignedURLString, err := request.Presign(60 * time.Second)
+	expiry := time.Now().Add(presignedURLTTL)
+	presignedURLString, err := request.Presign(presignedURLTTL)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
-	return v1Prefix + base64.RawURLEncoding.EncodeToString([]byte(presignedURLString)), nil
+	return v1Prefix + base64.RawURLEncoding.EncodeToString([]byte(presignedURLString)), expiry, nil
 }
 
 func StdinStderrTokenProvider() (string, error) {
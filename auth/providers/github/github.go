@@ -21,6 +21,7 @@ import (
 	"fmt"
 
 	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/util/httpclient"
 
 	"github.com/google/go-github/v25/github"
 	"github.com/pkg/errors"
@@ -56,26 +57,31 @@ func (g Authenticator) UID() string {
 	return OrgType
 }
 
-func (g *Authenticator) Check(token string) (*authv1.UserInfo, error) {
+func (g *Authenticator) Check(ctx context.Context, token string) (*authv1.UserInfo, error) {
 	var (
 		client *github.Client
 		err    error
 	)
 
+	// route the oauth2 transport through the configured proxy and CA bundle
+	httpClient, err := httpclient.NewClient(g.opts.Proxy, g.opts.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	authedClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	))
 	if g.opts.BaseUrl != "" {
-		client, err = github.NewEnterpriseClient(g.opts.BaseUrl, "", oauth2.NewClient(g.ctx, oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: token},
-		)))
+		client, err = github.NewEnterpriseClient(g.opts.BaseUrl, "", authedClient)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create Github enterprise client")
 		}
 	} else {
-		client = github.NewClient(oauth2.NewClient(g.ctx, oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: token},
-		)))
+		client = github.NewClient(authedClient)
 	}
 
-	mem, _, err := client.Organizations.GetOrgMembership(g.ctx, "", g.OrgName)
+	mem, _, err := client.Organizations.GetOrgMembership(ctx, "", g.OrgName)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to check user's membership in Org %s", g.OrgName)
 	}
@@ -85,6 +91,15 @@ func (g *Authenticator) Check(token string) (*authv1.UserInfo, error) {
 		UID:      fmt.Sprintf("%d", mem.User.GetID()),
 	}
 
+	if g.opts.UseGraphQL {
+		groups, err := g.teamSlugsViaGraphQL(ctx, authedClient, mem.User.GetLogin())
+		if err != nil {
+			return nil, err
+		}
+		resp.Groups = groups
+		return resp, nil
+	}
+
 	var groups []string
 	page := 1
 	pageSize := 25
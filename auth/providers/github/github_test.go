@@ -28,14 +28,11 @@ import (
 
 	"github.com/appscode/pat"
 
-	jsoniter "github.com/json-iterator/go"
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/authentication/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
-var json = jsoniter.ConfigCompatibleWithStandardLibrary
-
 const (
 	githubOrganization = "appscode"
 	githubGoodToken    = "secret"
@@ -297,7 +294,7 @@ func TestCheckGithub(t *testing.T) {
 
 			client := githubClientSetup(srv.URL, test.reqOrg)
 
-			resp, err := client.Check(test.accessToken)
+			resp, err := client.Check(context.Background(), test.accessToken)
 			assert.NotNil(t, err)
 			assert.Nil(t, resp)
 		})
@@ -317,7 +314,7 @@ func TestForDifferentTeamSizes(t *testing.T) {
 
 			client := githubClientSetup(srv.URL, githubOrganization)
 
-			resp, err := client.Check(githubGoodToken)
+			resp, err := client.Check(context.Background(), githubGoodToken)
 			assert.Nil(t, err)
 			assertUserInfo(t, resp, teamSize)
 		})
@@ -331,7 +328,7 @@ func TestAuthorizationHeader(t *testing.T) {
 
 	client := githubClientSetup(srv.URL, githubOrganization)
 
-	resp, err := client.Check("")
+	resp, err := client.Check(context.Background(), "")
 	assert.NotNil(t, err)
 	assert.Nil(t, resp)
 }
@@ -363,7 +360,7 @@ func TestTeamListErrorAtDifferentPage(t *testing.T) {
 
 			client := githubClientSetup(srv.URL, githubOrganization)
 
-			resp, err := client.Check(githubGoodToken)
+			resp, err := client.Check(context.Background(), githubGoodToken)
 			assert.NotNil(t, err)
 			assert.Nil(t, resp)
 		})
@@ -0,0 +1,148 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	// defaultGraphQLURL is the GraphQL endpoint of github.com.
+	defaultGraphQLURL = "https://api.github.com/graphql"
+	// teamsPageSize is the page size of the team membership query, so most
+	// users cost a single round trip.
+	teamsPageSize = 100
+)
+
+// teamsQuery resolves the teams of one user in one organization, a page at a
+// time. Slugs are requested instead of display names, so the resulting groups
+// stay stable when a team is renamed.
+const teamsQuery = `query($org: String!, $user: String!, $pageSize: Int!, $cursor: String) {
+  organization(login: $org) {
+    teams(first: $pageSize, userLogins: [$user], after: $cursor) {
+      pageInfo { hasNextPage endCursor }
+      nodes { slug }
+    }
+  }
+}`
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLTeamsResponse struct {
+	Data struct {
+		Organization *struct {
+			Teams struct {
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+				Nodes []struct {
+					Slug string `json:"slug"`
+				} `json:"nodes"`
+			} `json:"teams"`
+		} `json:"organization"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// teamSlugsViaGraphQL returns the user's team memberships in the
+// authenticator's organization as <org>/<team-slug> groups, paging through
+// the GraphQL API. Unlike the REST team listing, the query is scoped to the
+// organization, so a user on many teams elsewhere does not inflate the page
+// count.
+func (g Authenticator) teamSlugsViaGraphQL(ctx context.Context, client *http.Client, login string) ([]string, error) {
+	endpoint, err := g.opts.graphQLEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	var cursor *string
+	for {
+		body, err := json.Marshal(graphQLRequest{
+			Query: teamsQuery,
+			Variables: map[string]interface{}{
+				"org":      g.OrgName,
+				"user":     login,
+				"pageSize": teamsPageSize,
+				"cursor":   cursor,
+			},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal GraphQL query")
+		}
+
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create GraphQL request")
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		httpResp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to query user's teams for Org %s", g.OrgName)
+		}
+		parsed := graphQLTeamsResponse{}
+		err = func() error {
+			defer httpResp.Body.Close()
+			if httpResp.StatusCode != http.StatusOK {
+				msg, _ := ioutil.ReadAll(io.LimitReader(httpResp.Body, 1024))
+				return errors.Errorf("GraphQL endpoint answered %d: %s", httpResp.StatusCode, strings.TrimSpace(string(msg)))
+			}
+			return errors.Wrap(json.NewDecoder(httpResp.Body).Decode(&parsed), "failed to parse GraphQL response")
+		}()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(parsed.Errors) > 0 {
+			msgs := make([]string, 0, len(parsed.Errors))
+			for _, e := range parsed.Errors {
+				msgs = append(msgs, e.Message)
+			}
+			return nil, errors.Errorf("GraphQL query for user's teams in Org %s failed: %s", g.OrgName, strings.Join(msgs, "; "))
+		}
+		org := parsed.Data.Organization
+		if org == nil {
+			return nil, errors.Errorf("Org %s not found via GraphQL", g.OrgName)
+		}
+
+		for _, team := range org.Teams.Nodes {
+			groups = append(groups, g.OrgName+"/"+team.Slug)
+		}
+		if !org.Teams.PageInfo.HasNextPage {
+			return groups, nil
+		}
+		endCursor := org.Teams.PageInfo.EndCursor
+		cursor = &endCursor
+	}
+}
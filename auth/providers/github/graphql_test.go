@@ -0,0 +1,200 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/appscode/pat"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// graphQLTeamsHandler answers the team membership query for an organization
+// of teamSize teams named team1..teamN, paging by the requested page size.
+// The cursor is the number of the first team of the next page.
+func graphQLTeamsHandler(teamSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := verifyAuthorization(r); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write(getErrorMessage(err))
+			return
+		}
+
+		var req struct {
+			Query     string `json:"query"`
+			Variables struct {
+				Org      string  `json:"org"`
+				User     string  `json:"user"`
+				PageSize int     `json:"pageSize"`
+				Cursor   *string `json:"cursor"`
+			} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.Variables.Org != githubOrganization || req.Variables.User != githubUsername || req.Variables.PageSize < 1 {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"errors": []map[string]string{{"message": fmt.Sprintf("unexpected variables: %+v", req.Variables)}},
+			})
+			return
+		}
+
+		start := 1
+		if req.Variables.Cursor != nil {
+			start, _ = strconv.Atoi(*req.Variables.Cursor)
+		}
+		end := min(start+req.Variables.PageSize-1, teamSize)
+		nodes := []map[string]string{}
+		for i := start; i <= end; i++ {
+			nodes = append(nodes, map[string]string{"slug": "team" + strconv.Itoa(i)})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"organization": map[string]interface{}{
+					"teams": map[string]interface{}{
+						"pageInfo": map[string]interface{}{
+							"hasNextPage": end < teamSize,
+							"endCursor":   strconv.Itoa(end + 1),
+						},
+						"nodes": nodes,
+					},
+				},
+			},
+		})
+	}
+}
+
+// githubGraphQLServerSetup serves the REST membership endpoint the
+// authentication still goes through, and the GraphQL endpoint at the path the
+// enterprise derivation produces.
+func githubGraphQLServerSetup(graphQLHandler http.HandlerFunc) *httptest.Server {
+	m := pat.New()
+	m.Get(fmt.Sprintf("/user/memberships/orgs/%v", githubOrganization), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifyAuthorization(r); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write(getErrorMessage(err))
+			return
+		}
+		_, _ = w.Write([]byte(githubMemRespBody))
+	}))
+	m.Post("/api/graphql", graphQLHandler)
+	return httptest.NewServer(m)
+}
+
+func TestCheckGithubGraphQLTeams(t *testing.T) {
+	teamSizes := []int{0, 1, 150} // 150 forces a second page
+	for _, size := range teamSizes {
+		t.Run(fmt.Sprintf("team size: %v", size), func(t *testing.T) {
+			srv := githubGraphQLServerSetup(graphQLTeamsHandler(size))
+			defer srv.Close()
+
+			client := &Authenticator{
+				opts:    Options{BaseUrl: srv.URL, UseGraphQL: true},
+				ctx:     context.Background(),
+				OrgName: githubOrganization,
+			}
+
+			resp, err := client.Check(context.Background(), githubGoodToken)
+			assert.Nil(t, err)
+			assert.Equal(t, githubUsername, resp.Username)
+			assert.Equal(t, githubUID, resp.UID)
+			assert.Equal(t, size, len(resp.Groups))
+			groups := sets.NewString(resp.Groups...)
+			for i := 1; i <= size; i++ {
+				assert.True(t, groups.Has(fmt.Sprintf("%s/team%d", githubOrganization, i)), "group %s/team%d is missing", githubOrganization, i)
+			}
+		})
+	}
+}
+
+func TestCheckGithubGraphQLErrors(t *testing.T) {
+	dataset := []struct {
+		testName    string
+		handler     http.HandlerFunc
+		expectedErr string
+	}{
+		{
+			"graphql error response",
+			func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"errors": []map[string]string{{"message": "something went wrong"}},
+				})
+			},
+			"something went wrong",
+		},
+		{
+			"organization not found",
+			func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": map[string]interface{}{"organization": nil},
+				})
+			},
+			fmt.Sprintf("Org %s not found", githubOrganization),
+		},
+		{
+			"endpoint failure",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			"answered 500",
+		},
+	}
+	for _, test := range dataset {
+		t.Run(test.testName, func(t *testing.T) {
+			srv := githubGraphQLServerSetup(test.handler)
+			defer srv.Close()
+
+			client := &Authenticator{
+				opts:    Options{BaseUrl: srv.URL, UseGraphQL: true},
+				ctx:     context.Background(),
+				OrgName: githubOrganization,
+			}
+
+			resp, err := client.Check(context.Background(), githubGoodToken)
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), test.expectedErr)
+			assert.Nil(t, resp)
+		})
+	}
+}
+
+func TestGraphQLEndpoint(t *testing.T) {
+	dataset := []struct {
+		testName string
+		opts     Options
+		expected string
+	}{
+		{"github.com default", Options{}, defaultGraphQLURL},
+		{"derived from enterprise base url", Options{BaseUrl: "https://ghe.example.com/api/v3/"}, "https://ghe.example.com/api/graphql"},
+		{"explicit url wins", Options{BaseUrl: "https://ghe.example.com/api/v3/", GraphQLUrl: "https://graphql.example.com/gql"}, "https://graphql.example.com/gql"},
+	}
+	for _, test := range dataset {
+		t.Run(test.testName, func(t *testing.T) {
+			endpoint, err := test.opts.graphQLEndpoint()
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, endpoint)
+		})
+	}
+}
@@ -17,15 +17,26 @@ limitations under the License.
 package github
 
 import (
+	"context"
 	"log"
+	"strings"
 
 	"github.com/appscode/go/term"
+	"github.com/appscode/guard/util/httpclient"
 
+	"github.com/google/go-github/v25/github"
+	"github.com/pkg/errors"
 	"github.com/skratchdot/open-golang/open"
+	"golang.org/x/oauth2"
 )
 
-func IssueToken() {
+// IssueToken opens the personal access token page of github.com, or of a
+// GitHub Enterprise instance when baseURL is set.
+func IssueToken(baseURL string) {
 	codeURurl := "https://github.com/settings/tokens/new"
+	if baseURL != "" {
+		codeURurl = strings.TrimSuffix(baseURL, "/") + "/settings/tokens/new"
+	}
 	term.Infoln("Github url for personal access tokens:", codeURurl)
 	term.Infoln("Select only read:org permissions for the token")
 	term.Infoln("After the token is created, run:")
@@ -35,3 +46,36 @@ func IssueToken() {
 		log.Fatalln(err)
 	}
 }
+
+// VerifyToken checks the token against github.com or, when baseURL is set, a
+// GitHub Enterprise instance. caCertFile adds a private CA for self-hosted
+// instances with internally signed certificates.
+func VerifyToken(token, baseURL, caCertFile string) error {
+	ctx := context.Background()
+
+	baseClient, err := httpclient.NewClientWithCA(caCertFile)
+	if err != nil {
+		return err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, baseClient)
+	oauthClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	))
+
+	var client *github.Client
+	if baseURL != "" {
+		client, err = github.NewEnterpriseClient(baseURL, "", oauthClient)
+		if err != nil {
+			return errors.Wrap(err, "failed to create Github enterprise client")
+		}
+	} else {
+		client = github.NewClient(oauthClient)
+	}
+
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return errors.Wrap(err, "failed to verify token")
+	}
+	term.Successln("Token is valid for user", user.GetLogin())
+	return nil
+}
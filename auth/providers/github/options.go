@@ -18,7 +18,11 @@ package github
 
 import (
 	"fmt"
+	"net/url"
 
+	"github.com/appscode/guard/util/httpclient"
+
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	apps "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -26,6 +30,14 @@ import (
 
 type Options struct {
 	BaseUrl string
+	// UseGraphQL resolves team memberships through the GraphQL API instead of
+	// the REST team listing, returning groups as <org>/<team-slug>
+	UseGraphQL bool
+	// GraphQLUrl overrides the GraphQL endpoint; empty derives it from
+	// BaseUrl, or uses the github.com endpoint
+	GraphQLUrl string
+	Proxy      httpclient.ProxyConfig
+	CABundle   httpclient.CABundleConfig
 }
 
 func NewOptions() Options {
@@ -38,10 +50,42 @@ func (o *Options) Configure() error {
 
 func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.BaseUrl, "github.base-url", o.BaseUrl, "Base url for enterprise, keep empty to use default github base url")
+	fs.BoolVar(&o.UseGraphQL, "github.use-graphql", o.UseGraphQL, "If true, resolve team memberships through the GraphQL API and return groups as <org>/<team-slug> instead of team names. The query is scoped to the organization, so it pages less for users on many teams")
+	fs.StringVar(&o.GraphQLUrl, "github.graphql-url", o.GraphQLUrl, "URL of the GraphQL endpoint. Keep empty to derive it from github.base-url for Enterprise Server (https://<host>/api/graphql), or to use the github.com endpoint")
+	o.Proxy.AddFlagsWithPrefix(fs, "github.")
+	o.CABundle.AddFlagsWithPrefix(fs, "github.")
 }
 
 func (o *Options) Validate() []error {
-	return nil
+	var errs []error
+	if o.GraphQLUrl != "" {
+		if u, err := url.Parse(o.GraphQLUrl); err != nil || !u.IsAbs() {
+			errs = append(errs, errors.Errorf("github.graphql-url %q must be an absolute URL", o.GraphQLUrl))
+		}
+	}
+	errs = append(errs, o.Proxy.Validate("github.")...)
+	errs = append(errs, o.CABundle.Validate("github.")...)
+	return errs
+}
+
+// graphQLEndpoint returns the GraphQL endpoint the team resolution talks to.
+// An explicit github.graphql-url wins; otherwise the endpoint is derived from
+// github.base-url the way GitHub Enterprise Server lays out its API
+// (https://<host>/api/graphql), falling back to the github.com endpoint.
+func (o Options) graphQLEndpoint() (string, error) {
+	if o.GraphQLUrl != "" {
+		return o.GraphQLUrl, nil
+	}
+	if o.BaseUrl == "" {
+		return defaultGraphQLURL, nil
+	}
+	u, err := url.Parse(o.BaseUrl)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse github.base-url")
+	}
+	u.Path = "/api/graphql"
+	u.RawQuery = ""
+	return u.String(), nil
 }
 
 func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
@@ -49,6 +93,12 @@ func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err erro
 	if o.BaseUrl != "" {
 		args = append(args, fmt.Sprintf("--github.base-url=%s", o.BaseUrl))
 	}
+	if o.UseGraphQL {
+		args = append(args, "--github.use-graphql")
+	}
+	if o.GraphQLUrl != "" {
+		args = append(args, fmt.Sprintf("--github.graphql-url=%s", o.GraphQLUrl))
+	}
 
 	d.Spec.Template.Spec.Containers[0].Args = args
 	return extraObjs, nil
@@ -17,9 +17,11 @@ limitations under the License.
 package gitlab
 
 import (
+	"context"
 	"strconv"
 
 	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/util/httpclient"
 
 	"github.com/pkg/errors"
 	gitlab "github.com/xanzy/go-gitlab"
@@ -48,8 +50,14 @@ func (g Authenticator) UID() string {
 	return OrgType
 }
 
-func (g *Authenticator) Check(token string) (*authv1.UserInfo, error) {
-	var opts []gitlab.ClientOptionFunc
+func (g *Authenticator) Check(ctx context.Context, token string) (*authv1.UserInfo, error) {
+	httpClient, err := httpclient.NewClient(g.opts.Proxy, g.opts.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	opts := []gitlab.ClientOptionFunc{
+		gitlab.WithHTTPClient(httpClient),
+	}
 	if g.opts.BaseUrl != "" {
 		opts = append(opts, gitlab.WithBaseURL(g.opts.BaseUrl))
 	}
@@ -17,6 +17,7 @@ limitations under the License.
 package gitlab
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -262,7 +263,7 @@ func TestGitlab(t *testing.T) {
 
 				client := gitlabClientSetup(srv.URL, useGroupId)
 
-				resp, err := client.Check(test.token)
+				resp, err := client.Check(context.Background(), test.token)
 				if assert.NotNil(t, err) {
 					assert.Nil(t, resp)
 				}
@@ -283,7 +284,7 @@ func TestForDIfferentGroupSizes(t *testing.T) {
 
 				client := gitlabClientSetup(srv.URL, useGroupId)
 				if assert.NotNil(t, client) {
-					resp, err := client.Check(gitlabGoodToken)
+					resp, err := client.Check(context.Background(), gitlabGoodToken)
 					if assert.Nil(t, err) {
 						assertUserInfo(t, resp, useGroupId, groupSize)
 					}
@@ -318,7 +319,7 @@ func TestGroupListErrorInDifferentPage(t *testing.T) {
 				defer srv.Close()
 
 				client := gitlabClientSetup(srv.URL, useGroupId)
-				resp, err := client.Check(gitlabGoodToken)
+				resp, err := client.Check(context.Background(), gitlabGoodToken)
 				assert.NotNil(t, err)
 				assert.Nil(t, resp)
 			})
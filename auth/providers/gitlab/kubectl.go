@@ -18,17 +18,54 @@ package gitlab
 
 import (
 	"log"
+	"strings"
 
 	"github.com/appscode/go/term"
+	"github.com/appscode/guard/util/httpclient"
 
+	"github.com/pkg/errors"
 	"github.com/skratchdot/open-golang/open"
+	gitlab "github.com/xanzy/go-gitlab"
 )
 
-func IssueToken() {
+// IssueToken opens the personal access token page of gitlab.com, or of a
+// self-hosted GitLab instance when baseURL is set. baseURL may include the
+// API path, as with the gitlab.base-url flag.
+func IssueToken(baseURL string) {
 	codeURurl := "https://gitlab.com/profile/personal_access_tokens"
+	if baseURL != "" {
+		codeURurl = strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/api/v4") + "/profile/personal_access_tokens"
+	}
 	term.Infoln("Gitlab url for personal access tokens:", codeURurl)
 	err := open.Start(codeURurl)
 	if err != nil {
 		log.Fatalln(err)
 	}
 }
+
+// VerifyToken checks the token against gitlab.com or, when baseURL is set, a
+// self-hosted GitLab instance. caCertFile adds a private CA for instances
+// with internally signed certificates.
+func VerifyToken(token, baseURL, caCertFile string) error {
+	httpClient, err := httpclient.NewClientWithCA(caCertFile)
+	if err != nil {
+		return err
+	}
+
+	opts := []gitlab.ClientOptionFunc{gitlab.WithHTTPClient(httpClient)}
+	if baseURL != "" {
+		opts = append(opts, gitlab.WithBaseURL(baseURL))
+	}
+
+	client, err := gitlab.NewClient(token, opts...)
+	if err != nil {
+		return err
+	}
+
+	user, _, err := client.Users.CurrentUser()
+	if err != nil {
+		return errors.Wrap(err, "failed to verify token")
+	}
+	term.Successln("Token is valid for user", user.Username)
+	return nil
+}
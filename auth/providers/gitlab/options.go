@@ -19,6 +19,8 @@ package gitlab
 import (
 	"fmt"
 
+	"github.com/appscode/guard/util/httpclient"
+
 	"github.com/spf13/pflag"
 	apps "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -27,6 +29,8 @@ import (
 type Options struct {
 	BaseUrl    string
 	UseGroupID bool
+	Proxy      httpclient.ProxyConfig
+	CABundle   httpclient.CABundleConfig
 }
 
 func NewOptions() Options {
@@ -42,10 +46,15 @@ func (o *Options) Configure() error {
 func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.BaseUrl, "gitlab.base-url", o.BaseUrl, "Base url for GitLab, including the API path, keep empty to use default gitlab base url.")
 	fs.BoolVar(&o.UseGroupID, "gitlab.use-group-id", o.UseGroupID, "Use group ID for authentication instead of group full path")
+	o.Proxy.AddFlagsWithPrefix(fs, "gitlab.")
+	o.CABundle.AddFlagsWithPrefix(fs, "gitlab.")
 }
 
 func (o *Options) Validate() []error {
-	return nil
+	var errs []error
+	errs = append(errs, o.Proxy.Validate("gitlab.")...)
+	errs = append(errs, o.CABundle.Validate("gitlab.")...)
+	return errs
 }
 
 func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
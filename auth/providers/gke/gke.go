@@ -0,0 +1,54 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gke implements the kubectl credential plugin side of Google
+// clusters: it obtains a Google OAuth access token and emits an
+// ExecCredential, matching the eks and azure login paths.
+package gke
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+)
+
+const OrgType = "gke"
+
+// scopes are the OAuth scopes GKE accepts on user credentials.
+var scopes = []string{
+	"https://www.googleapis.com/auth/cloud-platform",
+	"https://www.googleapis.com/auth/userinfo.email",
+}
+
+// Get returns a Google OAuth access token and its expiry from Application
+// Default Credentials: a service account key via
+// GOOGLE_APPLICATION_CREDENTIALS, the gcloud user credential, or the GCE
+// metadata server. The browser flow is delegated to
+// `gcloud auth application-default login`, which stores the resulting
+// credential where ADC finds it.
+func Get() (string, time.Time, error) {
+	ts, err := google.DefaultTokenSource(context.Background(), scopes...)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "no application default credentials found. Run `gcloud auth application-default login` to complete the browser flow")
+	}
+	token, err := ts.Token()
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "failed to fetch token from application default credentials")
+	}
+	return token.AccessToken, token.Expiry, nil
+}
@@ -20,9 +20,11 @@ import (
 	"context"
 
 	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/util/httpclient"
 
 	oidc "github.com/coreos/go-oidc"
 	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
 	gdir "google.golang.org/api/admin/directory/v1"
 	gauth "google.golang.org/api/oauth2/v1"
 	"google.golang.org/api/option"
@@ -56,9 +58,15 @@ type TokenInfo struct {
 }
 
 func New(opts Options, domain string) (auth.Interface, error) {
+	// route the oidc and directory clients through the configured proxy and
+	// CA bundle
+	httpClient, err := httpclient.NewClient(opts.Proxy, opts.CABundle)
+	if err != nil {
+		return nil, err
+	}
 	g := &Authenticator{
 		Options:    opts,
-		ctx:        context.Background(),
+		ctx:        context.WithValue(context.Background(), oauth2.HTTPClient, httpClient),
 		domainName: domain,
 	}
 
@@ -72,8 +80,7 @@ func New(opts Options, domain string) (auth.Interface, error) {
 	})
 
 	if opts.ServiceAccountJsonFile != "" {
-		ctx := context.Background()
-		g.service, err = gdir.NewService(ctx, option.WithTokenSource(opts.jwtConfig.TokenSource(ctx)))
+		g.service, err = gdir.NewService(g.ctx, option.WithTokenSource(opts.jwtConfig.TokenSource(g.ctx)))
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to create admin/directory/v1 client for domain %s", domain)
 		}
@@ -86,8 +93,8 @@ func (g Authenticator) UID() string {
 }
 
 // https://developers.google.com/identity/protocols/OpenIDConnect#validatinganidtoken
-func (g *Authenticator) Check(token string) (*authv1.UserInfo, error) {
-	idToken, err := g.verifier.Verify(g.ctx, token)
+func (g *Authenticator) Check(ctx context.Context, token string) (*authv1.UserInfo, error) {
+	idToken, err := g.verifier.Verify(ctx, token)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to verify token for google")
 	}
@@ -315,7 +315,7 @@ func TestCheckGoogleAuthenticationSuccess(t *testing.T) {
 			// set client domain
 			client.domainName = domain
 
-			resp, err := client.Check(token)
+			resp, err := client.Check(context.Background(), token)
 			assert.Nil(t, err)
 			assertUserInfo(t, resp, test.groupSize)
 		})
@@ -410,7 +410,7 @@ func TestCheckGoogleAuthenticationFailed(t *testing.T) {
 			// set client domain
 			client.domainName = domain
 
-			resp, err := client.Check(token)
+			resp, err := client.Check(context.Background(), token)
 			//t.Log(test)
 			assert.NotNil(t, err)
 			assert.Nil(t, resp)
@@ -17,13 +17,17 @@ limitations under the License.
 package google
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 
 	"github.com/appscode/go/types"
+	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/util/httpclient"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
 	gdir "google.golang.org/api/admin/directory/v1"
@@ -36,7 +40,17 @@ import (
 type Options struct {
 	ServiceAccountJsonFile string
 	AdminEmail             string
-	jwtConfig              *jwt.Config
+	// ExistingSecret is the name of a pre-created Secret holding the key
+	// sa.json; the installer mounts it instead of reading the service account
+	// file and embedding it into the generated manifest
+	ExistingSecret string
+	// SecretsStoreProviderClass is the name of a SecretProviderClass whose
+	// objects include sa.json; the installer mounts it through the Secrets
+	// Store CSI driver, so the credential never exists as a Kubernetes Secret
+	SecretsStoreProviderClass string
+	Proxy                     httpclient.ProxyConfig
+	CABundle                  httpclient.CABundleConfig
+	jwtConfig                 *jwt.Config
 }
 
 func NewOptions() Options {
@@ -67,60 +81,100 @@ func (o *Options) Configure() error {
 func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.ServiceAccountJsonFile, "google.sa-json-file", o.ServiceAccountJsonFile, "Path to Google service account json file")
 	fs.StringVar(&o.AdminEmail, "google.admin-email", o.AdminEmail, "Email of G Suite administrator")
+	fs.StringVar(&o.ExistingSecret, "google.existing-secret", o.ExistingSecret, "Name of a pre-created Secret with key sa.json, mounted by the installer instead of embedding google.sa-json-file into the generated manifest")
+	fs.StringVar(&o.SecretsStoreProviderClass, "google.secrets-store-provider-class", o.SecretsStoreProviderClass, "Name of a SecretProviderClass with an object aliased sa.json, mounted by the installer through the Secrets Store CSI driver instead of a Kubernetes Secret")
+	o.Proxy.AddFlagsWithPrefix(fs, "google.")
+	o.CABundle.AddFlagsWithPrefix(fs, "google.")
 }
 
 func (o *Options) Validate() []error {
 	var errs []error
-	if o.ServiceAccountJsonFile == "" {
+	if o.ServiceAccountJsonFile == "" && o.ExistingSecret == "" && o.SecretsStoreProviderClass == "" {
 		errs = append(errs, errors.New("google.sa-json-file must be non-empty"))
 	}
+	if o.ExistingSecret != "" && o.SecretsStoreProviderClass != "" {
+		errs = append(errs, errors.New("google.existing-secret and google.secrets-store-provider-class are mutually exclusive"))
+	}
 	if o.AdminEmail == "" {
 		errs = append(errs, errors.New("google.admin-email must be non-empty"))
 	}
+	errs = append(errs, o.Proxy.Validate("google.")...)
+	errs = append(errs, o.CABundle.Validate("google.")...)
 	return errs
 }
 
-func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
-	container := d.Spec.Template.Spec.Containers[0]
+// ValidateCredentials fetches a token for the configured service account, so
+// a bad or revoked key is caught before the generated objects hit the
+// cluster.
+func (o Options) ValidateCredentials() error {
+	if err := o.Configure(); err != nil {
+		return err
+	}
+	if o.jwtConfig == nil {
+		return errors.New("google.sa-json-file must be non-empty")
+	}
 
-	// create auth secret
-	sa, err := ioutil.ReadFile(o.ServiceAccountJsonFile)
+	client, err := httpclient.NewClient(o.Proxy, o.CABundle)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	authSecret := &core.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "guard-google-auth",
-			Namespace: d.Namespace,
-			Labels:    d.Labels,
-		},
-		Data: map[string][]byte{
-			"sa.json": sa,
-		},
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	if _, err := o.jwtConfig.TokenSource(ctx).Token(); err != nil {
+		return errors.Wrapf(err, "failed to fetch token for service account %s", o.jwtConfig.Email)
+	}
+	return nil
+}
+
+func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
+	container := d.Spec.Template.Spec.Containers[0]
+
+	var vol core.Volume
+	if o.SecretsStoreProviderClass != "" {
+		// the CSI driver pulls sa.json from the external vault at pod start
+		vol = auth.SecretsStoreVolume("guard-google-auth", o.SecretsStoreProviderClass)
+	} else {
+		secretName := o.ExistingSecret
+		if secretName == "" {
+			// create auth secret
+			sa, err := ioutil.ReadFile(o.ServiceAccountJsonFile)
+			if err != nil {
+				return nil, err
+			}
+			authSecret := &core.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "guard-google-auth",
+					Namespace: d.Namespace,
+					Labels:    d.Labels,
+				},
+				Data: map[string][]byte{
+					"sa.json": sa,
+				},
+			}
+			extraObjs = append(extraObjs, authSecret)
+			secretName = authSecret.Name
+		}
+		vol = core.Volume{
+			Name: secretName,
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{
+					SecretName:  secretName,
+					DefaultMode: types.Int32P(0555),
+				},
+			},
+		}
 	}
-	extraObjs = append(extraObjs, authSecret)
 
 	// mount auth secret into deployment
 	volMount := core.VolumeMount{
-		Name:      authSecret.Name,
+		Name:      vol.Name,
 		MountPath: "/etc/guard/auth/google",
 	}
 	container.VolumeMounts = append(container.VolumeMounts, volMount)
-
-	vol := core.Volume{
-		Name: authSecret.Name,
-		VolumeSource: core.VolumeSource{
-			Secret: &core.SecretVolumeSource{
-				SecretName:  authSecret.Name,
-				DefaultMode: types.Int32P(0555),
-			},
-		},
-	}
 	d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, vol)
 
 	// use auth secret in container[0] args
 	args := container.Args
-	if o.ServiceAccountJsonFile != "" {
+	if o.ServiceAccountJsonFile != "" || o.ExistingSecret != "" || o.SecretsStoreProviderClass != "" {
 		args = append(args, "--google.sa-json-file=/etc/guard/auth/google/sa.json")
 	}
 	if o.AdminEmail != "" {
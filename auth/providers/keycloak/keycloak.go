@@ -0,0 +1,192 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keycloak
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/util/httpclient"
+
+	"github.com/pkg/errors"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+const (
+	OrgType = "keycloak"
+)
+
+func init() {
+	auth.SupportedOrgs = append(auth.SupportedOrgs, OrgType)
+}
+
+type Authenticator struct {
+	opts   Options
+	client *http.Client
+}
+
+func New(opts Options) (auth.Interface, error) {
+	client, err := httpclient.NewClientWithCA(opts.CACertFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Authenticator{
+		opts:   opts,
+		client: client,
+	}, nil
+}
+
+func (k Authenticator) UID() string {
+	return OrgType
+}
+
+// introspection is the relevant subset of the RFC 7662 response of Keycloak's
+// token introspection endpoint. Realm roles arrive in realm_access, client
+// roles in resource_access keyed by client.
+type introspection struct {
+	Active            bool   `json:"active"`
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username"`
+	Username          string `json:"username"`
+	RealmAccess       struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+	ResourceAccess map[string]struct {
+		Roles []string `json:"roles"`
+	} `json:"resource_access"`
+}
+
+func (k *Authenticator) Check(ctx context.Context, token string) (*authv1.UserInfo, error) {
+	var (
+		claims *introspection
+		err    error
+	)
+	// the introspection endpoint needs a confidential client, but also
+	// validates opaque tokens; without client credentials only the userinfo
+	// endpoint is available, which validates the token against the realm
+	if k.opts.ClientID != "" {
+		claims, err = k.introspectToken(token)
+	} else {
+		claims, err = k.userInfo(token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !claims.Active {
+		return nil, errors.New("token is not active")
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Username
+	}
+	if username == "" {
+		username = claims.Subject
+	}
+	if username == "" {
+		return nil, errors.New("token carries no user identity")
+	}
+
+	resp := &authv1.UserInfo{
+		Username: username,
+		UID:      claims.Subject,
+	}
+
+	var groups []string
+	for _, role := range claims.RealmAccess.Roles {
+		groups = append(groups, k.opts.RealmRolePrefix+role)
+	}
+	for client, access := range claims.ResourceAccess {
+		for _, role := range access.Roles {
+			groups = append(groups, fmt.Sprintf("%s%s:%s", k.opts.ClientRolePrefix, client, role))
+		}
+	}
+	resp.Groups = groups
+	return resp, nil
+}
+
+func (k *Authenticator) realmURL(parts ...string) string {
+	return strings.TrimSuffix(k.opts.BaseUrl, "/") + "/realms/" + k.opts.Realm + "/" + strings.Join(parts, "/")
+}
+
+func (k *Authenticator) introspectToken(token string) (*introspection, error) {
+	form := url.Values{}
+	form.Set("token", token)
+
+	req, err := http.NewRequest(http.MethodPost, k.realmURL("protocol", "openid-connect", "token", "introspect"), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create introspection request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(k.opts.ClientID, k.opts.ClientSecret)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to call introspection endpoint")
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read introspection response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("introspection endpoint returned status code: %d and response: %s", resp.StatusCode, string(data))
+	}
+
+	claims := introspection{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errors.Wrap(err, "failed to decode introspection response")
+	}
+	return &claims, nil
+}
+
+func (k *Authenticator) userInfo(token string) (*introspection, error) {
+	req, err := http.NewRequest(http.MethodGet, k.realmURL("protocol", "openid-connect", "userinfo"), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create userinfo request")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to call userinfo endpoint")
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read userinfo response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("userinfo endpoint returned status code: %d and response: %s", resp.StatusCode, string(data))
+	}
+
+	claims := introspection{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errors.Wrap(err, "failed to decode userinfo response")
+	}
+	// a successful userinfo call proves the token is valid for the realm
+	claims.Active = true
+	return &claims, nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIntrospection(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/auth/realms/prod/protocol/openid-connect/token/introspect", req.URL.Path)
+
+		clientID, clientSecret, ok := req.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "guard", clientID)
+		assert.Equal(t, "s3cr3t", clientSecret)
+
+		assert.Nil(t, req.ParseForm())
+		assert.Equal(t, "opaque-token", req.PostForm.Get("token"))
+
+		fmt.Fprint(w, `{
+			"active": true,
+			"sub": "1204",
+			"preferred_username": "nahid",
+			"realm_access": {"roles": ["admin"]},
+			"resource_access": {"kubernetes": {"roles": ["viewer"]}}
+		}`)
+	}))
+	defer ts.Close()
+
+	client, err := New(Options{
+		BaseUrl:          ts.URL + "/auth",
+		Realm:            "prod",
+		ClientID:         "guard",
+		ClientSecret:     "s3cr3t",
+		RealmRolePrefix:  "keycloak:",
+		ClientRolePrefix: "keycloak:",
+	})
+	assert.Nil(t, err)
+
+	resp, err := client.Check(context.Background(), "opaque-token")
+	assert.Nil(t, err)
+	assert.Equal(t, "nahid", resp.Username)
+	assert.Equal(t, "1204", resp.UID)
+	assert.ElementsMatch(t, []string{"keycloak:admin", "keycloak:kubernetes:viewer"}, resp.Groups)
+}
+
+func TestCheckIntrospectionInactiveToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"active": false}`)
+	}))
+	defer ts.Close()
+
+	client, err := New(Options{
+		BaseUrl:      ts.URL,
+		Realm:        "prod",
+		ClientID:     "guard",
+		ClientSecret: "s3cr3t",
+	})
+	assert.Nil(t, err)
+
+	resp, err := client.Check(context.Background(), "expired-token")
+	assert.NotNil(t, err)
+	assert.Nil(t, resp)
+	assert.Contains(t, err.Error(), "not active")
+}
+
+func TestCheckUserInfo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/realms/prod/protocol/openid-connect/userinfo", req.URL.Path)
+		assert.Equal(t, "Bearer jwt-token", req.Header.Get("Authorization"))
+
+		fmt.Fprint(w, `{"sub": "1204", "preferred_username": "nahid"}`)
+	}))
+	defer ts.Close()
+
+	// without a confidential client only the userinfo endpoint is available
+	client, err := New(Options{
+		BaseUrl: ts.URL,
+		Realm:   "prod",
+	})
+	assert.Nil(t, err)
+
+	resp, err := client.Check(context.Background(), "jwt-token")
+	assert.Nil(t, err)
+	assert.Equal(t, "nahid", resp.Username)
+	assert.Equal(t, "1204", resp.UID)
+}
+
+func TestCheckServerError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	client, err := New(Options{
+		BaseUrl: ts.URL,
+		Realm:   "prod",
+	})
+	assert.Nil(t, err)
+
+	resp, err := client.Check(context.Background(), "bad-token")
+	assert.NotNil(t, err)
+	assert.Nil(t, resp)
+	assert.Contains(t, err.Error(), "status code: 401")
+}
@@ -0,0 +1,154 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keycloak
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/appscode/go/types"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type Options struct {
+	// BaseUrl of the Keycloak server, e.g. https://keycloak.example.com
+	BaseUrl string
+	// Realm the tokens are validated against
+	Realm string
+	// ClientID/ClientSecret of a confidential client, required for the
+	// token introspection endpoint (opaque tokens)
+	ClientID     string
+	ClientSecret string
+	// CACertFile adds a private CA for self-hosted Keycloak instances
+	CACertFile string
+	// RealmRolePrefix/ClientRolePrefix are prepended to realm and client
+	// roles when mapped to Kubernetes groups
+	RealmRolePrefix  string
+	ClientRolePrefix string
+}
+
+func NewOptions() Options {
+	return Options{
+		ClientSecret: os.Getenv("KEYCLOAK_CLIENT_SECRET"),
+	}
+}
+
+func (o *Options) Configure() error {
+	return nil
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.BaseUrl, "keycloak.base-url", o.BaseUrl, "Base url of the Keycloak server")
+	fs.StringVar(&o.Realm, "keycloak.realm", o.Realm, "Name of the Keycloak realm tokens are validated against")
+	fs.StringVar(&o.ClientID, "keycloak.client-id", o.ClientID, "Client id of a confidential client, required for token introspection")
+	fs.StringVar(&o.ClientSecret, "keycloak.client-secret", o.ClientSecret, "Client secret of the confidential client")
+	fs.StringVar(&o.CACertFile, "keycloak.ca-cert-file", o.CACertFile, "Path to a CA certificate file for a Keycloak server with internally signed certificates")
+	fs.StringVar(&o.RealmRolePrefix, "keycloak.realm-role-prefix", o.RealmRolePrefix, "Prefix prepended to realm roles when mapped to Kubernetes groups")
+	fs.StringVar(&o.ClientRolePrefix, "keycloak.client-role-prefix", o.ClientRolePrefix, "Prefix prepended to client roles when mapped to Kubernetes groups")
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	if o.BaseUrl == "" {
+		errs = append(errs, errors.New("keycloak.base-url must be non-empty"))
+	}
+	if o.Realm == "" {
+		errs = append(errs, errors.New("keycloak.realm must be non-empty"))
+	}
+	if (o.ClientID == "") != (o.ClientSecret == "") {
+		errs = append(errs, errors.New("keycloak.client-id and keycloak.client-secret must be used together"))
+	}
+	return errs
+}
+
+func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
+	container := d.Spec.Template.Spec.Containers[0]
+
+	// create auth secret
+	if o.ClientSecret != "" {
+		authSecret := &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "guard-keycloak-auth",
+				Namespace: d.Namespace,
+				Labels:    d.Labels,
+			},
+			Data: map[string][]byte{
+				"client-secret": []byte(o.ClientSecret),
+			},
+		}
+		extraObjs = append(extraObjs, authSecret)
+
+		// mount auth secret into deployment
+		volMount := core.VolumeMount{
+			Name:      authSecret.Name,
+			MountPath: "/etc/guard/auth/keycloak",
+		}
+		container.VolumeMounts = append(container.VolumeMounts, volMount)
+
+		vol := core.Volume{
+			Name: authSecret.Name,
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{
+					SecretName:  authSecret.Name,
+					DefaultMode: types.Int32P(0555),
+				},
+			},
+		}
+		d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, vol)
+
+		// use auth secret in container[0] args
+		container.Env = append(container.Env, core.EnvVar{
+			Name: "KEYCLOAK_CLIENT_SECRET",
+			ValueFrom: &core.EnvVarSource{
+				SecretKeyRef: &core.SecretKeySelector{
+					LocalObjectReference: core.LocalObjectReference{
+						Name: authSecret.Name,
+					},
+					Key: "client-secret",
+				},
+			},
+		})
+	}
+
+	args := container.Args
+	if o.BaseUrl != "" {
+		args = append(args, fmt.Sprintf("--keycloak.base-url=%s", o.BaseUrl))
+	}
+	if o.Realm != "" {
+		args = append(args, fmt.Sprintf("--keycloak.realm=%s", o.Realm))
+	}
+	if o.ClientID != "" {
+		args = append(args, fmt.Sprintf("--keycloak.client-id=%s", o.ClientID))
+	}
+	if o.RealmRolePrefix != "" {
+		args = append(args, fmt.Sprintf("--keycloak.realm-role-prefix=%s", o.RealmRolePrefix))
+	}
+	if o.ClientRolePrefix != "" {
+		args = append(args, fmt.Sprintf("--keycloak.client-role-prefix=%s", o.ClientRolePrefix))
+	}
+
+	container.Args = args
+	d.Spec.Template.Spec.Containers[0] = container
+
+	return extraObjs, nil
+}
@@ -17,10 +17,12 @@ limitations under the License.
 package ldap
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/appscode/guard/auth"
 
@@ -39,6 +41,7 @@ const (
 	DefaultUserAttribute        = "uid"
 	DefaultGroupMemberAttribute = "member"
 	DefaultGroupNameAttribute   = "cn"
+	DefaultUIDAttribute         = "entryUUID"
 )
 
 func init() {
@@ -59,7 +62,7 @@ func (g Authenticator) UID() string {
 	return OrgType
 }
 
-func (s Authenticator) Check(token string) (*authv1.UserInfo, error) {
+func (s Authenticator) Check(ctx context.Context, token string) (*authv1.UserInfo, error) {
 	var (
 		err  error
 		conn *ldap.Conn
@@ -74,16 +77,22 @@ func (s Authenticator) Check(token string) (*authv1.UserInfo, error) {
 		tlsConfig.RootCAs = s.opts.CaCertPool
 	}
 
-	if s.opts.IsSecureLDAP {
-		conn, err = ldap.DialTLS("tcp", fmt.Sprintf("%s:%s", s.opts.ServerAddress, s.opts.ServerPort), tlsConfig)
-	} else {
-		conn, err = ldap.Dial("tcp", fmt.Sprintf("%s:%s", s.opts.ServerAddress, s.opts.ServerPort))
+	if s.opts.clientCertificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*s.opts.clientCertificate}
 	}
+
+	conn, err = s.dial(tlsConfig)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to create ldap connector for %s:%s", s.opts.ServerAddress, s.opts.ServerPort)
 	}
 	defer conn.Close()
 
+	// honor the request deadline on every operation over the connection, so a
+	// slow directory cannot hold the webhook past the API server's timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetTimeout(time.Until(deadline))
+	}
+
 	if s.opts.StartTLS {
 		err = conn.StartTLS(tlsConfig)
 		if err != nil {
@@ -113,10 +122,11 @@ func (s Authenticator) Check(token string) (*authv1.UserInfo, error) {
 		}
 	}
 
-	userDN, err := s.getUserDN(conn, username)
+	userEntry, err := s.getUserEntry(conn, username)
 	if err != nil {
 		return nil, errors.Wrap(err, "error when getting user DN")
 	}
+	userDN := userEntry.DN
 
 	// user group list
 	req := s.opts.newGroupSearchRequest(userDN)
@@ -142,9 +152,40 @@ func (s Authenticator) Check(token string) (*authv1.UserInfo, error) {
 	resp := &authv1.UserInfo{}
 	resp.Username = username
 	resp.Groups = groups
+	if auth.PopulateUID() {
+		resp.UID = userEntry.GetAttributeValue(s.opts.UIDAttribute)
+	}
 	return resp, nil
 }
 
+// dial opens the LDAP connection, tunneling through the configured proxy
+// with an HTTP CONNECT request when one applies. LDAP is not HTTP, so
+// Transport.Proxy cannot be used here.
+func (s Authenticator) dial(tlsConfig *tls.Config) (*ldap.Conn, error) {
+	addr := fmt.Sprintf("%s:%s", s.opts.ServerAddress, s.opts.ServerPort)
+
+	if !s.opts.ClientCert.Proxy.Applies() {
+		if s.opts.IsSecureLDAP {
+			return ldap.DialTLS("tcp", addr, tlsConfig)
+		}
+		return ldap.Dial("tcp", addr)
+	}
+
+	rawConn, err := s.opts.ClientCert.Proxy.Dial("tcp", addr, ldap.DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn *ldap.Conn
+	if s.opts.IsSecureLDAP {
+		conn = ldap.NewConn(tls.Client(rawConn, tlsConfig), true)
+	} else {
+		conn = ldap.NewConn(rawConn, false)
+	}
+	conn.Start()
+	return conn, nil
+}
+
 func (s Authenticator) authenticateUser(conn *ldap.Conn, token string) (string, error) {
 	if s.opts.AuthenticationChoice == AuthChoiceSimple {
 		//simple authentication
@@ -190,22 +231,30 @@ func (s Authenticator) authenticateUser(conn *ldap.Conn, token string) (string,
 }
 
 func (s Authenticator) getUserDN(conn *ldap.Conn, username string) (string, error) {
+	entry, err := s.getUserEntry(conn, username)
+	if err != nil {
+		return "", err
+	}
+	return entry.DN, nil
+}
+
+// getUserEntry searches for the user and returns the matched entry, so
+// callers can read the DN and the uid attribute from one lookup.
+func (s Authenticator) getUserEntry(conn *ldap.Conn, username string) (*ldap.Entry, error) {
 	req := s.opts.newUserSearchRequest(username)
 
 	res, err := conn.Search(req)
 	if err != nil {
-		return "", errors.Wrapf(err, "error searching for user %s", username)
+		return nil, errors.Wrapf(err, "error searching for user %s", username)
 	}
 
 	if len(res.Entries) == 0 {
-		return "", errors.Errorf("No result for the user search filter '%s'", req.Filter)
+		return nil, errors.Errorf("No result for the user search filter '%s'", req.Filter)
 	} else if len(res.Entries) > 1 {
-		return "", errors.Errorf("Multiple entries found for the user search filter '%s'", req.Filter)
+		return nil, errors.Errorf("Multiple entries found for the user search filter '%s'", req.Filter)
 	}
 
-	userDN := res.Entries[0].DN
-
-	return userDN, nil
+	return res.Entries[0], nil
 }
 
 // parseEncodedToken parses base64 encode token
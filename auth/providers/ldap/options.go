@@ -17,12 +17,14 @@ limitations under the License.
 package ldap
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"os"
 
 	"github.com/appscode/go/types"
+	"github.com/appscode/guard/util/httpclient"
 
 	"github.com/go-ldap/ldap"
 	"github.com/pkg/errors"
@@ -73,6 +75,11 @@ type Options struct {
 	// default: cn
 	GroupNameAttribute string
 
+	// Ldap attribute carrying the stable identifier of the user, returned in
+	// user.uid when --populate-user-uid is set
+	// default: entryUUID (objectGUID for Active Directory)
+	UIDAttribute string
+
 	SkipTLSVerification bool
 
 	// for LDAP over SSL
@@ -86,6 +93,12 @@ type Options struct {
 
 	CaCertPool *x509.CertPool
 
+	// client certificate presented to the LDAP server, for servers that
+	// require mutual TLS
+	ClientCert httpclient.ClientCertConfig
+
+	clientCertificate *tls.Certificate
+
 	// LDAP user authentication mechanism
 	// 0 for simple authentication
 	// 1 for kerberos(via GSSAPI)
@@ -131,6 +144,15 @@ func (o *Options) Configure() error {
 		}
 	}
 
+	// client certificate for mutual TLS to the LDAP server
+	if o.ClientCert.Enabled() {
+		cert, err := o.ClientCert.Certificate()
+		if err != nil {
+			return err
+		}
+		o.clientCertificate = &cert
+	}
+
 	// keytab required for kerberos
 	if o.AuthenticationChoice == AuthChoiceKerberos {
 		var err error
@@ -158,10 +180,12 @@ func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.GroupSearchFilter, "ldap.group-search-filter", DefaultGroupSearchFilter, "Filter to apply when searching the groups that user is member of")
 	fs.StringVar(&o.GroupMemberAttribute, "ldap.group-member-attribute", DefaultGroupMemberAttribute, "Ldap group member attribute")
 	fs.StringVar(&o.GroupNameAttribute, "ldap.group-name-attribute", DefaultGroupNameAttribute, "Ldap group name attribute")
+	fs.StringVar(&o.UIDAttribute, "ldap.uid-attribute", DefaultUIDAttribute, "Ldap attribute carrying the stable user identifier, returned in user.uid when --populate-user-uid is set")
 	fs.BoolVar(&o.SkipTLSVerification, "ldap.skip-tls-verification", false, "Skip LDAP server TLS verification, default : false")
 	fs.BoolVar(&o.IsSecureLDAP, "ldap.is-secure-ldap", false, "Secure LDAP (LDAPS)")
 	fs.BoolVar(&o.StartTLS, "ldap.start-tls", false, "Start tls connection")
 	fs.StringVar(&o.CaCertFile, "ldap.ca-cert-file", "", "ca cert file that used for self signed server certificate")
+	o.ClientCert.AddFlagsWithPrefix(fs, "ldap.")
 	fs.Var(&o.AuthenticationChoice, "ldap.auth-choice", "LDAP user authentication mechanisms Simple/Kerberos(via GSSAPI)")
 	fs.StringVar(&o.KeytabFile, "ldap.keytab-file", "", "path to the keytab file, it's contain LDAP service principal keys")
 	fs.StringVar(&o.ServiceAccountName, "ldap.service-account", "", "service account name")
@@ -170,7 +194,7 @@ func (o *Options) AddFlags(fs *pflag.FlagSet) {
 // request to search user
 func (o *Options) newUserSearchRequest(username string) *ldap.SearchRequest {
 	userFilter := fmt.Sprintf("(&%s(%s=%s))", o.UserSearchFilter, o.UserAttribute, username)
-	return &ldap.SearchRequest{
+	req := &ldap.SearchRequest{
 		BaseDN:       o.UserSearchDN,
 		Scope:        ldap.ScopeWholeSubtree,
 		DerefAliases: ldap.NeverDerefAliases,
@@ -179,6 +203,12 @@ func (o *Options) newUserSearchRequest(username string) *ldap.SearchRequest {
 		TypesOnly:    false,
 		Filter:       userFilter, // filter default format : (&(objectClass=person)(uid=%s))
 	}
+	if o.UIDAttribute != "" {
+		// entryUUID is operational in most servers and must be requested
+		// explicitly; requesting it never hurts when unused
+		req.Attributes = []string{o.UIDAttribute}
+	}
+	return req
 }
 
 // request to get user group list
@@ -225,9 +255,51 @@ func (o *Options) Validate() []error {
 	if o.AuthenticationChoice == AuthChoiceKerberos && o.KeytabFile == "" {
 		errs = append(errs, errors.New("for kerberos ldap.keytab-file must be non-empty"))
 	}
+	errs = append(errs, o.ClientCert.Validate("ldap.")...)
 	return errs
 }
 
+// ValidateCredentials connects to the LDAP server and binds with the
+// configured credentials, so a bad bind DN or password is caught before the
+// generated objects hit the cluster. The user and group searches are not
+// exercised, as they need a user token.
+func (o Options) ValidateCredentials() error {
+	if err := o.Configure(); err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         o.ServerAddress,
+		InsecureSkipVerify: o.SkipTLSVerification,
+	}
+	if o.CaCertFile != "" {
+		tlsConfig.RootCAs = o.CaCertPool
+	}
+	if o.clientCertificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*o.clientCertificate}
+	}
+
+	s := Authenticator{opts: o}
+	conn, err := s.dial(tlsConfig)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create ldap connector for %s:%s", o.ServerAddress, o.ServerPort)
+	}
+	defer conn.Close()
+
+	if o.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			return errors.Wrap(err, "unable to setup TLS connection")
+		}
+	}
+
+	if o.BindDN != "" && o.BindPassword != "" {
+		if err := conn.Bind(o.BindDN, o.BindPassword); err != nil {
+			return errors.Wrapf(err, "failed to bind as %s", o.BindDN)
+		}
+	}
+	return nil
+}
+
 func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
 	container := d.Spec.Template.Spec.Containers[0]
 
@@ -250,6 +322,18 @@ func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err erro
 		}
 		ldapData["krb5.keytab"] = key
 	}
+	if o.ClientCert.Enabled() {
+		cert, err := ioutil.ReadFile(o.ClientCert.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ioutil.ReadFile(o.ClientCert.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		ldapData["client.crt"] = cert
+		ldapData["client.key"] = key
+	}
 	authSecret := &core.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "guard-ldap-auth",
@@ -320,6 +404,9 @@ func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err erro
 	if o.UserSearchFilter != "" {
 		args = append(args, fmt.Sprintf("--ldap.user-attribute=%s", o.UserAttribute))
 	}
+	if o.UIDAttribute != "" {
+		args = append(args, fmt.Sprintf("--ldap.uid-attribute=%s", o.UIDAttribute))
+	}
 	if o.GroupSearchDN != "" {
 		args = append(args, fmt.Sprintf("--ldap.group-search-dn=%s", o.GroupSearchDN))
 	}
@@ -344,6 +431,10 @@ func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err erro
 	if o.CaCertFile != "" {
 		args = append(args, "--ldap.ca-cert-file=/etc/guard/auth/ldap/ca.crt")
 	}
+	if o.ClientCert.Enabled() {
+		args = append(args, "--ldap.client-cert-file=/etc/guard/auth/ldap/client.crt")
+		args = append(args, "--ldap.client-key-file=/etc/guard/auth/ldap/client.key")
+	}
 	if o.ServiceAccountName != "" {
 		args = append(args, fmt.Sprintf("--ldap.service-account=%s", o.ServiceAccountName))
 	}
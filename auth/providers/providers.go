@@ -21,11 +21,14 @@ import (
 	"strings"
 
 	"github.com/appscode/guard/auth"
+	_ "github.com/appscode/guard/auth/providers/aws"
 	_ "github.com/appscode/guard/auth/providers/azure"
 	_ "github.com/appscode/guard/auth/providers/github"
 	_ "github.com/appscode/guard/auth/providers/gitlab"
 	_ "github.com/appscode/guard/auth/providers/google"
+	_ "github.com/appscode/guard/auth/providers/keycloak"
 	_ "github.com/appscode/guard/auth/providers/ldap"
+	_ "github.com/appscode/guard/auth/providers/saml"
 	_ "github.com/appscode/guard/auth/providers/token"
 
 	"github.com/pkg/errors"
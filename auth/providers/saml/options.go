@@ -0,0 +1,156 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package saml
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/appscode/go/types"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type Options struct {
+	// path to the IdP metadata XML; the issuer entityID and the signing
+	// certificates are taken from it
+	IdPMetadataFile string
+	// audience (SP entityID) assertions must be restricted to
+	Audience string
+	// attribute whose values are mapped to Kubernetes groups
+	GroupsAttribute string
+	// attribute used as the username instead of the subject NameID
+	UsernameAttribute string
+	// allowed clock skew when checking assertion validity windows
+	ClockSkew time.Duration
+	// PEM certificate of a SAML-to-JWT gateway; when set, JWTs signed by
+	// the gateway are accepted alongside raw assertions
+	GatewayCertFile string
+}
+
+func NewOptions() Options {
+	return Options{
+		GroupsAttribute: "groups",
+		ClockSkew:       2 * time.Minute,
+	}
+}
+
+func (o *Options) Configure() error {
+	return nil
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.IdPMetadataFile, "saml.idp-metadata-file", o.IdPMetadataFile, "Path to the IdP metadata XML file, used for the issuer entityID and signing certificates")
+	fs.StringVar(&o.Audience, "saml.audience", o.Audience, "Audience (SP entityID) assertions must be restricted to")
+	fs.StringVar(&o.GroupsAttribute, "saml.groups-attribute", o.GroupsAttribute, "Name or friendly name of the attribute whose values are mapped to Kubernetes groups")
+	fs.StringVar(&o.UsernameAttribute, "saml.username-attribute", o.UsernameAttribute, "Name or friendly name of the attribute used as the username, keep empty to use the subject NameID")
+	fs.DurationVar(&o.ClockSkew, "saml.clock-skew", o.ClockSkew, "Allowed clock skew when checking assertion validity windows")
+	fs.StringVar(&o.GatewayCertFile, "saml.gateway-cert-file", o.GatewayCertFile, "Path to the PEM certificate of a SAML-to-JWT gateway, keep empty to accept raw assertions only")
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	if o.IdPMetadataFile == "" {
+		errs = append(errs, errors.New("saml.idp-metadata-file must be non-empty"))
+	} else if _, _, err := loadIdPMetadata(o.IdPMetadataFile); err != nil {
+		errs = append(errs, err)
+	}
+	if o.Audience == "" {
+		errs = append(errs, errors.New("saml.audience must be non-empty"))
+	}
+	if o.GatewayCertFile != "" {
+		if _, err := loadGatewayCert(o.GatewayCertFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
+	container := d.Spec.Template.Spec.Containers[0]
+
+	// create auth secret
+	metadata, err := ioutil.ReadFile(o.IdPMetadataFile)
+	if err != nil {
+		return nil, err
+	}
+	samlData := map[string][]byte{
+		"metadata.xml": metadata,
+	}
+	if o.GatewayCertFile != "" {
+		cert, err := ioutil.ReadFile(o.GatewayCertFile)
+		if err != nil {
+			return nil, err
+		}
+		samlData["gateway.crt"] = cert
+	}
+	authSecret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "guard-saml-auth",
+			Namespace: d.Namespace,
+			Labels:    d.Labels,
+		},
+		Data: samlData,
+	}
+	extraObjs = append(extraObjs, authSecret)
+
+	// mount auth secret into deployment
+	volMount := core.VolumeMount{
+		Name:      authSecret.Name,
+		MountPath: "/etc/guard/auth/saml",
+	}
+	container.VolumeMounts = append(container.VolumeMounts, volMount)
+
+	vol := core.Volume{
+		Name: authSecret.Name,
+		VolumeSource: core.VolumeSource{
+			Secret: &core.SecretVolumeSource{
+				SecretName:  authSecret.Name,
+				DefaultMode: types.Int32P(0444),
+			},
+		},
+	}
+	d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, vol)
+
+	// use auth secret in container[0] args
+	args := container.Args
+	args = append(args, "--saml.idp-metadata-file=/etc/guard/auth/saml/metadata.xml")
+	if o.Audience != "" {
+		args = append(args, fmt.Sprintf("--saml.audience=%s", o.Audience))
+	}
+	if o.GroupsAttribute != "" {
+		args = append(args, fmt.Sprintf("--saml.groups-attribute=%s", o.GroupsAttribute))
+	}
+	if o.UsernameAttribute != "" {
+		args = append(args, fmt.Sprintf("--saml.username-attribute=%s", o.UsernameAttribute))
+	}
+	args = append(args, fmt.Sprintf("--saml.clock-skew=%v", o.ClockSkew))
+	if o.GatewayCertFile != "" {
+		args = append(args, "--saml.gateway-cert-file=/etc/guard/auth/saml/gateway.crt")
+	}
+
+	container.Args = args
+	d.Spec.Template.Spec.Containers[0] = container
+
+	return extraObjs, nil
+}
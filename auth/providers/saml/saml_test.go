@@ -0,0 +1,233 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package saml
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/dgrijalva/jwt-go"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "https://kubernetes.example.com"
+)
+
+func genKeyAndCert(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, der
+}
+
+func writeMetadata(t *testing.T, dir string, certDER []byte) string {
+	metadata := fmt.Sprintf(`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s"><md:IDPSSODescriptor><md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor></md:IDPSSODescriptor></md:EntityDescriptor>`,
+		testIssuer, base64.StdEncoding.EncodeToString(certDER))
+	path := filepath.Join(dir, "metadata.xml")
+	if err := ioutil.WriteFile(path, []byte(metadata), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testAssertion(notBefore, notOnOrAfter time.Time) string {
+	return fmt.Sprintf(`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_a1b2c3" Version="2.0" IssueInstant="%s"><saml:Issuer>%s</saml:Issuer><saml:Subject><saml:NameID>jane@example.com</saml:NameID><saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer"><saml:SubjectConfirmationData NotOnOrAfter="%s"></saml:SubjectConfirmationData></saml:SubjectConfirmation></saml:Subject><saml:Conditions NotBefore="%s" NotOnOrAfter="%s"><saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction></saml:Conditions><saml:AttributeStatement><saml:Attribute Name="groups"><saml:AttributeValue>dev</saml:AttributeValue><saml:AttributeValue>ops</saml:AttributeValue></saml:Attribute></saml:AttributeStatement></saml:Assertion>`,
+		notBefore.Format(time.RFC3339), testIssuer, notOnOrAfter.Format(time.RFC3339),
+		notBefore.Format(time.RFC3339), notOnOrAfter.Format(time.RFC3339), testAudience)
+}
+
+// signAssertion envelopes a signature into the assertion the way an IdP
+// does, signing the exclusive canonical form of the assertion.
+func signAssertion(t *testing.T, key *rsa.PrivateKey, certDER []byte, assertionXML string) string {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(assertionXML); err != nil {
+		t.Fatal(err)
+	}
+	signingCtx := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  key,
+	}))
+	signed, err := signingCtx.SignEnveloped(doc.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedDoc := etree.NewDocument()
+	signedDoc.SetRoot(signed)
+	out, err := signedDoc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func newTestAuthenticator(t *testing.T, key *rsa.PrivateKey, certDER []byte, optsFunc func(*Options)) Authenticator {
+	dir, err := ioutil.TempDir("", "saml-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	opts := NewOptions()
+	opts.IdPMetadataFile = writeMetadata(t, dir, certDER)
+	opts.Audience = testAudience
+	if optsFunc != nil {
+		optsFunc(&opts)
+	}
+
+	client, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *client.(*Authenticator)
+}
+
+func TestCheckAssertion(t *testing.T) {
+	key, certDER := genKeyAndCert(t)
+	a := newTestAuthenticator(t, key, certDER, nil)
+
+	signed := signAssertion(t, key, certDER, testAssertion(time.Now().Add(-time.Minute), time.Now().Add(5*time.Minute)))
+	token := base64.StdEncoding.EncodeToString([]byte(signed))
+
+	resp, err := a.Check(context.Background(), token)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "jane@example.com", resp.Username)
+		assert.Equal(t, []string{"dev", "ops"}, resp.Groups)
+	}
+}
+
+func TestCheckAssertionTampered(t *testing.T) {
+	key, certDER := genKeyAndCert(t)
+	a := newTestAuthenticator(t, key, certDER, nil)
+
+	signed := signAssertion(t, key, certDER, testAssertion(time.Now().Add(-time.Minute), time.Now().Add(5*time.Minute)))
+	tampered := strings.Replace(signed, "jane@example.com", "mallory@example.com", 1)
+	_, err := a.Check(context.Background(), base64.StdEncoding.EncodeToString([]byte(tampered)))
+	assert.Error(t, err)
+}
+
+func TestCheckAssertionUnknownSigner(t *testing.T) {
+	_, certDER := genKeyAndCert(t)
+	otherKey, otherCertDER := genKeyAndCert(t)
+	a := newTestAuthenticator(t, otherKey, certDER, nil)
+
+	signed := signAssertion(t, otherKey, otherCertDER, testAssertion(time.Now().Add(-time.Minute), time.Now().Add(5*time.Minute)))
+	_, err := a.Check(context.Background(), base64.StdEncoding.EncodeToString([]byte(signed)))
+	assert.Error(t, err)
+}
+
+func TestCheckAssertionExpired(t *testing.T) {
+	key, certDER := genKeyAndCert(t)
+	a := newTestAuthenticator(t, key, certDER, nil)
+
+	signed := signAssertion(t, key, certDER, testAssertion(time.Now().Add(-time.Hour), time.Now().Add(-30*time.Minute)))
+	_, err := a.Check(context.Background(), base64.StdEncoding.EncodeToString([]byte(signed)))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "expired")
+	}
+}
+
+func TestCheckAssertionWrongAudience(t *testing.T) {
+	key, certDER := genKeyAndCert(t)
+	a := newTestAuthenticator(t, key, certDER, func(o *Options) {
+		o.Audience = "https://other.example.com"
+	})
+
+	signed := signAssertion(t, key, certDER, testAssertion(time.Now().Add(-time.Minute), time.Now().Add(5*time.Minute)))
+	_, err := a.Check(context.Background(), base64.StdEncoding.EncodeToString([]byte(signed)))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "audience")
+	}
+}
+
+func TestCheckGatewayToken(t *testing.T) {
+	key, certDER := genKeyAndCert(t)
+
+	dir, err := ioutil.TempDir("", "saml-gateway-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certPath := filepath.Join(dir, "gateway.crt")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestAuthenticator(t, key, certDER, func(o *Options) {
+		o.GatewayCertFile = certPath
+	})
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"nameid": "jane@example.com",
+		"groups": []string{"dev", "ops"},
+		"exp":    time.Now().Add(5 * time.Minute).Unix(),
+	}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := a.Check(context.Background(), token)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "jane@example.com", resp.Username)
+		assert.Equal(t, []string{"dev", "ops"}, resp.Groups)
+	}
+
+	otherKey, _ := genKeyAndCert(t)
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"nameid": "mallory"}).SignedString(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.Check(context.Background(), forged)
+	assert.Error(t, err)
+}
+
+func TestOptionsValidate(t *testing.T) {
+	opts := NewOptions()
+	errs := opts.Validate()
+	if assert.Len(t, errs, 2) {
+		assert.Contains(t, errs[0].Error(), "saml.idp-metadata-file")
+		assert.Contains(t, errs[1].Error(), "saml.audience")
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 
 	"github.com/appscode/go/types"
+	"github.com/appscode/guard/auth"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
@@ -31,6 +32,14 @@ import (
 
 type Options struct {
 	AuthFile string
+	// ExistingSecret is the name of a pre-created Secret holding the key
+	// token.csv; the installer mounts it instead of reading the token file
+	// and embedding it into the generated manifest
+	ExistingSecret string
+	// SecretsStoreProviderClass is the name of a SecretProviderClass whose
+	// objects include token.csv; the installer mounts it through the Secrets
+	// Store CSI driver, so the tokens never exist as a Kubernetes Secret
+	SecretsStoreProviderClass string
 }
 
 func NewOptions() Options {
@@ -39,62 +48,74 @@ func NewOptions() Options {
 
 func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.AuthFile, "token-auth-file", "", "To enable static token authentication")
+	fs.StringVar(&o.ExistingSecret, "token-auth-existing-secret", o.ExistingSecret, "Name of a pre-created Secret with key token.csv, mounted by the installer instead of embedding token-auth-file into the generated manifest")
+	fs.StringVar(&o.SecretsStoreProviderClass, "token-auth-secrets-store-provider-class", o.SecretsStoreProviderClass, "Name of a SecretProviderClass with an object aliased token.csv, mounted by the installer through the Secrets Store CSI driver instead of a Kubernetes Secret")
 }
 
 func (o *Options) Validate() []error {
 	var errs []error
-	if o.AuthFile == "" {
+	if o.AuthFile == "" && o.ExistingSecret == "" && o.SecretsStoreProviderClass == "" {
 		errs = append(errs, errors.New("token-auth-file must be non-empty"))
 	}
+	if o.ExistingSecret != "" && o.SecretsStoreProviderClass != "" {
+		errs = append(errs, errors.New("token-auth-existing-secret and token-auth-secrets-store-provider-class are mutually exclusive"))
+	}
 	return errs
 }
 
 func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
 	container := d.Spec.Template.Spec.Containers[0]
 
-	// create auth secret
-	_, err = LoadTokenFile(o.AuthFile)
-	if err != nil {
-		return nil, err
-	}
-	tokens, err := ioutil.ReadFile(o.AuthFile)
-	if err != nil {
-		return nil, err
-	}
-	authSecret := &core.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "guard-token-auth",
-			Namespace: d.Namespace,
-			Labels:    d.Labels,
-		},
-		Data: map[string][]byte{
-			"token.csv": tokens,
-		},
+	var vol core.Volume
+	if o.SecretsStoreProviderClass != "" {
+		// the CSI driver pulls token.csv from the external vault at pod start
+		vol = auth.SecretsStoreVolume("guard-token-auth", o.SecretsStoreProviderClass)
+	} else {
+		secretName := o.ExistingSecret
+		if secretName == "" {
+			// create auth secret
+			_, err = LoadTokenFile(o.AuthFile)
+			if err != nil {
+				return nil, err
+			}
+			tokens, err := ioutil.ReadFile(o.AuthFile)
+			if err != nil {
+				return nil, err
+			}
+			authSecret := &core.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "guard-token-auth",
+					Namespace: d.Namespace,
+					Labels:    d.Labels,
+				},
+				Data: map[string][]byte{
+					"token.csv": tokens,
+				},
+			}
+			extraObjs = append(extraObjs, authSecret)
+			secretName = authSecret.Name
+		}
+		vol = core.Volume{
+			Name: secretName,
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{
+					SecretName:  secretName,
+					DefaultMode: types.Int32P(0555),
+				},
+			},
+		}
 	}
-	extraObjs = append(extraObjs, authSecret)
 
 	// mount auth secret into deployment
 	volMount := core.VolumeMount{
-		Name:      authSecret.Name,
+		Name:      vol.Name,
 		MountPath: "/etc/guard/auth/token",
 	}
 	container.VolumeMounts = append(container.VolumeMounts, volMount)
-
-	vol := core.Volume{
-		Name: authSecret.Name,
-		VolumeSource: core.VolumeSource{
-			Secret: &core.SecretVolumeSource{
-				SecretName:  authSecret.Name,
-				DefaultMode: types.Int32P(0555),
-			},
-		},
-	}
 	d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, vol)
 
 	// use auth secret in container[0] args
-	if o.AuthFile != "" {
-		container.Args = append(container.Args, "--token-auth-file=/etc/guard/auth/token/token.csv")
-	}
+	container.Args = append(container.Args, "--token-auth-file=/etc/guard/auth/token/token.csv")
 	d.Spec.Template.Spec.Containers[0] = container
 
 	return extraObjs, nil
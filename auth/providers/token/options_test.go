@@ -43,11 +43,11 @@ func TestOptionsValidate(t *testing.T) {
 		opts        Options
 		expectedErr []error
 	}{
-		{Options{empty},
+		{Options{AuthFile: empty},
 			[]error{validateData.err},
 		},
 		{
-			Options{nonempty},
+			Options{AuthFile: nonempty},
 			nil,
 		},
 	}
@@ -18,9 +18,11 @@ package token
 
 import (
 	"bufio"
+	"context"
 	"encoding/csv"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 
@@ -67,7 +69,7 @@ func (s *Authenticator) UID() string {
 	return OrgType
 }
 
-func (s *Authenticator) Check(token string) (*authv1.UserInfo, error) {
+func (s *Authenticator) Check(ctx context.Context, token string) (*authv1.UserInfo, error) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
@@ -78,6 +80,25 @@ func (s *Authenticator) Check(token string) (*authv1.UserInfo, error) {
 	return &user, nil
 }
 
+// Users returns the sorted, de-duplicated user names currently loaded from the
+// token file. It is the redacted view of the file used in reload diffs; token
+// values never leave this package.
+func (s *Authenticator) Users() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	set := map[string]struct{}{}
+	for _, user := range s.tokenMap {
+		set[user.Username] = struct{}{}
+	}
+	users := make([]string, 0, len(set))
+	for u := range set {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users
+}
+
 //https://kubernetes.io/docs/admin/authentication/#static-token-file
 //csv token file:
 //  - four field required (format : token,user,uid,"group1,group2,group3")
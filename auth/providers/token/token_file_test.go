@@ -17,6 +17,7 @@ limitations under the License.
 package token
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -298,7 +299,7 @@ func TestCheckTokenAuth(t *testing.T) {
 
 	for _, testData := range dataset {
 		t.Run(testData.testName, func(t *testing.T) {
-			resp, err := srv.Check(testData.token)
+			resp, err := srv.Check(context.Background(), testData.token)
 
 			t.Log(srv.tokenMap)
 			t.Log("token :", testData.token)
@@ -0,0 +1,46 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+import (
+	"github.com/appscode/go/types"
+
+	core "k8s.io/api/core/v1"
+)
+
+// SecretsStoreCSIDriver is the driver name of the Kubernetes Secrets Store
+// CSI driver.
+const SecretsStoreCSIDriver = "secrets-store.csi.k8s.io"
+
+// SecretsStoreVolume builds a read-only CSI volume backed by the named
+// SecretProviderClass. The driver pulls the objects straight from the
+// external vault at pod start, so the credentials never exist as a
+// Kubernetes Secret.
+func SecretsStoreVolume(name, providerClass string) core.Volume {
+	return core.Volume{
+		Name: name,
+		VolumeSource: core.VolumeSource{
+			CSI: &core.CSIVolumeSource{
+				Driver:   SecretsStoreCSIDriver,
+				ReadOnly: types.BoolP(true),
+				VolumeAttributes: map[string]string{
+					"secretProviderClass": providerClass,
+				},
+			},
+		},
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package auth
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"sort"
@@ -60,5 +61,7 @@ func (o orgs) String() string {
 
 type Interface interface {
 	UID() string
-	Check(token string) (*authv1.UserInfo, error)
+	// Check validates the token. ctx carries per-request values such as the
+	// correlation id and is honored on outbound provider calls.
+	Check(ctx context.Context, token string) (*authv1.UserInfo, error)
 }
@@ -0,0 +1,35 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package auth
+
+// populateUID controls whether providers return the stable directory
+// identifier of the user (AAD oid, LDAP entryUUID) in user.uid. It is off by
+// default because a uid showing up in API server audit logs is a behavior
+// change for existing clusters.
+var populateUID bool
+
+// SetPopulateUID installs the uid policy. It is meant to be called once at
+// startup, before the server accepts requests.
+func SetPopulateUID(enabled bool) {
+	populateUID = enabled
+}
+
+// PopulateUID reports whether providers should return the directory
+// identifier of the user in user.uid.
+func PopulateUID() bool {
+	return populateUID
+}
@@ -0,0 +1,53 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// resultCacheKeyVersion is the version of the result cache key format. It is
+// bumped whenever the composition of the keys changes, so verdicts written by
+// an older guard never produce wrong hits against a shared store after an
+// upgrade: old entries live under a different prefix, are never read again
+// and age out of the store on their own.
+const resultCacheKeyVersion = "v2"
+
+// VersionedCacheKey prefixes a result cache key with the current key format
+// version. Every provider that stores verdicts must build its keys through
+// this helper.
+func VersionedCacheKey(key string) string {
+	return resultCacheKeyVersion + "|" + key
+}
+
+// GroupsDigest returns a short deterministic digest of the group memberships
+// of a subject access review, for inclusion in result cache keys. The groups
+// are sorted first, so the digest does not depend on the order the API server
+// sends them. A subject with no groups yields "-".
+func GroupsDigest(groups []string) string {
+	if len(groups) == 0 {
+		return "-"
+	}
+	sorted := make([]string, len(groups))
+	copy(sorted, groups)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
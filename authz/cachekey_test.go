@@ -0,0 +1,41 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionedCacheKey(t *testing.T) {
+	assert.Equal(t, "v2|tenant/user/ns/pods/get", VersionedCacheKey("tenant/user/ns/pods/get"))
+}
+
+func TestGroupsDigest(t *testing.T) {
+	assert.Equal(t, "-", GroupsDigest(nil))
+	assert.Equal(t, "-", GroupsDigest([]string{}))
+
+	// deterministic regardless of order, distinct for distinct memberships
+	assert.Equal(t, GroupsDigest([]string{"a", "b"}), GroupsDigest([]string{"b", "a"}))
+	assert.NotEqual(t, GroupsDigest([]string{"a", "b"}), GroupsDigest([]string{"a"}))
+
+	// the input slice is not reordered
+	groups := []string{"b", "a"}
+	GroupsDigest(groups)
+	assert.Equal(t, []string{"b", "a"}, groups)
+}
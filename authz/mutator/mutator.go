@@ -0,0 +1,262 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mutator post-processes authorization decisions after the authz
+// provider has answered. Operators declare mutation rules in a file — e.g.
+// downgrade a deny to no opinion for a break-glass group, or append a reason
+// pointing at internal documentation — so decision policy can be adjusted
+// without forking provider code.
+package mutator
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// decisions a rule can match on and set
+	DecisionAllowed   = "allowed"
+	DecisionDenied    = "denied"
+	DecisionNoOpinion = "no-opinion"
+)
+
+// Interface mutates a subject access review status in place. Implementations
+// must not mutate the request spec.
+type Interface interface {
+	Mutate(request *authzv1beta1.SubjectAccessReviewSpec, status *authzv1beta1.SubjectAccessReviewStatus)
+}
+
+// Chain applies each mutator in order; later mutators see the decisions left
+// by earlier ones. It lets in-process hooks be combined with the file-driven
+// rules without the handler knowing about either.
+type Chain []Interface
+
+func (c Chain) Mutate(request *authzv1beta1.SubjectAccessReviewSpec, status *authzv1beta1.SubjectAccessReviewStatus) {
+	for _, m := range c {
+		m.Mutate(request, status)
+	}
+}
+
+// Config is the root document of the mutator file.
+type Config struct {
+	Mutators []Rule `json:"mutators"`
+}
+
+// Rule mutates the decisions it matches. Users and Groups select the subjects
+// the rule applies to with the same trailing * wildcard the policy file uses;
+// both empty means every subject. Decisions limits the rule to reviews that
+// came back with one of the named decisions; empty means every decision.
+type Rule struct {
+	Name string `json:"name"`
+
+	Users     []string `json:"users,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	Decisions []string `json:"decisions,omitempty"`
+
+	// SetDecision downgrades the decision; no-opinion is the only valid value,
+	// so a mutator can soften a deny but never manufacture an allow.
+	SetDecision string `json:"setDecision,omitempty"`
+	// AppendReason is appended to the status reason when the rule fires.
+	AppendReason string `json:"appendReason,omitempty"`
+	// Log emits a log event whenever the rule fires.
+	Log bool `json:"log,omitempty"`
+}
+
+// Mutators applies the rules loaded from the mutator file. Like the policy
+// file authorizer it reloads the file when its modification time moves, and a
+// broken edit keeps the last loaded rules in effect.
+type Mutators struct {
+	mutatorFile string
+
+	lock    sync.RWMutex
+	config  *Config
+	modTime time.Time
+}
+
+func New(opts Options) (*Mutators, error) {
+	glog.Info("Loading authz decision mutators")
+	m := &Mutators{mutatorFile: opts.MutatorFile}
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Mutators) Mutate(request *authzv1beta1.SubjectAccessReviewSpec, status *authzv1beta1.SubjectAccessReviewStatus) {
+	if request == nil || status == nil {
+		return
+	}
+
+	if err := m.reloadIfChanged(); err != nil {
+		glog.Errorf("failed to reload mutator file, keeping the last loaded rules: %s", err)
+	}
+
+	m.lock.RLock()
+	config := m.config
+	m.lock.RUnlock()
+
+	for _, rule := range config.Mutators {
+		// decide per rule, so a rule can react to the decision left by an
+		// earlier rule in the file
+		decision := decisionOf(status)
+		if !rule.matches(request, decision) {
+			continue
+		}
+		if rule.SetDecision == DecisionNoOpinion && decision != DecisionNoOpinion {
+			status.Allowed = false
+			status.Denied = false
+		}
+		if rule.AppendReason != "" {
+			if status.Reason != "" {
+				status.Reason += "; "
+			}
+			status.Reason += rule.AppendReason
+		}
+		if rule.Log {
+			glog.Infof("mutator %s fired for user %s: %s -> %s", rule.Name, request.User, decision, decisionOf(status))
+		}
+	}
+}
+
+// reloadIfChanged reloads the rules when the file's modification time moved,
+// so edits take effect without a restart.
+func (m *Mutators) reloadIfChanged() error {
+	info, err := os.Stat(m.mutatorFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to stat mutator file")
+	}
+	m.lock.RLock()
+	unchanged := info.ModTime().Equal(m.modTime)
+	m.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+	return m.reload()
+}
+
+func (m *Mutators) reload() error {
+	info, err := os.Stat(m.mutatorFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to stat mutator file")
+	}
+	config, err := loadConfig(m.mutatorFile)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	m.config = config
+	m.modTime = info.ModTime()
+	m.lock.Unlock()
+	glog.Infof("loaded %d mutator rules from %s", len(config.Mutators), m.mutatorFile)
+	return nil
+}
+
+// loadConfig reads and validates the mutator file.
+func loadConfig(mutatorFile string) (*Config, error) {
+	data, err := ioutil.ReadFile(mutatorFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read mutator file")
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, errors.Wrap(err, "unable to parse mutator file")
+	}
+	for i, rule := range config.Mutators {
+		if rule.Name == "" {
+			return nil, errors.Errorf("mutator rule %d has no name", i)
+		}
+		if rule.SetDecision == "" && rule.AppendReason == "" && !rule.Log {
+			return nil, errors.Errorf("mutator rule %s has no action", rule.Name)
+		}
+		switch rule.SetDecision {
+		case "", DecisionNoOpinion:
+		default:
+			return nil, errors.Errorf("mutator rule %s has invalid setDecision %s. valid value is no-opinion", rule.Name, rule.SetDecision)
+		}
+		for _, d := range rule.Decisions {
+			switch d {
+			case DecisionAllowed, DecisionDenied, DecisionNoOpinion:
+			default:
+				return nil, errors.Errorf("mutator rule %s matches invalid decision %s. valid values are allowed, denied and no-opinion", rule.Name, d)
+			}
+		}
+	}
+	return config, nil
+}
+
+// matches reports whether the rule applies to the given review and decision.
+func (r Rule) matches(request *authzv1beta1.SubjectAccessReviewSpec, decision string) bool {
+	if len(r.Decisions) > 0 && !contains(r.Decisions, decision) {
+		return false
+	}
+	if len(r.Users) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+	for _, pattern := range r.Users {
+		if match(pattern, request.User) {
+			return true
+		}
+	}
+	for _, pattern := range r.Groups {
+		for _, group := range request.Groups {
+			if match(pattern, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decisionOf labels the decision carried by the status.
+func decisionOf(status *authzv1beta1.SubjectAccessReviewStatus) string {
+	switch {
+	case status.Allowed:
+		return DecisionAllowed
+	case status.Denied:
+		return DecisionDenied
+	default:
+		return DecisionNoOpinion
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, e := range list {
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
+
+// match compares a value against a pattern that may end in a * wildcard.
+func match(pattern, value string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == value
+}
@@ -0,0 +1,152 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+const testConfig = `
+mutators:
+- name: break-glass
+  groups:
+  - break-glass
+  decisions:
+  - denied
+  setDecision: no-opinion
+  appendReason: deny downgraded for break-glass access
+  log: true
+- name: docs-pointer
+  decisions:
+  - denied
+  appendReason: see https://wiki.example.com/access
+`
+
+func newTestMutators(t *testing.T, config string) *Mutators {
+	dir, err := ioutil.TempDir("", "mutator-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "mutators.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(Options{MutatorFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMutateDowngradesDeny(t *testing.T) {
+	m := newTestMutators(t, testConfig)
+
+	spec := &authzv1beta1.SubjectAccessReviewSpec{User: "jane", Groups: []string{"break-glass"}}
+	status := &authzv1beta1.SubjectAccessReviewStatus{Denied: true, Reason: "Access denied by policy file."}
+	m.Mutate(spec, status)
+
+	assert.False(t, status.Denied)
+	assert.False(t, status.Allowed)
+	// the second rule no longer matches because the decision is no longer denied
+	assert.Equal(t, "Access denied by policy file.; deny downgraded for break-glass access", status.Reason)
+}
+
+func TestMutateAppendsReason(t *testing.T) {
+	m := newTestMutators(t, testConfig)
+
+	spec := &authzv1beta1.SubjectAccessReviewSpec{User: "john", Groups: []string{"dev"}}
+	status := &authzv1beta1.SubjectAccessReviewStatus{Denied: true}
+	m.Mutate(spec, status)
+
+	assert.True(t, status.Denied)
+	assert.Equal(t, "see https://wiki.example.com/access", status.Reason)
+}
+
+func TestMutateLeavesUnmatchedDecisions(t *testing.T) {
+	m := newTestMutators(t, testConfig)
+
+	spec := &authzv1beta1.SubjectAccessReviewSpec{User: "jane", Groups: []string{"break-glass"}}
+	status := &authzv1beta1.SubjectAccessReviewStatus{Allowed: true, Reason: "ok"}
+	m.Mutate(spec, status)
+
+	assert.True(t, status.Allowed)
+	assert.Equal(t, "ok", status.Reason)
+}
+
+func TestMutateReloadsOnChange(t *testing.T) {
+	m := newTestMutators(t, testConfig)
+
+	updated := `
+mutators:
+- name: everyone
+  appendReason: updated
+`
+	// rewind the recorded mod time, so rewriting the file within the same
+	// second still looks like a change
+	m.lock.Lock()
+	m.modTime = m.modTime.Add(-time.Hour)
+	m.lock.Unlock()
+	if err := ioutil.WriteFile(m.mutatorFile, []byte(updated), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &authzv1beta1.SubjectAccessReviewSpec{User: "jane"}
+	status := &authzv1beta1.SubjectAccessReviewStatus{Allowed: true}
+	m.Mutate(spec, status)
+	assert.Equal(t, "updated", status.Reason)
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mutator-validate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"missing name", "mutators:\n- appendReason: x\n", "has no name"},
+		{"no action", "mutators:\n- name: x\n", "has no action"},
+		{"allow escalation rejected", "mutators:\n- name: x\n  setDecision: allowed\n", "invalid setDecision"},
+		{"bad decision", "mutators:\n- name: x\n  log: true\n  decisions: [maybe]\n", "invalid decision"},
+		{"unknown field", "rules:\n- name: x\n", "unable to parse"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, "mutators.yaml")
+			if err := ioutil.WriteFile(path, []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadConfig(path)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.errMsg)
+			}
+		})
+	}
+}
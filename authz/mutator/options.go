@@ -0,0 +1,45 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutator
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+type Options struct {
+	// path to the YAML or JSON mutator file; empty disables mutation
+	MutatorFile string
+}
+
+func NewOptions() Options {
+	return Options{}
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.MutatorFile, "authz-mutator-file", o.MutatorFile, "path to the YAML or JSON file of decision mutation rules applied to authz verdicts before they are returned. The file is reloaded when it changes")
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	if o.MutatorFile != "" {
+		if _, err := loadConfig(o.MutatorFile); err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid authz-mutator-file"))
+		}
+	}
+	return errs
+}
@@ -5,7 +5,7 @@ Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
 You may obtain a copy of the License at
 
-    http://www.apache.org/licenses/LICENSE-2.0
+	http://www.apache.org/licenses/LICENSE-2.0
 
 Unless required by applicable law or agreed to in writing, software
 distributed under the License is distributed on an "AS IS" BASIS,
@@ -16,13 +16,16 @@ limitations under the License.
 package azure
 
 import (
+	"context"
 	"strings"
 	"sync"
+	"time"
 
 	auth "github.com/appscode/guard/auth/providers/azure"
 	"github.com/appscode/guard/authz"
 	authzOpts "github.com/appscode/guard/authz/providers/azure/options"
 	"github.com/appscode/guard/authz/providers/azure/rbac"
+	"github.com/appscode/guard/util/featuregates"
 
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/golang/glog"
@@ -35,7 +38,7 @@ const (
 )
 
 var (
-	once   sync.Once
+	lock   sync.RWMutex
 	client authz.Interface
 	err    error
 )
@@ -45,22 +48,66 @@ func init() {
 }
 
 type Authorizer struct {
-	rbacClient *rbac.AccessInfo
+	rbacClient      *rbac.AccessInfo
+	anonymousPolicy string
+	// stopRefresher stops the client's background token refresher when the
+	// client is replaced by Refresh
+	stopRefresher chan struct{}
 }
 
 func New(opts authzOpts.Options, authopts auth.Options) (authz.Interface, error) {
-	once.Do(func() {
+	lock.RLock()
+	c, e := client, err
+	lock.RUnlock()
+	if c != nil || e != nil {
+		return c, e
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if client == nil && err == nil {
 		glog.Info("Creating Azure global authz client")
 		client, err = newAuthzClient(opts, authopts)
 		if client == nil || err != nil {
 			glog.Fatalf("Authz RBAC client creation failed. Error: %s", err)
 		}
-	})
+	}
 	return client, err
 }
 
+// Refresh rebuilds the global authz client from the given options and swaps
+// it in atomically, stopping the replaced client's token refresher. On
+// failure the current client stays in service, so a broken reload never
+// takes the authorizer down.
+func Refresh(opts authzOpts.Options, authopts auth.Options) error {
+	glog.Info("Refreshing Azure global authz client")
+	newClient, e := newAuthzClient(opts, authopts)
+	if e != nil {
+		return errors.Wrap(e, "failed to rebuild azure authz client")
+	}
+
+	lock.Lock()
+	old := client
+	client = newClient
+	err = nil
+	lock.Unlock()
+
+	// the old client left the global above, so it can only be stopped once
+	if a, ok := old.(*Authorizer); ok && a != nil && a.stopRefresher != nil {
+		close(a.stopRefresher)
+	}
+	return nil
+}
+
+// NewAuthorizer returns a fresh authz client for the given options instead
+// of the process-wide singleton New hands out. Each client runs its own
+// token refresher, so callers should create at most one per configuration.
+func NewAuthorizer(opts authzOpts.Options, authopts auth.Options) (authz.Interface, error) {
+	return newAuthzClient(opts, authopts)
+}
+
 func newAuthzClient(opts authzOpts.Options, authopts auth.Options) (authz.Interface, error) {
-	c := &Authorizer{}
+	c := &Authorizer{anonymousPolicy: opts.AnonymousPolicy, stopRefresher: make(chan struct{})}
 
 	authzInfoVal, err := getAuthzInfo(authopts.Environment)
 	if err != nil {
@@ -72,14 +119,42 @@ func newAuthzClient(opts authzOpts.Options, authopts auth.Options) (authz.Interf
 		return nil, errors.Wrap(err, "failed to create ms rbac client")
 	}
 
+	// the refresher runs until the client is replaced by Refresh, or for the
+	// lifetime of the process otherwise
+	go c.rbacClient.RunTokenRefresher(c.stopRefresher)
+
 	return c, nil
 }
 
-func (s Authorizer) Check(request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+// Healthy reports whether the authorizer holds a valid ARM token and ARM
+// accepts its checkaccess calls. It backs the /readyz probe, so both a
+// broken token provider and a missing role assignment show up there.
+func (s Authorizer) Healthy() error {
+	if s.rbacClient.IsTokenExpired() {
+		return errors.New("no valid ARM token; token provider cannot mint tokens")
+	}
+	if err := s.rbacClient.ARMAuthFailure(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s Authorizer) Check(ctx context.Context, request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, reqInfo authz.RequestInfo) (*authzv1beta1.SubjectAccessReviewStatus, error) {
 	if request == nil {
 		return nil, errors.New("subject access review is nil")
 	}
 
+	// anonymous requests always fail on ARM with oid errors, so they are
+	// answered locally per the configured policy
+	if strings.EqualFold(request.User, "system:anonymous") {
+		if s.anonymousPolicy == authzOpts.AnonymousPolicyDeny {
+			glog.V(3).Infof("returning deny to anonymous user per configured policy")
+			return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Denied: true, Reason: rbac.AccessDeniedForAnonymousVerdict}, nil
+		}
+		glog.V(3).Infof("returning no op to anonymous user")
+		return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Reason: rbac.NoOpinionVerdict}, nil
+	}
+
 	// check if user is system accounts
 	if strings.HasPrefix(strings.ToLower(request.User), "system:") {
 		glog.V(3).Infof("returning no op to system accounts")
@@ -91,6 +166,11 @@ func (s Authorizer) Check(request *authzv1beta1.SubjectAccessReviewSpec, store a
 		return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Reason: rbac.NoOpinionVerdict}, nil
 	}
 
+	if s.rbacClient.SkipAuthzCheckForSystemGroups(request) {
+		glog.V(3).Infof("user %s carries an allowlisted system: group. returning no op.", request.User)
+		return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Reason: rbac.NoOpinionVerdict}, nil
+	}
+
 	if _, ok := request.Extra["oid"]; !ok {
 		if s.rbacClient.ShouldSkipAuthzCheckForNonAADUsers() {
 			glog.V(3).Infof("Skip RBAC is set for non AAD users. Returning no opinion for user %s. You may observe this for AAD users for 'can-i' requests.", request.User)
@@ -101,36 +181,164 @@ func (s Authorizer) Check(request *authzv1beta1.SubjectAccessReviewSpec, store a
 		}
 	}
 
-	exist, result := s.rbacClient.GetResultFromCache(request, store)
-	if exist {
-		if result {
-			glog.V(3).Infof("cache hit: returning allowed to user %s", request.User)
-			return &authzv1beta1.SubjectAccessReviewStatus{Allowed: result, Reason: rbac.AccessAllowedVerdict}, nil
-		} else {
-			glog.V(3).Infof("cache hit: returning denied to user %s", request.User)
-			return &authzv1beta1.SubjectAccessReviewStatus{Allowed: result, Denied: true, Reason: rbac.AccessNotAllowedVerdict}, nil
+	resourceId, err := s.rbacClient.ResolveResourceId(reqInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve azure resource id for request")
+	}
+
+	if reqInfo.CacheBypass {
+		glog.V(3).Infof("cache bypass requested; skipping cached verdict for user %s", request.User)
+	} else {
+		exist, result := s.rbacClient.GetResultFromCache(request, store, resourceId, reqInfo.CommonName)
+		if exist {
+			if result {
+				glog.V(3).Infof("cache hit: returning allowed to user %s", request.User)
+				return &authzv1beta1.SubjectAccessReviewStatus{Allowed: result, Reason: rbac.AccessAllowedVerdict}, nil
+			} else {
+				glog.V(3).Infof("cache hit: returning denied to user %s", request.User)
+				return s.rbacClient.ApplyDeniedPolicy(&authzv1beta1.SubjectAccessReviewStatus{Allowed: result, Denied: true, Reason: rbac.AccessNotAllowedVerdict}), nil
+			}
 		}
 	}
 
 	// if set true, webhook will allow access to discovery APIs for authenticated users. If false, access check will be performed on Azure.
 	if s.rbacClient.AllowNonResPathDiscoveryAccess(request) {
 		glog.V(3).Infof("Allowing user %s access for discovery check.", request.User)
-		_ = s.rbacClient.SetResultInCache(request, true, store)
+		_ = s.rbacClient.SetResultInCache(request, true, 0, store, resourceId, reqInfo.CommonName)
 		return &authzv1beta1.SubjectAccessReviewStatus{Allowed: true, Reason: rbac.AccessAllowedVerdict}, nil
 	}
 
+	// the background refresher keeps the token fresh; this is a safety net in
+	// case it has been failing past the expiry time
 	if s.rbacClient.IsTokenExpired() {
-		_ = s.rbacClient.RefreshToken()
+		if err := s.rbacClient.RefreshToken(); err != nil && s.rbacClient.IsTokenExpired() {
+			// surface the refresh failure as its own verdict instead of letting
+			// the checkaccess call fail with a generic 401
+			glog.Errorf("returning no opinion for user %s: %s", request.User, err)
+			return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Reason: rbac.TokenRefreshFailedVerdict}, nil
+		}
 	}
 
-	response, err := s.rbacClient.CheckAccess(request)
-	if err == nil {
-		_ = s.rbacClient.SetResultInCache(request, response.Allowed, store)
-	} else {
-		_ = s.rbacClient.SetResultInCache(request, false, store)
+	return s.checkAccess(ctx, request, store, resourceId, reqInfo)
+}
+
+// CachedVerdict describes what the result cache holds for a review. It backs
+// guard's cache inspection debug endpoint.
+type CachedVerdict struct {
+	// Key is the full, versioned cache key the review maps to.
+	Key string
+	// Found reports whether the cache holds a usable verdict for the key.
+	Found bool
+	// Allowed is the cached verdict; meaningless unless Found is set.
+	Allowed bool
+	// Stale is set when the verdict is past its TTL but still within the
+	// stale limit, i.e. only the soft deadline fallback would serve it.
+	Stale bool
+}
+
+// InspectCache reports the cached verdict the review would be answered with,
+// without calling ARM and without the side effects of a real lookup.
+func (s Authorizer) InspectCache(request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, reqInfo authz.RequestInfo) (*CachedVerdict, error) {
+	if request == nil {
+		return nil, errors.New("subject access review is nil")
 	}
+	resourceId, err := s.rbacClient.ResolveResourceId(reqInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve azure resource id for request")
+	}
+	v := &CachedVerdict{Key: s.rbacClient.ResultCacheKeyFor(request, resourceId, reqInfo.CommonName)}
+	v.Found, v.Allowed, v.Stale = s.rbacClient.PeekResultFromCache(request, store, resourceId, reqInfo.CommonName)
+	return v, nil
+}
 
-	return response, err
+type checkResult struct {
+	status *authzv1beta1.SubjectAccessReviewStatus
+	ttl    time.Duration
+	err    error
+}
+
+// checkAccess performs the checkaccess call and maintains the verdict cache.
+// When a soft deadline is configured and ARM is slower, a recent stale
+// verdict is served instead, bounding webhook latency for the API server;
+// requests bypassing the cache always wait for the real verdict.
+func (s Authorizer) checkAccess(ctx context.Context, request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, resourceId string, reqInfo authz.RequestInfo) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+	clientID := reqInfo.CommonName
+	finish := func(r checkResult) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+		if r.err == nil {
+			_ = s.rbacClient.SetResultInCache(request, r.status.Allowed, r.ttl, store, resourceId, clientID)
+		} else {
+			if errors.Cause(r.err) == rbac.ErrBreakerOpen {
+				// ARM is known to be down; answer from the cache or with no
+				// opinion instead of surfacing an error for every request
+				return s.armUnavailable(request, store, resourceId, reqInfo)
+			}
+			if errors.Cause(r.err) == rbac.ErrARMAuthFailure {
+				// guard's own identity is misconfigured; say so instead of
+				// reporting a generic request failure about the user
+				glog.Errorf("returning no opinion for user %s: %s", request.User, r.err)
+				return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Reason: rbac.ARMAuthFailureVerdict}, nil
+			}
+			_ = s.rbacClient.SetResultInCache(request, false, 0, store, resourceId, clientID)
+		}
+		return r.status, r.err
+	}
+
+	softDeadline := s.rbacClient.SoftDeadline()
+	if softDeadline <= 0 || !featuregates.Enabled(featuregates.SoftTTLCache) {
+		status, ttl, err := s.rbacClient.CheckAccessBatched(ctx, request, resourceId)
+		return finish(checkResult{status, ttl, err})
+	}
+
+	resultCh := make(chan checkResult, 1)
+	go func() {
+		// the verdict refreshes the cache even when the caller has already
+		// been answered from stale data, so the call outlives the request ctx
+		status, ttl, err := s.rbacClient.CheckAccessBatched(context.Background(), request, resourceId)
+		resultCh <- checkResult{status, ttl, err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		return finish(r)
+	case <-time.After(softDeadline):
+	}
+
+	if !reqInfo.CacheBypass {
+		if found, result := s.rbacClient.GetStaleResultFromCache(request, store, resourceId, clientID); found {
+			glog.V(3).Infof("checkaccess exceeded soft deadline %s; serving stale cached verdict for user %s", softDeadline, request.User)
+			// let the slow verdict refresh the cache when it finally arrives
+			go func() {
+				if r := <-resultCh; r.err == nil {
+					_ = s.rbacClient.SetResultInCache(request, r.status.Allowed, r.ttl, store, resourceId, clientID)
+				}
+			}()
+			if result {
+				return &authzv1beta1.SubjectAccessReviewStatus{Allowed: true, Reason: rbac.AccessAllowedStaleVerdict}, nil
+			}
+			return s.rbacClient.ApplyDeniedPolicy(&authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Denied: true, Reason: rbac.AccessNotAllowedStaleVerdict}), nil
+		}
+	}
+
+	// no usable cached verdict; wait for the real one
+	return finish(<-resultCh)
+}
+
+// armUnavailable answers a review while the checkaccess circuit breaker is
+// open. A cached verdict no staler than the stale limit is served when one
+// exists; otherwise the request gets no opinion, so the API server falls
+// through to the next authorizer instead of erroring out.
+func (s Authorizer) armUnavailable(request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, resourceId string, reqInfo authz.RequestInfo) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+	if !reqInfo.CacheBypass {
+		if found, result := s.rbacClient.GetStaleResultFromCache(request, store, resourceId, reqInfo.CommonName); found {
+			glog.V(3).Infof("checkaccess breaker is open; serving stale cached verdict for user %s", request.User)
+			if result {
+				return &authzv1beta1.SubjectAccessReviewStatus{Allowed: true, Reason: rbac.AccessAllowedBreakerVerdict}, nil
+			}
+			return s.rbacClient.ApplyDeniedPolicy(&authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Denied: true, Reason: rbac.AccessNotAllowedBreakerVerdict}), nil
+		}
+	}
+	glog.V(3).Infof("checkaccess breaker is open and no cached verdict exists; returning no opinion for user %s", request.User)
+	return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Reason: rbac.ARMUnavailableVerdict}, nil
 }
 
 func getAuthzInfo(environment string) (*rbac.AuthzInfo, error) {
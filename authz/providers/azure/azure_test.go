@@ -17,6 +17,7 @@ limitations under the License.
 package azure
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -136,10 +137,48 @@ func TestCheck(t *testing.T) {
 			ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
 				Subresource: "status", Version: "v1", Name: "test", Verb: "delete"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
 
-		resp, err := client.Check(request, store)
+		resp, err := client.Check(context.Background(), request, store, authz.RequestInfo{})
 		assert.Nilf(t, err, "Should not have got error")
 		assert.NotNil(t, resp)
 		assert.Equal(t, resp.Allowed, true)
 		assert.Equal(t, resp.Denied, false)
 	})
+
+	t.Run("cache bypass skips cached verdict", func(t *testing.T) {
+		var validBody = `[{"accessDecision":"Allowed",
+		"actionId":"Microsoft.Kubernetes/connectedClusters/pods/delete",
+		"isDataAction":true,"roleAssignment":null,"denyAssignment":null,"timeToLiveInMs":300000}]`
+
+		srv, client, store := getServerAndClient(t, loginResp, validBody)
+		defer srv.Close()
+		defer store.Close()
+
+		request := &authzv1beta1.SubjectAccessReviewSpec{
+			User: "beta@bing.com",
+			ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
+				Subresource: "status", Version: "v1", Name: "test", Verb: "delete"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
+
+		// prime the cache with a denied verdict
+		resourceId, err := client.rbacClient.ResolveResourceId(authz.RequestInfo{})
+		assert.Nilf(t, err, "Should not have got error")
+		err = client.rbacClient.SetResultInCache(request, false, 0, store, resourceId, "")
+		assert.Nilf(t, err, "Should not have got error")
+
+		// without bypass the stale denied verdict wins
+		resp, err := client.Check(context.Background(), request, store, authz.RequestInfo{})
+		assert.Nilf(t, err, "Should not have got error")
+		assert.NotNil(t, resp)
+		assert.Equal(t, resp.Allowed, false)
+
+		// with bypass the fresh upstream allow wins and refreshes the cache
+		resp, err = client.Check(context.Background(), request, store, authz.RequestInfo{CacheBypass: true})
+		assert.Nilf(t, err, "Should not have got error")
+		assert.NotNil(t, resp)
+		assert.Equal(t, resp.Allowed, true)
+
+		resp, err = client.Check(context.Background(), request, store, authz.RequestInfo{})
+		assert.Nilf(t, err, "Should not have got error")
+		assert.NotNil(t, resp)
+		assert.Equal(t, resp.Allowed, true)
+	})
 }
@@ -84,6 +84,12 @@ func (s *DataStore) Delete(key string) error {
 	return nil
 }
 
+// Flush drops all cached entries, e.g. after a credential rotation made the
+// cached decisions untrustworthy.
+func (s *DataStore) Flush() error {
+	return s.cache.Reset()
+}
+
 // Close closes the DataStore.
 // When called, the cache is left for removal by the garbage collector.
 func (s *DataStore) Close() error {
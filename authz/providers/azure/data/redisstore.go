@@ -0,0 +1,182 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package data
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/appscode/guard/authz"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// RedisOptions configures the Redis backed authz store. A shared Redis lets
+// multiple guard replicas reuse decisions and survive restarts.
+type RedisOptions struct {
+	Address    string
+	Password   string
+	DB         int
+	KeyPrefix  string
+	UseTLS     bool
+	CACertFile string
+}
+
+func NewRedisOptions() RedisOptions {
+	return RedisOptions{
+		KeyPrefix: "guard:authz:",
+	}
+}
+
+func (o *RedisOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Address, "redis.address", o.Address, "host:port of the Redis server used as authz cache store")
+	fs.StringVar(&o.Password, "redis.password", o.Password, "password used to authenticate with the Redis server")
+	fs.IntVar(&o.DB, "redis.db", o.DB, "Redis database number used for the authz cache")
+	fs.StringVar(&o.KeyPrefix, "redis.key-prefix", o.KeyPrefix, "prefix applied to every key stored in Redis")
+	fs.BoolVar(&o.UseTLS, "redis.use-tls", o.UseTLS, "if true, connects to the Redis server over TLS")
+	fs.StringVar(&o.CACertFile, "redis.ca-cert-file", o.CACertFile, "File containing CA certificate used to verify the Redis server. If empty, the host CA set is used")
+}
+
+func (o *RedisOptions) Validate() []error {
+	var errs []error
+	if o.Address == "" {
+		errs = append(errs, errors.New("redis.address must be non-empty"))
+	}
+	if o.CACertFile != "" && !o.UseTLS {
+		errs = append(errs, errors.New("redis.ca-cert-file must be used with redis.use-tls"))
+	}
+	return errs
+}
+
+// RedisStore implements authz.Store on a shared Redis server.
+type RedisStore struct {
+	client    *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+}
+
+var _ authz.Store = &RedisStore{}
+
+// NewRedisStore creates a Redis backed authz store and verifies connectivity.
+func NewRedisStore(opts RedisOptions) (authz.Store, error) {
+	redisOpts := &redis.Options{
+		Addr:     opts.Address,
+		Password: opts.Password,
+		DB:       opts.DB,
+	}
+
+	if opts.UseTLS {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if opts.CACertFile != "" {
+			caCert, err := ioutil.ReadFile(opts.CACertFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read redis CA cert file")
+			}
+			pool := x509.NewCertPool()
+			if ok := pool.AppendCertsFromPEM(caCert); !ok {
+				return nil, errors.New("failed to add CA cert in CertPool for redis")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		redisOpts.TLSConfig = tlsConfig
+	}
+
+	client := redis.NewClient(redisOpts)
+	if err := client.Ping().Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to connect to redis")
+	}
+
+	return &RedisStore{
+		client:    client,
+		keyPrefix: opts.KeyPrefix,
+		ttl:       ttlInMins * time.Minute,
+	}, nil
+}
+
+// Set stores the given value for the given key.
+// The key must not be "" and the value must not be nil.
+func (s *RedisStore) Set(key string, value interface{}) error {
+	if key == "" || value == nil {
+		return errors.New("invalid key value pair")
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(s.keyPrefix+key, data, s.ttl).Err()
+}
+
+// Get retrieves the stored value for the given key.
+// If no value is found it returns (false, nil).
+// The key must not be "" and the pointer must not be nil.
+func (s *RedisStore) Get(key string, value interface{}) (found bool, err error) {
+	if key == "" || value == nil {
+		return false, errors.New("invalid key value pair")
+	}
+
+	data, err := s.client.Get(s.keyPrefix + key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(data, value)
+}
+
+// Delete deletes the stored value for the given key.
+// The key must not be "".
+func (s *RedisStore) Delete(key string) error {
+	if key == "" {
+		return errors.New("invalid key")
+	}
+	return s.client.Del(s.keyPrefix + key).Err()
+}
+
+// Flush deletes every key under the store's prefix, e.g. after a credential
+// rotation made the cached decisions untrustworthy. Other users of a shared
+// Redis are untouched.
+func (s *RedisStore) Flush() error {
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(cursor, s.keyPrefix+"*", 100).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := s.client.Del(keys...).Err(); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Close closes the connection to the Redis server.
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,124 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package data
+
+import (
+	"sync"
+
+	"github.com/appscode/guard/authz"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// writeQueueSize bounds the number of cache writes waiting for the writer.
+// At typical verdict sizes the queue costs a few hundred KB at most.
+const writeQueueSize = 1024
+
+var cacheWrites = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "authz_cache_writes_total",
+		Help: "A counter of asynchronous authz cache writes partitioned by outcome (written, failed or dropped).",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(cacheWrites)
+}
+
+type cacheWrite struct {
+	key   string
+	value interface{}
+}
+
+// WriteBehindStore decorates a store so Set never blocks the request path:
+// writes are queued to a single writer goroutine and dropped (and counted)
+// when the queue is full. Losing a cache write only costs a future cache
+// miss, while waiting on a slow cache shard would add latency to every
+// authorization response.
+type WriteBehindStore struct {
+	store authz.Store
+	queue chan cacheWrite
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// NewWriteBehindStore wraps the store with the asynchronous write-behind
+// queue and starts its writer.
+func NewWriteBehindStore(store authz.Store) authz.Store {
+	s := &WriteBehindStore{
+		store:  store,
+		queue:  make(chan cacheWrite, writeQueueSize),
+		stopCh: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *WriteBehindStore) run() {
+	for {
+		select {
+		case w := <-s.queue:
+			if err := s.store.Set(w.key, w.value); err != nil {
+				cacheWrites.WithLabelValues("failed").Inc()
+				glog.V(5).Infof("async cache write for key %s failed: %s", w.key, err)
+			} else {
+				cacheWrites.WithLabelValues("written").Inc()
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// Set queues the write and returns immediately. When the queue is full the
+// write is dropped and counted instead of blocking the caller.
+func (s *WriteBehindStore) Set(key string, value interface{}) error {
+	select {
+	case s.queue <- cacheWrite{key: key, value: value}:
+	default:
+		cacheWrites.WithLabelValues("dropped").Inc()
+		glog.V(5).Infof("async cache write for key %s dropped: queue is full", key)
+	}
+	return nil
+}
+
+func (s *WriteBehindStore) Get(key string, value interface{}) (bool, error) {
+	return s.store.Get(key, value)
+}
+
+func (s *WriteBehindStore) Delete(key string) error {
+	return s.store.Delete(key)
+}
+
+// Flush forwards to the underlying store, so a credential rotation still
+// drops every cached verdict.
+func (s *WriteBehindStore) Flush() error {
+	if flusher, ok := s.store.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// Close stops the writer and closes the underlying store. Queued writes are
+// discarded; they only ever held re-creatable cache state.
+func (s *WriteBehindStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	return s.store.Close()
+}
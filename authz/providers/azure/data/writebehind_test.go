@@ -0,0 +1,111 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingStore blocks every Set until released, so tests can hold the
+// writer goroutine in a known state.
+type recordingStore struct {
+	sets    chan cacheWrite
+	release chan struct{}
+	flushed bool
+}
+
+func newRecordingStore() *recordingStore {
+	return &recordingStore{
+		sets:    make(chan cacheWrite, writeQueueSize+16),
+		release: make(chan struct{}),
+	}
+}
+
+func (s *recordingStore) Set(key string, value interface{}) error {
+	<-s.release
+	s.sets <- cacheWrite{key: key, value: value}
+	return nil
+}
+
+func (s *recordingStore) Get(key string, value interface{}) (bool, error) { return false, nil }
+func (s *recordingStore) Delete(key string) error                         { return nil }
+func (s *recordingStore) Flush() error                                    { s.flushed = true; return nil }
+func (s *recordingStore) Close() error                                    { return nil }
+
+func TestWriteBehindStoreSetDoesNotBlock(t *testing.T) {
+	rec := newRecordingStore()
+	store := NewWriteBehindStore(rec)
+	defer store.Close()
+
+	// the underlying store is stuck, yet Set returns immediately
+	done := make(chan struct{})
+	go func() {
+		assert.Nil(t, store.Set("key", "value"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Set blocked on a slow underlying store")
+	}
+
+	// once the store recovers, the queued write lands
+	close(rec.release)
+	select {
+	case w := <-rec.sets:
+		assert.Equal(t, "key", w.key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued write never reached the underlying store")
+	}
+}
+
+func TestWriteBehindStoreDropsOnOverflow(t *testing.T) {
+	rec := newRecordingStore()
+	store := NewWriteBehindStore(rec)
+	defer store.Close()
+
+	// the writer is stuck on the first write; fill the queue and overflow it.
+	// every Set must still return immediately
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < writeQueueSize+16; i++ {
+			assert.Nil(t, store.Set("key", i))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Set blocked once the write queue overflowed")
+	}
+	close(rec.release)
+}
+
+func TestWriteBehindStoreForwardsFlush(t *testing.T) {
+	rec := newRecordingStore()
+	store := NewWriteBehindStore(rec)
+	defer store.Close()
+
+	flusher, ok := store.(interface{ Flush() error })
+	if assert.True(t, ok) {
+		assert.Nil(t, flusher.Flush())
+		assert.True(t, rec.flushed)
+	}
+}
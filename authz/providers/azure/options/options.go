@@ -16,8 +16,10 @@ package options
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/appscode/guard/auth/providers/azure"
+	"github.com/appscode/guard/util/httpclient"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
@@ -30,21 +32,73 @@ const (
 	ARCAuthzMode               = "arc"
 	defaultArmCallLimit        = 2000
 	maxPermissibleArmCallLimit = 4000
+	maxPermissibleBatchWindow  = time.Second
+
+	defaultCheckAccessMaxAttempts  = 2
+	maxPermissibleRetryAttempts    = 5
+	defaultCheckAccessRetryBackoff = 500 * time.Millisecond
+	maxPermissibleRetryBackoff     = 5 * time.Second
+
+	defaultCheckAccessStaleLimit = 2 * time.Minute
+	maxPermissibleSoftDeadline   = 30 * time.Second
+	maxPermissibleStaleLimit     = 10 * time.Minute
+
+	defaultBreakerOpenDuration    = 30 * time.Second
+	defaultBreakerHalfOpenProbes  = 1
+	maxPermissibleBreakerDuration = 5 * time.Minute
+
+	// resource id selectors for fleet-scoped guard
+	CertCNResourceIdSelector = "cert-cn"
+	HeaderResourceIdSelector = "header"
+
+	// policies for requests made by system:anonymous
+	AnonymousPolicyNoOpinion = "no-opinion"
+	AnonymousPolicyDeny      = "deny"
+
+	// policies for requests Azure RBAC evaluates as not allowed
+	DeniedRequestPolicyNoOpinion = "no-opinion"
+	DeniedRequestPolicyDeny      = "deny"
 )
 
 type Options struct {
 	AuthzMode                      string
 	ResourceId                     string
+	ResourceIds                    []string
+	ResourceIdSelector             string
 	AKSAuthzTokenURL               string
 	ARMCallLimit                   int
 	SkipAuthzCheck                 []string
+	SkipAuthzSystemGroups          []string
 	AuthzResolveGroupMemberships   bool
 	SkipAuthzForNonAADUsers        bool
 	AllowNonResDiscoveryPathAccess bool
+	AnonymousPolicy                string
+	DeniedRequestPolicy            string
+	CheckAccessBatchWindow         time.Duration
+	CheckAccessMaxAttempts         int
+	CheckAccessRetryBackoff        time.Duration
+	CheckAccessSoftDeadline        time.Duration
+	CheckAccessStaleLimit          time.Duration
+	// circuit breaker for the ARM checkaccess and AAD token endpoints;
+	// a zero threshold disables it
+	BreakerErrorThreshold int
+	BreakerOpenDuration   time.Duration
+	BreakerHalfOpenProbes int
+	// client certificate presented to the ARM endpoint, for private
+	// endpoints that require mutual TLS
+	ARMClientCert httpclient.ClientCertConfig
 }
 
 func NewOptions() Options {
 	return Options{
+		ResourceIdSelector:             CertCNResourceIdSelector,
+		AnonymousPolicy:                AnonymousPolicyNoOpinion,
+		DeniedRequestPolicy:            DeniedRequestPolicyDeny,
+		CheckAccessMaxAttempts:         defaultCheckAccessMaxAttempts,
+		CheckAccessRetryBackoff:        defaultCheckAccessRetryBackoff,
+		CheckAccessStaleLimit:          defaultCheckAccessStaleLimit,
+		BreakerOpenDuration:            defaultBreakerOpenDuration,
+		BreakerHalfOpenProbes:          defaultBreakerHalfOpenProbes,
 		ARMCallLimit:                   defaultArmCallLimit,
 		SkipAuthzCheck:                 []string{""},
 		AuthzResolveGroupMemberships:   true,
@@ -55,12 +109,40 @@ func NewOptions() Options {
 func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.AuthzMode, "azure.authz-mode", "", "authz mode to call RBAC api, valid value is either aks or arc")
 	fs.StringVar(&o.ResourceId, "azure.resource-id", "", "azure cluster resource id (//subscription/<subName>/resourcegroups/<RGname>/providers/Microsoft.ContainerService/managedClusters/<clustername> for AKS or //subscription/<subName>/resourcegroups/<RGname>/providers/Microsoft.Kubernetes/connectedClusters/<clustername> for arc) to be used as scope for RBAC check")
+	fs.StringSliceVar(&o.ResourceIds, "azure.resource-ids", o.ResourceIds, "list of <cluster-name>=<azure cluster resource id> pairs used when guard runs centrally for a fleet of arc clusters. The resource id for a request is selected using azure.resource-id-selector and azure.resource-id falls back as default")
+	fs.StringVar(&o.ResourceIdSelector, "azure.resource-id-selector", o.ResourceIdSelector, "rule to select the cluster name for a request when azure.resource-ids is set, valid value is either cert-cn (CN of the client certificate) or header (x-guard-cluster-name request header)")
 	fs.StringVar(&o.AKSAuthzTokenURL, "azure.aks-authz-token-url", "", "url to call for AKS Authz flow")
 	fs.IntVar(&o.ARMCallLimit, "azure.arm-call-limit", o.ARMCallLimit, "No of calls before which webhook switch to new ARM instance to avoid throttling")
 	fs.StringSliceVar(&o.SkipAuthzCheck, "azure.skip-authz-check", o.SkipAuthzCheck, "name of usernames/email for which authz check will be skipped")
+	fs.StringSliceVar(&o.SkipAuthzSystemGroups, "azure.skip-authz-system-groups", o.SkipAuthzSystemGroups, "list of well-known system: groups (e.g. system:nodes, system:kube-scheduler) whose subject access reviews get no opinion without calling Azure RBAC. Azure can never resolve kubernetes-internal groups, so skipping them saves ARM calls and denial noise")
 	fs.BoolVar(&o.AuthzResolveGroupMemberships, "azure.authz-resolve-group-memberships", o.AuthzResolveGroupMemberships, "set to true to resolve group membership by authorizer. Setting to false will use group list from subjectaccessreview request")
 	fs.BoolVar(&o.SkipAuthzForNonAADUsers, "azure.skip-authz-for-non-aad-users", o.SkipAuthzForNonAADUsers, "skip authz for non AAD users")
 	fs.BoolVar(&o.AllowNonResDiscoveryPathAccess, "azure.allow-nonres-discovery-path-access", o.AllowNonResDiscoveryPathAccess, "allow access on Non Resource paths required for discovery, setting it false will require explicit non resource path role assignment for all users in Azure RBAC")
+	fs.IntVar(&o.CheckAccessMaxAttempts, "azure.checkaccess-max-attempts", o.CheckAccessMaxAttempts, "max no of attempts for a checkaccess call. Transient ARM errors (429 and 5xx) are retried with exponential backoff until this many attempts are made")
+	fs.DurationVar(&o.CheckAccessRetryBackoff, "azure.checkaccess-retry-backoff", o.CheckAccessRetryBackoff, "initial backoff between checkaccess retries, doubled on every attempt. ARM Retry-After hints take precedence")
+	fs.DurationVar(&o.CheckAccessSoftDeadline, "azure.checkaccess-soft-deadline", o.CheckAccessSoftDeadline, "soft deadline for a checkaccess call. When exceeded, a cached verdict no staler than azure.checkaccess-stale-limit is served instead, bounding webhook latency. 0 disables the fallback")
+	fs.DurationVar(&o.CheckAccessStaleLimit, "azure.checkaccess-stale-limit", o.CheckAccessStaleLimit, "how long an expired cached verdict may still be served when the checkaccess soft deadline is exceeded")
+	fs.IntVar(&o.BreakerErrorThreshold, "azure.breaker-error-threshold", o.BreakerErrorThreshold, "no of consecutive checkaccess or token call failures after which guard stops calling the endpoint and fails fast with cached or no-opinion results. 0 disables the circuit breaker")
+	fs.DurationVar(&o.BreakerOpenDuration, "azure.breaker-open-duration", o.BreakerOpenDuration, "how long an open circuit breaker refuses calls before probing the endpoint again")
+	fs.IntVar(&o.BreakerHalfOpenProbes, "azure.breaker-half-open-probes", o.BreakerHalfOpenProbes, "max no of probe calls let through once the open duration has passed. One success closes the breaker, one failure reopens it")
+	fs.DurationVar(&o.CheckAccessBatchWindow, "azure.checkaccess-batch-window", o.CheckAccessBatchWindow, "time to wait for more subject access reviews of the same user/namespace before issuing a checkaccess call, so they are coalesced into one POST with multiple actions. 0 disables batching")
+	o.ARMClientCert.AddFlagsWithPrefix(fs, "azure.arm-")
+	fs.StringVar(&o.AnonymousPolicy, "azure.anonymous-request-policy", o.AnonymousPolicy, "policy for requests made by system:anonymous, valid value is either no-opinion or deny. Anonymous requests are never sent to Azure RBAC")
+	fs.StringVar(&o.DeniedRequestPolicy, "azure.denied-request-policy", o.DeniedRequestPolicy, "policy for requests Azure RBAC evaluates as not allowed, valid value is either deny or no-opinion. With no-opinion the API server falls through to the next authorizer instead of denying outright, so guard can be chained before native RBAC")
+}
+
+// ParseResourceIds parses a list of <cluster-name>=<azure cluster resource id>
+// pairs into a map keyed by cluster name.
+func ParseResourceIds(resourceIds []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(resourceIds))
+	for _, pair := range resourceIds {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("azure.resource-ids entry %s is not in <cluster-name>=<resource-id> format", pair)
+		}
+		parsed[strings.ToLower(parts[0])] = parts[1]
+	}
+	return parsed, nil
 }
 
 func (o *Options) Validate(azure azure.Options) []error {
@@ -73,8 +155,37 @@ func (o *Options) Validate(azure azure.Options) []error {
 		errs = append(errs, errors.New("invalid azure.authz-mode. valid value is either aks or arc"))
 	}
 
-	if o.AuthzMode != "" && o.ResourceId == "" {
-		errs = append(errs, errors.New("azure.resource-id must be non-empty for authorization"))
+	if o.AuthzMode != "" && o.ResourceId == "" && len(o.ResourceIds) == 0 {
+		errs = append(errs, errors.New("azure.resource-id or azure.resource-ids must be non-empty for authorization"))
+	}
+
+	if len(o.ResourceIds) > 0 && o.AuthzMode != ARCAuthzMode {
+		errs = append(errs, errors.New("azure.resource-ids is supported only with arc authz mode"))
+	}
+
+	if _, err := ParseResourceIds(o.ResourceIds); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch o.ResourceIdSelector {
+	case CertCNResourceIdSelector:
+	case HeaderResourceIdSelector:
+	default:
+		errs = append(errs, errors.New("invalid azure.resource-id-selector. valid value is either cert-cn or header"))
+	}
+
+	switch o.AnonymousPolicy {
+	case AnonymousPolicyNoOpinion:
+	case AnonymousPolicyDeny:
+	default:
+		errs = append(errs, errors.New("invalid azure.anonymous-request-policy. valid value is either no-opinion or deny"))
+	}
+
+	switch o.DeniedRequestPolicy {
+	case DeniedRequestPolicyDeny:
+	case DeniedRequestPolicyNoOpinion:
+	default:
+		errs = append(errs, errors.New("invalid azure.denied-request-policy. valid value is either deny or no-opinion"))
 	}
 
 	if o.AuthzMode == AKSAuthzMode && o.AKSAuthzTokenURL == "" {
@@ -94,6 +205,46 @@ func (o *Options) Validate(azure azure.Options) []error {
 		}
 	}
 
+	if o.CheckAccessMaxAttempts < 1 || o.CheckAccessMaxAttempts > maxPermissibleRetryAttempts {
+		errs = append(errs, fmt.Errorf("azure.checkaccess-max-attempts must be between 1 and %d", maxPermissibleRetryAttempts))
+	}
+
+	if o.CheckAccessRetryBackoff <= 0 || o.CheckAccessRetryBackoff > maxPermissibleRetryBackoff {
+		errs = append(errs, fmt.Errorf("azure.checkaccess-retry-backoff must be between 0 and %s", maxPermissibleRetryBackoff))
+	}
+
+	if o.CheckAccessBatchWindow < 0 || o.CheckAccessBatchWindow > maxPermissibleBatchWindow {
+		errs = append(errs, fmt.Errorf("azure.checkaccess-batch-window must be between 0 and %s", maxPermissibleBatchWindow))
+	}
+
+	if o.CheckAccessSoftDeadline < 0 || o.CheckAccessSoftDeadline > maxPermissibleSoftDeadline {
+		errs = append(errs, fmt.Errorf("azure.checkaccess-soft-deadline must be between 0 and %s", maxPermissibleSoftDeadline))
+	}
+
+	if o.CheckAccessStaleLimit < 0 || o.CheckAccessStaleLimit > maxPermissibleStaleLimit {
+		errs = append(errs, fmt.Errorf("azure.checkaccess-stale-limit must be between 0 and %s", maxPermissibleStaleLimit))
+	}
+
+	for _, group := range o.SkipAuthzSystemGroups {
+		if group != "" && !strings.HasPrefix(strings.ToLower(group), "system:") {
+			errs = append(errs, fmt.Errorf("azure.skip-authz-system-groups entry %s must start with system:", group))
+		}
+	}
+
+	if o.BreakerErrorThreshold < 0 {
+		errs = append(errs, errors.New("azure.breaker-error-threshold must not be negative"))
+	}
+	if o.BreakerErrorThreshold > 0 {
+		if o.BreakerOpenDuration <= 0 || o.BreakerOpenDuration > maxPermissibleBreakerDuration {
+			errs = append(errs, fmt.Errorf("azure.breaker-open-duration must be between 0 and %s", maxPermissibleBreakerDuration))
+		}
+		if o.BreakerHalfOpenProbes < 1 {
+			errs = append(errs, errors.New("azure.breaker-half-open-probes must be positive"))
+		}
+	}
+
+	errs = append(errs, o.ARMClientCert.Validate("azure.arm-")...)
+
 	if o.ARMCallLimit > maxPermissibleArmCallLimit {
 		errs = append(errs, fmt.Errorf("azure.arm-call-limit must not be more than %d", maxPermissibleArmCallLimit))
 	}
@@ -116,16 +267,47 @@ func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err erro
 		args = append(args, fmt.Sprintf("--azure.aks-authz-token-url=%s", o.AKSAuthzTokenURL))
 	}
 
+	if len(o.ResourceIds) > 0 {
+		args = append(args, fmt.Sprintf("--azure.resource-ids=%s", strings.Join(o.ResourceIds, ",")))
+		args = append(args, fmt.Sprintf("--azure.resource-id-selector=%s", o.ResourceIdSelector))
+	}
+
 	if len(o.SkipAuthzCheck) > 0 {
 		args = append(args, fmt.Sprintf("--azure.skip-authz-check=%s", strings.Join(o.SkipAuthzCheck, ",")))
 	}
 
+	if len(o.SkipAuthzSystemGroups) > 0 {
+		args = append(args, fmt.Sprintf("--azure.skip-authz-system-groups=%s", strings.Join(o.SkipAuthzSystemGroups, ",")))
+	}
+
 	args = append(args, fmt.Sprintf("--azure.authz-resolve-group-memberships=%t", o.AuthzResolveGroupMemberships))
 
 	args = append(args, fmt.Sprintf("--azure.skip-authz-for-non-aad-users=%t", o.SkipAuthzForNonAADUsers))
 
 	args = append(args, fmt.Sprintf("--azure.allow-nonres-discovery-path-access=%t", o.AllowNonResDiscoveryPathAccess))
 
+	args = append(args, fmt.Sprintf("--azure.anonymous-request-policy=%s", o.AnonymousPolicy))
+
+	args = append(args, fmt.Sprintf("--azure.denied-request-policy=%s", o.DeniedRequestPolicy))
+
+	if o.CheckAccessBatchWindow > 0 {
+		args = append(args, fmt.Sprintf("--azure.checkaccess-batch-window=%s", o.CheckAccessBatchWindow))
+	}
+
+	if o.CheckAccessSoftDeadline > 0 {
+		args = append(args, fmt.Sprintf("--azure.checkaccess-soft-deadline=%s", o.CheckAccessSoftDeadline))
+		args = append(args, fmt.Sprintf("--azure.checkaccess-stale-limit=%s", o.CheckAccessStaleLimit))
+	}
+
+	if o.BreakerErrorThreshold > 0 {
+		args = append(args, fmt.Sprintf("--azure.breaker-error-threshold=%d", o.BreakerErrorThreshold))
+		args = append(args, fmt.Sprintf("--azure.breaker-open-duration=%s", o.BreakerOpenDuration))
+		args = append(args, fmt.Sprintf("--azure.breaker-half-open-probes=%d", o.BreakerHalfOpenProbes))
+	}
+
+	args = append(args, fmt.Sprintf("--azure.checkaccess-max-attempts=%d", o.CheckAccessMaxAttempts))
+	args = append(args, fmt.Sprintf("--azure.checkaccess-retry-backoff=%s", o.CheckAccessRetryBackoff))
+
 	d.Spec.Template.Spec.Containers[0].Args = args
 	return extraObjs, nil
 }
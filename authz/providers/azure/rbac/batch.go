@@ -0,0 +1,146 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rbac
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/appscode/guard/util/featuregates"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+// maxActionsPerBatch bounds the number of Actions coalesced into a single
+// checkaccess POST.
+const maxActionsPerBatch = 25
+
+type checkResult struct {
+	status *authzv1beta1.SubjectAccessReviewStatus
+	ttl    time.Duration
+	err    error
+}
+
+type pendingCheck struct {
+	request  *authzv1beta1.SubjectAccessReviewSpec
+	resultCh chan checkResult
+}
+
+// batchKey groups pending checks that can share a single checkaccess call:
+// same subject, same namespace scope and same cluster resource.
+func batchKey(request *authzv1beta1.SubjectAccessReviewSpec, resourceId string) string {
+	key := path.Join(resourceId, request.User)
+	if request.ResourceAttributes != nil {
+		key = path.Join(key, request.ResourceAttributes.Namespace)
+	}
+	return key
+}
+
+// CheckAccessBatched coalesces checkaccess calls for the same subject and
+// scope arriving within the configured batching window into one POST with
+// multiple Actions, reducing ARM throttling under kubectl-heavy workloads.
+// With no batching window configured, or with the BatchSAR feature gate off,
+// it issues the call directly.
+func (a *AccessInfo) CheckAccessBatched(ctx context.Context, request *authzv1beta1.SubjectAccessReviewSpec, resourceId string) (*authzv1beta1.SubjectAccessReviewStatus, time.Duration, error) {
+	if a.batchWindow <= 0 || !featuregates.Enabled(featuregates.BatchSAR) {
+		return a.CheckAccess(ctx, request, resourceId)
+	}
+
+	key := batchKey(request, resourceId)
+	item := &pendingCheck{request: request, resultCh: make(chan checkResult, 1)}
+
+	a.batchLock.Lock()
+	items, exist := a.pendingBatches[key]
+	a.pendingBatches[key] = append(items, item)
+	flushNow := len(items)+1 >= maxActionsPerBatch
+	if !exist {
+		// first request of the window; flush once the window elapses
+		time.AfterFunc(a.batchWindow, func() { a.flushBatch(key, resourceId) })
+	}
+	a.batchLock.Unlock()
+
+	if flushNow {
+		a.flushBatch(key, resourceId)
+	}
+
+	// the flush serves every caller of the batch, so a single caller's
+	// deadline must not cancel it; each caller stops waiting on its own
+	select {
+	case result := <-item.resultCh:
+		return result.status, result.ttl, result.err
+	case <-ctx.Done():
+		return nil, 0, errors.Wrap(ctx.Err(), "context done before checkaccess batch completed")
+	}
+}
+
+func (a *AccessInfo) flushBatch(key, resourceId string) {
+	a.batchLock.Lock()
+	items := a.pendingBatches[key]
+	delete(a.pendingBatches, key)
+	a.batchLock.Unlock()
+
+	if len(items) == 0 {
+		return
+	}
+
+	fail := func(err error) {
+		for _, item := range items {
+			item.resultCh <- checkResult{err: err}
+		}
+	}
+
+	checkAccessBody, err := prepareCheckAccessRequestBody(items[0].request, a.clusterType, resourceId, a.retrieveGroupMemberships)
+	if err != nil {
+		fail(errors.Wrap(err, "error in preparing check access request"))
+		return
+	}
+
+	actionIds := make([]string, len(items))
+	actionIds[0] = checkAccessBody.Actions[0].Id
+	for i, item := range items[1:] {
+		action := getDataAction(item.request, a.clusterType)
+		checkAccessBody.Actions = append(checkAccessBody.Actions, action)
+		actionIds[i+1] = action.Id
+	}
+
+	glog.V(5).Infof("sending batched checkaccess request with %d actions", len(checkAccessBody.Actions))
+	// the call is made on behalf of every pending caller, so it runs under
+	// its own context rather than any single request's
+	data, err := a.sendCheckAccessRequest(context.Background(), checkAccessBody, items[0].request, resourceId)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	decisions, err := ConvertBatchCheckAccessResponse(data)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	for i, item := range items {
+		decision, found := decisions[actionIds[i]]
+		if !found {
+			item.resultCh <- checkResult{err: errors.Errorf("no decision for action %s in check access response", actionIds[i])}
+			continue
+		}
+		status, ttl := convertDecision(decision)
+		item.resultCh <- checkResult{status: a.ApplyDeniedPolicy(status), ttl: ttl}
+	}
+}
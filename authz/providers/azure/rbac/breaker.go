@@ -0,0 +1,140 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrBreakerOpen is returned when a call is refused because the circuit
+// breaker for its endpoint is open. Callers fall back to cached verdicts or
+// no opinion instead of waiting on an endpoint that is known to be failing.
+var ErrBreakerOpen = errors.New("circuit breaker is open: calls are failing")
+
+var breakerState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "azure_checkaccess_breaker_open",
+		Help: "Whether the circuit breaker for the endpoint is open (1) or closed (0).",
+	},
+	[]string{"endpoint"},
+)
+
+var breakerTrips = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "azure_checkaccess_breaker_trips_total",
+		Help: "A counter of circuit breaker trips partitioned by endpoint.",
+	},
+	[]string{"endpoint"},
+)
+
+func init() {
+	prometheus.MustRegister(breakerState, breakerTrips)
+}
+
+// circuitBreaker fails calls to an endpoint fast once it has seen enough
+// consecutive errors, instead of stacking up goroutines waiting on timeouts.
+// After the open duration a limited number of probe calls is let through;
+// one success closes the breaker again, one failure reopens it.
+type circuitBreaker struct {
+	endpoint       string
+	threshold      int
+	openDuration   time.Duration
+	halfOpenProbes int
+
+	lock sync.Mutex
+	// consecutive failures observed while closed
+	failures int
+	// openedAt is the zero value while the breaker is closed
+	openedAt time.Time
+	// probes in flight once the open duration has passed
+	probes int
+}
+
+// newCircuitBreaker returns nil when the threshold is not positive; a nil
+// breaker allows every call and observes nothing.
+func newCircuitBreaker(endpoint string, threshold int, openDuration time.Duration, halfOpenProbes int) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		endpoint:       endpoint,
+		threshold:      threshold,
+		openDuration:   openDuration,
+		halfOpenProbes: halfOpenProbes,
+	}
+}
+
+// allow reports whether a call may proceed, returning ErrBreakerOpen when it
+// may not. While half open it admits up to halfOpenProbes concurrent calls.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if time.Since(b.openedAt) < b.openDuration {
+		return errors.Wrapf(ErrBreakerOpen, "%s unavailable", b.endpoint)
+	}
+	// half open: let a bounded number of probes through
+	if b.probes >= b.halfOpenProbes {
+		return errors.Wrapf(ErrBreakerOpen, "%s unavailable", b.endpoint)
+	}
+	b.probes++
+	return nil
+}
+
+// observe records the outcome of an allowed call. A failure past the
+// threshold (or any failed probe) opens the breaker; a successful probe
+// closes it.
+func (b *circuitBreaker) observe(failed bool) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if failed {
+		b.failures++
+		if !b.openedAt.IsZero() || b.failures >= b.threshold {
+			if b.openedAt.IsZero() {
+				breakerTrips.WithLabelValues(b.endpoint).Inc()
+				glog.Errorf("%s circuit breaker opened for %s after %d consecutive failures", b.endpoint, b.openDuration, b.failures)
+			}
+			breakerState.WithLabelValues(b.endpoint).Set(1)
+			b.openedAt = time.Now()
+			b.probes = 0
+		}
+		return
+	}
+
+	if !b.openedAt.IsZero() {
+		glog.Infof("%s circuit breaker closed after a successful probe", b.endpoint)
+	}
+	b.openedAt = time.Time{}
+	b.failures = 0
+	b.probes = 0
+	breakerState.WithLabelValues(b.endpoint).Set(0)
+}
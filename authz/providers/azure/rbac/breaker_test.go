@@ -0,0 +1,124 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	b := newCircuitBreaker("test", 2, time.Minute, 1)
+
+	assert.Nil(t, b.allow())
+	b.observe(true)
+	assert.Nil(t, b.allow(), "one failure is below the threshold")
+	b.observe(true)
+
+	err := b.allow()
+	assert.NotNil(t, err, "breaker should be open after two consecutive failures")
+	assert.Equal(t, ErrBreakerOpen, errors.Cause(err))
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	b := newCircuitBreaker("test", 2, time.Minute, 1)
+
+	b.observe(true)
+	b.observe(false)
+	b.observe(true)
+	assert.Nil(t, b.allow(), "failures were not consecutive, breaker stays closed")
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	b := newCircuitBreaker("test", 1, time.Millisecond, 1)
+
+	b.observe(true)
+	assert.NotNil(t, b.allow(), "breaker should be open")
+
+	// after the open duration a single probe is let through
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, b.allow())
+	assert.NotNil(t, b.allow(), "only one probe may be in flight")
+
+	// a successful probe closes the breaker
+	b.observe(false)
+	assert.Nil(t, b.allow())
+	assert.Nil(t, b.allow())
+}
+
+func TestCircuitBreakerReopensOnFailedProbe(t *testing.T) {
+	b := newCircuitBreaker("test", 1, time.Millisecond, 1)
+
+	b.observe(true)
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, b.allow())
+	b.observe(true)
+
+	assert.NotNil(t, b.allow(), "failed probe should reopen the breaker")
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreaker("test", 0, time.Minute, 1)
+	assert.Nil(t, b, "zero threshold disables the breaker")
+	assert.Nil(t, b.allow())
+	b.observe(true)
+	assert.Nil(t, b.allow())
+}
+
+func TestCheckAccessFailsFastWhenBreakerOpen(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	apiURL, _ := url.Parse(ts.URL)
+	u := &AccessInfo{
+		client:             http.DefaultClient,
+		apiURL:             apiURL,
+		staticHeaders:      http.Header{},
+		clusterType:        "arc",
+		azureResourceId:    "resourceid",
+		maxAttempts:        1,
+		checkAccessBreaker: newCircuitBreaker("ARM checkaccess", 1, time.Minute, 1),
+	}
+	u.token.Store(&tokenSnapshot{expiresAt: time.Now().Add(time.Hour)})
+
+	request := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alpha@bing.com",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
+			Version: "v1", Verb: "get"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
+
+	_, _, err := u.CheckAccess(context.Background(), request, u.azureResourceId)
+	assert.NotNilf(t, err, "should get error from the failing endpoint")
+
+	// the breaker tripped on the first failure; the next call never reaches ARM
+	_, _, err = u.CheckAccess(context.Background(), request, u.azureResourceId)
+	assert.NotNilf(t, err, "should get breaker open error")
+	assert.Equal(t, ErrBreakerOpen, errors.Cause(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "open breaker should refuse the call before it reaches the endpoint")
+}
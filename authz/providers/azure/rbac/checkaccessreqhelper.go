@@ -5,7 +5,7 @@ Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
 You may obtain a copy of the License at
 
-    http://www.apache.org/licenses/LICENSE-2.0
+	http://www.apache.org/licenses/LICENSE-2.0
 
 Unless required by applicable law or agreed to in writing, software
 distributed under the License is distributed on an "AS IS" BASIS,
@@ -17,8 +17,13 @@ package rbac
 
 import (
 	"encoding/json"
+	"fmt"
 	"path"
 	"strings"
+	"time"
+
+	"github.com/appscode/guard/authz"
+	"github.com/appscode/guard/pkg/k8sverbs"
 
 	"github.com/golang/glog"
 	"github.com/google/uuid"
@@ -27,12 +32,27 @@ import (
 )
 
 const (
-	AccessAllowedVerdict     = "Access allowed"
-	Allowed                  = "allowed"
-	AccessNotAllowedVerdict  = "User does not have access to the resource in Azure. Update role assignment to allow access."
-	namespaces               = "namespaces"
-	NotAllowedForNonAADUsers = "Access denied by Azure RBAC for non AAD users. Configure --azure.skip-authz-for-non-aad-users to enable access."
-	NoOpinionVerdict         = "Azure does not have opinion for this user."
+	AccessAllowedVerdict            = "Access allowed"
+	Allowed                         = "allowed"
+	AccessNotAllowedVerdict         = "User does not have access to the resource in Azure. Update role assignment to allow access."
+	namespaces                      = "namespaces"
+	NotAllowedForNonAADUsers        = "Access denied by Azure RBAC for non AAD users. Configure --azure.skip-authz-for-non-aad-users to enable access."
+	NoOpinionVerdict                = "Azure does not have opinion for this user."
+	AccessDeniedForAnonymousVerdict = "Access denied for anonymous users. Configure --azure.anonymous-request-policy to change this behavior."
+	// stale verdicts are served when the checkaccess call exceeds the
+	// configured soft deadline
+	AccessAllowedStaleVerdict    = "Access allowed (stale cached verdict: checkaccess exceeded the soft deadline)"
+	AccessNotAllowedStaleVerdict = "User does not have access to the resource in Azure (stale cached verdict: checkaccess exceeded the soft deadline)"
+	// served when guard cannot obtain an ARM token to perform the access check
+	TokenRefreshFailedVerdict = "Unable to check access on Azure: ARM access token refresh failed."
+	// served when the circuit breaker is open, i.e. the endpoint is failing
+	// and guard answers without calling it
+	AccessAllowedBreakerVerdict    = "Access allowed (stale cached verdict: ARM is unavailable)"
+	AccessNotAllowedBreakerVerdict = "User does not have access to the resource in Azure (stale cached verdict: ARM is unavailable)"
+	ARMUnavailableVerdict          = "Unable to check access on Azure: ARM calls are failing and the circuit breaker is open."
+	// served when ARM rejects checkaccess with 401/403, i.e. guard's own
+	// identity is misconfigured and cannot evaluate anyone's access
+	ARMAuthFailureVerdict = "Unable to check access on Azure: guard's identity is not authorized to call checkaccess. Check the role assignment of guard's identity on the cluster scope."
 )
 
 type SubjectInfoAttributes struct {
@@ -121,6 +141,59 @@ type AuthorizationDecision struct {
 	AzureRoleAssignment AzureRoleAssignment `json:"roleAssignment"`
 	AzureDenyAssignment AzureDenyAssignment `json:"denyAssignment"`
 	TimeToLiveInMs      int                 `json:"timeToLiveInMs"`
+	// UnknownFields preserves response fields this version of guard does not
+	// interpret, so newer API versions or sovereign cloud extensions are not
+	// silently dropped
+	UnknownFields map[string]json.RawMessage `json:"-"`
+}
+
+// knownDecisionFields are the fields of AuthorizationDecision guard
+// interprets; anything else lands in UnknownFields.
+var knownDecisionFields = []string{"accessDecision", "actionId", "isDataAction", "roleAssignment", "denyAssignment", "timeToLiveInMs"}
+
+func (d *AuthorizationDecision) UnmarshalJSON(data []byte) error {
+	// alias drops the custom unmarshaller, so the known fields decode
+	// through the plain struct tags
+	type decisionAlias AuthorizationDecision
+	var alias decisionAlias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, field := range knownDecisionFields {
+		delete(all, field)
+	}
+	if len(all) > 0 {
+		alias.UnknownFields = all
+	}
+	*d = AuthorizationDecision(alias)
+	return nil
+}
+
+// decodeCheckAccessResponse decodes a checkaccess response body tolerantly:
+// unknown fields are preserved per decision, while the fields guard acts on
+// are validated so a schema change cannot silently turn into a wrong verdict.
+func decodeCheckAccessResponse(body []byte) ([]AuthorizationDecision, error) {
+	var response []AuthorizationDecision
+	if err := json.Unmarshal(body, &response); err != nil {
+		glog.V(10).Infof("Failed to parse checkacccess response. Error:%s", err.Error())
+		return nil, errors.Wrap(err, "Error in unmarshalling check access response.")
+	}
+	if len(response) == 0 {
+		return nil, errors.New("checkaccess response contains no decisions")
+	}
+	for i, decision := range response {
+		if decision.Decision == "" {
+			return nil, errors.Errorf("checkaccess decision %d is missing the accessDecision field", i)
+		}
+		if decision.TimeToLiveInMs < 0 {
+			return nil, errors.Errorf("checkaccess decision %d carries a negative timeToLiveInMs", i)
+		}
+	}
+	return response, nil
 }
 
 func getScope(resourceId string, attr *authzv1beta1.ResourceAttributes) string {
@@ -145,48 +218,14 @@ func getValidSecurityGroups(groups []string) []string {
 	return finalGroups
 }
 
+// actionMapper resolves verbs through the shared mapping table; azure names
+// its data actions exactly like the shared semantics, so no overrides apply.
+var actionMapper = k8sverbs.NewMapper(nil)
+
+// getActionName delegates to the shared verb mapping, so every cloud authz
+// provider resolves verbs identically.
 func getActionName(verb string) string {
-	/* Kubernetes supprots some special verbs for which we need to return data action /verb/action.
-	Following is the list of special verbs and their API group/resources in Kubernetes:
-	use:policy/podsecuritypolicies
-	bind:rbac.authorization.k8s.io/roles,clusterroles
-	escalate:rbac.authorization.k8s.io/roles,clusterroles
-	impersonate:core/users,groups,serviceaccounts
-	impersonate:authentication.k8s.io/userextras
-
-	https://kubernetes.io/docs/reference/access-authn-authz/authorization/#determine-the-request-verb
-	*/
-	switch verb {
-	case "get":
-		fallthrough
-	case "list":
-		fallthrough
-	case "watch":
-		return "read"
-
-	case "bind":
-		return "bind/action"
-	case "escalate":
-		return "escalate/action"
-	case "use":
-		return "use/action"
-	case "impersonate":
-		return "impersonate/action"
-
-	case "create":
-		fallthrough //instead of action create will be mapped to write
-	case "patch":
-		fallthrough
-	case "update":
-		return "write"
-
-	case "delete":
-		fallthrough
-	case "deletecollection": // TODO: verify scenario
-		return "delete"
-	default:
-		return ""
-	}
+	return actionMapper.ActionName(verb)
 }
 
 func getDataAction(subRevReq *authzv1beta1.SubjectAccessReviewSpec, clusterType string) AuthorizationActionInfo {
@@ -195,12 +234,9 @@ func getDataAction(subRevReq *authzv1beta1.SubjectAccessReviewSpec, clusterType
 
 	authInfo.AuthorizationEntity.Id = clusterType
 	if subRevReq.ResourceAttributes != nil {
-		if subRevReq.ResourceAttributes.Group != "" {
-			authInfo.AuthorizationEntity.Id = path.Join(authInfo.AuthorizationEntity.Id, subRevReq.ResourceAttributes.Group)
-		}
-		authInfo.AuthorizationEntity.Id = path.Join(authInfo.AuthorizationEntity.Id, subRevReq.ResourceAttributes.Resource, getActionName(subRevReq.ResourceAttributes.Verb))
+		authInfo.AuthorizationEntity.Id = actionMapper.ResourceActionID(clusterType, subRevReq.ResourceAttributes)
 	} else if subRevReq.NonResourceAttributes != nil {
-		authInfo.AuthorizationEntity.Id = path.Join(authInfo.AuthorizationEntity.Id, subRevReq.NonResourceAttributes.Path, getActionName(subRevReq.NonResourceAttributes.Verb))
+		authInfo.AuthorizationEntity.Id = actionMapper.NonResourceActionID(clusterType, subRevReq.NonResourceAttributes)
 	}
 	return authInfo
 }
@@ -212,6 +248,17 @@ func defaultDir(s string) string {
 	return "-" // invalid for a namespace
 }
 
+// ResultCacheKey exposes the request half of the result cache key for
+// debugging tools like `guard test authz`, so admins can locate or bypass
+// the cached verdict a request maps to.
+func ResultCacheKey(subRevReq *authzv1beta1.SubjectAccessReviewSpec) string {
+	return getResultCacheKey(subRevReq)
+}
+
+// getResultCacheKey composes the request half of a result cache key. It
+// covers every request field that can change the checkaccess verdict,
+// including the group memberships forwarded to azure. Changing this
+// composition requires bumping the key format version in the authz package.
 func getResultCacheKey(subRevReq *authzv1beta1.SubjectAccessReviewSpec) string {
 	cacheKey := subRevReq.User
 
@@ -219,11 +266,12 @@ func getResultCacheKey(subRevReq *authzv1beta1.SubjectAccessReviewSpec) string {
 		cacheKey = path.Join(cacheKey, defaultDir(subRevReq.ResourceAttributes.Namespace))
 		cacheKey = path.Join(cacheKey, defaultDir(subRevReq.ResourceAttributes.Group))
 		cacheKey = path.Join(cacheKey, subRevReq.ResourceAttributes.Resource, getActionName(subRevReq.ResourceAttributes.Verb))
+		cacheKey = path.Join(cacheKey, defaultDir(subRevReq.ResourceAttributes.Subresource), defaultDir(subRevReq.ResourceAttributes.Name))
 	} else if subRevReq.NonResourceAttributes != nil {
 		cacheKey = path.Join(cacheKey, subRevReq.NonResourceAttributes.Path, getActionName(subRevReq.NonResourceAttributes.Verb))
 	}
 
-	return cacheKey
+	return path.Join(cacheKey, authz.GroupsDigest(subRevReq.Groups))
 }
 
 func prepareCheckAccessRequestBody(req *authzv1beta1.SubjectAccessReviewSpec, clusterType, resourceId string, retrieveGroupMemberships bool) (*CheckAccessRequest, error) {
@@ -312,18 +360,19 @@ func getNameSpaceScope(req *authzv1beta1.SubjectAccessReviewSpec) (bool, string)
 	return false, namespace
 }
 
-func ConvertCheckAccessResponse(body []byte) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+// ConvertCheckAccessResponse converts the checkaccess response into a
+// SubjectAccessReviewStatus along with the time the verdict may be cached,
+// taken from the TimeToLiveInMs hint of the response.
+func ConvertCheckAccessResponse(body []byte) (*authzv1beta1.SubjectAccessReviewStatus, time.Duration, error) {
 	var (
-		response []AuthorizationDecision
-		allowed  bool
-		denied   bool
-		verdict  string
+		allowed bool
+		denied  bool
+		verdict string
 	)
 
-	err := json.Unmarshal(body, &response)
+	response, err := decodeCheckAccessResponse(body)
 	if err != nil {
-		glog.V(10).Infof("Failed to parse checkacccess response. Error:%s", err.Error())
-		return nil, errors.Wrap(err, "Error in unmarshalling check access response.")
+		return nil, 0, err
 	}
 
 	if strings.ToLower(response[0].Decision) == Allowed {
@@ -332,8 +381,59 @@ func ConvertCheckAccessResponse(body []byte) (*authzv1beta1.SubjectAccessReviewS
 	} else {
 		allowed = false
 		denied = true
-		verdict = AccessNotAllowedVerdict
+		verdict = denyVerdict(response[0])
+	}
+
+	ttl := time.Duration(response[0].TimeToLiveInMs) * time.Millisecond
+	return &authzv1beta1.SubjectAccessReviewStatus{Allowed: allowed, Reason: verdict, Denied: denied}, ttl, nil
+}
+
+// ConvertBatchCheckAccessResponse parses a checkaccess response holding
+// multiple decisions and indexes them by action id.
+func ConvertBatchCheckAccessResponse(body []byte) (map[string]AuthorizationDecision, error) {
+	response, err := decodeCheckAccessResponse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make(map[string]AuthorizationDecision, len(response))
+	for _, decision := range response {
+		decisions[decision.ActionId] = decision
+	}
+	return decisions, nil
+}
+
+// convertDecision converts a single checkaccess decision into a
+// SubjectAccessReviewStatus and the time the verdict may be cached.
+func convertDecision(decision AuthorizationDecision) (*authzv1beta1.SubjectAccessReviewStatus, time.Duration) {
+	ttl := time.Duration(decision.TimeToLiveInMs) * time.Millisecond
+	if strings.ToLower(decision.Decision) == Allowed {
+		return &authzv1beta1.SubjectAccessReviewStatus{Allowed: true, Reason: AccessAllowedVerdict}, ttl
+	}
+	return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Denied: true, Reason: denyVerdict(decision)}, ttl
+}
+
+// denyVerdict explains a deny decision. When the block originates from an
+// Azure deny assignment the verdict names the assignment and its condition,
+// so cluster users can tell a deny assignment apart from a missing role
+// assignment.
+func denyVerdict(decision AuthorizationDecision) string {
+	deny := decision.AzureDenyAssignment
+	if deny.Name == "" && deny.Id == "" {
+		return AccessNotAllowedVerdict
 	}
 
-	return &authzv1beta1.SubjectAccessReviewStatus{Allowed: allowed, Reason: verdict, Denied: denied}, nil
+	name := deny.Name
+	if name == "" {
+		name = deny.Id
+	}
+	verdict := fmt.Sprintf("Access denied by Azure deny assignment %s", name)
+	if deny.Id != "" && deny.Name != "" {
+		verdict = fmt.Sprintf("%s (id: %s)", verdict, deny.Id)
+	}
+	verdict += "."
+	if deny.Condition != "" {
+		verdict = fmt.Sprintf("%s Condition: %s", verdict, deny.Condition)
+	}
+	return verdict
 }
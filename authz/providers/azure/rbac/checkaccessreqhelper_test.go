@@ -218,20 +218,20 @@ func Test_getResultCacheKey(t *testing.T) {
 			subRevReq: &authzv1beta1.SubjectAccessReviewSpec{
 				User:                  "charlie@yahoo.com",
 				NonResourceAttributes: &authzv1beta1.NonResourceAttributes{Path: "/apis/v1", Verb: "list"}}},
-			"charlie@yahoo.com/apis/v1/read"},
+			"charlie@yahoo.com/apis/v1/read/-"},
 
 		{"aks", args{
 			subRevReq: &authzv1beta1.SubjectAccessReviewSpec{
 				User:                  "echo@outlook.com",
 				NonResourceAttributes: &authzv1beta1.NonResourceAttributes{Path: "/logs", Verb: "get"}}},
-			"echo@outlook.com/logs/read"},
+			"echo@outlook.com/logs/read/-"},
 
 		{"aks", args{
 			subRevReq: &authzv1beta1.SubjectAccessReviewSpec{
 				User: "alpha@bing.com",
 				ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
 					Subresource: "status", Version: "v1", Name: "test", Verb: "delete"}}},
-			"alpha@bing.com/dev/-/pods/delete"},
+			"alpha@bing.com/dev/-/pods/delete/status/test/-"},
 
 		{"arc", args{
 			subRevReq: &authzv1beta1.SubjectAccessReviewSpec{
@@ -239,14 +239,14 @@ func Test_getResultCacheKey(t *testing.T) {
 				ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "azure-arc",
 					Group: "authentication.k8s.io", Resource: "userextras", Subresource: "scopes", Version: "v1",
 					Name: "test", Verb: "impersonate"}}},
-			"beta@msn.com/azure-arc/authentication.k8s.io/userextras/impersonate/action"},
+			"beta@msn.com/azure-arc/authentication.k8s.io/userextras/impersonate/action/scopes/test/-"},
 
 		{"arc", args{
 			subRevReq: &authzv1beta1.SubjectAccessReviewSpec{
 				User: "beta@msn.com",
 				ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "", Group: "", Resource: "nodes",
 					Subresource: "scopes", Version: "v1", Name: "", Verb: "list"}}},
-			"beta@msn.com/-/-/nodes/read"},
+			"beta@msn.com/-/-/nodes/read/scopes/-/-"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -256,3 +256,86 @@ func Test_getResultCacheKey(t *testing.T) {
 		})
 	}
 }
+
+func Test_getResultCacheKeyGroups(t *testing.T) {
+	req := func(groups ...string) *authzv1beta1.SubjectAccessReviewSpec {
+		return &authzv1beta1.SubjectAccessReviewSpec{
+			User:   "alpha@bing.com",
+			Groups: groups,
+			ResourceAttributes: &authzv1beta1.ResourceAttributes{
+				Namespace: "dev", Resource: "pods", Verb: "get"},
+		}
+	}
+
+	// the key is deterministic regardless of the order the API server sends
+	// the groups, but changes when the memberships themselves change
+	if got, want := getResultCacheKey(req("a", "b")), getResultCacheKey(req("b", "a")); got != want {
+		t.Errorf("getResultCacheKey() depends on group order: %v != %v", got, want)
+	}
+	if got, want := getResultCacheKey(req("a", "b")), getResultCacheKey(req("a")); got == want {
+		t.Errorf("getResultCacheKey() ignores group memberships: %v", got)
+	}
+	if got, want := getResultCacheKey(req("a")), getResultCacheKey(req()); got == want {
+		t.Errorf("getResultCacheKey() ignores group memberships: %v", got)
+	}
+}
+
+func Test_denyVerdict(t *testing.T) {
+	tests := []struct {
+		name     string
+		decision AuthorizationDecision
+		want     string
+	}{
+		{
+			"no deny assignment falls back to the generic verdict",
+			AuthorizationDecision{Decision: "NotAllowed"},
+			AccessNotAllowedVerdict,
+		},
+		{
+			"deny assignment with name, id and condition",
+			AuthorizationDecision{
+				Decision: "Denied",
+				AzureDenyAssignment: AzureDenyAssignment{
+					DenyAssignment: DenyAssignment{
+						Id:        "/subscriptions/s/providers/Microsoft.Authorization/denyAssignments/da",
+						Name:      "block-kube-system",
+						Condition: "!(resource.namespace == 'default')",
+					},
+				},
+			},
+			"Access denied by Azure deny assignment block-kube-system (id: /subscriptions/s/providers/Microsoft.Authorization/denyAssignments/da). Condition: !(resource.namespace == 'default')",
+		},
+		{
+			"deny assignment with only an id",
+			AuthorizationDecision{
+				Decision: "Denied",
+				AzureDenyAssignment: AzureDenyAssignment{
+					DenyAssignment: DenyAssignment{Id: "da-id"},
+				},
+			},
+			"Access denied by Azure deny assignment da-id.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := denyVerdict(tt.decision); got != tt.want {
+				t.Errorf("denyVerdict() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertCheckAccessResponseDenyAssignment(t *testing.T) {
+	body := `[{"accessDecision":"Denied","actionId":"Microsoft.ContainerService/managedClusters/pods/delete","isDataAction":true,"denyAssignment":{"id":"da-id","name":"deny-pod-deletes"},"timeToLiveInMs":300000}]`
+	status, _, err := ConvertCheckAccessResponse([]byte(body))
+	if err != nil {
+		t.Fatalf("ConvertCheckAccessResponse should not return error: %s", err)
+	}
+	if status.Allowed || !status.Denied {
+		t.Errorf("expected denied status, actual: %+v", status)
+	}
+	want := "Access denied by Azure deny assignment deny-pod-deletes (id: da-id)."
+	if status.Reason != want {
+		t.Errorf("reason = %q, want %q", status.Reason, want)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package rbac
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	checkAccessDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "azure_checkaccess_request_duration_seconds",
+			Help:    "A histogram of latencies for checkaccess calls to ARM.",
+			Buckets: []float64{.25, .5, 1, 2.5, 5, 10},
+		},
+	)
+
+	checkAccessRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_checkaccess_requests_total",
+			Help: "A counter of checkaccess calls to ARM partitioned by HTTP status code.",
+		},
+		[]string{"code"},
+	)
+
+	// armRemainingQuota tracks the x-ms-ratelimit-remaining-subscription-reads
+	// header of the last checkaccess response, so operators can alert before
+	// ARM starts throttling with 429s.
+	armRemainingQuota = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azure_checkaccess_remaining_arm_quota",
+			Help: "Remaining ARM subscription read quota reported by the last checkaccess response.",
+		},
+	)
+
+	checkAccessCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azure_checkaccess_cache_requests_total",
+			Help: "A counter of checkaccess result cache lookups partitioned by outcome (hit or miss).",
+		},
+		[]string{"result"},
+	)
+
+	tokenRefreshFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "azure_checkaccess_token_refresh_failures_total",
+			Help: "A counter of failed ARM token refreshes.",
+		},
+	)
+
+	// auth failures are 401/403 answers from ARM, i.e. guard's own identity
+	// lacks checkaccess permission; they are alerted on separately from
+	// transient errors because only a role assignment change fixes them
+	checkAccessAuthFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "azure_checkaccess_auth_failures_total",
+			Help: "A counter of checkaccess calls ARM rejected with 401 or 403, i.e. guard's identity lacks checkaccess permission.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(checkAccessDuration, checkAccessRequests, armRemainingQuota, checkAccessCacheRequests, tokenRefreshFailures, checkAccessAuthFailures)
+}
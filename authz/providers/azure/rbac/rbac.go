@@ -17,15 +17,18 @@ package rbac
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"path"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	v "github.com/appscode/go/version"
@@ -33,6 +36,7 @@ import (
 	"github.com/appscode/guard/auth/providers/azure/graph"
 	"github.com/appscode/guard/authz"
 	authzOpts "github.com/appscode/guard/authz/providers/azure/options"
+	"github.com/appscode/guard/util/debuglog"
 
 	"github.com/golang/glog"
 	"github.com/moul/http2curl"
@@ -47,8 +51,27 @@ const (
 	checkAccessAPIVersion     = "2018-09-01-preview"
 	remainingSubReadARMHeader = "x-ms-ratelimit-remaining-subscription-reads"
 	expiryDelta               = 60 * time.Second
+	// background token renewal is spread over this window ahead of expiry,
+	// so guard replicas do not refresh in lockstep
+	tokenRefreshJitter        = 30 * time.Second
+	tokenRefreshRetryInterval = 30 * time.Second
+	// defaultResultCacheTTL is used for cached verdicts when the checkaccess
+	// response does not carry a TimeToLiveInMs hint.
+	defaultResultCacheTTL = 5 * time.Minute
 )
 
+// ErrARMAuthFailure is returned when ARM answers checkaccess with 401 or 403,
+// i.e. guard's own identity is not authorized to perform access checks. This
+// is a deployment misconfiguration, not a verdict about the user under review.
+var ErrARMAuthFailure = errors.New("guard's identity is not authorized to call checkaccess")
+
+// cachedResult is the value stored in the authz result cache. ExpiresAt
+// honors the TimeToLiveInMs hint of the checkaccess response.
+type cachedResult struct {
+	Result    bool      `json:"result"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
 type AuthzInfo struct {
 	AADEndpoint string
 	ARMEndPoint string
@@ -56,23 +79,54 @@ type AuthzInfo struct {
 
 type void struct{}
 
+// tokenSnapshot is an immutable authorization/expiry pair. A refresh swaps in
+// a whole new snapshot, so concurrent checkaccess requests always observe a
+// token together with its own expiry.
+type tokenSnapshot struct {
+	authorization string
+	expiresAt     time.Time
+}
+
 // AccessInfo allows you to check user access from MS RBAC
 type AccessInfo struct {
-	headers   http.Header
-	client    *http.Client
-	expiresAt time.Time
+	// staticHeaders are fixed at construction and never mutated afterwards,
+	// so requests can copy them without locking.
+	staticHeaders http.Header
+	client        *http.Client
+	// token holds the current *tokenSnapshot; it is swapped atomically by
+	// refreshes while request goroutines read it.
+	token atomic.Value
 	// These allow us to mock out the URL for testing
 	apiURL *url.URL
 
 	tokenProvider                  graph.TokenProvider
 	clusterType                    string
 	azureResourceId                string
+	azureResourceIds               map[string]string
+	resourceIdSelector             string
 	armCallLimit                   int
+	maxAttempts                    int
+	retryBackoff                   time.Duration
+	softDeadline                   time.Duration
+	staleLimit                     time.Duration
 	skipCheck                      map[string]void
+	skipSystemGroups               map[string]void
 	retrieveGroupMemberships       bool
 	skipAuthzForNonAADUsers        bool
 	allowNonResDiscoveryPathAccess bool
-	lock                           sync.RWMutex
+	denyAsNoOpinion                bool
+	refreshLock                    sync.Mutex
+	// breakers fail ARM and AAD calls fast during an outage; nil when the
+	// breaker is disabled
+	checkAccessBreaker *circuitBreaker
+	tokenBreaker       *circuitBreaker
+	// armAuthFailure holds a description of the last 401/403 ARM answered
+	// checkaccess with, empty while healthy; /readyz surfaces it
+	armAuthFailure atomic.Value
+
+	batchWindow    time.Duration
+	batchLock      sync.Mutex
+	pendingBatches map[string][]*pendingCheck
 }
 
 func getClusterType(clsType string) string {
@@ -86,20 +140,36 @@ func getClusterType(clsType string) string {
 	}
 }
 
-func newAccessInfo(tokenProvider graph.TokenProvider, rbacURL *url.URL, opts authzOpts.Options) (*AccessInfo, error) {
+func newAccessInfo(tokenProvider graph.TokenProvider, rbacURL *url.URL, opts authzOpts.Options, httpClient *http.Client) (*AccessInfo, error) {
+	resourceIds, err := authzOpts.ParseResourceIds(opts.ResourceIds)
+	if err != nil {
+		return nil, err
+	}
+
 	u := &AccessInfo{
-		client: http.DefaultClient,
-		headers: http.Header{
+		client: httpClient,
+		staticHeaders: http.Header{
 			"Content-Type": []string{"application/json"},
 			"User-Agent":   []string{fmt.Sprintf("%s-%s-%s-%s", v.Version.Platform, v.Version.GoVersion, v.Version.Version, opts.AuthzMode)},
 		},
 		apiURL:                         rbacURL,
 		tokenProvider:                  tokenProvider,
 		azureResourceId:                opts.ResourceId,
+		azureResourceIds:               resourceIds,
+		resourceIdSelector:             opts.ResourceIdSelector,
 		armCallLimit:                   opts.ARMCallLimit,
+		maxAttempts:                    opts.CheckAccessMaxAttempts,
+		retryBackoff:                   opts.CheckAccessRetryBackoff,
+		softDeadline:                   opts.CheckAccessSoftDeadline,
+		staleLimit:                     opts.CheckAccessStaleLimit,
 		retrieveGroupMemberships:       opts.AuthzResolveGroupMemberships,
 		skipAuthzForNonAADUsers:        opts.SkipAuthzForNonAADUsers,
 		allowNonResDiscoveryPathAccess: opts.AllowNonResDiscoveryPathAccess,
+		denyAsNoOpinion:                opts.DeniedRequestPolicy == authzOpts.DeniedRequestPolicyNoOpinion,
+		batchWindow:                    opts.CheckAccessBatchWindow,
+		pendingBatches:                 make(map[string][]*pendingCheck),
+		checkAccessBreaker:             newCircuitBreaker("ARM checkaccess", opts.BreakerErrorThreshold, opts.BreakerOpenDuration, opts.BreakerHalfOpenProbes),
+		tokenBreaker:                   newCircuitBreaker("AAD token", opts.BreakerErrorThreshold, opts.BreakerOpenDuration, opts.BreakerHalfOpenProbes),
 	}
 
 	u.skipCheck = make(map[string]void, len(opts.SkipAuthzCheck))
@@ -108,65 +178,272 @@ func newAccessInfo(tokenProvider graph.TokenProvider, rbacURL *url.URL, opts aut
 		u.skipCheck[strings.ToLower(s)] = member
 	}
 
+	u.skipSystemGroups = make(map[string]void, len(opts.SkipAuthzSystemGroups))
+	for _, g := range opts.SkipAuthzSystemGroups {
+		if g != "" {
+			u.skipSystemGroups[strings.ToLower(g)] = member
+		}
+	}
+
 	u.clusterType = getClusterType(opts.AuthzMode)
-	u.lock = sync.RWMutex{}
+	// the zero snapshot is already expired, so the first request refreshes
+	u.token.Store(&tokenSnapshot{})
 
 	return u, nil
 }
 
+// loadToken returns the current token snapshot. The snapshot is immutable;
+// callers read it freely while refreshes swap in replacements.
+func (a *AccessInfo) loadToken() *tokenSnapshot {
+	if snapshot, ok := a.token.Load().(*tokenSnapshot); ok {
+		return snapshot
+	}
+	// an AccessInfo built without newAccessInfo has not stored a snapshot
+	// yet; report an expired, empty token
+	return &tokenSnapshot{}
+}
+
 func New(opts authzOpts.Options, authopts auth.Options, authzInfo *AuthzInfo) (*AccessInfo, error) {
 	rbacURL, err := url.Parse(authzInfo.ARMEndPoint)
 	if err != nil {
 		return nil, err
 	}
 
+	// the same client is used for the token and checkaccess calls, so guard
+	// can present a client certificate to private ARM endpoints
+	httpClient, err := opts.ARMClientCert.NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
 	var tokenProvider graph.TokenProvider
 	switch opts.AuthzMode {
 	case authzOpts.ARCAuthzMode:
-		tokenProvider = graph.NewClientCredentialTokenProvider(authopts.ClientID, authopts.ClientSecret,
-			fmt.Sprintf("%s%s/oauth2/v2.0/token", authzInfo.AADEndpoint, authopts.TenantID),
-			fmt.Sprintf("%s.default", authzInfo.ARMEndPoint))
+		if authopts.AuthMode == auth.WorkloadIdentityAuthMode {
+			tokenProvider = graph.NewFederatedTokenProvider(authopts.ClientID, authopts.FederatedTokenFile,
+				fmt.Sprintf("%s%s/oauth2/v2.0/token", authzInfo.AADEndpoint, authopts.TenantID),
+				fmt.Sprintf("%s.default", authzInfo.ARMEndPoint), httpClient)
+		} else if authopts.ClientAssertionCertFile != "" {
+			tokenProvider, err = graph.NewClientAssertionTokenProvider(authopts.ClientID,
+				authopts.ClientAssertionCertFile, authopts.ClientAssertionKeyFile,
+				fmt.Sprintf("%s%s/oauth2/v2.0/token", authzInfo.AADEndpoint, authopts.TenantID),
+				fmt.Sprintf("%s.default", authzInfo.ARMEndPoint), httpClient)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			tokenProvider = graph.NewClientCredentialTokenProvider(authopts.ClientID, authopts.ClientSecret,
+				fmt.Sprintf("%s%s/oauth2/v2.0/token", authzInfo.AADEndpoint, authopts.TenantID),
+				fmt.Sprintf("%s.default", authzInfo.ARMEndPoint), httpClient)
+		}
 	case authzOpts.AKSAuthzMode:
-		tokenProvider = graph.NewAKSTokenProvider(opts.AKSAuthzTokenURL, authopts.TenantID)
+		tokenProvider = graph.NewAKSTokenProvider(opts.AKSAuthzTokenURL, authopts.TenantID, httpClient)
 	}
 
-	return newAccessInfo(tokenProvider, rbacURL, opts)
+	return newAccessInfo(tokenProvider, rbacURL, opts, httpClient)
 }
 
 func (a *AccessInfo) RefreshToken() error {
-	a.lock.Lock()
-	defer a.lock.Unlock()
-	if a.IsTokenExpired() {
-		resp, err := a.tokenProvider.Acquire("")
-		if err != nil {
-			glog.Errorf("%s failed to refresh token : %s", a.tokenProvider.Name(), err.Error())
-			return errors.Wrap(err, "failed to refresh rbac token")
-		}
+	a.refreshLock.Lock()
+	defer a.refreshLock.Unlock()
+	// another caller may have refreshed the token while we waited for the lock
+	if !a.IsTokenExpired() {
+		return nil
+	}
+	return a.acquireToken()
+}
 
-		// Set the authorization headers for future requests
-		a.headers.Set("Authorization", fmt.Sprintf("Bearer %s", resp.Token))
-		expIn := time.Duration(resp.Expires) * time.Second
-		a.expiresAt = time.Now().Add(expIn - expiryDelta)
-		glog.Infof("Token refreshed successfully on %s. Expire at:%s", time.Now(), a.expiresAt)
+// acquireToken fetches a fresh token and swaps it in. The token call happens
+// outside the header lock, so in-flight checkaccess requests are not blocked.
+// Callers must hold refreshLock.
+func (a *AccessInfo) acquireToken() error {
+	if err := a.tokenBreaker.allow(); err != nil {
+		return err
 	}
 
+	resp, err := a.tokenProvider.Acquire(context.Background(), "")
+	a.tokenBreaker.observe(err != nil)
+	if err != nil {
+		glog.Errorf("%s failed to refresh token : %s", a.tokenProvider.Name(), err.Error())
+		tokenRefreshFailures.Inc()
+		return errors.Wrap(err, "failed to refresh rbac token")
+	}
+
+	// swap in the new token for future requests; in-flight requests keep the
+	// snapshot they already loaded
+	expIn := time.Duration(resp.Expires) * time.Second
+	snapshot := &tokenSnapshot{
+		authorization: fmt.Sprintf("Bearer %s", resp.Token),
+		expiresAt:     time.Now().Add(expIn - expiryDelta),
+	}
+	a.token.Store(snapshot)
+	glog.Infof("Token refreshed successfully on %s. Expire at:%s", time.Now(), snapshot.expiresAt)
+
 	return nil
 }
 
+// RunTokenRefresher renews the token in the background ahead of expiry, so
+// checkaccess requests never block on token acquisition. A nil stopCh runs
+// the refresher for the lifetime of the process.
+func (a *AccessInfo) RunTokenRefresher(stopCh <-chan struct{}) {
+	for {
+		wait := time.Until(a.loadToken().expiresAt) - time.Duration(rand.Int63n(int64(tokenRefreshJitter)))
+		if wait < 0 {
+			wait = 0
+		}
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(wait):
+		}
+
+		a.refreshLock.Lock()
+		err := a.acquireToken()
+		a.refreshLock.Unlock()
+		if err != nil {
+			// the current token stays valid until expiresAt, so failures are
+			// retried instead of surfacing on the request path
+			glog.Errorf("background token refresh failed, retrying in %s: %s", tokenRefreshRetryInterval, err)
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(tokenRefreshRetryInterval):
+			}
+		}
+	}
+}
+
 func (a *AccessInfo) IsTokenExpired() bool {
-	return a.expiresAt.Before(time.Now())
+	return a.loadToken().expiresAt.Before(time.Now())
+}
+
+// ARMAuthFailure returns an error describing the last 401/403 ARM answered
+// checkaccess with, or nil when the last call was authorized. It backs the
+// /readyz probe, so a misconfigured role assignment shows up there instead
+// of only as failed reviews.
+func (a *AccessInfo) ARMAuthFailure() error {
+	if msg, _ := a.armAuthFailure.Load().(string); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
 }
 
 func (a *AccessInfo) ShouldSkipAuthzCheckForNonAADUsers() bool {
 	return a.skipAuthzForNonAADUsers
 }
 
-func (a *AccessInfo) GetResultFromCache(request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store) (bool, bool) {
-	var result bool
+// ResolveResourceId returns the azure resource id to use as RBAC scope for
+// the given request. When azure.resource-ids is configured, the cluster name
+// is selected per request using the configured selector; otherwise the single
+// azure.resource-id is used.
+func (a *AccessInfo) ResolveResourceId(reqInfo authz.RequestInfo) (string, error) {
+	if len(a.azureResourceIds) == 0 {
+		return a.azureResourceId, nil
+	}
+
+	var clusterName string
+	switch a.resourceIdSelector {
+	case authzOpts.HeaderResourceIdSelector:
+		clusterName = reqInfo.ClusterHeader
+	default:
+		clusterName = reqInfo.CommonName
+	}
+
+	if resourceId, ok := a.azureResourceIds[strings.ToLower(clusterName)]; ok {
+		return resourceId, nil
+	}
+	if a.azureResourceId != "" {
+		return a.azureResourceId, nil
+	}
+	return "", errors.Errorf("no azure resource id configured for cluster %s", clusterName)
+}
+
+func (a *AccessInfo) resultCacheKey(request *authzv1beta1.SubjectAccessReviewSpec, resourceId, clientID string) string {
 	key := getResultCacheKey(request)
+	// keep cached verdicts of different clusters apart when guard serves a fleet
+	if len(a.azureResourceIds) > 0 {
+		key = path.Join(resourceId, key)
+	}
+	// hard tenant isolation: verdicts are always namespaced by the client
+	// identity, so one tenant's decision is never served to another. The key
+	// format is versioned; entries written under an older format are simply
+	// never read again after an upgrade.
+	return authz.VersionedCacheKey(path.Join(defaultDir(strings.ToLower(clientID)), key))
+}
+
+func (a *AccessInfo) GetResultFromCache(request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, resourceId, clientID string) (bool, bool) {
+	var result cachedResult
+	key := a.resultCacheKey(request, resourceId, clientID)
 	glog.V(10).Infof("Cache search for key: %s", key)
 	found, _ := store.Get(key, &result)
-	return found, result
+	if found && time.Now().After(result.ExpiresAt) {
+		glog.V(10).Infof("Cache entry for key: %s expired at %s", key, result.ExpiresAt)
+		// expired entries are kept around for the stale limit, so the soft
+		// deadline fallback can still use them
+		if a.staleLimit <= 0 || time.Since(result.ExpiresAt) > a.staleLimit {
+			_ = store.Delete(key)
+		}
+		checkAccessCacheRequests.WithLabelValues("miss").Inc()
+		return false, false
+	}
+	if found {
+		checkAccessCacheRequests.WithLabelValues("hit").Inc()
+	} else {
+		checkAccessCacheRequests.WithLabelValues("miss").Inc()
+	}
+	return found, result.Result
+}
+
+// SoftDeadline returns the configured checkaccess soft deadline; 0 disables
+// the stale cache fallback.
+func (a *AccessInfo) SoftDeadline() time.Duration {
+	return a.softDeadline
+}
+
+// GetStaleResultFromCache returns a cached verdict even after it has expired,
+// as long as it expired less than the stale limit ago. It backs the soft
+// deadline fallback.
+func (a *AccessInfo) GetStaleResultFromCache(request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, resourceId, clientID string) (bool, bool) {
+	var result cachedResult
+	key := a.resultCacheKey(request, resourceId, clientID)
+	found, _ := store.Get(key, &result)
+	if !found {
+		return false, false
+	}
+	if time.Now().Before(result.ExpiresAt) {
+		return true, result.Result
+	}
+	if a.staleLimit > 0 && time.Since(result.ExpiresAt) <= a.staleLimit {
+		return true, result.Result
+	}
+	return false, false
+}
+
+// ResultCacheKeyFor composes the full, versioned cache key a verdict for the
+// review is stored under for the given client, so cache inspection can show
+// admins the exact key a request maps to.
+func (a *AccessInfo) ResultCacheKeyFor(request *authzv1beta1.SubjectAccessReviewSpec, resourceId, clientID string) string {
+	return a.resultCacheKey(request, resourceId, clientID)
+}
+
+// PeekResultFromCache reports what the result cache holds for the request
+// without the metric and eviction side effects of GetResultFromCache, so
+// inspecting the cache never changes it. stale is true for an entry past its
+// TTL that is still within the stale limit.
+func (a *AccessInfo) PeekResultFromCache(request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, resourceId, clientID string) (found, allowed, stale bool) {
+	var result cachedResult
+	found, _ = store.Get(a.resultCacheKey(request, resourceId, clientID), &result)
+	if !found {
+		return false, false, false
+	}
+	if time.Now().After(result.ExpiresAt) {
+		if a.staleLimit <= 0 || time.Since(result.ExpiresAt) > a.staleLimit {
+			return false, false, false
+		}
+		return true, result.Result, true
+	}
+	return true, result.Result, false
 }
 
 func (a *AccessInfo) SkipAuthzCheck(request *authzv1beta1.SubjectAccessReviewSpec) bool {
@@ -177,10 +454,29 @@ func (a *AccessInfo) SkipAuthzCheck(request *authzv1beta1.SubjectAccessReviewSpe
 	return false
 }
 
-func (a *AccessInfo) SetResultInCache(request *authzv1beta1.SubjectAccessReviewSpec, result bool, store authz.Store) error {
-	key := getResultCacheKey(request)
-	glog.V(10).Infof("Cache set for key: %s, value: %t", key, result)
-	return store.Set(key, result)
+// SkipAuthzCheckForSystemGroups reports whether the subject carries one of
+// the allowlisted system: groups. Azure RBAC can never resolve these
+// kubernetes-internal groups, so such reviews are answered locally with no
+// opinion instead of producing a spurious ARM call and a denial log.
+func (a *AccessInfo) SkipAuthzCheckForSystemGroups(request *authzv1beta1.SubjectAccessReviewSpec) bool {
+	if len(a.skipSystemGroups) == 0 {
+		return false
+	}
+	for _, group := range request.Groups {
+		if _, ok := a.skipSystemGroups[strings.ToLower(group)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AccessInfo) SetResultInCache(request *authzv1beta1.SubjectAccessReviewSpec, result bool, ttl time.Duration, store authz.Store, resourceId, clientID string) error {
+	if ttl <= 0 {
+		ttl = defaultResultCacheTTL
+	}
+	key := a.resultCacheKey(request, resourceId, clientID)
+	glog.V(10).Infof("Cache set for key: %s, value: %t, ttl: %s", key, result, ttl)
+	return store.Set(key, cachedResult{Result: result, ExpiresAt: time.Now().Add(ttl)})
 }
 
 func (a *AccessInfo) AllowNonResPathDiscoveryAccess(request *authzv1beta1.SubjectAccessReviewSpec) bool {
@@ -193,29 +489,53 @@ func (a *AccessInfo) AllowNonResPathDiscoveryAccess(request *authzv1beta1.Subjec
 	return false
 }
 
+// setReqHeaders clones the static headers into the request and sets the
+// current token on it, so the request owns its header map and value slices
+// outright and a concurrent refresh can never touch them.
 func (a *AccessInfo) setReqHeaders(req *http.Request) {
-	a.lock.RLock()
-	defer a.lock.RUnlock()
-	// Set the auth headers for the request
 	if req.Header == nil {
-		req.Header = make(http.Header)
+		req.Header = make(http.Header, len(a.staticHeaders)+1)
 	}
 
-	for k, value := range a.headers {
-		req.Header[k] = value
+	for k, values := range a.staticHeaders {
+		req.Header[k] = append([]string(nil), values...)
+	}
+	if authorization := a.loadToken().authorization; authorization != "" {
+		req.Header.Set("Authorization", authorization)
 	}
 }
 
-func (a *AccessInfo) CheckAccess(request *authzv1beta1.SubjectAccessReviewSpec) (*authzv1beta1.SubjectAccessReviewStatus, error) {
-	checkAccessBody, err := prepareCheckAccessRequestBody(request, a.clusterType, a.azureResourceId, a.retrieveGroupMemberships)
+func (a *AccessInfo) CheckAccess(ctx context.Context, request *authzv1beta1.SubjectAccessReviewSpec, resourceId string) (*authzv1beta1.SubjectAccessReviewStatus, time.Duration, error) {
+	checkAccessBody, err := prepareCheckAccessRequestBody(request, a.clusterType, resourceId, a.retrieveGroupMemberships)
 
 	if err != nil {
-		return nil, errors.Wrap(err, "error in preparing check access request")
+		return nil, 0, errors.Wrap(err, "error in preparing check access request")
 	}
 
+	data, err := a.sendCheckAccessRequest(ctx, checkAccessBody, request, resourceId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Decode response and prepare k8s response
+	status, ttl, err := ConvertCheckAccessResponse(data)
+	return a.ApplyDeniedPolicy(status), ttl, err
+}
+
+// ApplyDeniedPolicy downgrades an explicit deny from Azure RBAC to no opinion
+// when azure.denied-request-policy is no-opinion, so the API server falls
+// through to the next authorizer. The reason is kept for visibility.
+func (a *AccessInfo) ApplyDeniedPolicy(status *authzv1beta1.SubjectAccessReviewStatus) *authzv1beta1.SubjectAccessReviewStatus {
+	if a.denyAsNoOpinion && status != nil && status.Denied {
+		status.Denied = false
+	}
+	return status
+}
+
+func (a *AccessInfo) sendCheckAccessRequest(ctx context.Context, checkAccessBody *CheckAccessRequest, request *authzv1beta1.SubjectAccessReviewSpec, resourceId string) ([]byte, error) {
 	checkAccessURL := *a.apiURL
 	// Append the path for azure cluster resource id
-	checkAccessURL.Path = path.Join(checkAccessURL.Path, a.azureResourceId)
+	checkAccessURL.Path = path.Join(checkAccessURL.Path, resourceId)
 	exist, nameSpaceString := getNameSpaceScope(request)
 	if exist {
 		checkAccessURL.Path = path.Join(checkAccessURL.Path, nameSpaceString)
@@ -230,17 +550,57 @@ func (a *AccessInfo) CheckAccess(request *authzv1beta1.SubjectAccessReviewSpec)
 	if err := json.NewEncoder(buf).Encode(checkAccessBody); err != nil {
 		return nil, errors.Wrap(err, "error encoding check access request")
 	}
+	body := buf.Bytes()
 
-	if glog.V(10) {
-		binaryData, _ := json.MarshalIndent(checkAccessBody, "", "    ")
-		glog.V(10).Infof("checkAccessURI:%s", checkAccessURL.String())
-		glog.V(10).Infof("binary data:%s", binaryData)
+	glog.V(10).Infof("checkAccessURI:%s", checkAccessURL.String())
+	glog.V(10).Infof("binary data:%s", debuglog.JSON(checkAccessBody))
+
+	// one logical checkaccess call is one breaker observation; retries below
+	// count as part of the same call
+	if err := a.checkAccessBreaker.allow(); err != nil {
+		return nil, err
+	}
+
+	backoff := a.retryBackoff
+	for attempt := 1; ; attempt++ {
+		data, retryAfter, retriable, err := a.doCheckAccessRequest(ctx, checkAccessURL.String(), body)
+		if err == nil {
+			a.checkAccessBreaker.observe(false)
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			// the caller is gone; its deadline passing says nothing about ARM
+			return nil, errors.Wrap(ctx.Err(), "context done before checkaccess completed")
+		}
+		if !retriable || attempt >= a.maxAttempts {
+			a.checkAccessBreaker.observe(retriable)
+			return nil, err
+		}
+
+		// ARM Retry-After hints take precedence over our own backoff
+		delay := backoff
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		glog.Warningf("checkaccess attempt %d of %d failed, retrying in %s: %s", attempt, a.maxAttempts, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "context done before checkaccess completed")
+		case <-time.After(delay):
+		}
+		backoff *= 2
 	}
+}
 
-	req, err := http.NewRequest(http.MethodPost, checkAccessURL.String(), buf)
+// doCheckAccessRequest issues a single checkaccess POST. On failure it
+// reports whether the error is transient (connection errors, 429 and 5xx)
+// and any Retry-After hint sent by ARM.
+func (a *AccessInfo) doCheckAccessRequest(ctx context.Context, checkAccessURL string, body []byte) (data []byte, retryAfter time.Duration, retriable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, checkAccessURL, bytes.NewReader(body))
 	if err != nil {
-		return nil, errors.Wrap(err, "error creating check access request")
+		return nil, 0, false, errors.Wrap(err, "error creating check access request")
 	}
+	req = req.WithContext(ctx)
 
 	a.setReqHeaders(req)
 
@@ -249,30 +609,44 @@ func (a *AccessInfo) CheckAccess(request *authzv1beta1.SubjectAccessReviewSpec)
 		glog.V(10).Infoln(cmd)
 	}
 
+	start := time.Now()
 	resp, err := a.client.Do(req)
+	checkAccessDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
-		return nil, errors.Wrap(err, "error in check access request execution")
+		checkAccessRequests.WithLabelValues("<error>").Inc()
+		return nil, 0, true, errors.Wrap(err, "error in check access request execution")
 	}
+	checkAccessRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
 
-	data, err := ioutil.ReadAll(resp.Body)
+	data, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.Wrap(err, "error in reading response body")
+		return nil, 0, true, errors.Wrap(err, "error in reading response body")
 	}
 
 	defer resp.Body.Close()
 	glog.V(10).Infof("checkaccess response: %s, Configured ARM call limit: %d", string(data), a.armCallLimit)
 	if resp.StatusCode != http.StatusOK {
 		glog.Errorf("error in check access response. error code: %d, response: %s", resp.StatusCode, string(data))
+		// 401/403 means guard's own identity cannot perform access checks, a
+		// deployment misconfiguration no retry will fix
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			checkAccessAuthFailures.Inc()
+			msg := fmt.Sprintf("ARM answered %d to checkaccess; check the role assignment of guard's identity on the cluster scope. response: %s", resp.StatusCode, string(data))
+			a.armAuthFailure.Store(msg)
+			return nil, 0, false, errors.Wrap(ErrARMAuthFailure, msg)
+		}
 		if resp.StatusCode == http.StatusTooManyRequests {
 			glog.V(10).Infoln("Closing idle TCP connections.")
 			a.client.CloseIdleConnections()
-			// TODO: add prom metrics for this scenario
 		}
-		return nil, errors.Errorf("request %s failed with status code: %d and response: %s", req.URL.Path, resp.StatusCode, string(data))
+		retriable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), retriable, errors.Errorf("request %s failed with status code: %d and response: %s", req.URL.Path, resp.StatusCode, string(data))
 	} else {
+		a.armAuthFailure.Store("")
 		remaining := resp.Header.Get(remainingSubReadARMHeader)
 		glog.Infof("Remaining request count in ARM instance:%s", remaining)
 		count, _ := strconv.Atoi(remaining)
+		armRemainingQuota.Set(float64(count))
 		if count < a.armCallLimit {
 			if glog.V(10) {
 				glog.V(10).Infoln("Closing idle TCP connections.")
@@ -284,6 +658,22 @@ func (a *AccessInfo) CheckAccess(request *authzv1beta1.SubjectAccessReviewSpec)
 		}
 	}
 
-	// Decode response and prepare k8s response
-	return ConvertCheckAccessResponse(data)
+	return data, 0, false, nil
+}
+
+// parseRetryAfter parses the Retry-After response header, either a delay in
+// seconds or an HTTP date. It returns 0 when the header is absent or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay
+		}
+	}
+	return 0
 }
@@ -16,16 +16,21 @@ limitations under the License.
 package rbac
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/appscode/guard/auth/providers/azure/graph"
+	"github.com/appscode/guard/authz/providers/azure/data"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	authzv1beta1 "k8s.io/api/authorization/v1beta1"
 )
@@ -39,12 +44,11 @@ func getAPIServerAndAccessInfo(returnCode int, body, clusterType, resourceId str
 	u := &AccessInfo{
 		client:          http.DefaultClient,
 		apiURL:          apiURL,
-		headers:         http.Header{},
-		expiresAt:       time.Now().Add(time.Hour),
+		staticHeaders:   http.Header{},
 		clusterType:     clusterType,
 		azureResourceId: resourceId,
-		armCallLimit:    0,
-		lock:            sync.RWMutex{}}
+		armCallLimit:    0}
+	u.token.Store(&tokenSnapshot{expiresAt: time.Now().Add(time.Hour)})
 	return ts, u
 }
 
@@ -62,7 +66,7 @@ func TestCheckAccess(t *testing.T) {
 			ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
 				Subresource: "status", Version: "v1", Name: "test", Verb: "delete"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
 
-		response, err := u.CheckAccess(request)
+		response, _, err := u.CheckAccess(context.Background(), request, u.azureResourceId)
 
 		assert.Nilf(t, err, "Should not have got error")
 		assert.NotNil(t, response)
@@ -81,7 +85,7 @@ func TestCheckAccess(t *testing.T) {
 			ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
 				Subresource: "status", Version: "v1", Name: "test", Verb: "delete"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
 
-		response, err := u.CheckAccess(request)
+		response, _, err := u.CheckAccess(context.Background(), request, u.azureResourceId)
 
 		assert.Nilf(t, response, "response should be nil")
 		assert.NotNilf(t, err, "should get error")
@@ -99,24 +103,221 @@ func TestCheckAccess(t *testing.T) {
 			ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
 				Subresource: "status", Version: "v1", Name: "test", Verb: "delete"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
 
-		response, err := u.CheckAccess(request)
+		response, _, err := u.CheckAccess(context.Background(), request, u.azureResourceId)
 
 		assert.Nilf(t, response, "response should be nil")
 		assert.NotNilf(t, err, "should get error")
 	})
 }
 
+func TestSkipAuthzCheckForSystemGroups(t *testing.T) {
+	u := &AccessInfo{skipSystemGroups: map[string]void{"system:nodes": {}, "system:kube-scheduler": {}}}
+
+	request := &authzv1beta1.SubjectAccessReviewSpec{User: "kubelet", Groups: []string{"system:authenticated", "SYSTEM:NODES"}}
+	assert.True(t, u.SkipAuthzCheckForSystemGroups(request), "allowlisted group should match case-insensitively")
+
+	request = &authzv1beta1.SubjectAccessReviewSpec{User: "alpha@bing.com", Groups: []string{"system:authenticated"}}
+	assert.False(t, u.SkipAuthzCheckForSystemGroups(request), "system:authenticated is not allowlisted")
+
+	u = &AccessInfo{}
+	assert.False(t, u.SkipAuthzCheckForSystemGroups(request), "no allowlist configured")
+}
+
+func TestCheckAccessARMAuthFailure(t *testing.T) {
+	ts, u := getAPIServerAndAccessInfo(http.StatusForbidden, `""`, "arc", "resourceid")
+	defer ts.Close()
+
+	request := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alpha@bing.com",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
+			Version: "v1", Verb: "get"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
+
+	response, _, err := u.CheckAccess(context.Background(), request, u.azureResourceId)
+
+	assert.Nilf(t, response, "response should be nil")
+	assert.NotNilf(t, err, "should get error")
+	assert.Equal(t, ErrARMAuthFailure, errors.Cause(err), "403 from ARM should be reported as an auth failure")
+	assert.NotNilf(t, u.ARMAuthFailure(), "auth failure should be recorded for readyz")
+}
+
+func TestCheckAccessDeniedRequestPolicy(t *testing.T) {
+	var deniedBody = `[{"accessDecision":"NotAllowed",
+	"actionId":"Microsoft.Kubernetes/connectedClusters/pods/delete",
+	"isDataAction":true,"roleAssignment":null,"denyAssignment":null,"timeToLiveInMs":300000}]`
+
+	request := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alpha@bing.com",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
+			Subresource: "status", Version: "v1", Name: "test", Verb: "delete"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
+
+	t.Run("default policy returns explicit deny", func(t *testing.T) {
+		ts, u := getAPIServerAndAccessInfo(http.StatusOK, deniedBody, "arc", "resourceid")
+		defer ts.Close()
+
+		response, _, err := u.CheckAccess(context.Background(), request, u.azureResourceId)
+
+		assert.Nilf(t, err, "Should not have got error")
+		assert.NotNil(t, response)
+		assert.Equal(t, response.Allowed, false)
+		assert.Equal(t, response.Denied, true)
+	})
+
+	t.Run("no-opinion policy downgrades deny", func(t *testing.T) {
+		ts, u := getAPIServerAndAccessInfo(http.StatusOK, deniedBody, "arc", "resourceid")
+		defer ts.Close()
+		u.denyAsNoOpinion = true
+
+		response, _, err := u.CheckAccess(context.Background(), request, u.azureResourceId)
+
+		assert.Nilf(t, err, "Should not have got error")
+		assert.NotNil(t, response)
+		assert.Equal(t, response.Allowed, false)
+		assert.Equal(t, response.Denied, false)
+		assert.Equal(t, response.Reason, AccessNotAllowedVerdict)
+	})
+}
+
+func TestCheckAccessRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[{"accessDecision":"Allowed","actionId":"Microsoft.Kubernetes/connectedClusters/pods/read","timeToLiveInMs":300000}]`))
+	}))
+	defer ts.Close()
+
+	apiURL, _ := url.Parse(ts.URL)
+	u := &AccessInfo{
+		client:          http.DefaultClient,
+		apiURL:          apiURL,
+		staticHeaders:   http.Header{},
+		clusterType:     "arc",
+		azureResourceId: "resourceid",
+		armCallLimit:    0,
+		maxAttempts:     3,
+		retryBackoff:    time.Millisecond,
+	}
+	u.token.Store(&tokenSnapshot{expiresAt: time.Now().Add(time.Hour)})
+
+	request := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alpha@bing.com",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
+			Subresource: "status", Version: "v1", Name: "test", Verb: "delete"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
+
+	response, _, err := u.CheckAccess(context.Background(), request, u.azureResourceId)
+	assert.Nilf(t, err, "Should not have got error")
+	assert.NotNil(t, response)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "transient 429s should be retried")
+}
+
+func TestCheckAccessHonorsContext(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// hold the call until the test is done, well past the client deadline
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	apiURL, _ := url.Parse(ts.URL)
+	u := &AccessInfo{
+		client:          http.DefaultClient,
+		apiURL:          apiURL,
+		staticHeaders:   http.Header{},
+		clusterType:     "arc",
+		azureResourceId: "resourceid",
+		maxAttempts:     1,
+	}
+	u.token.Store(&tokenSnapshot{expiresAt: time.Now().Add(time.Hour)})
+
+	request := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alpha@bing.com",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: "pods",
+			Version: "v1", Verb: "get"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		_, _, err := u.CheckAccess(ctx, request, u.azureResourceId)
+		assert.NotNilf(t, err, "should get error once the context deadline passes")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CheckAccess did not return after the context deadline")
+	}
+}
+
+func TestCheckAccessBatched(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var req CheckAccessRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode checkaccess request: %s", err)
+		}
+		decisions := make([]AuthorizationDecision, len(req.Actions))
+		for i, action := range req.Actions {
+			decisions[i] = AuthorizationDecision{Decision: "Allowed", ActionId: action.Id, TimeToLiveInMs: 300000}
+		}
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(decisions)
+	}))
+	defer ts.Close()
+
+	apiURL, _ := url.Parse(ts.URL)
+	u := &AccessInfo{
+		client:          http.DefaultClient,
+		apiURL:          apiURL,
+		staticHeaders:   http.Header{},
+		clusterType:     "arc",
+		azureResourceId: "resourceid",
+		armCallLimit:    0,
+		batchWindow:     50 * time.Millisecond,
+		pendingBatches:  make(map[string][]*pendingCheck),
+	}
+	u.token.Store(&tokenSnapshot{expiresAt: time.Now().Add(time.Hour)})
+
+	newRequest := func(resource string) *authzv1beta1.SubjectAccessReviewSpec {
+		return &authzv1beta1.SubjectAccessReviewSpec{
+			User: "alpha@bing.com",
+			ResourceAttributes: &authzv1beta1.ResourceAttributes{Namespace: "dev", Group: "", Resource: resource,
+				Version: "v1", Verb: "get"}, Extra: map[string]authzv1beta1.ExtraValue{"oid": {"00000000-0000-0000-0000-000000000000"}}}
+	}
+
+	var wg sync.WaitGroup
+	for _, resource := range []string{"pods", "deployments"} {
+		wg.Add(1)
+		go func(resource string) {
+			defer wg.Done()
+			response, _, err := u.CheckAccessBatched(context.Background(), newRequest(resource), u.azureResourceId)
+			assert.Nilf(t, err, "Should not have got error")
+			assert.NotNil(t, response)
+			assert.Equal(t, response.Allowed, true)
+		}(resource)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "both reviews should be coalesced into one checkaccess call")
+}
+
 func getAuthServerAndAccessInfo(returnCode int, body, clientID, clientSecret string) (*httptest.Server, *AccessInfo) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(returnCode)
 		_, _ = w.Write([]byte(body))
 	}))
 	u := &AccessInfo{
-		client:  http.DefaultClient,
-		headers: http.Header{},
-		lock:    sync.RWMutex{},
+		client:        http.DefaultClient,
+		staticHeaders: http.Header{},
 	}
-	u.tokenProvider = graph.NewClientCredentialTokenProvider(clientID, clientSecret, ts.URL, "")
+	u.tokenProvider = graph.NewClientCredentialTokenProvider(clientID, clientSecret, ts.URL, "", nil)
 	return ts, u
 }
 
@@ -135,11 +336,12 @@ func TestLogin(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error when trying to log in: %s", err)
 		}
-		if u.headers.Get("Authorization") != fmt.Sprintf("Bearer %s", validToken) {
-			t.Errorf("Authorization header should be set. Expected: %q. Got: %q", fmt.Sprintf("Bearer %s", validToken), u.headers.Get("Authorization"))
+		snapshot := u.loadToken()
+		if snapshot.authorization != fmt.Sprintf("Bearer %s", validToken) {
+			t.Errorf("Authorization header should be set. Expected: %q. Got: %q", fmt.Sprintf("Bearer %s", validToken), snapshot.authorization)
 		}
-		if !time.Now().Before(u.expiresAt) {
-			t.Errorf("Expiry not set properly. Expected it to be after the current time. Actual: %v", u.expiresAt)
+		if !time.Now().Before(snapshot.expiresAt) {
+			t.Errorf("Expiry not set properly. Expected it to be after the current time. Actual: %v", snapshot.expiresAt)
 		}
 	})
 
@@ -154,11 +356,10 @@ func TestLogin(t *testing.T) {
 	t.Run("request error", func(t *testing.T) {
 		badURL := "https://127.0.0.1:34567"
 		u := &AccessInfo{
-			client:  http.DefaultClient,
-			headers: http.Header{},
-			lock:    sync.RWMutex{},
+			client:        http.DefaultClient,
+			staticHeaders: http.Header{},
 		}
-		u.tokenProvider = graph.NewClientCredentialTokenProvider("CIA", "outcome", badURL, "")
+		u.tokenProvider = graph.NewClientCredentialTokenProvider("CIA", "outcome", badURL, "", nil)
 
 		err := u.RefreshToken()
 		assert.NotNilf(t, err, "Should have gotten error")
@@ -172,3 +373,92 @@ func TestLogin(t *testing.T) {
 		assert.NotNilf(t, err, "Should have gotten error")
 	})
 }
+
+func TestResultCacheTenantIsolation(t *testing.T) {
+	u := &AccessInfo{}
+	store, err := data.NewDataStore(data.Options{
+		HardMaxCacheSize:   1,
+		Shards:             1,
+		LifeWindow:         1 * time.Minute,
+		CleanWindow:        1 * time.Minute,
+		MaxEntriesInWindow: 10,
+		MaxEntrySize:       5,
+		Verbose:            false,
+	})
+	assert.Nil(t, err)
+
+	request := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alpha@bing.com",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{
+			Namespace: "dev", Group: "", Resource: "pods", Verb: "delete",
+		},
+	}
+
+	err = u.SetResultInCache(request, true, time.Minute, store, "resourceId", "tenant-a")
+	assert.Nil(t, err)
+
+	// the verdict cached for tenant-a must never be served to tenant-b
+	found, _ := u.GetResultFromCache(request, store, "resourceId", "tenant-b")
+	assert.False(t, found, "cached verdict of one tenant must not be visible to another")
+
+	found, result := u.GetResultFromCache(request, store, "resourceId", "tenant-a")
+	assert.True(t, found)
+	assert.True(t, result)
+}
+
+func TestResultCacheKeyPerClient(t *testing.T) {
+	u := &AccessInfo{}
+	request := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alpha@bing.com",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{
+			Namespace: "dev", Group: "", Resource: "pods", Verb: "delete",
+		},
+	}
+
+	keyA := u.resultCacheKey(request, "resourceId", "Tenant-A")
+	keyB := u.resultCacheKey(request, "resourceId", "tenant-b")
+	assert.NotEqual(t, keyA, keyB)
+	// client names are case insensitive, matching certificate comparison
+	assert.Equal(t, keyA, u.resultCacheKey(request, "resourceId", "tenant-a"))
+}
+
+func TestGetStaleResultFromCache(t *testing.T) {
+	store, err := data.NewDataStore(data.Options{
+		HardMaxCacheSize:   1,
+		Shards:             1,
+		LifeWindow:         1 * time.Minute,
+		CleanWindow:        1 * time.Minute,
+		MaxEntriesInWindow: 10,
+		MaxEntrySize:       5,
+		Verbose:            false,
+	})
+	assert.Nil(t, err)
+
+	request := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alpha@bing.com",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{
+			Namespace: "dev", Group: "", Resource: "pods", Verb: "delete",
+		},
+	}
+
+	u := &AccessInfo{staleLimit: time.Minute}
+	err = u.SetResultInCache(request, true, time.Millisecond, store, "resourceId", "tenant-a")
+	assert.Nil(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	// the expired verdict is not served on the fresh path but remains
+	// available to the stale fallback
+	found, _ := u.GetResultFromCache(request, store, "resourceId", "tenant-a")
+	assert.False(t, found)
+	found, result := u.GetStaleResultFromCache(request, store, "resourceId", "tenant-a")
+	assert.True(t, found)
+	assert.True(t, result)
+
+	// without a stale limit the expired verdict is unusable and deleted
+	u = &AccessInfo{}
+	err = u.SetResultInCache(request, false, time.Millisecond, store, "resourceId", "tenant-b")
+	assert.Nil(t, err)
+	time.Sleep(5 * time.Millisecond)
+	found, _ = u.GetStaleResultFromCache(request, store, "resourceId", "tenant-b")
+	assert.False(t, found)
+}
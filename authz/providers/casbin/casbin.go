@@ -0,0 +1,333 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package casbin answers subject access reviews from casbin-format policy
+// files, giving RBAC-with-domains semantics over SubjectAccessReview
+// attributes without writing Go code. The casbin library itself is not a
+// dependency: only the standard rbac_with_domains model is evaluated, over
+// the usual policy.csv p and g rules, where sub is the user or a role, dom is
+// the namespace, obj is the resource (or non-resource path) and act is the
+// verb.
+package casbin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appscode/guard/authz"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+const (
+	OrgType = "casbin"
+
+	// policy effects, the optional fifth p column
+	effectAllow = "allow"
+	effectDeny  = "deny"
+
+	// verdicts returned to the API server
+	AccessAllowedVerdict = "Access allowed by casbin policy."
+	AccessDeniedVerdict  = "Access denied by casbin policy."
+	NoOpinionVerdict     = "Casbin policy has no opinion, please check Kubernetes RBAC."
+)
+
+var (
+	once   sync.Once
+	client authz.Interface
+	err    error
+)
+
+func init() {
+	authz.SupportedOrgs = append(authz.SupportedOrgs, OrgType)
+}
+
+// policyRule is a p line: p, sub, dom, obj, act [, eft].
+type policyRule struct {
+	sub, dom, obj, act, eft string
+}
+
+// grouping is a g line assigning a role to a user: g, user, role [, dom].
+type grouping struct {
+	user, role, dom string
+}
+
+// enforcer evaluates the rbac_with_domains model over the loaded rules.
+type enforcer struct {
+	policies  []policyRule
+	groupings []grouping
+}
+
+// Authorizer answers subject access reviews from casbin policy files.
+type Authorizer struct {
+	modelFile  string
+	policyFile string
+
+	lock     sync.RWMutex
+	enforcer *enforcer
+	modTime  time.Time
+}
+
+func New(opts Options) (authz.Interface, error) {
+	once.Do(func() {
+		glog.Info("Creating casbin authz client")
+		client, err = newAuthorizer(opts)
+	})
+	return client, err
+}
+
+// NewAuthorizer returns a fresh authorizer for the given options. Unlike New
+// it is not once-guarded, letting callers run a second model and policy next
+// to the primary one.
+func NewAuthorizer(opts Options) (authz.Interface, error) {
+	return newAuthorizer(opts)
+}
+
+func newAuthorizer(opts Options) (*Authorizer, error) {
+	a := &Authorizer{modelFile: opts.ModelFile, policyFile: opts.PolicyFile}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Authorizer) Check(ctx context.Context, request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, reqInfo authz.RequestInfo) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+	if request == nil {
+		return nil, errors.New("subject access review is nil")
+	}
+
+	// pick up edits without a restart; a broken edit keeps the last loaded
+	// policy serving instead of failing reviews
+	if err := a.reloadIfChanged(); err != nil {
+		glog.Errorf("failed to reload casbin policy, keeping the last loaded policy: %s", err)
+	}
+
+	a.lock.RLock()
+	e := a.enforcer
+	a.lock.RUnlock()
+
+	var dom, obj, act string
+	switch {
+	case request.ResourceAttributes != nil:
+		attr := request.ResourceAttributes
+		dom, obj, act = attr.Namespace, attr.Resource, attr.Verb
+		if attr.Subresource != "" {
+			obj = attr.Resource + "/" + attr.Subresource
+		}
+	case request.NonResourceAttributes != nil:
+		obj, act = request.NonResourceAttributes.Path, request.NonResourceAttributes.Verb
+	default:
+		return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Reason: NoOpinionVerdict}, nil
+	}
+
+	switch e.enforce(request.User, request.Groups, dom, obj, act) {
+	case effectDeny:
+		glog.V(3).Infof("casbin policy denies user %s", request.User)
+		return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Denied: true, Reason: AccessDeniedVerdict}, nil
+	case effectAllow:
+		glog.V(3).Infof("casbin policy allows user %s", request.User)
+		return &authzv1beta1.SubjectAccessReviewStatus{Allowed: true, Reason: AccessAllowedVerdict}, nil
+	}
+	return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Reason: NoOpinionVerdict}, nil
+}
+
+// reloadIfChanged reloads the rules when the policy file's modification time
+// moved, so edits take effect without a restart.
+func (a *Authorizer) reloadIfChanged() error {
+	info, err := os.Stat(a.policyFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to stat casbin policy file")
+	}
+	a.lock.RLock()
+	unchanged := info.ModTime().Equal(a.modTime)
+	a.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+	return a.reload()
+}
+
+func (a *Authorizer) reload() error {
+	info, err := os.Stat(a.policyFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to stat casbin policy file")
+	}
+	e, err := loadEnforcer(a.modelFile, a.policyFile)
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	a.enforcer = e
+	a.modTime = info.ModTime()
+	a.lock.Unlock()
+	glog.Infof("loaded %d casbin policy rules and %d role assignments from %s", len(e.policies), len(e.groupings), a.policyFile)
+	return nil
+}
+
+// loadEnforcer validates the model and parses the policy csv.
+func loadEnforcer(modelFile, policyFile string) (*enforcer, error) {
+	if modelFile != "" {
+		data, err := ioutil.ReadFile(modelFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read casbin model file")
+		}
+		if err := validateModel(string(data)); err != nil {
+			return nil, err
+		}
+	}
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read casbin policy file")
+	}
+	return parsePolicyCSV(string(data))
+}
+
+// validateModel checks that the model file describes the rbac_with_domains
+// model this package evaluates, so a policy written against a different model
+// is rejected at startup instead of silently misinterpreted.
+func validateModel(model string) error {
+	definitions := make(map[string]string)
+	for _, line := range strings.Split(model, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		definitions[strings.TrimSpace(parts[0])] = strings.Join(strings.Fields(parts[1]), "")
+	}
+
+	if r := definitions["r"]; r != "sub,dom,obj,act" {
+		return errors.Errorf("unsupported casbin request definition %q. only the rbac_with_domains model (r = sub, dom, obj, act) is supported", r)
+	}
+	if p := definitions["p"]; p != "sub,dom,obj,act" && p != "sub,dom,obj,act,eft" {
+		return errors.Errorf("unsupported casbin policy definition %q. only the rbac_with_domains model (p = sub, dom, obj, act [, eft]) is supported", p)
+	}
+	return nil
+}
+
+// parsePolicyCSV parses the p and g lines of a casbin policy.csv.
+func parsePolicyCSV(policy string) (*enforcer, error) {
+	e := &enforcer{}
+	for i, line := range strings.Split(policy, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		for j := range fields {
+			fields[j] = strings.TrimSpace(fields[j])
+		}
+		switch fields[0] {
+		case "p":
+			if len(fields) != 5 && len(fields) != 6 {
+				return nil, errors.Errorf("casbin policy line %d must be p, sub, dom, obj, act [, eft]", i+1)
+			}
+			rule := policyRule{sub: fields[1], dom: fields[2], obj: fields[3], act: fields[4], eft: effectAllow}
+			if len(fields) == 6 {
+				if fields[5] != effectAllow && fields[5] != effectDeny {
+					return nil, errors.Errorf("casbin policy line %d has invalid effect %s. valid value is either allow or deny", i+1, fields[5])
+				}
+				rule.eft = fields[5]
+			}
+			e.policies = append(e.policies, rule)
+		case "g":
+			if len(fields) != 3 && len(fields) != 4 {
+				return nil, errors.Errorf("casbin policy line %d must be g, user, role [, dom]", i+1)
+			}
+			assignment := grouping{user: fields[1], role: fields[2]}
+			if len(fields) == 4 {
+				assignment.dom = fields[3]
+			}
+			e.groupings = append(e.groupings, assignment)
+		default:
+			return nil, errors.Errorf("casbin policy line %d has unknown rule type %s", i+1, fields[0])
+		}
+	}
+	return e, nil
+}
+
+// enforce evaluates a request with deny-override semantics: a matching deny
+// rule wins, else a matching allow rule allows, else there is no opinion.
+func (e *enforcer) enforce(user string, groups []string, dom, obj, act string) string {
+	// the caller's kubernetes groups count as already-granted roles
+	subjects := append([]string{user}, groups...)
+	subjects = e.expandRoles(subjects, dom)
+
+	effect := ""
+	for _, rule := range e.policies {
+		if !match(rule.dom, dom) || !match(rule.obj, obj) || !match(rule.act, act) {
+			continue
+		}
+		for _, subject := range subjects {
+			if !match(rule.sub, subject) {
+				continue
+			}
+			if rule.eft == effectDeny {
+				return effectDeny
+			}
+			effect = effectAllow
+			break
+		}
+	}
+	return effect
+}
+
+// expandRoles resolves the transitive role assignments of the subjects within
+// the given domain.
+func (e *enforcer) expandRoles(subjects []string, dom string) []string {
+	seen := make(map[string]bool, len(subjects))
+	queue := append([]string{}, subjects...)
+	for _, subject := range queue {
+		seen[subject] = true
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, assignment := range e.groupings {
+			if assignment.user != current || seen[assignment.role] {
+				continue
+			}
+			if assignment.dom != "" && !match(assignment.dom, dom) {
+				continue
+			}
+			seen[assignment.role] = true
+			queue = append(queue, assignment.role)
+			subjects = append(subjects, assignment.role)
+		}
+	}
+	return subjects
+}
+
+// match compares a value against a policy entry that may end in a * wildcard.
+func match(pattern, value string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == value
+}
@@ -0,0 +1,151 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appscode/guard/authz"
+
+	"github.com/stretchr/testify/assert"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+const testPolicyCSV = `
+# developers may read pods in the dev namespaces
+p, developer, dev-*, pods, get
+p, developer, dev-*, pods, list
+p, cluster-admin, *, *, *
+p, *, kube-system, secrets, *, deny
+g, jane@corp.example.com, developer
+g, ops-leads, cluster-admin
+`
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "guard-casbin")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write %s: %s", name, err)
+	}
+	return file
+}
+
+func resourceReview(user, verb, resource, namespace string, groups ...string) *authzv1beta1.SubjectAccessReviewSpec {
+	return &authzv1beta1.SubjectAccessReviewSpec{
+		User:   user,
+		Groups: groups,
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{
+			Verb: verb, Resource: resource, Namespace: namespace,
+		},
+	}
+}
+
+func TestCasbinCheck(t *testing.T) {
+	a, err := newAuthorizer(Options{PolicyFile: writeTestFile(t, "policy.csv", testPolicyCSV)})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name    string
+		request *authzv1beta1.SubjectAccessReviewSpec
+		allowed bool
+		denied  bool
+	}{
+		{"role assignment allows", resourceReview("jane@corp.example.com", "get", "pods", "dev-web"), true, false},
+		{"domain bounds the role", resourceReview("jane@corp.example.com", "get", "pods", "prod"), false, false},
+		{"verb outside the policy", resourceReview("jane@corp.example.com", "delete", "pods", "dev-web"), false, false},
+		{"kubernetes group acts as role", resourceReview("bob@corp.example.com", "delete", "deployments", "prod", "ops-leads"), true, false},
+		{"deny rule wins over allow", resourceReview("bob@corp.example.com", "get", "secrets", "kube-system", "ops-leads"), false, true},
+		{"unknown user gets no opinion", resourceReview("eve@corp.example.com", "get", "pods", "dev-web"), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := a.Check(context.Background(), tt.request, nil, authz.RequestInfo{})
+			assert.Nil(t, err)
+			assert.NotNil(t, status)
+			assert.Equal(t, tt.allowed, status.Allowed)
+			assert.Equal(t, tt.denied, status.Denied)
+		})
+	}
+}
+
+func TestValidateModel(t *testing.T) {
+	supported := `
+[request_definition]
+r = sub, dom, obj, act
+
+[policy_definition]
+p = sub, dom, obj, act
+
+[role_definition]
+g = _, _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && r.obj == p.obj && r.act == p.act
+`
+	assert.Nil(t, validateModel(supported))
+
+	unsupported := `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+`
+	assert.NotNil(t, validateModel(unsupported))
+}
+
+func TestParsePolicyCSV(t *testing.T) {
+	_, err := parsePolicyCSV("p, developer, dev-*, pods")
+	assert.NotNil(t, err, "p rule with too few fields should fail")
+
+	_, err = parsePolicyCSV("p, developer, dev-*, pods, get, audit")
+	assert.NotNil(t, err, "p rule with unknown effect should fail")
+
+	_, err = parsePolicyCSV("q, foo, bar")
+	assert.NotNil(t, err, "unknown rule type should fail")
+
+	e, err := parsePolicyCSV(testPolicyCSV)
+	assert.Nil(t, err)
+	assert.Len(t, e.policies, 4)
+	assert.Len(t, e.groupings, 2)
+}
+
+func TestCasbinTransitiveRoles(t *testing.T) {
+	policy := `
+p, admin, *, *, *
+g, team-lead, admin
+g, jane@corp.example.com, team-lead
+`
+	a, err := newAuthorizer(Options{PolicyFile: writeTestFile(t, "policy.csv", policy)})
+	assert.Nil(t, err)
+
+	status, err := a.Check(context.Background(), resourceReview("jane@corp.example.com", "delete", "nodes", ""), nil, authz.RequestInfo{})
+	assert.Nil(t, err)
+	assert.True(t, status.Allowed)
+}
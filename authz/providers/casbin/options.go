@@ -0,0 +1,63 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type Options struct {
+	// path to the casbin model.conf; empty uses the built-in
+	// rbac_with_domains model
+	ModelFile string
+	// path to the casbin policy.csv
+	PolicyFile string
+}
+
+func NewOptions() Options {
+	return Options{}
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ModelFile, "casbin.model-file", o.ModelFile, "path to the casbin model.conf. Keep empty to use the built-in rbac_with_domains model, which is also the only model supported")
+	fs.StringVar(&o.PolicyFile, "casbin.policy-csv-file", o.PolicyFile, "path to the casbin policy.csv holding the p and g rules. The file is reloaded when it changes")
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	if o.PolicyFile == "" {
+		errs = append(errs, errors.New("casbin.policy-csv-file must be non-empty"))
+	} else if _, err := loadEnforcer(o.ModelFile, o.PolicyFile); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
+	args := d.Spec.Template.Spec.Containers[0].Args
+	if o.ModelFile != "" {
+		args = append(args, fmt.Sprintf("--casbin.model-file=%s", o.ModelFile))
+	}
+	args = append(args, fmt.Sprintf("--casbin.policy-csv-file=%s", o.PolicyFile))
+	d.Spec.Template.Spec.Containers[0].Args = args
+	return extraObjs, nil
+}
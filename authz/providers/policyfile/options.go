@@ -0,0 +1,56 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policyfile
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type Options struct {
+	// path to the YAML or JSON policy file
+	PolicyFile string
+}
+
+func NewOptions() Options {
+	return Options{}
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.PolicyFile, "policyfile.path", o.PolicyFile, "path to the YAML or JSON policy file answering subject access reviews locally. The file is reloaded when it changes")
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	if o.PolicyFile == "" {
+		errs = append(errs, errors.New("policyfile.path must be non-empty"))
+	} else if _, err := loadPolicy(o.PolicyFile); err != nil {
+		errs = append(errs, errors.Wrap(err, "invalid policyfile.path"))
+	}
+	return errs
+}
+
+func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
+	args := d.Spec.Template.Spec.Containers[0].Args
+	args = append(args, fmt.Sprintf("--policyfile.path=%s", o.PolicyFile))
+	d.Spec.Template.Spec.Containers[0].Args = args
+	return extraObjs, nil
+}
@@ -0,0 +1,274 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policyfile
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appscode/guard/authz"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	OrgType = "policyfile"
+
+	// rule effects
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+
+	// verdicts returned to the API server
+	AccessAllowedVerdict = "Access allowed by policy file."
+	AccessDeniedVerdict  = "Access denied by policy file."
+	NoOpinionVerdict     = "Policy file has no opinion, please check Kubernetes RBAC."
+)
+
+var (
+	once   sync.Once
+	client authz.Interface
+	err    error
+)
+
+func init() {
+	authz.SupportedOrgs = append(authz.SupportedOrgs, OrgType)
+}
+
+// Policy is the root document of the policy file.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule grants or denies a set of subjects access to a set of request
+// attributes. Entries support a trailing * wildcard; an empty attribute list
+// matches any value. Deny rules win over allow rules, so the file can act as
+// a deny-list overlay in front of another authorizer.
+type Rule struct {
+	// Users and Groups select the subjects the rule applies to; at least one
+	// entry must match the caller
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+
+	Verbs      []string `json:"verbs,omitempty"`
+	APIGroups  []string `json:"apiGroups,omitempty"`
+	Resources  []string `json:"resources,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NonResourcePaths make the rule match non-resource requests instead of
+	// the resource attributes above
+	NonResourcePaths []string `json:"nonResourcePaths,omitempty"`
+
+	// Effect is either allow or deny, defaulting to allow
+	Effect string `json:"effect,omitempty"`
+}
+
+// Authorizer answers subject access reviews from a local policy file, for
+// air-gapped clusters and deny-list overlays that must not depend on an
+// external authorizer.
+type Authorizer struct {
+	policyFile string
+
+	lock    sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+}
+
+func New(opts Options) (authz.Interface, error) {
+	once.Do(func() {
+		glog.Info("Creating policy file authz client")
+		client, err = newAuthorizer(opts)
+	})
+	return client, err
+}
+
+// NewAuthorizer returns a fresh authorizer for the given options, bypassing
+// the process-wide singleton, so a second policy file can be evaluated side
+// by side with the primary one.
+func NewAuthorizer(opts Options) (authz.Interface, error) {
+	return newAuthorizer(opts)
+}
+
+func newAuthorizer(opts Options) (*Authorizer, error) {
+	a := &Authorizer{policyFile: opts.PolicyFile}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Authorizer) Check(ctx context.Context, request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, reqInfo authz.RequestInfo) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+	if request == nil {
+		return nil, errors.New("subject access review is nil")
+	}
+
+	// pick up edits without a restart; a broken edit keeps the last loaded
+	// policy serving instead of failing reviews
+	if err := a.reloadIfChanged(); err != nil {
+		glog.Errorf("failed to reload policy file, keeping the last loaded policy: %s", err)
+	}
+
+	a.lock.RLock()
+	policy := a.policy
+	a.lock.RUnlock()
+
+	allowed := false
+	for _, rule := range policy.Rules {
+		if !rule.matches(request) {
+			continue
+		}
+		if rule.Effect == EffectDeny {
+			glog.V(3).Infof("policy file denies user %s", request.User)
+			return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Denied: true, Reason: AccessDeniedVerdict}, nil
+		}
+		allowed = true
+	}
+	if allowed {
+		glog.V(3).Infof("policy file allows user %s", request.User)
+		return &authzv1beta1.SubjectAccessReviewStatus{Allowed: true, Reason: AccessAllowedVerdict}, nil
+	}
+	return &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Reason: NoOpinionVerdict}, nil
+}
+
+// reloadIfChanged reloads the policy when the file's modification time moved,
+// so edits take effect without a restart.
+func (a *Authorizer) reloadIfChanged() error {
+	info, err := os.Stat(a.policyFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to stat policy file")
+	}
+	a.lock.RLock()
+	unchanged := info.ModTime().Equal(a.modTime)
+	a.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+	return a.reload()
+}
+
+func (a *Authorizer) reload() error {
+	info, err := os.Stat(a.policyFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to stat policy file")
+	}
+	policy, err := loadPolicy(a.policyFile)
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	a.policy = policy
+	a.modTime = info.ModTime()
+	a.lock.Unlock()
+	glog.Infof("loaded %d policy rules from %s", len(policy.Rules), a.policyFile)
+	return nil
+}
+
+// loadPolicy reads and validates the policy file.
+func loadPolicy(policyFile string) (*Policy, error) {
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read policy file")
+	}
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, errors.Wrap(err, "unable to parse policy file")
+	}
+	for i, rule := range policy.Rules {
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			return nil, errors.Errorf("policy rule %d selects no users or groups", i)
+		}
+		switch rule.Effect {
+		case "", EffectAllow, EffectDeny:
+		default:
+			return nil, errors.Errorf("policy rule %d has invalid effect %s. valid value is either allow or deny", i, rule.Effect)
+		}
+	}
+	return policy, nil
+}
+
+// matches reports whether the rule applies to the given review.
+func (r Rule) matches(request *authzv1beta1.SubjectAccessReviewSpec) bool {
+	if !r.matchesSubject(request) {
+		return false
+	}
+	if request.ResourceAttributes != nil {
+		attr := request.ResourceAttributes
+		return len(r.NonResourcePaths) == 0 &&
+			matchList(r.Verbs, attr.Verb) &&
+			matchList(r.APIGroups, attr.Group) &&
+			matchList(r.Resources, attr.Resource) &&
+			matchList(r.Namespaces, attr.Namespace)
+	}
+	if request.NonResourceAttributes != nil {
+		// a rule only covers non-resource requests when it names paths, so a
+		// subject-plus-verbs rule does not silently match everything
+		if len(r.NonResourcePaths) == 0 {
+			return false
+		}
+		return matchList(r.Verbs, request.NonResourceAttributes.Verb) &&
+			matchList(r.NonResourcePaths, request.NonResourceAttributes.Path)
+	}
+	return false
+}
+
+// matchesSubject reports whether any user or group entry matches the caller.
+func (r Rule) matchesSubject(request *authzv1beta1.SubjectAccessReviewSpec) bool {
+	for _, pattern := range r.Users {
+		if match(pattern, request.User) {
+			return true
+		}
+	}
+	for _, pattern := range r.Groups {
+		for _, group := range request.Groups {
+			if match(pattern, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchList reports whether any pattern matches the value; an empty list
+// matches anything.
+func matchList(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// match compares a value against a pattern that may end in a * wildcard.
+func match(pattern, value string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == value
+}
@@ -0,0 +1,157 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policyfile
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appscode/guard/authz"
+
+	"github.com/stretchr/testify/assert"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+const testPolicy = `
+rules:
+- users: ["jane@corp.example.com"]
+  verbs: ["get", "list"]
+  resources: ["pods"]
+  namespaces: ["dev-*"]
+- groups: ["ops-*"]
+  nonResourcePaths: ["/metrics"]
+- users: ["*"]
+  verbs: ["delete"]
+  namespaces: ["kube-system"]
+  effect: deny
+`
+
+func writePolicyFile(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "guard-policy")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	policyFile := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(policyFile, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write policy file: %s", err)
+	}
+	return policyFile
+}
+
+func resourceReview(user, verb, resource, namespace string, groups ...string) *authzv1beta1.SubjectAccessReviewSpec {
+	return &authzv1beta1.SubjectAccessReviewSpec{
+		User:   user,
+		Groups: groups,
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{
+			Verb: verb, Resource: resource, Namespace: namespace,
+		},
+	}
+}
+
+func TestPolicyFileCheck(t *testing.T) {
+	a, err := newAuthorizer(Options{PolicyFile: writePolicyFile(t, testPolicy)})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name    string
+		request *authzv1beta1.SubjectAccessReviewSpec
+		allowed bool
+		denied  bool
+	}{
+		{"user rule allows", resourceReview("jane@corp.example.com", "get", "pods", "dev-web"), true, false},
+		{"namespace wildcard bounds the rule", resourceReview("jane@corp.example.com", "get", "pods", "prod"), false, false},
+		{"verb outside the rule", resourceReview("jane@corp.example.com", "create", "pods", "dev-web"), false, false},
+		{"unknown user gets no opinion", resourceReview("bob@corp.example.com", "get", "pods", "dev-web"), false, false},
+		{"deny overlay wins over allow", resourceReview("jane@corp.example.com", "delete", "pods", "kube-system"), false, true},
+		{"group wildcard matches non-resource path", &authzv1beta1.SubjectAccessReviewSpec{
+			User: "bob@corp.example.com", Groups: []string{"ops-oncall"},
+			NonResourceAttributes: &authzv1beta1.NonResourceAttributes{Verb: "get", Path: "/metrics"},
+		}, true, false},
+		{"resource rule does not match non-resource request", &authzv1beta1.SubjectAccessReviewSpec{
+			User:                  "jane@corp.example.com",
+			NonResourceAttributes: &authzv1beta1.NonResourceAttributes{Verb: "get", Path: "/logs"},
+		}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := a.Check(context.Background(), tt.request, nil, authz.RequestInfo{})
+			assert.Nil(t, err)
+			assert.NotNil(t, status)
+			assert.Equal(t, tt.allowed, status.Allowed)
+			assert.Equal(t, tt.denied, status.Denied)
+		})
+	}
+}
+
+func TestPolicyFileReload(t *testing.T) {
+	policyFile := writePolicyFile(t, testPolicy)
+	a, err := newAuthorizer(Options{PolicyFile: policyFile})
+	assert.Nil(t, err)
+
+	request := resourceReview("bob@corp.example.com", "get", "pods", "dev-web")
+	status, err := a.Check(context.Background(), request, nil, authz.RequestInfo{})
+	assert.Nil(t, err)
+	assert.False(t, status.Allowed)
+
+	updated := testPolicy + `
+- users: ["bob@corp.example.com"]
+  resources: ["pods"]
+`
+	if err := ioutil.WriteFile(policyFile, []byte(updated), 0600); err != nil {
+		t.Fatalf("failed to update policy file: %s", err)
+	}
+	// make the edit visible even on filesystems with coarse mtime resolution
+	if err := os.Chtimes(policyFile, time.Now(), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("failed to update policy file mtime: %s", err)
+	}
+
+	status, err = a.Check(context.Background(), request, nil, authz.RequestInfo{})
+	assert.Nil(t, err)
+	assert.True(t, status.Allowed)
+
+	// a broken edit keeps the last loaded policy serving
+	if err := ioutil.WriteFile(policyFile, []byte("rules: ["), 0600); err != nil {
+		t.Fatalf("failed to corrupt policy file: %s", err)
+	}
+	if err := os.Chtimes(policyFile, time.Now(), time.Now().Add(2*time.Second)); err != nil {
+		t.Fatalf("failed to update policy file mtime: %s", err)
+	}
+	status, err = a.Check(context.Background(), request, nil, authz.RequestInfo{})
+	assert.Nil(t, err)
+	assert.True(t, status.Allowed)
+}
+
+func TestLoadPolicyValidation(t *testing.T) {
+	_, err := loadPolicy(writePolicyFile(t, `
+rules:
+- verbs: ["get"]
+`))
+	assert.NotNil(t, err, "rule without users or groups should fail")
+
+	_, err = loadPolicy(writePolicyFile(t, `
+rules:
+- users: ["jane"]
+  effect: audit
+`))
+	assert.NotNil(t, err, "rule with unknown effect should fail")
+}
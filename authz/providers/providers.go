@@ -21,6 +21,9 @@ import (
 
 	"github.com/appscode/guard/authz"
 	_ "github.com/appscode/guard/authz/providers/azure"
+	_ "github.com/appscode/guard/authz/providers/casbin"
+	_ "github.com/appscode/guard/authz/providers/policyfile"
+	_ "github.com/appscode/guard/authz/providers/webhook"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
@@ -0,0 +1,88 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/appscode/guard/util/httpclient"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+)
+
+type Options struct {
+	// URL of the downstream SubjectAccessReview webhook
+	Endpoint string
+	// CA bundle used to verify the downstream webhook serving certificate
+	CACertFile string
+	// client certificate presented to the downstream webhook
+	ClientCert httpclient.ClientCertConfig
+	Timeout    time.Duration
+	// how long downstream verdicts are cached; 0 disables caching
+	CacheTTL time.Duration
+}
+
+func NewOptions() Options {
+	return Options{
+		Timeout: defaultTimeout,
+	}
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Endpoint, "authz-webhook.endpoint", o.Endpoint, "url of the downstream webhook that subject access reviews are forwarded to")
+	fs.StringVar(&o.CACertFile, "authz-webhook.ca-cert-file", o.CACertFile, "ca cert file used to verify the downstream webhook serving certificate")
+	o.ClientCert.AddFlagsWithPrefix(fs, "authz-webhook.")
+	fs.DurationVar(&o.Timeout, "authz-webhook.timeout", o.Timeout, "timeout for a call to the downstream webhook")
+	fs.DurationVar(&o.CacheTTL, "authz-webhook.cache-ttl", o.CacheTTL, "how long downstream verdicts are cached. 0 disables caching")
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	if o.Endpoint == "" {
+		errs = append(errs, errors.New("authz-webhook.endpoint must be non-empty"))
+	} else if u, err := url.Parse(o.Endpoint); err != nil || u.Scheme != "https" {
+		errs = append(errs, errors.New("authz-webhook.endpoint must be a valid https url"))
+	}
+	if o.Timeout <= 0 {
+		errs = append(errs, errors.New("authz-webhook.timeout must be positive"))
+	}
+	if o.CacheTTL < 0 {
+		errs = append(errs, errors.New("authz-webhook.cache-ttl must not be negative"))
+	}
+	errs = append(errs, o.ClientCert.Validate("authz-webhook.")...)
+	return errs
+}
+
+func (o Options) Apply(d *apps.Deployment) (extraObjs []runtime.Object, err error) {
+	args := d.Spec.Template.Spec.Containers[0].Args
+	args = append(args, fmt.Sprintf("--authz-webhook.endpoint=%s", o.Endpoint))
+	args = append(args, fmt.Sprintf("--authz-webhook.timeout=%s", o.Timeout))
+	if o.CacheTTL > 0 {
+		args = append(args, fmt.Sprintf("--authz-webhook.cache-ttl=%s", o.CacheTTL))
+	}
+	d.Spec.Template.Spec.Containers[0].Args = args
+	return extraObjs, nil
+}
@@ -0,0 +1,191 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/appscode/guard/authz"
+
+	"github.com/golang/glog"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	OrgType = "webhook"
+
+	// clusterNameHeader is forwarded to the downstream webhook, so a fleet
+	// aware downstream can route the request the same way guard does
+	clusterNameHeader = "x-guard-cluster-name"
+	// clientCNHeader carries the identity of the API server that called guard
+	clientCNHeader = "x-guard-client-cn"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+func init() {
+	authz.SupportedOrgs = append(authz.SupportedOrgs, OrgType)
+}
+
+// Authorizer forwards subject access reviews to a downstream webhook, so
+// guard can front other authorizers and apply caching and metrics uniformly.
+type Authorizer struct {
+	endpoint string
+	client   *http.Client
+	cacheTTL time.Duration
+}
+
+// cachedVerdict is the value stored in the verdict cache.
+type cachedVerdict struct {
+	Status    authzv1beta1.SubjectAccessReviewStatus `json:"status"`
+	ExpiresAt time.Time                              `json:"expiresAt"`
+}
+
+func New(opts Options) (authz.Interface, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if opts.CACertFile != "" {
+		caCert, err := ioutil.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read authz webhook ca cert file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("unable to parse authz webhook ca cert file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.ClientCert.Enabled() {
+		cert, err := opts.ClientCert.Certificate()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &Authorizer{
+		endpoint: opts.Endpoint,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		cacheTTL: opts.CacheTTL,
+	}, nil
+}
+
+func (s *Authorizer) Check(ctx context.Context, request *authzv1beta1.SubjectAccessReviewSpec, store authz.Store, reqInfo authz.RequestInfo) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+	if request == nil {
+		return nil, errors.New("subject access review is nil")
+	}
+
+	key := cacheKey(request, reqInfo)
+	if s.cacheTTL > 0 && store != nil {
+		var verdict cachedVerdict
+		if found, _ := store.Get(key, &verdict); found {
+			if time.Now().Before(verdict.ExpiresAt) {
+				glog.V(3).Infof("cache hit: returning downstream verdict to user %s", request.User)
+				return &verdict.Status, nil
+			}
+			_ = store.Delete(key)
+		}
+	}
+
+	status, err := s.forward(request, reqInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cacheTTL > 0 && store != nil {
+		_ = store.Set(key, cachedVerdict{Status: *status, ExpiresAt: time.Now().Add(s.cacheTTL)})
+	}
+	return status, nil
+}
+
+// forward relays the subject access review to the downstream webhook and
+// returns its verdict.
+func (s *Authorizer) forward(request *authzv1beta1.SubjectAccessReviewSpec, reqInfo authz.RequestInfo) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+	body := authzv1beta1.SubjectAccessReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: authzv1beta1.SchemeGroupVersion.String(),
+			Kind:       "SubjectAccessReview",
+		},
+		Spec: *request,
+	}
+
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(body); err != nil {
+		return nil, errors.Wrap(err, "failed to encode subject access review")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, buf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if reqInfo.ClusterHeader != "" {
+		req.Header.Set(clusterNameHeader, reqInfo.ClusterHeader)
+	}
+	if reqInfo.CommonName != "" {
+		req.Header.Set(clientCNHeader, reqInfo.CommonName)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send request to downstream webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, errors.Errorf("downstream webhook returned status code: %d and response: %s", resp.StatusCode, string(data))
+	}
+
+	var review authzv1beta1.SubjectAccessReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return nil, errors.Wrap(err, "failed to decode downstream webhook response")
+	}
+	return &review.Status, nil
+}
+
+// cacheKey namespaces cached downstream verdicts by the client identity, so
+// one tenant's decision is never served to another. The key covers the group
+// memberships forwarded downstream and is versioned, so entries written under
+// an older format are simply never read again after an upgrade.
+func cacheKey(request *authzv1beta1.SubjectAccessReviewSpec, reqInfo authz.RequestInfo) string {
+	key := path.Join("authz-webhook", strings.ToLower(reqInfo.CommonName), request.User)
+	if request.ResourceAttributes != nil {
+		attr := request.ResourceAttributes
+		key = path.Join(key, attr.Namespace, attr.Group, attr.Resource, attr.Subresource, attr.Name, attr.Verb)
+	} else if request.NonResourceAttributes != nil {
+		key = path.Join(key, request.NonResourceAttributes.Path, request.NonResourceAttributes.Verb)
+	}
+	return authz.VersionedCacheKey(path.Join(key, authz.GroupsDigest(request.Groups)))
+}
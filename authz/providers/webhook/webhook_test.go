@@ -0,0 +1,104 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appscode/guard/authz"
+	"github.com/appscode/guard/authz/providers/azure/data"
+
+	"github.com/stretchr/testify/assert"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+func testStore(t *testing.T) authz.Store {
+	store, err := data.NewDataStore(data.Options{
+		HardMaxCacheSize:   1,
+		Shards:             1,
+		LifeWindow:         1 * time.Minute,
+		CleanWindow:        1 * time.Minute,
+		MaxEntriesInWindow: 10,
+		MaxEntrySize:       5,
+		Verbose:            false,
+	})
+	assert.Nil(t, err)
+	return store
+}
+
+func TestCheckForwardsToDownstreamWebhook(t *testing.T) {
+	var calls int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		assert.Equal(t, "cluster-1", req.Header.Get(clusterNameHeader))
+		assert.NotEmpty(t, req.Header.Get(clientCNHeader))
+
+		review := authzv1beta1.SubjectAccessReview{}
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&review))
+		assert.Equal(t, "alpha@bing.com", review.Spec.User)
+
+		review.Status = authzv1beta1.SubjectAccessReviewStatus{Allowed: true, Reason: "granted downstream"}
+		assert.Nil(t, json.NewEncoder(w).Encode(review))
+	}))
+	defer ts.Close()
+
+	s := &Authorizer{endpoint: ts.URL, client: ts.Client(), cacheTTL: time.Minute}
+	store := testStore(t)
+
+	request := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alpha@bing.com",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{
+			Namespace: "dev", Resource: "pods", Verb: "delete",
+		},
+	}
+	reqInfo := authz.RequestInfo{CommonName: "apiserver-1", ClusterHeader: "cluster-1"}
+
+	status, err := s.Check(context.Background(), request, store, reqInfo)
+	assert.Nil(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, "granted downstream", status.Reason)
+
+	// the second identical request is served from cache
+	status, err = s.Check(context.Background(), request, store, reqInfo)
+	assert.Nil(t, err)
+	assert.True(t, status.Allowed)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// a different client identity must not share the cached verdict
+	_, err = s.Check(context.Background(), request, store, authz.RequestInfo{CommonName: "apiserver-2", ClusterHeader: "cluster-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestCheckDownstreamFailure(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	s := &Authorizer{endpoint: ts.URL, client: ts.Client()}
+
+	status, err := s.Check(context.Background(), &authzv1beta1.SubjectAccessReviewSpec{User: "alpha@bing.com"}, testStore(t), authz.RequestInfo{})
+	assert.NotNil(t, err)
+	assert.Nil(t, status)
+	assert.Contains(t, err.Error(), "status code: 500")
+}
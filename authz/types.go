@@ -16,6 +16,7 @@ limitations under the License.
 package authz
 
 import (
+	"context"
 	"sort"
 	"strings"
 
@@ -46,7 +47,23 @@ func (o orgs) String() string {
 }
 
 type Interface interface {
-	Check(request *authzv1beta1.SubjectAccessReviewSpec, store Store) (*authzv1beta1.SubjectAccessReviewStatus, error)
+	// Check answers the review. ctx carries per-request values such as the
+	// correlation id and is honored on outbound provider calls.
+	Check(ctx context.Context, request *authzv1beta1.SubjectAccessReviewSpec, store Store, reqInfo RequestInfo) (*authzv1beta1.SubjectAccessReviewStatus, error)
+}
+
+// RequestInfo carries per-request attributes of the webhook call that
+// providers may use to route the request, e.g. to pick the right cluster
+// resource when guard serves a fleet of clusters.
+type RequestInfo struct {
+	// CommonName is the CN of the client certificate used for the request.
+	CommonName string
+	// ClusterHeader is the value of the cluster selection header, if sent.
+	ClusterHeader string
+	// CacheBypass forces the provider to answer from the upstream source
+	// instead of a cached verdict. It is only set for allowlisted admin
+	// clients.
+	CacheBypass bool
 }
 
 type Store interface {
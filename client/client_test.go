@@ -0,0 +1,164 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+// newTestClient builds a client against the httptest server, trusting its
+// certificate directly since it is not written out as a PEM file.
+func newTestClient(t *testing.T, srv *httptest.Server, cfg Config) *Client {
+	cfg.Addr = srv.URL
+	c, err := New(cfg)
+	assert.Nil(t, err)
+	c.httpClient = srv.Client()
+	if cfg.Timeout != 0 {
+		c.httpClient.Timeout = cfg.Timeout
+	}
+	return c
+}
+
+func TestReviewToken(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/tokenreviews", r.URL.Path)
+		assert.Equal(t, "my-cluster", r.Header.Get(clusterNameHeader))
+
+		review := authv1.TokenReview{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&review))
+		assert.Equal(t, "sekret", review.Spec.Token)
+
+		review.Status = authv1.TokenReviewStatus{
+			Authenticated: true,
+			User:          authv1.UserInfo{Username: "jane"},
+		}
+		assert.Nil(t, json.NewEncoder(w).Encode(review))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv, Config{ClusterName: "my-cluster"})
+	status, err := c.ReviewToken(context.Background(), "sekret")
+	assert.Nil(t, err)
+	assert.True(t, status.Authenticated)
+	assert.Equal(t, "jane", status.User.Username)
+}
+
+func TestReviewAccess(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/subjectaccessreviews", r.URL.Path)
+		assert.Empty(t, r.Header.Get(clusterNameHeader))
+
+		review := authzv1beta1.SubjectAccessReview{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&review))
+		assert.Equal(t, "jane", review.Spec.User)
+
+		review.Status = authzv1beta1.SubjectAccessReviewStatus{Allowed: true}
+		assert.Nil(t, json.NewEncoder(w).Encode(review))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv, Config{})
+	status, err := c.ReviewAccess(context.Background(), &authzv1beta1.SubjectAccessReviewSpec{User: "jane"})
+	assert.Nil(t, err)
+	assert.True(t, status.Allowed)
+
+	_, err = c.ReviewAccess(context.Background(), nil)
+	assert.NotNil(t, err)
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	var calls int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			http.Error(w, "transient", http.StatusServiceUnavailable)
+			return
+		}
+		review := authv1.TokenReview{Status: authv1.TokenReviewStatus{Authenticated: true}}
+		assert.Nil(t, json.NewEncoder(w).Encode(review))
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv, Config{Retries: 2, RetryBackoff: time.Millisecond})
+	status, err := c.ReviewToken(context.Background(), "sekret")
+	assert.Nil(t, err)
+	assert.True(t, status.Authenticated)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	var calls int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "Missing client certificate", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv, Config{Retries: 3, RetryBackoff: time.Millisecond})
+	// a 4xx answer is deterministic, so it is surfaced without retrying
+	_, err := c.ReviewToken(context.Background(), "sekret")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Missing client certificate")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetryHonorsContext(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "transient", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := newTestClient(t, srv, Config{Retries: 100, RetryBackoff: time.Minute})
+	start := time.Now()
+	_, err := c.ReviewToken(ctx, "sekret")
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Config{})
+	assert.NotNil(t, err)
+
+	_, err = New(Config{Addr: "localhost:443", CACertFile: "/no/such/file"})
+	assert.NotNil(t, err)
+
+	_, err = New(Config{Addr: "localhost:443", ClientCertFile: "/no/such/cert", ClientKeyFile: "/no/such/key"})
+	assert.NotNil(t, err)
+}
+
+func TestAddrNormalization(t *testing.T) {
+	c, err := New(Config{Addr: "10.96.10.96:443"})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://10.96.10.96:443", c.baseURL)
+
+	c, err = New(Config{Addr: "https://guard.kube-system.svc"})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://guard.kube-system.svc", c.baseURL)
+}
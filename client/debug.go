@@ -0,0 +1,91 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+// TraceResponse is the decision trace guard's /debug/authz/trace endpoint
+// answers with. The same struct is the server's wire format, so the client
+// and server cannot drift apart.
+type TraceResponse struct {
+	// Provider is the authz provider that evaluated the review.
+	Provider string `json:"provider"`
+	// Decision is allowed, denied or no opinion.
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+	// EvaluationError carries the provider failure, if any.
+	EvaluationError string `json:"evaluationError,omitempty"`
+	// CacheBypass reports whether the evaluation skipped cached verdicts.
+	CacheBypass bool `json:"cacheBypass,omitempty"`
+	// Elapsed is the provider evaluation time, as a time.Duration string.
+	Elapsed string `json:"elapsed"`
+	// CacheKey is the request half of the result cache key, for providers
+	// that cache verdicts.
+	CacheKey string `json:"cacheKey,omitempty"`
+}
+
+// CacheInfoResponse is guard's /debug/authz/cache answer describing what the
+// result cache holds for a review.
+type CacheInfoResponse struct {
+	// CacheKey is the full, versioned key the review maps to.
+	CacheKey string `json:"cacheKey"`
+	// Found reports whether the cache holds a usable verdict.
+	Found bool `json:"found"`
+	// Allowed is the cached verdict; meaningless unless Found is set.
+	Allowed bool `json:"allowed"`
+	// Stale is set when the verdict is past its TTL but still within the
+	// stale limit.
+	Stale bool `json:"stale"`
+}
+
+// Trace posts the review spec to the debug trace endpoint and returns the
+// decision trace. Set bypass to skip cached verdicts, telling a stale cache
+// entry apart from a real role assignment gap.
+func (c *Client) Trace(ctx context.Context, spec *authzv1beta1.SubjectAccessReviewSpec, bypass bool) (*TraceResponse, error) {
+	if spec == nil {
+		return nil, errors.New("subject access review spec is nil")
+	}
+	path := "/debug/authz/trace"
+	if bypass {
+		path += "?bypass=true"
+	}
+	review := authzv1beta1.SubjectAccessReview{Spec: *spec}
+	result := TraceResponse{}
+	if err := c.post(ctx, path, &review, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CacheInfo posts the review spec to the cache inspection endpoint and
+// returns what the result cache holds for it, without evaluating the review.
+func (c *Client) CacheInfo(ctx context.Context, spec *authzv1beta1.SubjectAccessReviewSpec) (*CacheInfoResponse, error) {
+	if spec == nil {
+		return nil, errors.New("subject access review spec is nil")
+	}
+	review := authzv1beta1.SubjectAccessReview{Spec: *spec}
+	result := CacheInfoResponse{}
+	if err := c.post(ctx, "/debug/authz/cache", &review, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,280 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/appscode/guard/installer"
+
+	"github.com/golang/glog"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1beta1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/versioning"
+	"k8s.io/client-go/kubernetes"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NewCmdDiff returns the `guard diff` command. It renders the installer
+// objects for the given options and diffs them against the live cluster
+// objects using a server-side dry-run, so upgrades can be previewed before
+// applying the generated YAML.
+func NewCmdDiff() *cobra.Command {
+	authopts := installer.NewAuthOptions()
+	authzopts := installer.NewAuthzOptions()
+	kubeconfigPath := ""
+
+	cmd := &cobra.Command{
+		Use:               "diff",
+		Short:             "Diffs the rendered installer objects against the live cluster objects",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			errs := authopts.Validate()
+			if errs != nil {
+				glog.Fatal(errs)
+			}
+
+			errs = authzopts.Validate(&authopts)
+			if errs != nil {
+				glog.Fatal(errs)
+			}
+
+			objects, err := installer.GenerateObjects(authopts, authzopts)
+			if err != nil {
+				glog.Fatal(err)
+			}
+
+			client, err := newKubeClient(kubeconfigPath)
+			if err != nil {
+				glog.Fatal(err)
+			}
+
+			drifted, err := diffObjects(os.Stdout, client, objects)
+			if err != nil {
+				glog.Fatal(err)
+			}
+			if drifted == 0 {
+				fmt.Println("no drift detected")
+				return
+			}
+			os.Exit(1)
+		},
+	}
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "path to the kubeconfig file used to connect to the cluster. If empty, the default loading rules apply")
+	authopts.AddFlags(cmd.Flags())
+	authzopts.AddFlags(cmd.Flags())
+	return cmd
+}
+
+func newKubeClient(kubeconfigPath string) (kubernetes.Interface, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfigPath != "" {
+		loadingRules.ExplicitPath = kubeconfigPath
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// diffObjects prints a unified diff per drifted object and returns the number
+// of objects that drifted.
+func diffObjects(out *os.File, client kubernetes.Interface, objects []runtime.Object) (int, error) {
+	mediaType := "application/yaml"
+	info, ok := runtime.SerializerInfoForMediaType(clientsetscheme.Codecs.SupportedMediaTypes(), mediaType)
+	if !ok {
+		return 0, fmt.Errorf("unsupported media type %q", mediaType)
+	}
+	codec := versioning.NewDefaultingCodecForScheme(clientsetscheme.Scheme, info.Serializer, info.Serializer, nil, nil)
+
+	drifted := 0
+	for _, obj := range objects {
+		live, merged, err := dryRunApply(client, obj)
+		if err != nil {
+			return drifted, err
+		}
+
+		liveYAML, err := encodeForDiff(codec, live)
+		if err != nil {
+			return drifted, err
+		}
+		mergedYAML, err := encodeForDiff(codec, merged)
+		if err != nil {
+			return drifted, err
+		}
+
+		if bytes.Equal(liveYAML, mergedYAML) {
+			continue
+		}
+		drifted++
+
+		name := objectDiffName(obj)
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(liveYAML)),
+			B:        difflib.SplitLines(string(mergedYAML)),
+			FromFile: fmt.Sprintf("live/%s", name),
+			ToFile:   fmt.Sprintf("generated/%s", name),
+			Context:  3,
+		})
+		if err != nil {
+			return drifted, err
+		}
+		fmt.Fprint(out, diff)
+	}
+	return drifted, nil
+}
+
+// dryRunApply submits the generated object to the API server with dry-run,
+// so the returned object carries server defaults and can be compared against
+// the live object without noise. A nil live object means the object does not
+// exist yet.
+func dryRunApply(client kubernetes.Interface, obj runtime.Object) (live, merged runtime.Object, err error) {
+	ctx := context.TODO()
+	getOpts := metav1.GetOptions{}
+	createOpts := metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}
+	updateOpts := metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}}
+
+	switch o := obj.(type) {
+	case *core.Namespace:
+		cur, err := client.CoreV1().Namespaces().Get(ctx, o.Name, getOpts)
+		if kerr.IsNotFound(err) {
+			merged, err := client.CoreV1().Namespaces().Create(ctx, o, createOpts)
+			return nil, merged, err
+		} else if err != nil {
+			return nil, nil, err
+		}
+		o.ResourceVersion = cur.ResourceVersion
+		merged, err := client.CoreV1().Namespaces().Update(ctx, o, updateOpts)
+		return cur, merged, err
+	case *core.ServiceAccount:
+		cur, err := client.CoreV1().ServiceAccounts(o.Namespace).Get(ctx, o.Name, getOpts)
+		if kerr.IsNotFound(err) {
+			merged, err := client.CoreV1().ServiceAccounts(o.Namespace).Create(ctx, o, createOpts)
+			return nil, merged, err
+		} else if err != nil {
+			return nil, nil, err
+		}
+		o.ResourceVersion = cur.ResourceVersion
+		o.Secrets = cur.Secrets
+		merged, err := client.CoreV1().ServiceAccounts(o.Namespace).Update(ctx, o, updateOpts)
+		return cur, merged, err
+	case *rbac.ClusterRole:
+		cur, err := client.RbacV1beta1().ClusterRoles().Get(ctx, o.Name, getOpts)
+		if kerr.IsNotFound(err) {
+			merged, err := client.RbacV1beta1().ClusterRoles().Create(ctx, o, createOpts)
+			return nil, merged, err
+		} else if err != nil {
+			return nil, nil, err
+		}
+		o.ResourceVersion = cur.ResourceVersion
+		merged, err := client.RbacV1beta1().ClusterRoles().Update(ctx, o, updateOpts)
+		return cur, merged, err
+	case *rbac.ClusterRoleBinding:
+		cur, err := client.RbacV1beta1().ClusterRoleBindings().Get(ctx, o.Name, getOpts)
+		if kerr.IsNotFound(err) {
+			merged, err := client.RbacV1beta1().ClusterRoleBindings().Create(ctx, o, createOpts)
+			return nil, merged, err
+		} else if err != nil {
+			return nil, nil, err
+		}
+		o.ResourceVersion = cur.ResourceVersion
+		merged, err := client.RbacV1beta1().ClusterRoleBindings().Update(ctx, o, updateOpts)
+		return cur, merged, err
+	case *core.Secret:
+		cur, err := client.CoreV1().Secrets(o.Namespace).Get(ctx, o.Name, getOpts)
+		if kerr.IsNotFound(err) {
+			merged, err := client.CoreV1().Secrets(o.Namespace).Create(ctx, o, createOpts)
+			return nil, merged, err
+		} else if err != nil {
+			return nil, nil, err
+		}
+		o.ResourceVersion = cur.ResourceVersion
+		merged, err := client.CoreV1().Secrets(o.Namespace).Update(ctx, o, updateOpts)
+		return cur, merged, err
+	case *apps.Deployment:
+		cur, err := client.AppsV1().Deployments(o.Namespace).Get(ctx, o.Name, getOpts)
+		if kerr.IsNotFound(err) {
+			merged, err := client.AppsV1().Deployments(o.Namespace).Create(ctx, o, createOpts)
+			return nil, merged, err
+		} else if err != nil {
+			return nil, nil, err
+		}
+		o.ResourceVersion = cur.ResourceVersion
+		merged, err := client.AppsV1().Deployments(o.Namespace).Update(ctx, o, updateOpts)
+		return cur, merged, err
+	case *core.Service:
+		cur, err := client.CoreV1().Services(o.Namespace).Get(ctx, o.Name, getOpts)
+		if kerr.IsNotFound(err) {
+			merged, err := client.CoreV1().Services(o.Namespace).Create(ctx, o, createOpts)
+			return nil, merged, err
+		} else if err != nil {
+			return nil, nil, err
+		}
+		o.ResourceVersion = cur.ResourceVersion
+		o.Spec.ClusterIP = cur.Spec.ClusterIP
+		merged, err := client.CoreV1().Services(o.Namespace).Update(ctx, o, updateOpts)
+		return cur, merged, err
+	default:
+		return nil, nil, fmt.Errorf("unsupported object type %T", obj)
+	}
+}
+
+// encodeForDiff renders an object as YAML with churn-prone metadata removed.
+// A nil object renders empty, so missing objects show up as pure additions.
+func encodeForDiff(codec runtime.Encoder, obj runtime.Object) ([]byte, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+		accessor.SetUID("")
+		accessor.SetResourceVersion("")
+		accessor.SetGeneration(0)
+		accessor.SetCreationTimestamp(metav1.Time{})
+	}
+	var buf bytes.Buffer
+	if err := codec.Encode(obj, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func objectDiffName(obj runtime.Object) string {
+	kind := fmt.Sprintf("%T", obj)
+	if gvks, _, err := clientsetscheme.Scheme.ObjectKinds(obj); err == nil && len(gvks) > 0 {
+		kind = gvks[0].Kind
+	}
+	if accessor, err := meta.Accessor(obj); err == nil {
+		if accessor.GetNamespace() != "" {
+			return fmt.Sprintf("%s.%s.%s", kind, accessor.GetNamespace(), accessor.GetName())
+		}
+		return fmt.Sprintf("%s.%s", kind, accessor.GetName())
+	}
+	return kind
+}
@@ -27,6 +27,7 @@ func NewCmdGet() *cobra.Command {
 		DisableAutoGenTag: true,
 	}
 	cmd.AddCommand(NewCmdGetWebhookConfig())
+	cmd.AddCommand(NewCmdReissueWebhookConfig())
 	cmd.AddCommand(NewCmdGetToken())
 	cmd.AddCommand(NewCmdInstaller())
 	return cmd
@@ -28,6 +28,9 @@ import (
 func NewCmdInstaller() *cobra.Command {
 	authopts := installer.NewAuthOptions()
 	authzopts := installer.NewAuthzOptions()
+	secretopts := installer.NewSecretOptions()
+	format := "yaml"
+	validateCredentials := false
 
 	cmd := &cobra.Command{
 		Use:               "installer",
@@ -44,14 +47,37 @@ func NewCmdInstaller() *cobra.Command {
 				glog.Fatal(errs)
 			}
 
-			data, err := installer.Generate(authopts, authzopts)
+			errs = secretopts.Validate()
+			if errs != nil {
+				glog.Fatal(errs)
+			}
+
+			if validateCredentials {
+				if errs := authopts.ValidateCredentials(); errs != nil {
+					glog.Fatal(errs)
+				}
+			}
+
+			var data []byte
+			var err error
+			switch format {
+			case "yaml":
+				data, err = installer.Generate(authopts, authzopts, secretopts)
+			case "helm":
+				data, err = installer.GenerateHelmChart(authopts, authzopts, secretopts)
+			default:
+				glog.Fatalf("unknown format %s, valid value is either yaml or helm", format)
+			}
 			if err != nil {
 				glog.Fatal(err)
 			}
 			fmt.Println(string(data))
 		},
 	}
+	cmd.Flags().StringVar(&format, "format", format, "Output format. Use helm to render a Helm chart file stream instead of a plain YAML stream.")
+	cmd.Flags().BoolVar(&validateCredentials, "validate-credentials", validateCredentials, "Exercise the credentials of each configured provider (AAD token fetch, LDAP bind, Google service account) before emitting objects.")
 	authopts.AddFlags(cmd.Flags())
 	authzopts.AddFlags(cmd.Flags())
+	secretopts.AddFlags(cmd.Flags())
 	return cmd
 }
@@ -0,0 +1,179 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/appscode/go/term"
+	"github.com/appscode/guard/auth"
+
+	"github.com/golang/glog"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"gomodules.xyz/cert"
+	"gomodules.xyz/cert/certstore"
+)
+
+const (
+	defaultClientCertTTL = 24 * time.Hour
+	// short-lived certs are the point of this command; refuse to issue
+	// anything that outlives a regular rotation cycle
+	maxClientCertTTL = 30 * 24 * time.Hour
+)
+
+func NewCmdIssue() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "issue",
+		Short:             `Issue short-lived certificates from the guard CA`,
+		DisableAutoGenTag: true,
+	}
+	cmd.AddCommand(NewCmdIssueClientCert())
+	return cmd
+}
+
+// NewCmdIssueClientCert issues a short-lived client certificate pair from the
+// guard CA. Unlike `init client` the validity is bounded by --ttl, so API
+// servers in large fleets can rotate their webhook credentials frequently
+// without re-running the full PKI bootstrap.
+func NewCmdIssueClientCert() *cobra.Command {
+	var (
+		rootDir = auth.DefaultDataDir
+		org     string
+		ttl     = defaultClientCertTTL
+		approve bool
+	)
+	cmd := &cobra.Command{
+		Use:               "client-cert",
+		Short:             "Issue a short-lived client certificate pair",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			org = strings.ToLower(org)
+			if len(args) == 0 {
+				switch org {
+				// for gitlab/azure/ldap client name not required
+				case "gitlab", "azure", "ldap":
+					args = []string{org}
+				}
+			}
+
+			if len(args) == 0 {
+				glog.Fatalln("Missing client name.")
+			}
+			if len(args) > 1 {
+				glog.Fatalln("Multiple client name found.")
+			}
+
+			if ttl <= 0 || ttl > maxClientCertTTL {
+				glog.Fatalf("Invalid ttl %s. Must be positive and at most %s.", ttl, maxClientCertTTL)
+			}
+
+			cfg := cert.Config{
+				AltNames: cert.AltNames{
+					DNSNames: []string{args[0]},
+				},
+				Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}
+
+			if org == "" {
+				glog.Fatalf("Missing organization name. Set flag -o %s", auth.SupportedOrgs)
+			} else if !auth.SupportedOrgs.Has(org) {
+				glog.Fatalf("Unknown organization %s.", org)
+			} else {
+				cfg.Organization = []string{org}
+			}
+
+			store, err := certstore.NewCertStore(afero.NewOsFs(), filepath.Join(rootDir, "pki"), cfg.Organization...)
+			if err != nil {
+				glog.Fatalf("Failed to create certificate store. Reason: %v.", err)
+			}
+			if err = store.LoadCA(); err != nil {
+				glog.Fatalf("Failed to load ca certificate. Reason: %v.", err)
+			}
+
+			if !approve {
+				if !term.Ask(fmt.Sprintf("Issue a client certificate for %s valid for %s?", args[0], ttl), false) {
+					os.Exit(1)
+				}
+			}
+
+			crt, key, err := issueClientCertPair(store, cfg, ttl)
+			if err != nil {
+				glog.Fatalf("Failed to issue certificate pair. Reason: %v.", err)
+			}
+			err = store.WriteBytes(filename(cfg), crt, key)
+			if err != nil {
+				glog.Fatalf("Failed to write client certificate pair. Reason: %v.", err)
+			}
+			term.Successln(fmt.Sprintf("Wrote client certificates in %s, valid until %s", store.Location(), time.Now().Add(ttl).UTC().Format(time.RFC3339)))
+		},
+	}
+
+	cmd.Flags().StringVar(&rootDir, "pki-dir", rootDir, "Path to directory where pki files are stored.")
+	cmd.Flags().StringVarP(&org, "organization", "o", org, fmt.Sprintf("Name of Organization (%v).", auth.SupportedOrgs))
+	cmd.Flags().DurationVar(&ttl, "ttl", ttl, "Validity of the issued certificate.")
+	cmd.Flags().BoolVar(&approve, "approve", approve, "Skip the interactive approval prompt.")
+	return cmd
+}
+
+// issueClientCertPair signs a client certificate with the CA loaded in the
+// store, with validity bounded by ttl instead of the one year default of the
+// cert library.
+func issueClientCertPair(store *certstore.CertStore, cfg cert.Config, ttl time.Duration) ([]byte, []byte, error) {
+	key, err := cert.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   cfg.AltNames.DNSNames[0],
+			Organization: cfg.Organization,
+		},
+		DNSNames:     cfg.AltNames.DNSNames,
+		SerialNumber: serial,
+		NotBefore:    now.UTC(),
+		NotAfter:     now.Add(ttl).UTC(),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  cfg.Usages,
+	}
+
+	der, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, store.CACert(), &key.PublicKey, store.CAKey())
+	if err != nil {
+		return nil, nil, err
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert.EncodeCertPEM(crt), cert.EncodePrivateKeyPEM(key), nil
+}
@@ -0,0 +1,251 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/appscode/guard/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+// guardClientOptions holds the connection flags shared by the kubectl-guard
+// subcommands. The client certificate decides the provider (organization)
+// and org/domain (common name), exactly like the API server's webhook
+// credentials.
+type guardClientOptions struct {
+	Addr        string
+	CACertFile  string
+	CertFile    string
+	KeyFile     string
+	ClusterName string
+	Timeout     time.Duration
+}
+
+func (o *guardClientOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Addr, "addr", o.Addr, "Address (host:port or URL) of the guard server, e.g. localhost:8443 for a port-forward")
+	fs.StringVar(&o.CACertFile, "ca-cert-file", o.CACertFile, "Path to the PEM bundle the guard server certificate is verified against")
+	fs.StringVar(&o.CertFile, "cert-file", o.CertFile, "Path to the client certificate presented to guard")
+	fs.StringVar(&o.KeyFile, "key-file", o.KeyFile, "Path to the client certificate key")
+	fs.StringVar(&o.ClusterName, "cluster-name", o.ClusterName, "Cluster the request is evaluated for, as sent in the x-guard-cluster-name header")
+	fs.DurationVar(&o.Timeout, "timeout", 10*time.Second, "Timeout for a single request to guard")
+}
+
+func (o *guardClientOptions) New() (*client.Client, error) {
+	return client.New(client.Config{
+		Addr:           o.Addr,
+		CACertFile:     o.CACertFile,
+		ClientCertFile: o.CertFile,
+		ClientKeyFile:  o.KeyFile,
+		ClusterName:    o.ClusterName,
+		Timeout:        o.Timeout,
+	})
+}
+
+// NewCmdKubectlGuard is the `kubectl guard` plugin command set. Installing
+// the guard binary on the PATH as kubectl-guard makes kubectl pick it up as
+// a plugin; `guard kubectl-guard ...` runs the same commands directly.
+func NewCmdKubectlGuard() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kubectl-guard",
+		Short: "kubectl plugin for debugging guard from a workstation",
+		Long: `Debug guard from a workstation through its RBAC-gated debug endpoints.
+
+Install the guard binary on the PATH as kubectl-guard and kubectl picks it up
+as a plugin, so the commands below are available as 'kubectl guard ...'.
+Reach the server with a port-forward, e.g.:
+
+    kubectl -n kube-system port-forward svc/guard 8443:443
+
+and point --addr at localhost:8443 with the webhook client credentials.`,
+		DisableAutoGenTag: true,
+	}
+	cmd.AddCommand(newCmdKubectlGuardCanI())
+	cmd.AddCommand(newCmdKubectlGuardCache())
+	return cmd
+}
+
+// parseReviewArgs composes a review spec from kubectl auth can-i style
+// arguments: VERB RESOURCE[.GROUP] [NAME], or VERB /non-resource-path.
+func parseReviewArgs(args []string, user string, groups []string, namespace, subresource string) (*authzv1beta1.SubjectAccessReviewSpec, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, fmt.Errorf("expected VERB RESOURCE [NAME], got %d arguments", len(args))
+	}
+	if user == "" {
+		return nil, fmt.Errorf("missing user; set flag --as")
+	}
+	spec := &authzv1beta1.SubjectAccessReviewSpec{
+		User:   user,
+		Groups: groups,
+	}
+	if strings.HasPrefix(args[1], "/") {
+		if len(args) > 2 || subresource != "" {
+			return nil, fmt.Errorf("non-resource path %s takes no name or subresource", args[1])
+		}
+		spec.NonResourceAttributes = &authzv1beta1.NonResourceAttributes{
+			Verb: args[0],
+			Path: args[1],
+		}
+		return spec, nil
+	}
+	resource, apiGroup := args[1], ""
+	if i := strings.Index(resource, "."); i >= 0 {
+		resource, apiGroup = resource[:i], resource[i+1:]
+	}
+	spec.ResourceAttributes = &authzv1beta1.ResourceAttributes{
+		Verb:        args[0],
+		Group:       apiGroup,
+		Resource:    resource,
+		Subresource: subresource,
+		Namespace:   namespace,
+	}
+	if len(args) == 3 {
+		spec.ResourceAttributes.Name = args[2]
+	}
+	return spec, nil
+}
+
+// newCmdKubectlGuardCanI traces a can-i style query through guard's debug
+// trace endpoint, so admins see the decision guard would answer the API
+// server with, including the provider latency and cache key.
+func newCmdKubectlGuardCanI() *cobra.Command {
+	o := &guardClientOptions{}
+	var (
+		user        string
+		groups      []string
+		namespace   string
+		subresource string
+		bypassCache bool
+	)
+	cmd := &cobra.Command{
+		Use:   "can-i VERB RESOURCE[.GROUP] [NAME]",
+		Short: "Trace whether a user can perform an action, as guard would decide it",
+		Example: `  # Can jane@corp.example delete pods in dev?
+  kubectl guard can-i delete pods -n dev --as jane@corp.example --addr localhost:8443 --cert-file client.crt --key-file client.key
+
+  # Skip cached verdicts to tell a stale cache entry from a role assignment gap
+  kubectl guard can-i get deployments.apps --as jane@corp.example --bypass-cache ...`,
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			spec, err := parseReviewArgs(args, user, groups, namespace, subresource)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			c, err := o.New()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			trace, err := c.Trace(context.Background(), spec, bypassCache)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			switch trace.Decision {
+			case "allowed":
+				fmt.Println("yes")
+			case "denied":
+				fmt.Println("no")
+			default:
+				fmt.Println("no opinion")
+			}
+			if trace.Reason != "" {
+				fmt.Printf("Reason:    %s\n", trace.Reason)
+			}
+			if trace.EvaluationError != "" {
+				fmt.Printf("Evaluation error: %s\n", trace.EvaluationError)
+			}
+			fmt.Printf("Provider:  %s\n", trace.Provider)
+			fmt.Printf("Elapsed:   %s\n", trace.Elapsed)
+			if trace.CacheKey != "" {
+				fmt.Printf("Cache key: %s\n", trace.CacheKey)
+			}
+			if trace.CacheBypass {
+				fmt.Println("(cached verdicts were bypassed)")
+			}
+		},
+	}
+	cmd.Flags().StringVar(&user, "as", user, "User the review is evaluated for")
+	cmd.Flags().StringSliceVar(&groups, "as-group", groups, "Group of the user; may be repeated")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", namespace, "Namespace of the request")
+	cmd.Flags().StringVar(&subresource, "subresource", subresource, "Subresource, e.g. log")
+	cmd.Flags().BoolVar(&bypassCache, "bypass-cache", bypassCache, "Skip cached verdicts and ask the upstream source")
+	o.AddFlags(cmd.Flags())
+	return cmd
+}
+
+// newCmdKubectlGuardCache inspects guard's authz result cache for a query
+// without evaluating it, so admins can see whether a decision came from the
+// cache and which key it is stored under.
+func newCmdKubectlGuardCache() *cobra.Command {
+	o := &guardClientOptions{}
+	var (
+		user        string
+		groups      []string
+		namespace   string
+		subresource string
+	)
+	cmd := &cobra.Command{
+		Use:               "cache VERB RESOURCE[.GROUP] [NAME]",
+		Short:             "Show what guard's authz result cache holds for a query",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			spec, err := parseReviewArgs(args, user, groups, namespace, subresource)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			c, err := o.New()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			info, err := c.CacheInfo(context.Background(), spec)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Cache key: %s\n", info.CacheKey)
+			switch {
+			case !info.Found:
+				fmt.Println("Cached verdict: none")
+			case info.Allowed && info.Stale:
+				fmt.Println("Cached verdict: allowed (stale)")
+			case info.Allowed:
+				fmt.Println("Cached verdict: allowed")
+			case info.Stale:
+				fmt.Println("Cached verdict: denied (stale)")
+			default:
+				fmt.Println("Cached verdict: denied")
+			}
+		},
+	}
+	cmd.Flags().StringVar(&user, "as", user, "User the review is evaluated for")
+	cmd.Flags().StringSliceVar(&groups, "as-group", groups, "Group of the user; may be repeated")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", namespace, "Namespace of the request")
+	cmd.Flags().StringVar(&subresource, "subresource", subresource, "Subresource, e.g. log")
+	o.AddFlags(cmd.Flags())
+	return cmd
+}
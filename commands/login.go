@@ -18,16 +18,31 @@ package commands
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/auth/providers/azure"
+	azurelogin "github.com/appscode/guard/auth/providers/azure/login"
 	"github.com/appscode/guard/auth/providers/eks"
+	"github.com/appscode/guard/auth/providers/gke"
+	"github.com/appscode/guard/pkg/execcredential"
 
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
 )
 
 func NewCmdLogin() *cobra.Command {
-	var cluster, provider string
+	var (
+		cluster, provider string
+
+		tenantID string
+		clientID string
+		scope    string
+
+		output = "v1beta1"
+	)
 
 	cmd := &cobra.Command{
 		Use:               "login",
@@ -35,28 +50,49 @@ func NewCmdLogin() *cobra.Command {
 		Long:              "Kubectl credential plugin. Visit here for more info: https://kubernetes.io/docs/reference/access-authn-authz/authentication/#client-go-credential-plugins",
 		DisableAutoGenTag: true,
 		Run: func(cmd *cobra.Command, args []string) {
+			if _, err := execcredential.APIVersion(output); err != nil {
+				glog.Fatal(err)
+			}
+
+			var (
+				token  string
+				expiry time.Time
+				err    error
+			)
 			provider = strings.ToLower(provider)
 			switch provider {
 			case eks.OrgType:
-				token, err := eks.Get(cluster)
-				if err != nil {
-					glog.Fatal(err)
-				}
-				printToken, err := eks.PrintToken(token)
-				if err != nil {
-					glog.Fatal(err)
-				}
-				fmt.Println(printToken)
-				return
+				token, expiry, err = eks.Get(cluster)
+			case azure.OrgType:
+				token, expiry, err = azurelogin.Get(azurelogin.Options{
+					TenantID: tenantID,
+					ClientID: clientID,
+					Scope:    scope,
+					CacheDir: filepath.Join(auth.DefaultDataDir, "azure"),
+				})
+			case gke.OrgType:
+				token, expiry, err = gke.Get()
 			case "":
 				glog.Fatalln("Missing cloud provider name. Set flag -p.")
 			default:
 				glog.Fatalf("Unsupported cloud provider %s.", provider)
 			}
+			if err != nil {
+				glog.Fatal(err)
+			}
+			printToken, err := execcredential.Print(output, token, expiry)
+			if err != nil {
+				glog.Fatal(err)
+			}
+			fmt.Println(printToken)
 		},
 	}
 
 	cmd.Flags().StringVarP(&cluster, "cluster", "k", cluster, "Name of cluster")
 	cmd.Flags().StringVarP(&provider, "provider", "p", provider, "Name of cloud provider")
+	cmd.Flags().StringVar(&tenantID, "tenant-id", tenantID, "AAD tenant to log in to, used with -p azure")
+	cmd.Flags().StringVar(&clientID, "client-id", azurelogin.DefaultClientID, "Public client application used for the device code flow, used with -p azure")
+	cmd.Flags().StringVar(&scope, "scope", azurelogin.DefaultScope, "Scope requested for the access token, used with -p azure")
+	cmd.Flags().StringVarP(&output, "output", "o", output, "ExecCredential API version to print (v1alpha1, v1beta1 or v1)")
 	return cmd
 }
@@ -0,0 +1,127 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+// renamedFlags maps flag names of earlier guard releases to their current
+// spelling. Entries are matched against `--<name>` occurrences in flag lists
+// and rendered manifests.
+var renamedFlags = map[string]string{
+	// serving flags were renamed when TLS handling was consolidated
+	"ca-cert-file": "tls-ca-file",
+	"cert-file":    "tls-cert-file",
+	"key-file":     "tls-private-key-file",
+	"web-address":  "secure-addr",
+	// renamed for consistency with the other azure flags
+	"azure.use-group-id": "azure.use-group-uid",
+}
+
+// removedFlags maps flags that no longer exist to a note explaining what
+// replaces them. Occurrences are reported but left in place, so the operator
+// can decide how to handle them.
+var removedFlags = map[string]string{
+	"azure.graph-url": "the graph endpoint is now derived from --azure.environment",
+}
+
+// NewCmdMigrateConfig returns the `guard migrate-config` command. It reads a
+// flag list or rendered manifest produced for an older guard release and
+// rewrites deprecated flags to the current schema, reporting every change, so
+// operators can upgrade configs without chasing release notes.
+func NewCmdMigrateConfig() *cobra.Command {
+	inputFile := "-"
+
+	cmd := &cobra.Command{
+		Use:               "migrate-config",
+		Short:             "Rewrites deprecated guard flags in a config or manifest to the current schema",
+		Long:              "Reads a flag list or rendered manifest (e.g. installer YAML) from the given file or stdin, rewrites flags renamed in newer releases and reports deprecated options. The migrated config is written to stdout, the report to stderr.",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			var data []byte
+			var err error
+			if inputFile == "-" {
+				data, err = ioutil.ReadAll(os.Stdin)
+			} else {
+				data, err = ioutil.ReadFile(inputFile)
+			}
+			if err != nil {
+				glog.Fatal(err)
+			}
+
+			migrated, report := migrateConfig(data)
+			for _, line := range report {
+				fmt.Fprintln(os.Stderr, line)
+			}
+			if len(report) == 0 {
+				fmt.Fprintln(os.Stderr, "no deprecated options found")
+			}
+			fmt.Print(string(migrated))
+		},
+	}
+	cmd.Flags().StringVar(&inputFile, "file", inputFile, "file containing the config or manifest to migrate. Use - for stdin")
+	return cmd
+}
+
+// migrateConfig rewrites deprecated flag occurrences in the given config and
+// returns the migrated config together with a report of what changed.
+func migrateConfig(data []byte) ([]byte, []string) {
+	var report []string
+
+	// process the table in a stable order, so the report is deterministic
+	names := make([]string, 0, len(renamedFlags))
+	for name := range renamedFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := string(data)
+	for _, name := range names {
+		// match --<name> followed by =, whitespace or end of input, so
+		// flags sharing a prefix are not rewritten by accident
+		re := regexp.MustCompile(`--` + regexp.QuoteMeta(name) + `(=|\s|$)`)
+		count := len(re.FindAllString(out, -1))
+		if count == 0 {
+			continue
+		}
+		out = re.ReplaceAllString(out, "--"+renamedFlags[name]+"$1")
+		report = append(report, fmt.Sprintf("renamed --%s to --%s (%d occurrence(s))", name, renamedFlags[name], count))
+	}
+
+	names = names[:0]
+	for name := range removedFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		re := regexp.MustCompile(`--` + regexp.QuoteMeta(name) + `(=|\s|$)`)
+		if count := len(re.FindAllString(out, -1)); count > 0 {
+			report = append(report, fmt.Sprintf("WARNING: --%s was removed and left unchanged (%d occurrence(s)): %s", name, count, removedFlags[name]))
+		}
+	}
+
+	return []byte(out), report
+}
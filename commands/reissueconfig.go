@@ -0,0 +1,83 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NewCmdReissueWebhookConfig rewrites an existing webhook kubeconfig with an
+// updated guard server address, keeping the embedded client key and
+// certificates untouched. Use it when guard moves (new service IP, split
+// components) without rotating any credentials.
+func NewCmdReissueWebhookConfig() *cobra.Command {
+	var (
+		addr                string
+		expiryWarningWindow time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:               "reissue-webhook-config <kubeconfig>",
+		Short:             "Prints an existing webhook config with an updated guard server address, without regenerating keys",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				glog.Fatalln("Missing kubeconfig path.")
+			}
+			if len(args) > 1 {
+				glog.Fatalln("Multiple kubeconfig paths found.")
+			}
+
+			config, err := clientcmd.LoadFromFile(args[0])
+			if err != nil {
+				glog.Fatalf("Failed to load kubeconfig %s. Reason: %v.", args[0], err)
+			}
+
+			var caCert, clientCert []byte
+			for _, cluster := range config.Clusters {
+				// keep the scheme and the /tokenreviews or
+				// /subjectaccessreviews path, swap only the endpoint
+				u, err := url.Parse(cluster.Server)
+				if err != nil {
+					glog.Fatalf("Failed to parse server URL %s. Reason: %v.", cluster.Server, err)
+				}
+				u.Host = addr
+				cluster.Server = u.String()
+				caCert = cluster.CertificateAuthorityData
+			}
+			for _, authInfo := range config.AuthInfos {
+				clientCert = authInfo.ClientCertificateData
+			}
+
+			data, err := clientcmd.Write(*config)
+			if err != nil {
+				glog.Fatalln(err)
+			}
+			fmt.Println(string(data))
+			printConfigMetadata(caCert, clientCert, expiryWarningWindow)
+		},
+	}
+
+	cmd.Flags().StringVar(&addr, "addr", "10.96.10.96:443", "New address (host:port) of guard server.")
+	cmd.Flags().DurationVar(&expiryWarningWindow, "expiry-warning-window", 30*24*time.Hour, "Emit a warning comment when a certificate expires within this window. Set to 0 to disable")
+	return cmd
+}
@@ -48,9 +48,17 @@ func NewRootCmd() *cobra.Command {
 	cmd.PersistentFlags().BoolVar(&cli.EnableAnalytics, "analytics", cli.EnableAnalytics, "Send analytical events to Google Guard")
 
 	cmd.AddCommand(NewCmdInit())
+	cmd.AddCommand(NewCmdIssue())
 	cmd.AddCommand(NewCmdGet())
 	cmd.AddCommand(NewCmdRun())
+	cmd.AddCommand(NewCmdShadow())
 	cmd.AddCommand(NewCmdLogin())
+	cmd.AddCommand(NewCmdVerify())
+	cmd.AddCommand(NewCmdTest())
+	cmd.AddCommand(NewCmdKubectlGuard())
+	cmd.AddCommand(NewCmdDiff())
+	cmd.AddCommand(NewCmdSmoke())
+	cmd.AddCommand(NewCmdMigrateConfig())
 	cmd.AddCommand(v.NewCmdVersion())
 	return cmd
 }
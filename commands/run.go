@@ -19,6 +19,7 @@ package commands
 import (
 	v "github.com/appscode/go/version"
 	"github.com/appscode/guard/server"
+	"github.com/appscode/guard/util/flagutil"
 
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
@@ -32,6 +33,7 @@ func NewCmdRun() *cobra.Command {
 		AuthRecommendedOptions:  o,
 		AuthzRecommendedOptions: ao,
 	}
+	configPath := ""
 	cmd := &cobra.Command{
 		Use:               "run",
 		Short:             "Run server",
@@ -40,12 +42,38 @@ func NewCmdRun() *cobra.Command {
 			cli.SendPeriodicAnalytics(c, v.Version.Version)
 		},
 		Run: func(cmd *cobra.Command, args []string) {
+			if configPath != "" {
+				if err := server.ApplyConfigFile(cmd.Flags(), configPath); err != nil {
+					glog.Fatal(err)
+				}
+				// let the server re-apply the file when it changes on disk
+				srv.ConfigPath = configPath
+				srv.ConfigFlags = cmd.Flags()
+			}
+			if err := server.ResolveSecretRefs(cmd.Flags()); err != nil {
+				glog.Fatal(err)
+			}
+			if configPath != "" {
+				// tenant blocks override the effective flags, so they are
+				// built after the file and secret refs have been applied
+				tenants, err := server.NewTenantRouterFromConfig(cmd.Flags(), configPath)
+				if err != nil {
+					glog.Fatal(err)
+				}
+				srv.Tenants = tenants
+			}
 			if !srv.AuthRecommendedOptions.SecureServing.UseTLS() {
 				glog.Fatalln("Guard server must use SSL.")
 			}
+			srv.AuthRecommendedOptions.UserAgent.Apply()
+			server.DumpConfiguration(cmd.Flags())
 			srv.ListenAndServe()
 		},
 	}
+	cmd.Flags().StringVar(&configPath, "config", configPath, "path to a GuardConfiguration YAML file. Flags given on the command line override the file")
 	srv.AddFlags(cmd.Flags())
+	// the server registers flags for every provider; group the help output so
+	// it stays navigable
+	flagutil.SetGroupedUsage(cmd)
 	return cmd
 }
@@ -0,0 +1,130 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	v "github.com/appscode/go/version"
+	"github.com/appscode/guard/server"
+	"github.com/appscode/guard/util/flagutil"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"kmodules.xyz/client-go/tools/cli"
+)
+
+// NewCmdShadow runs the server like `guard run`, but additionally evaluates
+// every subject access review against a secondary configuration and logs
+// divergent decisions without affecting responses, so a changed configuration
+// can be judged against live traffic before rollout.
+func NewCmdShadow() *cobra.Command {
+	o := server.NewAuthRecommendedOptions()
+	ao := server.NewAuthzRecommendedOptions()
+	srv := server.Server{
+		AuthRecommendedOptions:  o,
+		AuthzRecommendedOptions: ao,
+	}
+	configPath := ""
+	secondaryConfigPath := ""
+	cmd := &cobra.Command{
+		Use:               "shadow",
+		Short:             "Run server, comparing decisions against a secondary configuration",
+		DisableAutoGenTag: true,
+		PreRun: func(c *cobra.Command, args []string) {
+			cli.SendPeriodicAnalytics(c, v.Version.Version)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if secondaryConfigPath == "" {
+				glog.Fatalln("Missing secondary configuration. Set flag --secondary-config.")
+			}
+			if configPath != "" {
+				if err := server.ApplyConfigFile(cmd.Flags(), configPath); err != nil {
+					glog.Fatal(err)
+				}
+			}
+			if err := server.ResolveSecretRefs(cmd.Flags()); err != nil {
+				glog.Fatal(err)
+			}
+
+			// the secondary configuration starts from the primary one, with
+			// the secondary config file applied on top
+			shadowAuth := server.NewAuthRecommendedOptions()
+			shadowAuthz := server.NewAuthzRecommendedOptions()
+			shadowSrv := server.Server{
+				AuthRecommendedOptions:  shadowAuth,
+				AuthzRecommendedOptions: shadowAuthz,
+			}
+			shadowFlags := pflag.NewFlagSet("shadow", pflag.ContinueOnError)
+			shadowSrv.AddFlags(shadowFlags)
+			if err := copyFlagValues(cmd.Flags(), shadowFlags); err != nil {
+				glog.Fatal(err)
+			}
+			if err := server.ApplyConfigFile(shadowFlags, secondaryConfigPath); err != nil {
+				glog.Fatal(err)
+			}
+			if err := server.ResolveSecretRefs(shadowFlags); err != nil {
+				glog.Fatal(err)
+			}
+			if errs := shadowAuthz.Validate(shadowAuth); errs != nil {
+				glog.Fatal(errs)
+			}
+			srv.ShadowAuthOptions = shadowAuth
+			srv.ShadowAuthzOptions = shadowAuthz
+
+			if !srv.AuthRecommendedOptions.SecureServing.UseTLS() {
+				glog.Fatalln("Guard server must use SSL.")
+			}
+			srv.AuthRecommendedOptions.UserAgent.Apply()
+			server.DumpConfiguration(cmd.Flags())
+			srv.ListenAndServe()
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "config", configPath, "path to a GuardConfiguration YAML file. Flags given on the command line override the file")
+	cmd.Flags().StringVar(&secondaryConfigPath, "secondary-config", secondaryConfigPath, "path to a GuardConfiguration YAML file evaluated in shadow. Its flags are applied on top of the primary configuration")
+	srv.AddFlags(cmd.Flags())
+	flagutil.SetGroupedUsage(cmd)
+	return cmd
+}
+
+// copyFlagValues copies the flags changed on src to dst, so the secondary
+// configuration starts from the primary command line. Values set this way are
+// not marked changed, letting the secondary config file override them.
+func copyFlagValues(src, dst *pflag.FlagSet) error {
+	var retErr error
+	src.Visit(func(flag *pflag.Flag) {
+		if retErr != nil {
+			return
+		}
+		target := dst.Lookup(flag.Name)
+		if target == nil {
+			// flags like --config exist only on the command itself
+			return
+		}
+		if sv, ok := flag.Value.(pflag.SliceValue); ok {
+			if tv, ok := target.Value.(pflag.SliceValue); ok {
+				if err := tv.Replace(sv.GetSlice()); err != nil {
+					retErr = err
+				}
+				return
+			}
+		}
+		if err := target.Value.Set(flag.Value.String()); err != nil {
+			retErr = err
+		}
+	})
+	return retErr
+}
@@ -0,0 +1,170 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NewCmdSmoke returns the `guard smoke` command. It verifies a guard install
+// end to end by sending a TokenReview and a SubjectAccessReview through the
+// API server, which forwards them to guard exactly like regular cluster
+// traffic, and reports pass/fail per leg.
+func NewCmdSmoke() *cobra.Command {
+	var (
+		kubeconfigPath string
+		token          string
+		user           string
+		groups         []string
+		verb           string
+		resource       string
+		subresource    string
+		namespace      string
+		name           string
+	)
+
+	cmd := &cobra.Command{
+		Use:               "smoke",
+		Short:             "Runs an end-to-end authentication and authorization smoke test through the API server",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			if token == "" && user == "" {
+				glog.Fatal("either --token or --user must be provided; there is nothing to test otherwise")
+			}
+
+			client, err := newKubeClient(kubeconfigPath)
+			if err != nil {
+				glog.Fatal(err)
+			}
+
+			failed := false
+
+			// authentication leg: the API server forwards the token to guard's
+			// tokenreviews endpoint when guard is its authentication webhook
+			if token != "" {
+				identity, ok := smokeTokenReview(client, token)
+				if !ok {
+					failed = true
+				} else if user == "" {
+					// reuse the reviewed identity for the authorization leg
+					user = identity.Username
+					groups = identity.Groups
+				}
+			} else {
+				fmt.Println("SKIP tokenreview: no --token provided")
+			}
+
+			// authorization leg: the API server consults guard's
+			// subjectaccessreviews endpoint when guard is in its authorizer
+			// chain
+			if user != "" {
+				if !smokeSubjectAccessReview(client, user, groups, authorizationv1.ResourceAttributes{
+					Verb:        verb,
+					Resource:    resource,
+					Subresource: subresource,
+					Namespace:   namespace,
+					Name:        name,
+				}) {
+					failed = true
+				}
+			} else {
+				fmt.Println("SKIP subjectaccessreview: no identity to review")
+			}
+
+			if failed {
+				fmt.Println("smoke test failed")
+				os.Exit(1)
+			}
+			fmt.Println("smoke test passed")
+		},
+	}
+
+	cmd.Flags().StringVar(&kubeconfigPath, "kubeconfig", kubeconfigPath, "path to the kubeconfig file used to connect to the cluster. If empty, the default loading rules apply")
+	cmd.Flags().StringVar(&token, "token", token, "bearer token reviewed through the API server. Empty skips the authentication leg")
+	cmd.Flags().StringVar(&user, "user", user, "user of the subject access review. Defaults to the identity returned by the token review")
+	cmd.Flags().StringSliceVar(&groups, "groups", groups, "groups of the subject access review. Defaults to the groups returned by the token review")
+	cmd.Flags().StringVar(&verb, "verb", "get", "verb of the subject access review")
+	cmd.Flags().StringVar(&resource, "resource", "pods", "resource of the subject access review")
+	cmd.Flags().StringVar(&subresource, "subresource", subresource, "subresource of the subject access review")
+	cmd.Flags().StringVar(&namespace, "namespace", "default", "namespace of the subject access review")
+	cmd.Flags().StringVar(&name, "name", name, "resource name of the subject access review")
+	return cmd
+}
+
+// smokeTokenReview reviews the token through the API server and reports the
+// outcome. The returned identity is only meaningful when ok is true.
+func smokeTokenReview(client kubernetes.Interface, token string) (authenticationv1.UserInfo, bool) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	resp, err := client.AuthenticationV1().TokenReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+	if err != nil {
+		fmt.Printf("FAIL tokenreview: %s\n", err)
+		return authenticationv1.UserInfo{}, false
+	}
+	if !resp.Status.Authenticated {
+		fmt.Printf("FAIL tokenreview: not authenticated: %s\n", resp.Status.Error)
+		return authenticationv1.UserInfo{}, false
+	}
+	fmt.Printf("PASS tokenreview: authenticated as %s (groups: %s)\n", resp.Status.User.Username, strings.Join(resp.Status.User.Groups, ", "))
+	return resp.Status.User, true
+}
+
+// smokeSubjectAccessReview reviews the access of the given identity through
+// the API server and reports the outcome. Both an allow and a deny pass the
+// smoke test; only a failure to obtain a decision fails it.
+func smokeSubjectAccessReview(client kubernetes.Interface, user string, groups []string, attrs authorizationv1.ResourceAttributes) bool {
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:               user,
+			Groups:             groups,
+			ResourceAttributes: &attrs,
+		},
+	}
+	resp, err := client.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+	if err != nil {
+		fmt.Printf("FAIL subjectaccessreview: %s\n", err)
+		return false
+	}
+	if resp.Status.EvaluationError != "" {
+		fmt.Printf("FAIL subjectaccessreview: evaluation error: %s\n", resp.Status.EvaluationError)
+		return false
+	}
+
+	decision := "no opinion"
+	if resp.Status.Allowed {
+		decision = "allowed"
+	} else if resp.Status.Denied {
+		decision = "denied"
+	}
+	if resp.Status.Reason != "" {
+		fmt.Printf("PASS subjectaccessreview: %s %s %s/%s: %s (%s)\n", decision, attrs.Verb, attrs.Namespace, attrs.Resource, user, resp.Status.Reason)
+	} else {
+		fmt.Printf("PASS subjectaccessreview: %s %s %s/%s: %s\n", decision, attrs.Verb, attrs.Namespace, attrs.Resource, user)
+	}
+	return true
+}
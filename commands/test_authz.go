@@ -0,0 +1,164 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/appscode/guard/authz"
+	azureauthz "github.com/appscode/guard/authz/providers/azure"
+	"github.com/appscode/guard/authz/providers/azure/rbac"
+	"github.com/appscode/guard/server"
+	"github.com/appscode/guard/util/flagutil"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+func NewCmdTest() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "test",
+		Short:             "Simulate requests against guard's provider code paths",
+		DisableAutoGenTag: true,
+	}
+	cmd.AddCommand(NewCmdTestAuthz())
+	return cmd
+}
+
+// NewCmdTestAuthz evaluates a subject access review against the configured
+// authz provider the same way the webhook would, so admins can test RBAC
+// changes before users hit them.
+func NewCmdTestAuthz() *cobra.Command {
+	o := server.NewAuthRecommendedOptions()
+	ao := server.NewAuthzRecommendedOptions()
+	var (
+		org         string
+		sarFile     string
+		clusterName string
+
+		user            string
+		groups          []string
+		verb            string
+		apiGroup        string
+		resource        string
+		subresource     string
+		namespace       string
+		name            string
+		nonResourcePath string
+	)
+	cmd := &cobra.Command{
+		Use:               "authz",
+		Short:             "Evaluate a SubjectAccessReview against an authz provider",
+		Long:              "Reads a SubjectAccessReview YAML (or composes one from flags) and evaluates it against the configured authz provider through the same code path the server uses, printing the decision and, for azure, the result cache key the request maps to.",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			if org == "" {
+				glog.Fatalln("Missing authz provider name. Set flag -p.")
+			}
+
+			var spec *authzv1beta1.SubjectAccessReviewSpec
+			if sarFile != "" {
+				data, err := ioutil.ReadFile(sarFile)
+				if err != nil {
+					glog.Fatal(err)
+				}
+				sar := authzv1beta1.SubjectAccessReview{}
+				if err := yaml.UnmarshalStrict(data, &sar); err != nil {
+					glog.Fatalf("failed to parse subject access review file: %s", err)
+				}
+				spec = &sar.Spec
+			} else {
+				spec = &authzv1beta1.SubjectAccessReviewSpec{
+					User:   user,
+					Groups: groups,
+				}
+				if nonResourcePath != "" {
+					spec.NonResourceAttributes = &authzv1beta1.NonResourceAttributes{
+						Path: nonResourcePath,
+						Verb: verb,
+					}
+				} else {
+					spec.ResourceAttributes = &authzv1beta1.ResourceAttributes{
+						Verb:        verb,
+						Group:       apiGroup,
+						Resource:    resource,
+						Subresource: subresource,
+						Namespace:   namespace,
+						Name:        name,
+					}
+				}
+			}
+			if spec.User == "" {
+				glog.Fatalln("Missing user. Set flag --user or provide a SubjectAccessReview file.")
+			}
+
+			ao.AuthzProvider.Providers = []string{strings.ToLower(org)}
+			e, err := server.NewShadowEvaluator(o, ao)
+			if err != nil {
+				glog.Fatal(err)
+			}
+
+			status, err := e.Check(context.Background(), org, spec, authz.RequestInfo{
+				CommonName:    "guard-test-authz",
+				ClusterHeader: clusterName,
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "evaluation failed: %s\n", err)
+				os.Exit(1)
+			}
+			switch {
+			case status.Allowed:
+				fmt.Println("Decision: allowed")
+			case status.Denied:
+				fmt.Println("Decision: denied")
+			default:
+				fmt.Println("Decision: no opinion")
+			}
+			if status.Reason != "" {
+				fmt.Printf("Reason:   %s\n", status.Reason)
+			}
+			if status.EvaluationError != "" {
+				fmt.Printf("Evaluation error: %s\n", status.EvaluationError)
+			}
+			if strings.ToLower(org) == azureauthz.OrgType {
+				fmt.Printf("Cache key: %s\n", rbac.ResultCacheKey(spec))
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&org, "provider", "p", org, "Name of the authz provider, e.g. azure")
+	cmd.Flags().StringVar(&sarFile, "sar-file", sarFile, "Path to a SubjectAccessReview YAML; overrides the attribute flags")
+	cmd.Flags().StringVar(&clusterName, "cluster-name", clusterName, "Cluster the review is evaluated for, as sent in the x-guard-cluster-name header")
+	cmd.Flags().StringVar(&user, "user", user, "User the review is evaluated for")
+	cmd.Flags().StringSliceVar(&groups, "groups", groups, "Groups of the user")
+	cmd.Flags().StringVar(&verb, "verb", verb, "Request verb, e.g. get")
+	cmd.Flags().StringVar(&apiGroup, "api-group", apiGroup, "API group of the resource")
+	cmd.Flags().StringVar(&resource, "resource", resource, "Resource, e.g. pods")
+	cmd.Flags().StringVar(&subresource, "subresource", subresource, "Subresource, e.g. log")
+	cmd.Flags().StringVar(&namespace, "namespace", namespace, "Namespace of the request")
+	cmd.Flags().StringVar(&name, "name", name, "Name of the object")
+	cmd.Flags().StringVar(&nonResourcePath, "non-resource-path", nonResourcePath, "Non-resource path, e.g. /logs; composes a non-resource review instead of a resource one")
+	o.AddFlags(cmd.Flags())
+	ao.AddFlags(cmd.Flags())
+	flagutil.SetGroupedUsage(cmd)
+	return cmd
+}
@@ -33,6 +33,10 @@ import (
 type tokenOptions struct {
 	Org  string
 	LDAP ldap.TokenOptions
+	// self-hosted GitHub Enterprise / GitLab instances
+	BaseUrl     string
+	CACertFile  string
+	VerifyToken string
 }
 
 func NewCmdGetToken() *cobra.Command {
@@ -46,10 +50,22 @@ func NewCmdGetToken() *cobra.Command {
 			opts.Org = strings.ToLower(opts.Org)
 			switch opts.Org {
 			case github.OrgType:
-				github.IssueToken()
+				if opts.VerifyToken != "" {
+					if err := github.VerifyToken(opts.VerifyToken, opts.BaseUrl, opts.CACertFile); err != nil {
+						glog.Fatal(err)
+					}
+					return
+				}
+				github.IssueToken(opts.BaseUrl)
 				return
 			case gitlab.OrgType:
-				gitlab.IssueToken()
+				if opts.VerifyToken != "" {
+					if err := gitlab.VerifyToken(opts.VerifyToken, opts.BaseUrl, opts.CACertFile); err != nil {
+						glog.Fatal(err)
+					}
+					return
+				}
+				gitlab.IssueToken(opts.BaseUrl)
 				return
 			case google.OrgType:
 				err := google.IssueToken()
@@ -72,6 +88,9 @@ func NewCmdGetToken() *cobra.Command {
 	}
 
 	cmd.Flags().StringVarP(&opts.Org, "organization", "o", opts.Org, fmt.Sprintf("Name of Organization (%v).", auth.SupportedOrgs))
+	cmd.Flags().StringVar(&opts.BaseUrl, "base-url", opts.BaseUrl, "Base url of a self-hosted GitHub Enterprise / GitLab instance, keep empty for the hosted service")
+	cmd.Flags().StringVar(&opts.CACertFile, "ca-cert-file", opts.CACertFile, "Path to a CA certificate file for a self-hosted instance with internally signed certificates")
+	cmd.Flags().StringVar(&opts.VerifyToken, "verify-token", opts.VerifyToken, "Verify the given token against the instance instead of issuing a new one")
 	opts.LDAP.AddFlags(cmd.Flags())
 	return cmd
 }
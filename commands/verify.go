@@ -0,0 +1,96 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/appscode/guard/server"
+	"github.com/appscode/guard/util/flagutil"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+func NewCmdVerify() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "verify",
+		Short:             "Verify credentials against guard's provider code paths",
+		DisableAutoGenTag: true,
+	}
+	cmd.AddCommand(NewCmdVerifyToken())
+	return cmd
+}
+
+// NewCmdVerifyToken checks a bearer token through the same authenticator code
+// path the server uses and prints the resulting UserInfo or the exact error,
+// so "Unauthorized" reports can be debugged without tailing server logs.
+func NewCmdVerifyToken() *cobra.Command {
+	o := server.NewAuthRecommendedOptions()
+	srv := server.Server{AuthRecommendedOptions: o}
+	var (
+		org        string
+		commonName string
+		bearer     string
+	)
+	cmd := &cobra.Command{
+		Use:               "token",
+		Short:             "Verify a bearer token against an auth provider",
+		Long:              "Runs a bearer token through the same authenticator code path the server uses and prints the resulting UserInfo (username, UID, groups, extra) or the exact error. The token is read from --token, or from stdin when the flag is unset, so it stays out of the shell history.",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			if org == "" {
+				glog.Fatalln("Missing provider name. Set flag -p.")
+			}
+			if bearer == "" {
+				fmt.Fprint(os.Stderr, "Token: ")
+				scanner := bufio.NewScanner(os.Stdin)
+				if scanner.Scan() {
+					bearer = strings.TrimSpace(scanner.Text())
+				}
+				if err := scanner.Err(); err != nil {
+					glog.Fatal(err)
+				}
+			}
+			if bearer == "" {
+				glog.Fatalln("Missing token. Set flag --token or pipe the token to stdin.")
+			}
+
+			info, err := srv.VerifyToken(context.Background(), org, commonName, bearer)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "token verification failed: %s\n", err)
+				os.Exit(1)
+			}
+			out, err := json.MarshalIndent(info, "", "  ")
+			if err != nil {
+				glog.Fatal(err)
+			}
+			fmt.Println(string(out))
+		},
+	}
+	cmd.Flags().StringVarP(&org, "provider", "p", org, "Name of the auth provider, e.g. github")
+	cmd.Flags().StringVarP(&commonName, "common-name", "k", commonName, "Stands in for the client certificate common name, which providers like github and google read as the organization or domain")
+	cmd.Flags().StringVar(&bearer, "token", bearer, "Bearer token to verify; read from stdin when unset")
+	o.AddFlags(cmd.Flags())
+	flagutil.SetGroupedUsage(cmd)
+	return cmd
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/appscode/guard/auth"
 
@@ -38,6 +39,13 @@ func NewCmdGetWebhookConfig() *cobra.Command {
 		org     string
 		addr    string
 		mode    string
+		authz   bool
+
+		webhookVersion       string
+		timeout              time.Duration
+		cacheAuthorizedTTL   time.Duration
+		cacheUnauthorizedTTL time.Duration
+		expiryWarningWindow  time.Duration
 	)
 	cmd := &cobra.Command{
 		Use:               "webhook-config",
@@ -45,6 +53,9 @@ func NewCmdGetWebhookConfig() *cobra.Command {
 		DisableAutoGenTag: true,
 		Run: func(cmd *cobra.Command, args []string) {
 			org = strings.ToLower(org)
+			if authz {
+				mode = "authz"
+			}
 			if len(args) == 0 {
 				switch org {
 				// for gitlab/azure/ldap client name not required
@@ -122,6 +133,7 @@ func NewCmdGetWebhookConfig() *cobra.Command {
 					glog.Fatalln(err)
 				}
 				fmt.Println(string(data))
+				printConfigMetadata(caCert, clientCert, expiryWarningWindow)
 			}
 
 			if mode == "authz" {
@@ -153,6 +165,15 @@ func NewCmdGetWebhookConfig() *cobra.Command {
 					glog.Fatalln(err)
 				}
 				fmt.Println(string(data))
+				printConfigMetadata(caCert, clientCert, expiryWarningWindow)
+
+				// emitted as comments so the output remains a valid kubeconfig
+				fmt.Println("# Recommended kube-apiserver settings for this webhook:")
+				fmt.Printf("#   --authorization-webhook-config-file=<path to this file>\n")
+				fmt.Printf("#   --authorization-webhook-version=%s\n", webhookVersion)
+				fmt.Printf("#   --authorization-webhook-timeout=%s\n", timeout)
+				fmt.Printf("#   --authorization-webhook-cache-authorized-ttl=%s\n", cacheAuthorizedTTL)
+				fmt.Printf("#   --authorization-webhook-cache-unauthorized-ttl=%s\n", cacheUnauthorizedTTL)
 			}
 		},
 	}
@@ -161,5 +182,41 @@ func NewCmdGetWebhookConfig() *cobra.Command {
 	cmd.Flags().StringVarP(&org, "organization", "o", org, fmt.Sprintf("Name of Organization (%v).", auth.SupportedOrgs))
 	cmd.Flags().StringVar(&addr, "addr", "10.96.10.96:443", "Address (host:port) of guard server.")
 	cmd.Flags().StringVar(&mode, "mode", "authn", "Mode to generate config, Supported mode: authn, authz")
+	cmd.Flags().BoolVar(&authz, "authz", false, "Generate authorization webhook config, shorthand for --mode=authz")
+	cmd.Flags().StringVar(&webhookVersion, "webhook-version", "v1beta1", "SubjectAccessReview API version used in the recommended --authorization-webhook-version setting")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "Timeout used in the recommended --authorization-webhook-timeout setting")
+	cmd.Flags().DurationVar(&cacheAuthorizedTTL, "cache-authorized-ttl", 5*time.Minute, "TTL used in the recommended --authorization-webhook-cache-authorized-ttl setting")
+	cmd.Flags().DurationVar(&cacheUnauthorizedTTL, "cache-unauthorized-ttl", 30*time.Second, "TTL used in the recommended --authorization-webhook-cache-unauthorized-ttl setting")
+	cmd.Flags().DurationVar(&expiryWarningWindow, "expiry-warning-window", 30*24*time.Hour, "Emit a warning comment when a certificate expires within this window. Set to 0 to disable")
 	return cmd
 }
+
+// printConfigMetadata emits creation metadata and the embedded certificate
+// lifetimes as comments, so the output remains a valid kubeconfig.
+func printConfigMetadata(caCert, clientCert []byte, warnWindow time.Duration) {
+	fmt.Printf("# Generated by guard on %s\n", time.Now().UTC().Format(time.RFC3339))
+	printCertLifetime("CA", caCert, warnWindow)
+	printCertLifetime("client", clientCert, warnWindow)
+}
+
+// printCertLifetime prints the validity window of the first certificate in
+// the PEM bundle and warns when it is expired or about to expire. Keys are
+// untouched by kubeconfig generation, so an expiring certificate needs
+// `guard init` to rotate, not a regenerated config.
+func printCertLifetime(kind string, pemBytes []byte, warnWindow time.Duration) {
+	certs, err := cert.ParseCertsPEM(pemBytes)
+	if err != nil || len(certs) == 0 {
+		return
+	}
+	c := certs[0]
+	fmt.Printf("# %s certificate valid from %s to %s\n", kind, c.NotBefore.UTC().Format(time.RFC3339), c.NotAfter.UTC().Format(time.RFC3339))
+	if warnWindow <= 0 {
+		return
+	}
+	switch {
+	case time.Now().After(c.NotAfter):
+		fmt.Printf("# WARNING: %s certificate has expired\n", kind)
+	case time.Until(c.NotAfter) < warnWindow:
+		fmt.Printf("# WARNING: %s certificate expires in %s\n", kind, time.Until(c.NotAfter).Round(time.Hour))
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"net"
+	"strings"
+
+	"github.com/appscode/go/types"
+
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// certManagerAPIVersion is the API group/version of the generated Certificate
+// and Issuer resources. The cert-manager types are not part of the client-go
+// scheme, so they are rendered as unstructured objects.
+const certManagerAPIVersion = "cert-manager.io/v1"
+
+// newCertManagerObject builds one unstructured cert-manager resource carrying
+// the shared guard labels.
+func newCertManagerObject(kind, name, namespace string, spec map[string]interface{}) *unstructured.Unstructured {
+	objLabels := map[string]interface{}{}
+	for k, v := range labels {
+		objLabels[k] = v
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": certManagerAPIVersion,
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+				"labels":    objLabels,
+			},
+			"spec": spec,
+		},
+	}
+}
+
+// newCertManagerIssuers renders the bootstrap issuer chain: a self-signed
+// issuer signs the guard CA certificate, and a CA issuer backed by that CA
+// signs the server and client certificates. cert-manager rotates all of them
+// before expiry, replacing the static PKI baked from --pki-dir.
+func newCertManagerIssuers(namespace string) []runtime.Object {
+	return []runtime.Object{
+		newCertManagerObject("Issuer", "guard-selfsigned", namespace, map[string]interface{}{
+			"selfSigned": map[string]interface{}{},
+		}),
+		newCertManagerObject("Certificate", "guard-ca", namespace, map[string]interface{}{
+			"isCA":       true,
+			"commonName": "ca",
+			"secretName": "guard-ca",
+			"issuerRef": map[string]interface{}{
+				"name": "guard-selfsigned",
+				"kind": "Issuer",
+			},
+		}),
+		newCertManagerObject("Issuer", "guard-ca", namespace, map[string]interface{}{
+			"ca": map[string]interface{}{
+				"secretName": "guard-ca",
+			},
+		}),
+	}
+}
+
+// newCertManagerServerCertificate renders the serving Certificate of one
+// Deployment. Its secret uses the same name and keys the static PKI secret
+// would, so the serving volume of the Deployment works unchanged.
+func newCertManagerServerCertificate(name, namespace, addr string) runtime.Object {
+	spec := map[string]interface{}{
+		"commonName": "server",
+		"secretName": name + "-pki",
+		"dnsNames": []interface{}{
+			name,
+			name + "." + namespace,
+			name + "." + namespace + ".svc",
+		},
+		"usages": []interface{}{"server auth"},
+		"issuerRef": map[string]interface{}{
+			"name": "guard-ca",
+			"kind": "Issuer",
+		},
+	}
+	// the webhook config addresses guard by service IP
+	if host, _, err := net.SplitHostPort(addr); err == nil && net.ParseIP(host) != nil {
+		spec["ipAddresses"] = []interface{}{host}
+	}
+	return newCertManagerObject("Certificate", name+"-server", namespace, spec)
+}
+
+// newCertManagerClientCertificate renders the client Certificate the API
+// server presents for one auth provider. Guard routes requests by the
+// certificate organization, so the provider org goes into the subject.
+func newCertManagerClientCertificate(org, namespace string) runtime.Object {
+	org = strings.ToLower(org)
+	return newCertManagerObject("Certificate", "guard-"+org+"-client", namespace, map[string]interface{}{
+		"commonName": org,
+		"subject": map[string]interface{}{
+			"organizations": []interface{}{org},
+		},
+		"secretName": "guard-" + org + "-client",
+		"usages":     []interface{}{"client auth"},
+		"issuerRef": map[string]interface{}{
+			"name": "guard-ca",
+			"kind": "Issuer",
+		},
+	})
+}
+
+// applyCertManagerServing mounts the cert-manager managed serving secret into
+// the Deployment the same way SecureServingOptions.Apply mounts the static
+// one, without embedding any key material in the manifest.
+func applyCertManagerServing(d *apps.Deployment) {
+	secretName := d.Name + "-pki"
+
+	d.Spec.Template.Spec.Containers[0].VolumeMounts = append(d.Spec.Template.Spec.Containers[0].VolumeMounts, core.VolumeMount{
+		Name:      secretName,
+		MountPath: "/etc/guard/pki",
+	})
+	d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, core.Volume{
+		Name: secretName,
+		VolumeSource: core.VolumeSource{
+			Secret: &core.SecretVolumeSource{
+				SecretName:  secretName,
+				DefaultMode: types.Int32P(0555),
+			},
+		},
+	})
+
+	args := d.Spec.Template.Spec.Containers[0].Args
+	args = append(args, "--tls-ca-file=/etc/guard/pki/ca.crt")
+	args = append(args, "--tls-cert-file=/etc/guard/pki/tls.crt")
+	args = append(args, "--tls-private-key-file=/etc/guard/pki/tls.key")
+	d.Spec.Template.Spec.Containers[0].Args = args
+}
@@ -22,37 +22,50 @@ import (
 	stringz "github.com/appscode/go/strings"
 	"github.com/appscode/go/types"
 	v "github.com/appscode/go/version"
+	"github.com/appscode/guard/auth/providers/aws"
 	"github.com/appscode/guard/auth/providers/azure"
 	"github.com/appscode/guard/auth/providers/github"
 	"github.com/appscode/guard/auth/providers/gitlab"
 	"github.com/appscode/guard/auth/providers/google"
+	"github.com/appscode/guard/auth/providers/keycloak"
 	"github.com/appscode/guard/auth/providers/ldap"
+	"github.com/appscode/guard/auth/providers/saml"
 	"github.com/appscode/guard/auth/providers/token"
 	azureauthz "github.com/appscode/guard/authz/providers/azure"
 	"github.com/appscode/guard/server"
 
+	"github.com/pkg/errors"
 	apps "k8s.io/api/apps/v1"
 	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func newDeployment(authopts AuthOptions, authzopts AuthzOptions) (objects []runtime.Object, err error) {
+	return newComponentDeployment("guard", labels, authopts, authzopts)
+}
+
+// newComponentDeployment renders one guard Deployment. The combined install
+// uses the name "guard"; with --split-components it is called once per
+// component with distinct names and selector labels, so the authn and authz
+// Deployments can scale independently.
+func newComponentDeployment(name string, componentLabels map[string]string, authopts AuthOptions, authzopts AuthzOptions) (objects []runtime.Object, err error) {
 	d := &apps.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "guard",
+			Name:      name,
 			Namespace: authopts.Namespace,
-			Labels:    labels,
+			Labels:    componentLabels,
 		},
 		Spec: apps.DeploymentSpec{
-			Replicas: types.Int32P(1),
+			Replicas: types.Int32P(authopts.Replicas),
 			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
+				MatchLabels: componentLabels,
 			},
 			Template: core.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels: componentLabels,
 					Annotations: map[string]string{
 						"scheduler.alpha.kubernetes.io/critical-pod": "",
 					},
@@ -104,6 +117,40 @@ func newDeployment(authopts AuthOptions, authzopts AuthzOptions) (objects []runt
 			},
 		},
 	}
+	if authopts.multiReplica() {
+		// losing guard blocks all cluster authentication, so replicas are
+		// spread across nodes and zones to survive drains and zone outages
+		d.Spec.Template.Spec.Affinity = &core.Affinity{
+			PodAntiAffinity: &core.PodAntiAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []core.WeightedPodAffinityTerm{
+					{
+						Weight: 100,
+						PodAffinityTerm: core.PodAffinityTerm{
+							LabelSelector: &metav1.LabelSelector{
+								MatchLabels: componentLabels,
+							},
+							TopologyKey: "kubernetes.io/hostname",
+						},
+					},
+				},
+			},
+		}
+		d.Spec.Template.Spec.TopologySpreadConstraints = []core.TopologySpreadConstraint{
+			{
+				MaxSkew:           1,
+				TopologyKey:       "topology.kubernetes.io/zone",
+				WhenUnsatisfiable: core.ScheduleAnyway,
+				LabelSelector: &metav1.LabelSelector{
+					MatchLabels: componentLabels,
+				},
+			},
+		}
+	}
+	if res, err := authopts.resources(); err != nil {
+		return nil, err
+	} else {
+		d.Spec.Template.Spec.Containers[0].Resources = res
+	}
 	if authopts.imagePullSecret != "" {
 		d.Spec.Template.Spec.ImagePullSecrets = []core.LocalObjectReference{
 			{
@@ -123,11 +170,17 @@ func newDeployment(authopts AuthOptions, authzopts AuthzOptions) (objects []runt
 	}
 	objects = append(objects, d)
 
-	servingOpts := server.NewSecureServingOptionsFromDir(authopts.PkiDir)
-	if extras, err := servingOpts.Apply(d); err != nil {
-		return nil, err
+	if authopts.UseCertManager {
+		// the serving secret is created and rotated by cert-manager; only the
+		// volume and args are wired here
+		applyCertManagerServing(d)
 	} else {
-		objects = append(objects, extras...)
+		servingOpts := server.NewSecureServingOptionsFromDir(authopts.PkiDir)
+		if extras, err := servingOpts.Apply(d); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, extras...)
+		}
 	}
 
 	if extras, err := authopts.AuthProvider.Apply(d); err != nil {
@@ -160,6 +213,14 @@ func newDeployment(authopts AuthOptions, authzopts AuthzOptions) (objects []runt
 		}
 	}
 
+	if authopts.AuthProvider.Has(keycloak.OrgType) {
+		if extras, err := authopts.Keycloak.Apply(d); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, extras...)
+		}
+	}
+
 	if authopts.AuthProvider.Has(ldap.OrgType) {
 		if extras, err := authopts.LDAP.Apply(d); err != nil {
 			return nil, err
@@ -168,6 +229,22 @@ func newDeployment(authopts AuthOptions, authzopts AuthzOptions) (objects []runt
 		}
 	}
 
+	if authopts.AuthProvider.Has(aws.OrgType) {
+		if extras, err := authopts.AWS.Apply(d); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, extras...)
+		}
+	}
+
+	if authopts.AuthProvider.Has(saml.OrgType) {
+		if extras, err := authopts.SAML.Apply(d); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, extras...)
+		}
+	}
+
 	if authopts.AuthProvider.Has(github.OrgType) {
 		if extras, err := authopts.Github.Apply(d); err != nil {
 			return nil, err
@@ -202,3 +279,41 @@ func newDeployment(authopts AuthOptions, authzopts AuthzOptions) (objects []runt
 
 	return
 }
+
+// multiReplica reports whether the generated Deployments can run more than
+// one pod, either statically or through the autoscaler.
+func (o AuthOptions) multiReplica() bool {
+	return o.Replicas > 1 || (o.EnableHPA && o.HPAMaxReplicas > 1)
+}
+
+// resources parses the configured container resource quantities into the
+// requirements of the guard container. Empty values leave the corresponding
+// field unset.
+func (o AuthOptions) resources() (core.ResourceRequirements, error) {
+	res := core.ResourceRequirements{}
+	quantities := []struct {
+		flag  string
+		value string
+		list  *core.ResourceList
+		name  core.ResourceName
+	}{
+		{"cpu-request", o.CPURequest, &res.Requests, core.ResourceCPU},
+		{"memory-request", o.MemoryRequest, &res.Requests, core.ResourceMemory},
+		{"cpu-limit", o.CPULimit, &res.Limits, core.ResourceCPU},
+		{"memory-limit", o.MemoryLimit, &res.Limits, core.ResourceMemory},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		qty, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return res, errors.Wrapf(err, "invalid %s %q", q.flag, q.value)
+		}
+		if *q.list == nil {
+			*q.list = core.ResourceList{}
+		}
+		(*q.list)[q.name] = qty
+	}
+	return res, nil
+}
@@ -0,0 +1,153 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	stringz "github.com/appscode/go/strings"
+	v "github.com/appscode/go/version"
+
+	"github.com/pkg/errors"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/versioning"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+const chartName = "guard"
+
+type chartMetadata struct {
+	APIVersion  string `json:"apiVersion"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion"`
+	Description string `json:"description"`
+}
+
+type chartImageValues struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+}
+
+type chartValues struct {
+	Image          chartImageValues `json:"image"`
+	ReplicaCount   int              `json:"replicaCount"`
+	AuthProviders  []string         `json:"authProviders,omitempty"`
+	AuthzProviders []string         `json:"authzProviders,omitempty"`
+}
+
+// GenerateHelmChart renders the installer objects as a Helm chart. The chart
+// files are emitted as a single YAML stream where every document carries a
+// `# Source: <path>` header, so the stream can be split into chart files with
+// standard tools or piped into a Helm plugin.
+func GenerateHelmChart(authopts AuthOptions, authzopts AuthzOptions, secretopts SecretOptions) ([]byte, error) {
+	objects, err := GenerateObjects(authopts, authzopts)
+	if err != nil {
+		return nil, err
+	}
+
+	version := stringz.Val(v.Version.Version, "canary")
+	meta := chartMetadata{
+		APIVersion:  "v1",
+		Name:        chartName,
+		Version:     strings.TrimPrefix(version, "v"),
+		AppVersion:  version,
+		Description: "Kubernetes Authentication & Authorization WebHook Server",
+	}
+	metaBytes, err := yaml.Marshal(meta)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal Chart.yaml")
+	}
+
+	values := chartValues{
+		Image: chartImageValues{
+			Repository: fmt.Sprintf("%s/guard", authopts.PrivateRegistry),
+			Tag:        version,
+		},
+		ReplicaCount:   1,
+		AuthProviders:  authopts.AuthProvider.Providers,
+		AuthzProviders: authzopts.AuthzProvider.Providers,
+	}
+	valuesBytes, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal values.yaml")
+	}
+
+	var buf bytes.Buffer
+	writeChartFile(&buf, "Chart.yaml", metaBytes)
+	buf.WriteString("---\n")
+	writeChartFile(&buf, "values.yaml", valuesBytes)
+
+	mediaType := "application/yaml"
+	info, ok := runtime.SerializerInfoForMediaType(clientsetscheme.Codecs.SupportedMediaTypes(), mediaType)
+	if !ok {
+		return nil, errors.Errorf("unsupported media type %q", mediaType)
+	}
+	codec := versioning.NewDefaultingCodecForScheme(clientsetscheme.Scheme, info.Serializer, info.Serializer, nil, nil)
+
+	for _, obj := range objects {
+		var objBuf bytes.Buffer
+		if err := codec.Encode(obj, &objBuf); err != nil {
+			return nil, err
+		}
+
+		fileName := templateFileName(obj)
+		manifest := objBuf.Bytes()
+		if secret, ok := obj.(*core.Secret); ok {
+			manifest, err = secretopts.encryptSecret(secret, manifest)
+			if err != nil {
+				return nil, err
+			}
+			if secretopts.Format == SealedSecretFormat {
+				fileName = "templates/sealedsecret.yaml"
+			}
+		}
+
+		buf.WriteString("---\n")
+		writeChartFile(&buf, fileName, templatize(manifest, values))
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeChartFile(buf *bytes.Buffer, name string, data []byte) {
+	fmt.Fprintf(buf, "# Source: %s/%s\n", chartName, name)
+	buf.Write(data)
+}
+
+func templateFileName(obj runtime.Object) string {
+	kind := strings.ToLower(obj.GetObjectKind().GroupVersionKind().Kind)
+	return fmt.Sprintf("templates/%s.yaml", kind)
+}
+
+// templatize replaces the values baked into the rendered object with Helm
+// template expressions, so image and replica count can be managed from
+// values.yaml.
+func templatize(data []byte, values chartValues) []byte {
+	out := string(data)
+	out = strings.Replace(out,
+		fmt.Sprintf("image: %s:%s", values.Image.Repository, values.Image.Tag),
+		`image: "{{ .Values.image.repository }}:{{ .Values.image.tag }}"`, 1)
+	out = strings.Replace(out,
+		fmt.Sprintf("replicas: %d", values.ReplicaCount),
+		"replicas: {{ .Values.replicaCount }}", 1)
+	return []byte(out)
+}
@@ -18,9 +18,14 @@ package installer
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/pkg/errors"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/versioning"
 	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
@@ -30,7 +35,19 @@ var labels = map[string]string{
 	"app": "guard",
 }
 
-func Generate(authopts AuthOptions, authzopts AuthzOptions) ([]byte, error) {
+// GenerateObjects renders the installer objects for the given options.
+func GenerateObjects(authopts AuthOptions, authzopts AuthzOptions) ([]runtime.Object, error) {
+	objects, err := generateObjects(authopts, authzopts)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyExtraMetadata(objects, authopts); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func generateObjects(authopts AuthOptions, authzopts AuthzOptions) ([]runtime.Object, error) {
 	var objects []runtime.Object
 
 	if authopts.Namespace != metav1.NamespaceSystem && authopts.Namespace != metav1.NamespaceDefault {
@@ -39,16 +56,204 @@ func Generate(authopts AuthOptions, authzopts AuthzOptions) ([]byte, error) {
 	objects = append(objects, newServiceAccount(authopts.Namespace))
 	objects = append(objects, newClusterRole(authopts.Namespace))
 	objects = append(objects, newClusterRoleBinding(authopts.Namespace))
+
+	if authopts.SplitComponents {
+		// independent Deployments and Services per component; the authz
+		// component also carries the auth provider configuration, which its
+		// authorizers (azure) depend on
+		authnLabels := componentLabels("authn")
+		if deployObjects, err := newComponentDeployment("guard-authn", authnLabels, authopts, AuthzOptions{}); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, deployObjects...)
+		}
+		if svc, err := newService("guard-authn", authopts.Namespace, authopts.Addr, authnLabels); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, svc)
+		}
+		if authopts.EnableHPA {
+			objects = append(objects, newHPA("guard-authn", authopts, authnLabels))
+		}
+		if authopts.multiReplica() && authopts.PDBMinAvailable != "" {
+			if pdb, err := newPDB("guard-authn", authopts, authnLabels); err != nil {
+				return nil, err
+			} else {
+				objects = append(objects, pdb)
+			}
+		}
+
+		authzLabels := componentLabels("authz")
+		if deployObjects, err := newComponentDeployment("guard-authz", authzLabels, authopts, authzopts); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, deployObjects...)
+		}
+		if svc, err := newService("guard-authz", authopts.Namespace, authzopts.Addr, authzLabels); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, svc)
+		}
+		if authopts.EnableHPA {
+			objects = append(objects, newHPA("guard-authz", authopts, authzLabels))
+		}
+		if authopts.multiReplica() && authopts.PDBMinAvailable != "" {
+			if pdb, err := newPDB("guard-authz", authopts, authzLabels); err != nil {
+				return nil, err
+			} else {
+				objects = append(objects, pdb)
+			}
+		}
+
+		if authopts.EnableNetworkPolicy {
+			objects = append(objects, newNetworkPolicy(authopts.Namespace, authopts))
+		}
+		if authopts.UseCertManager {
+			objects = append(objects, newCertManagerIssuers(authopts.Namespace)...)
+			objects = append(objects, newCertManagerServerCertificate("guard-authn", authopts.Namespace, authopts.Addr))
+			objects = append(objects, newCertManagerServerCertificate("guard-authz", authopts.Namespace, authzopts.Addr))
+			for _, org := range authopts.AuthProvider.Providers {
+				objects = append(objects, newCertManagerClientCertificate(org, authopts.Namespace))
+			}
+		}
+		if authopts.SecretsStoreVaultName != "" {
+			if spc, err := newSecretProviderClass(authopts.Namespace, authopts); err != nil {
+				return nil, err
+			} else {
+				objects = append(objects, spc)
+			}
+		}
+
+		// provider secrets are rendered once per component; drop the repeats
+		return dedupeObjects(objects), nil
+	}
+
 	if deployObjects, err := newDeployment(authopts, authzopts); err != nil {
 		return nil, err
 	} else {
 		objects = append(objects, deployObjects...)
 	}
-	if svc, err := newService(authopts.Namespace, authopts.Addr); err != nil {
+	if svc, err := newService("guard", authopts.Namespace, authopts.Addr, labels); err != nil {
 		return nil, err
 	} else {
 		objects = append(objects, svc)
 	}
+	if authopts.EnableHPA {
+		objects = append(objects, newHPA("guard", authopts, labels))
+	}
+	if authopts.multiReplica() && authopts.PDBMinAvailable != "" {
+		if pdb, err := newPDB("guard", authopts, labels); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, pdb)
+		}
+	}
+	if authopts.EnableNetworkPolicy {
+		objects = append(objects, newNetworkPolicy(authopts.Namespace, authopts))
+	}
+	if authopts.UseCertManager {
+		objects = append(objects, newCertManagerIssuers(authopts.Namespace)...)
+		objects = append(objects, newCertManagerServerCertificate("guard", authopts.Namespace, authopts.Addr))
+		for _, org := range authopts.AuthProvider.Providers {
+			objects = append(objects, newCertManagerClientCertificate(org, authopts.Namespace))
+		}
+	}
+	if authopts.SecretsStoreVaultName != "" {
+		if spc, err := newSecretProviderClass(authopts.Namespace, authopts); err != nil {
+			return nil, err
+		} else {
+			objects = append(objects, spc)
+		}
+	}
+
+	return objects, nil
+}
+
+// componentLabels returns the selector labels of one component, so each split
+// Deployment and Service pair only addresses its own pods.
+func componentLabels(component string) map[string]string {
+	l := map[string]string{"component": component}
+	for k, v := range labels {
+		l[k] = v
+	}
+	return l
+}
+
+// applyExtraMetadata stamps the configured extra labels and annotations on
+// every generated object, including the pod templates of the Deployments.
+// Keys guard sets itself are left alone, so the passthrough can never break
+// the selector labels.
+func applyExtraMetadata(objects []runtime.Object, authopts AuthOptions) error {
+	extraLabels, err := parseKeyValuePairs("extra-labels", authopts.ExtraLabels)
+	if err != nil {
+		return err
+	}
+	extraAnnotations, err := parseKeyValuePairs("extra-annotations", authopts.ExtraAnnotations)
+	if err != nil {
+		return err
+	}
+	if len(extraLabels) == 0 && len(extraAnnotations) == 0 {
+		return nil
+	}
+
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return errors.Wrapf(err, "cannot apply extra metadata to %T", obj)
+		}
+		accessor.SetLabels(mergeMetadata(accessor.GetLabels(), extraLabels))
+		accessor.SetAnnotations(mergeMetadata(accessor.GetAnnotations(), extraAnnotations))
+		if d, ok := obj.(*apps.Deployment); ok {
+			// policy controllers usually check pods, not just their owners
+			d.Spec.Template.Labels = mergeMetadata(d.Spec.Template.Labels, extraLabels)
+			d.Spec.Template.Annotations = mergeMetadata(d.Spec.Template.Annotations, extraAnnotations)
+		}
+	}
+	return nil
+}
+
+// mergeMetadata adds the extra entries to own, without overwriting keys guard
+// already set.
+func mergeMetadata(own, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return own
+	}
+	merged := make(map[string]string, len(own)+len(extra))
+	for k, v := range extra {
+		merged[k] = v
+	}
+	for k, v := range own {
+		merged[k] = v
+	}
+	return merged
+}
+
+// dedupeObjects drops repeated objects, keeping the first occurrence. Shared
+// provider secrets are rendered by both split components.
+func dedupeObjects(objects []runtime.Object) []runtime.Object {
+	seen := map[string]bool{}
+	out := make([]runtime.Object, 0, len(objects))
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			out = append(out, obj)
+			continue
+		}
+		key := fmt.Sprintf("%T/%s/%s", obj, accessor.GetNamespace(), accessor.GetName())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, obj)
+	}
+	return out
+}
+
+func Generate(authopts AuthOptions, authzopts AuthzOptions, secretopts SecretOptions) ([]byte, error) {
+	objects, err := GenerateObjects(authopts, authzopts)
+	if err != nil {
+		return nil, err
+	}
 
 	mediaType := "application/yaml"
 	info, ok := runtime.SerializerInfoForMediaType(clientsetscheme.Codecs.SupportedMediaTypes(), mediaType)
@@ -62,10 +267,26 @@ func Generate(authopts AuthOptions, authzopts AuthzOptions) ([]byte, error) {
 		if i > 0 {
 			buf.WriteString("---\n")
 		}
-		err := codec.Encode(obj, &buf)
-		if err != nil {
+
+		var objBuf bytes.Buffer
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			// cert-manager types are not registered in the client-go scheme,
+			// so they skip the defaulting codec
+			if err := info.Serializer.Encode(u, &objBuf); err != nil {
+				return nil, err
+			}
+		} else if err := codec.Encode(obj, &objBuf); err != nil {
 			return nil, err
 		}
+
+		manifest := objBuf.Bytes()
+		if secret, ok := obj.(*core.Secret); ok {
+			manifest, err = secretopts.encryptSecret(secret, manifest)
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf.Write(manifest)
 	}
 	return buf.Bytes(), nil
 }
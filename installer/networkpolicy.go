@@ -0,0 +1,88 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"github.com/appscode/guard/server"
+
+	core "k8s.io/api/core/v1"
+	networking "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// newNetworkPolicy renders the NetworkPolicy locking guard down: ingress only
+// from the API server CIDRs on the serving port, egress only to DNS and the
+// identity provider CIDRs. It selects on the shared app label, so one policy
+// also covers both split components.
+func newNetworkPolicy(namespace string, opts AuthOptions) runtime.Object {
+	tcp := core.ProtocolTCP
+	udp := core.ProtocolUDP
+	servingPort := intstr.FromInt(server.ServingPort)
+	dnsPort := intstr.FromInt(53)
+
+	ingressPeers := make([]networking.NetworkPolicyPeer, 0, len(opts.APIServerCIDRs))
+	for _, cidr := range opts.APIServerCIDRs {
+		ingressPeers = append(ingressPeers, networking.NetworkPolicyPeer{
+			IPBlock: &networking.IPBlock{CIDR: cidr},
+		})
+	}
+	egressPeers := make([]networking.NetworkPolicyPeer, 0, len(opts.EgressCIDRs))
+	for _, cidr := range opts.EgressCIDRs {
+		egressPeers = append(egressPeers, networking.NetworkPolicyPeer{
+			IPBlock: &networking.IPBlock{CIDR: cidr},
+		})
+	}
+
+	return &networking.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "guard",
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			PolicyTypes: []networking.PolicyType{
+				networking.PolicyTypeIngress,
+				networking.PolicyTypeEgress,
+			},
+			Ingress: []networking.NetworkPolicyIngressRule{
+				{
+					From: ingressPeers,
+					Ports: []networking.NetworkPolicyPort{
+						{Protocol: &tcp, Port: &servingPort},
+					},
+				},
+			},
+			Egress: []networking.NetworkPolicyEgressRule{
+				// name resolution of the identity provider endpoints
+				{
+					Ports: []networking.NetworkPolicyPort{
+						{Protocol: &udp, Port: &dnsPort},
+						{Protocol: &tcp, Port: &dnsPort},
+					},
+				},
+				{
+					To: egressPeers,
+				},
+			},
+		},
+	}
+}
@@ -17,18 +17,26 @@ limitations under the License.
 package installer
 
 import (
+	"net"
+	"strings"
+
 	"github.com/appscode/guard/auth"
 	"github.com/appscode/guard/auth/providers"
+	"github.com/appscode/guard/auth/providers/aws"
 	"github.com/appscode/guard/auth/providers/azure"
 	"github.com/appscode/guard/auth/providers/github"
 	"github.com/appscode/guard/auth/providers/gitlab"
 	"github.com/appscode/guard/auth/providers/google"
+	"github.com/appscode/guard/auth/providers/keycloak"
 	"github.com/appscode/guard/auth/providers/ldap"
+	"github.com/appscode/guard/auth/providers/saml"
 	"github.com/appscode/guard/auth/providers/token"
 	authz "github.com/appscode/guard/authz/providers"
 	azureauthz "github.com/appscode/guard/authz/providers/azure"
 	authzOpts "github.com/appscode/guard/authz/providers/azure/options"
+	"github.com/appscode/guard/util/flagutil"
 
+	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -41,12 +49,59 @@ type AuthOptions struct {
 	RunOnMaster     bool
 	PrivateRegistry string
 	imagePullSecret string
+	// SplitComponents emits independent authn and authz Deployments and
+	// Services, so the two load profiles can scale separately
+	SplitComponents bool
+	// Replicas is the replica count of the generated Deployments; an HPA, if
+	// enabled, takes over from there
+	Replicas int32
+	// resource requests and limits of the guard container, as Kubernetes
+	// quantity strings; empty leaves the field unset
+	CPURequest    string
+	MemoryRequest string
+	CPULimit      string
+	MemoryLimit   string
+	// EnableHPA emits a HorizontalPodAutoscaler per generated Deployment
+	EnableHPA               bool
+	HPAMinReplicas          int32
+	HPAMaxReplicas          int32
+	HPATargetCPUUtilization int32
+	// PDBMinAvailable is the minAvailable of the PodDisruptionBudget
+	// generated for multi-replica Deployments, as a count or percentage.
+	// Losing guard blocks all cluster authentication, so node drains must
+	// not take every replica down at once
+	PDBMinAvailable string
+	// EnableNetworkPolicy emits a NetworkPolicy that only admits ingress from
+	// the API server CIDRs on the serving port and egress to DNS and the
+	// identity provider CIDRs, locking the webhook down by default
+	EnableNetworkPolicy bool
+	APIServerCIDRs      []string
+	EgressCIDRs         []string
+	// UseCertManager generates cert-manager Certificate/Issuer resources for
+	// the server and client certificates instead of embedding static PKI read
+	// from --pki-dir, so guard's TLS material rotates automatically
+	UseCertManager bool
+	// SecretsStoreVaultName generates a SecretProviderClass for the Secrets
+	// Store CSI driver pulling the listed objects from this Azure Key Vault;
+	// the provider secrets-store-provider-class flags reference it
+	SecretsStoreVaultName string
+	SecretsStoreTenantID  string
+	SecretsStoreObjects   []string
+	// ExtraLabels and ExtraAnnotations are key=value pairs stamped on every
+	// generated object, so org-wide policy controllers (cost-center labels,
+	// ownership annotations) are satisfied without post-processing the YAML.
+	// Guard's own metadata wins on a key clash
+	ExtraLabels      []string
+	ExtraAnnotations []string
 
 	AuthProvider providers.AuthProviders
 	Token        token.Options
 	Google       google.Options
 	Azure        azure.Options
+	Keycloak     keycloak.Options
 	LDAP         ldap.Options
+	SAML         saml.Options
+	AWS          aws.Options
 	Github       github.Options
 	Gitlab       gitlab.Options
 }
@@ -54,6 +109,8 @@ type AuthOptions struct {
 type AuthzOptions struct {
 	AuthzProvider authz.AuthzProviders
 	Azure         authzOpts.Options
+	// Addr is the address of the authz Service, used with --split-components
+	Addr string
 }
 
 func NewAuthOptions() AuthOptions {
@@ -63,45 +120,143 @@ func NewAuthOptions() AuthOptions {
 		Addr:            "10.96.10.96:443",
 		PrivateRegistry: "appscode",
 		RunOnMaster:     true,
-		Token:           token.NewOptions(),
-		Google:          google.NewOptions(),
-		Azure:           azure.NewOptions(),
-		LDAP:            ldap.NewOptions(),
-		Github:          github.NewOptions(),
-		Gitlab:          gitlab.NewOptions(),
+		Replicas:        1,
+
+		HPAMinReplicas:          1,
+		HPAMaxReplicas:          3,
+		HPATargetCPUUtilization: 80,
+		PDBMinAvailable:         "1",
+		EgressCIDRs:             []string{"0.0.0.0/0"},
+
+		Token:    token.NewOptions(),
+		Google:   google.NewOptions(),
+		Azure:    azure.NewOptions(),
+		Keycloak: keycloak.NewOptions(),
+		LDAP:     ldap.NewOptions(),
+		SAML:     saml.NewOptions(),
+		AWS:      aws.NewOptions(),
+		Github:   github.NewOptions(),
+		Gitlab:   gitlab.NewOptions(),
 	}
 }
 
 func NewAuthzOptions() AuthzOptions {
 	return AuthzOptions{
 		Azure: authzOpts.NewOptions(),
+		Addr:  "10.96.10.97:443",
 	}
 }
 
 func (o *AuthOptions) AddFlags(fs *pflag.FlagSet) {
-	fs.StringVar(&o.PkiDir, "pki-dir", o.PkiDir, "Path to directory where pki files are stored.")
-	fs.StringVarP(&o.Namespace, "namespace", "n", o.Namespace, "Name of Kubernetes namespace used to run guard server.")
-	fs.StringVar(&o.Addr, "addr", o.Addr, "Address (host:port) of guard server.")
-	fs.BoolVar(&o.RunOnMaster, "run-on-master", o.RunOnMaster, "If true, runs Guard server on master instances")
-	fs.StringVar(&o.PrivateRegistry, "private-registry", o.PrivateRegistry, "Private Docker registry")
-	fs.StringVar(&o.imagePullSecret, "image-pull-secret", o.imagePullSecret, "Name of image pull secret")
-	o.AuthProvider.AddFlags(fs)
-	o.Token.AddFlags(fs)
-	o.Google.AddFlags(fs)
-	o.Azure.AddFlags(fs)
-	o.LDAP.AddFlags(fs)
-	o.Github.AddFlags(fs)
-	o.Gitlab.AddFlags(fs)
+	groups := flagutil.NewGroups()
+	installerGroup := groups.Group("installer")
+	installerGroup.StringVar(&o.PkiDir, "pki-dir", o.PkiDir, "Path to directory where pki files are stored.")
+	installerGroup.StringVarP(&o.Namespace, "namespace", "n", o.Namespace, "Name of Kubernetes namespace used to run guard server.")
+	installerGroup.StringVar(&o.Addr, "addr", o.Addr, "Address (host:port) of guard server.")
+	installerGroup.BoolVar(&o.RunOnMaster, "run-on-master", o.RunOnMaster, "If true, runs Guard server on master instances")
+	installerGroup.StringVar(&o.PrivateRegistry, "private-registry", o.PrivateRegistry, "Private Docker registry")
+	installerGroup.StringVar(&o.imagePullSecret, "image-pull-secret", o.imagePullSecret, "Name of image pull secret")
+	installerGroup.BoolVar(&o.SplitComponents, "split-components", o.SplitComponents, "If true, generate separate authn and authz Deployments and Services, so both components can be scaled and certified independently")
+	installerGroup.Int32Var(&o.Replicas, "replicas", o.Replicas, "Replica count of the generated Deployments")
+	installerGroup.StringVar(&o.CPURequest, "cpu-request", o.CPURequest, "CPU request of the guard container, e.g. 100m. Empty leaves it unset")
+	installerGroup.StringVar(&o.MemoryRequest, "memory-request", o.MemoryRequest, "Memory request of the guard container, e.g. 128Mi. Empty leaves it unset")
+	installerGroup.StringVar(&o.CPULimit, "cpu-limit", o.CPULimit, "CPU limit of the guard container, e.g. 500m. Empty leaves it unset")
+	installerGroup.StringVar(&o.MemoryLimit, "memory-limit", o.MemoryLimit, "Memory limit of the guard container, e.g. 256Mi. Empty leaves it unset")
+	installerGroup.BoolVar(&o.EnableHPA, "enable-hpa", o.EnableHPA, "If true, generate a HorizontalPodAutoscaler per Deployment. Requires --cpu-request")
+	installerGroup.Int32Var(&o.HPAMinReplicas, "hpa-min-replicas", o.HPAMinReplicas, "Minimum replica count of the HorizontalPodAutoscaler")
+	installerGroup.Int32Var(&o.HPAMaxReplicas, "hpa-max-replicas", o.HPAMaxReplicas, "Maximum replica count of the HorizontalPodAutoscaler")
+	installerGroup.Int32Var(&o.HPATargetCPUUtilization, "hpa-target-cpu-utilization", o.HPATargetCPUUtilization, "Target average CPU utilization percentage of the HorizontalPodAutoscaler")
+	installerGroup.StringVar(&o.PDBMinAvailable, "pdb-min-available", o.PDBMinAvailable, "minAvailable of the PodDisruptionBudget generated for multi-replica Deployments, as a count or percentage, e.g. 1 or 50%")
+	installerGroup.BoolVar(&o.EnableNetworkPolicy, "enable-network-policy", o.EnableNetworkPolicy, "If true, generate a NetworkPolicy restricting guard to API server ingress and identity provider egress. Requires --apiserver-cidr")
+	installerGroup.StringSliceVar(&o.APIServerCIDRs, "apiserver-cidr", o.APIServerCIDRs, "CIDR blocks of the API servers / control plane nodes allowed to reach guard")
+	installerGroup.StringSliceVar(&o.EgressCIDRs, "egress-cidr", o.EgressCIDRs, "CIDR blocks guard may reach for identity provider calls. Narrow this to your provider's published address ranges")
+	installerGroup.BoolVar(&o.UseCertManager, "use-cert-manager", o.UseCertManager, "If true, generate cert-manager Certificate/Issuer resources instead of embedding PKI from --pki-dir. Requires cert-manager in the cluster")
+	installerGroup.StringVar(&o.SecretsStoreVaultName, "secrets-store-keyvault-name", o.SecretsStoreVaultName, "Name of the Azure Key Vault a generated SecretProviderClass named "+guardSecretProviderClass+" pulls credentials from. Requires the Secrets Store CSI driver and its azure provider in the cluster")
+	installerGroup.StringVar(&o.SecretsStoreTenantID, "secrets-store-keyvault-tenant-id", o.SecretsStoreTenantID, "Tenant ID of the Azure Key Vault named by --secrets-store-keyvault-name")
+	installerGroup.StringSliceVar(&o.SecretsStoreObjects, "secrets-store-object", o.SecretsStoreObjects, "Key Vault object mounted by the SecretProviderClass, as objectName=alias with an optional secret/, key/ or cert/ prefix selecting the object type. The alias must match the file name the provider expects, e.g. sa.json or client-secret. Repeatable")
+	installerGroup.StringSliceVar(&o.ExtraLabels, "extra-labels", o.ExtraLabels, "list of key=value labels added to every generated object, e.g. cost-center=platform. Guard's own labels win on a clash")
+	installerGroup.StringSliceVar(&o.ExtraAnnotations, "extra-annotations", o.ExtraAnnotations, "list of key=value annotations added to every generated object. Guard's own annotations win on a clash")
+	o.AuthProvider.AddFlags(groups.Group("auth"))
+	o.Token.AddFlags(groups.Group("token auth"))
+	o.Google.AddFlags(groups.Group("google auth"))
+	o.Azure.AddFlags(groups.Group("azure auth"))
+	o.Keycloak.AddFlags(groups.Group("keycloak auth"))
+	o.LDAP.AddFlags(groups.Group("ldap auth"))
+	o.SAML.AddFlags(groups.Group("saml auth"))
+	o.AWS.AddFlags(groups.Group("aws auth"))
+	o.Github.AddFlags(groups.Group("github auth"))
+	o.Gitlab.AddFlags(groups.Group("gitlab auth"))
+	// a clash is a programming error in a provider; fail loudly at startup
+	// with the owning groups named instead of panicking inside pflag
+	if errs := groups.ApplyTo(fs); errs != nil {
+		glog.Fatal(errs)
+	}
 }
 
 func (o *AuthzOptions) AddFlags(fs *pflag.FlagSet) {
-	o.AuthzProvider.AddFlags(fs)
-	o.Azure.AddFlags(fs)
+	groups := flagutil.NewGroups()
+	authzGroup := groups.Group("authz")
+	o.AuthzProvider.AddFlags(authzGroup)
+	authzGroup.StringVar(&o.Addr, "authz-addr", o.Addr, "Address (host:port) of the guard authz server, used with --split-components.")
+	o.Azure.AddFlags(groups.Group("azure authz"))
+	if errs := groups.ApplyTo(fs); errs != nil {
+		glog.Fatal(errs)
+	}
 }
 func (o *AuthOptions) Validate() []error {
 	var errs []error
 	errs = append(errs, o.AuthProvider.Validate()...)
 
+	if o.Replicas < 1 {
+		errs = append(errs, errors.New("replicas must be at least 1."))
+	}
+	if _, err := o.resources(); err != nil {
+		errs = append(errs, err)
+	}
+	if o.PDBMinAvailable != "" {
+		if _, err := parseMinAvailable(o.PDBMinAvailable); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.EnableHPA {
+		if o.CPURequest == "" {
+			errs = append(errs, errors.New("enable-hpa requires cpu-request, the autoscaler scales on CPU utilization."))
+		}
+		if o.HPAMinReplicas < 1 || o.HPAMaxReplicas < o.HPAMinReplicas {
+			errs = append(errs, errors.New("hpa-min-replicas must be at least 1 and not exceed hpa-max-replicas."))
+		}
+		if o.HPATargetCPUUtilization < 1 || o.HPATargetCPUUtilization > 100 {
+			errs = append(errs, errors.New("hpa-target-cpu-utilization must be between 1 and 100."))
+		}
+	}
+	if o.EnableNetworkPolicy && len(o.APIServerCIDRs) == 0 {
+		errs = append(errs, errors.New("enable-network-policy requires apiserver-cidr, or the generated policy would cut the API server off from guard."))
+	}
+	for _, cidr := range append(append([]string{}, o.APIServerCIDRs...), o.EgressCIDRs...) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, errors.Wrapf(err, "invalid CIDR %q", cidr))
+		}
+	}
+	if o.SecretsStoreVaultName != "" {
+		if o.SecretsStoreTenantID == "" {
+			errs = append(errs, errors.New("secrets-store-keyvault-name requires secrets-store-keyvault-tenant-id."))
+		}
+		if len(o.SecretsStoreObjects) == 0 {
+			errs = append(errs, errors.New("secrets-store-keyvault-name requires at least one secrets-store-object."))
+		}
+	}
+	for _, s := range o.SecretsStoreObjects {
+		if _, err := parseSecretsStoreObject(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := parseKeyValuePairs("extra-labels", o.ExtraLabels); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseKeyValuePairs("extra-annotations", o.ExtraAnnotations); err != nil {
+		errs = append(errs, err)
+	}
+
 	if o.AuthProvider.Has(token.OrgType) {
 		errs = append(errs, o.Token.Validate()...)
 	}
@@ -111,9 +266,18 @@ func (o *AuthOptions) Validate() []error {
 	if o.AuthProvider.Has(azure.OrgType) {
 		errs = append(errs, o.Azure.Validate()...)
 	}
+	if o.AuthProvider.Has(keycloak.OrgType) {
+		errs = append(errs, o.Keycloak.Validate()...)
+	}
 	if o.AuthProvider.Has(ldap.OrgType) {
 		errs = append(errs, o.LDAP.Validate()...)
 	}
+	if o.AuthProvider.Has(saml.OrgType) {
+		errs = append(errs, o.SAML.Validate()...)
+	}
+	if o.AuthProvider.Has(aws.OrgType) {
+		errs = append(errs, o.AWS.Validate()...)
+	}
 	if o.AuthProvider.Has(github.OrgType) {
 		errs = append(errs, o.Github.Validate()...)
 	}
@@ -124,10 +288,51 @@ func (o *AuthOptions) Validate() []error {
 	return errs
 }
 
+// parseKeyValuePairs parses the key=value entries of flagName into a map.
+func parseKeyValuePairs(flagName string, pairs []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("%s entry %q is not in key=value format.", flagName, pair)
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed, nil
+}
+
+// ValidateCredentials exercises the credentials of each configured provider
+// against its backend (AAD token fetch, LDAP bind, Google service account),
+// so bad secrets are caught before the generated objects hit the cluster.
+// Providers without installer-side credentials are skipped.
+func (o *AuthOptions) ValidateCredentials() []error {
+	var errs []error
+	if o.AuthProvider.Has(azure.OrgType) {
+		if err := o.Azure.ValidateCredentials(); err != nil {
+			errs = append(errs, errors.Wrap(err, "azure credential validation failed"))
+		}
+	}
+	if o.AuthProvider.Has(google.OrgType) {
+		if err := o.Google.ValidateCredentials(); err != nil {
+			errs = append(errs, errors.Wrap(err, "google credential validation failed"))
+		}
+	}
+	if o.AuthProvider.Has(ldap.OrgType) {
+		if err := o.LDAP.ValidateCredentials(); err != nil {
+			errs = append(errs, errors.Wrap(err, "ldap credential validation failed"))
+		}
+	}
+	return errs
+}
+
 func (o *AuthzOptions) Validate(opt *AuthOptions) []error {
 	var errs []error
 	errs = append(errs, o.AuthzProvider.Validate()...)
 
+	if opt.SplitComponents && len(o.AuthzProvider.Providers) == 0 {
+		errs = append(errs, errors.New("--split-components requires at least one authz provider."))
+	}
+
 	if o.AuthzProvider.Has(azureauthz.OrgType) {
 		if !opt.AuthProvider.Has(azure.OrgType) {
 			errs = append(errs, errors.New("azure authz option must be used only with azure auth provider."))
@@ -0,0 +1,66 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	policy "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// newPDB renders a PodDisruptionBudget keeping the configured number of pods
+// of the named Deployment available through voluntary disruptions.
+func newPDB(name string, opts AuthOptions, componentLabels map[string]string) (runtime.Object, error) {
+	minAvailable, err := parseMinAvailable(opts.PDBMinAvailable)
+	if err != nil {
+		return nil, err
+	}
+	return &policy.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: opts.Namespace,
+			Labels:    componentLabels,
+		},
+		Spec: policy.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: componentLabels,
+			},
+		},
+	}, nil
+}
+
+// parseMinAvailable parses a pdb-min-available value, either a plain count or
+// a percentage.
+func parseMinAvailable(value string) (intstr.IntOrString, error) {
+	if strings.HasSuffix(value, "%") {
+		if _, err := strconv.Atoi(strings.TrimSuffix(value, "%")); err != nil {
+			return intstr.IntOrString{}, errors.Errorf("invalid pdb-min-available %q, expected a count or percentage", value)
+		}
+		return intstr.FromString(value), nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return intstr.IntOrString{}, errors.Errorf("invalid pdb-min-available %q, expected a count or percentage", value)
+	}
+	return intstr.FromInt(n), nil
+}
@@ -0,0 +1,263 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	PlainSecretFormat  = "plain"
+	SealedSecretFormat = "sealed"
+	SopsSecretFormat   = "sops"
+)
+
+// SecretOptions controls how the installer renders the provider credential
+// Secrets. The sealed and sops formats emit encrypted manifests, so the
+// generated YAML can be checked into a GitOps repository safely.
+type SecretOptions struct {
+	Format string
+
+	// certificate of the sealed-secrets controller, used to encrypt
+	// SealedSecret values
+	SealedSecretsCertFile string
+
+	// recipients passed to sops when encrypting
+	SopsAgeRecipients   []string
+	SopsPGPFingerprints []string
+}
+
+func NewSecretOptions() SecretOptions {
+	return SecretOptions{
+		Format: PlainSecretFormat,
+	}
+}
+
+func (o *SecretOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Format, "secret-format", o.Format, "format used to render credential secrets, valid value is plain, sealed (SealedSecret manifests) or sops (sops encrypted manifests)")
+	fs.StringVar(&o.SealedSecretsCertFile, "sealed-secrets-cert-file", o.SealedSecretsCertFile, "certificate of the sealed-secrets controller used to encrypt SealedSecret values")
+	fs.StringSliceVar(&o.SopsAgeRecipients, "sops-age-recipients", o.SopsAgeRecipients, "age recipients passed to sops when encrypting secrets")
+	fs.StringSliceVar(&o.SopsPGPFingerprints, "sops-pgp-fingerprints", o.SopsPGPFingerprints, "PGP key fingerprints passed to sops when encrypting secrets")
+}
+
+func (o *SecretOptions) Validate() []error {
+	var errs []error
+	switch o.Format {
+	case PlainSecretFormat:
+	case SealedSecretFormat:
+		if o.SealedSecretsCertFile == "" {
+			errs = append(errs, errors.New("sealed-secrets-cert-file must be non-empty for sealed secret format"))
+		}
+	case SopsSecretFormat:
+		if len(o.SopsAgeRecipients) == 0 && len(o.SopsPGPFingerprints) == 0 {
+			errs = append(errs, errors.New("sops-age-recipients or sops-pgp-fingerprints must be non-empty for sops secret format"))
+		}
+	default:
+		errs = append(errs, errors.New("invalid secret-format. valid value is plain, sealed or sops"))
+	}
+	return errs
+}
+
+// encryptSecret rewrites the rendered manifest of a credential Secret per the
+// configured format. For the plain format the manifest is returned unchanged.
+func (o SecretOptions) encryptSecret(secret *core.Secret, manifest []byte) ([]byte, error) {
+	switch o.Format {
+	case SealedSecretFormat:
+		return o.sealSecret(secret)
+	case SopsSecretFormat:
+		return o.sopsEncrypt(secret, manifest)
+	default:
+		return manifest, nil
+	}
+}
+
+// sealedSecret is the bitnami.com/v1alpha1 SealedSecret manifest understood
+// by the sealed-secrets controller.
+type sealedSecret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   metav1.ObjectMeta `json:"metadata"`
+	Spec       sealedSecretSpec  `json:"spec"`
+}
+
+type sealedSecretSpec struct {
+	EncryptedData map[string]string    `json:"encryptedData"`
+	Template      sealedSecretTemplate `json:"template"`
+}
+
+type sealedSecretTemplate struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+}
+
+func (o SecretOptions) sealSecret(secret *core.Secret) ([]byte, error) {
+	pub, err := parseSealingKey(o.SealedSecretsCertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// strict scope: values are bound to the secret name and namespace
+	label := []byte(fmt.Sprintf("%s/%s", secret.Namespace, secret.Name))
+	encryptedData := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		sealed, err := sealValue(pub, label, value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to seal key %s of secret %s", key, secret.Name)
+		}
+		encryptedData[key] = base64.StdEncoding.EncodeToString(sealed)
+	}
+
+	obj := sealedSecret{
+		APIVersion: "bitnami.com/v1alpha1",
+		Kind:       "SealedSecret",
+		Metadata: metav1.ObjectMeta{
+			Name:      secret.Name,
+			Namespace: secret.Namespace,
+			Labels:    secret.Labels,
+		},
+		Spec: sealedSecretSpec{
+			EncryptedData: encryptedData,
+			Template: sealedSecretTemplate{
+				Metadata: metav1.ObjectMeta{
+					Name:      secret.Name,
+					Namespace: secret.Namespace,
+					Labels:    secret.Labels,
+				},
+			},
+		},
+	}
+	return yaml.Marshal(obj)
+}
+
+func parseSealingKey(certFile string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read sealed-secrets cert file")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("unable to decode PEM block in sealed-secrets cert file")
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse sealed-secrets certificate")
+		}
+		key = cert.PublicKey
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse sealed-secrets public key")
+		}
+	}
+
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("sealed-secrets cert must contain an RSA public key")
+	}
+	return pub, nil
+}
+
+// sealValue implements the hybrid encryption scheme used by kubeseal: the
+// value is encrypted with a fresh AES-256-GCM session key, which in turn is
+// encrypted with RSA-OAEP under the controller certificate. The label binds
+// the ciphertext to the secret name and namespace.
+func sealValue(pub *rsa.PublicKey, label, plaintext []byte) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aed, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// the session key is never reused, so the zero nonce is safe
+	zeroNonce := make([]byte, aed.NonceSize())
+
+	out := make([]byte, 2)
+	binary.BigEndian.PutUint16(out, uint16(len(rsaCiphertext)))
+	out = append(out, rsaCiphertext...)
+	return aed.Seal(out, zeroNonce, plaintext, nil), nil
+}
+
+// sopsEncrypt pipes the rendered secret manifest through the sops binary, so
+// key management follows the recipients given on the command line.
+func (o SecretOptions) sopsEncrypt(secret *core.Secret, manifest []byte) ([]byte, error) {
+	f, err := ioutil.TempFile("", fmt.Sprintf("%s-*.yaml", secret.Name))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create temp file for sops")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(manifest); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "unable to write temp file for sops")
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	args := []string{"--encrypt", "--input-type", "yaml", "--output-type", "yaml"}
+	if len(o.SopsAgeRecipients) > 0 {
+		args = append(args, "--age", strings.Join(o.SopsAgeRecipients, ","))
+	}
+	if len(o.SopsPGPFingerprints) > 0 {
+		args = append(args, "--pgp", strings.Join(o.SopsPGPFingerprints, ","))
+	}
+	args = append(args, f.Name())
+
+	out, err := exec.Command("sops", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, errors.Errorf("sops failed for secret %s: %s", secret.Name, string(exitErr.Stderr))
+		}
+		return nil, errors.Wrapf(err, "failed to run sops for secret %s", secret.Name)
+	}
+	return out, nil
+}
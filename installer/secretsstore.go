@@ -0,0 +1,108 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package installer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// secretsStoreAPIVersion is the API group/version of the generated
+// SecretProviderClass. Like the cert-manager types, it is not part of the
+// client-go scheme, so it is rendered as an unstructured object.
+const secretsStoreAPIVersion = "secrets-store.csi.x-k8s.io/v1"
+
+// guardSecretProviderClass is the name of the generated SecretProviderClass;
+// the provider secrets-store-provider-class flags reference it.
+const guardSecretProviderClass = "guard-auth"
+
+// secretsStoreObject is one vault object the CSI driver mounts, parsed from
+// a --secrets-store-object flag value.
+type secretsStoreObject struct {
+	Name  string
+	Type  string
+	Alias string
+}
+
+// parseSecretsStoreObject parses "objectName=alias", optionally prefixed with
+// "secret/", "key/" or "cert/" selecting the vault object type. The alias is
+// the file name under the mount path and must match what the provider expects
+// there, e.g. sa.json or client-secret.
+func parseSecretsStoreObject(s string) (secretsStoreObject, error) {
+	obj := secretsStoreObject{Type: "secret"}
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return obj, errors.Errorf("invalid secrets-store-object %q, expected objectName=alias", s)
+	}
+	name, alias := parts[0], parts[1]
+	if typed := strings.SplitN(name, "/", 2); len(typed) == 2 {
+		switch typed[0] {
+		case "secret", "key", "cert":
+			obj.Type = typed[0]
+		default:
+			return obj, errors.Errorf("invalid secrets-store-object type %q, expected secret, key or cert", typed[0])
+		}
+		name = typed[1]
+	}
+	obj.Name = name
+	obj.Alias = alias
+	return obj, nil
+}
+
+// newSecretProviderClass renders the SecretProviderClass backing the guard
+// auth volumes. Only the azure (Key Vault) provider has first-class parameter
+// flags; the objects parameter is the YAML array format the azure provider
+// expects.
+func newSecretProviderClass(namespace string, opts AuthOptions) (runtime.Object, error) {
+	var objects strings.Builder
+	objects.WriteString("array:\n")
+	for _, s := range opts.SecretsStoreObjects {
+		obj, err := parseSecretsStoreObject(s)
+		if err != nil {
+			return nil, err
+		}
+		objects.WriteString(fmt.Sprintf("  - |\n    objectName: %s\n    objectType: %s\n    objectAlias: %s\n", obj.Name, obj.Type, obj.Alias))
+	}
+
+	objLabels := map[string]interface{}{}
+	for k, v := range labels {
+		objLabels[k] = v
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": secretsStoreAPIVersion,
+			"kind":       "SecretProviderClass",
+			"metadata": map[string]interface{}{
+				"name":      guardSecretProviderClass,
+				"namespace": namespace,
+				"labels":    objLabels,
+			},
+			"spec": map[string]interface{}{
+				"provider": "azure",
+				"parameters": map[string]interface{}{
+					"keyvaultName": opts.SecretsStoreVaultName,
+					"tenantId":     opts.SecretsStoreTenantID,
+					"objects":      objects.String(),
+				},
+			},
+		},
+	}, nil
+}
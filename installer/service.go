@@ -29,7 +29,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
-func newService(namespace, addr string) (runtime.Object, error) {
+func newService(name, namespace, addr string, selector map[string]string) (runtime.Object, error) {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, errors.Wrap(err, "Guard server address is invalid.")
@@ -41,9 +41,9 @@ func newService(namespace, addr string) (runtime.Object, error) {
 
 	return &core.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "guard",
+			Name:      name,
 			Namespace: namespace,
-			Labels:    labels,
+			Labels:    selector,
 		},
 		Spec: core.ServiceSpec{
 			Type:      core.ServiceTypeClusterIP,
@@ -56,7 +56,7 @@ func newService(namespace, addr string) (runtime.Object, error) {
 					TargetPort: intstr.FromInt(server.ServingPort),
 				},
 			},
-			Selector: labels,
+			Selector: selector,
 		},
 	}, nil
 }
@@ -18,16 +18,29 @@ package main
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 
 	logs "github.com/appscode/go/log/golog"
 	"github.com/appscode/guard/commands"
+
+	"github.com/spf13/cobra"
 )
 
 func main() {
 	logs.InitLogs()
 	defer logs.FlushLogs()
 
-	if err := commands.NewRootCmd().Execute(); err != nil {
+	// when installed on the PATH as kubectl-guard, kubectl runs the binary as
+	// a plugin; serve the plugin command set directly in that case
+	var rootCmd *cobra.Command
+	if strings.HasPrefix(filepath.Base(os.Args[0]), "kubectl-guard") {
+		rootCmd = commands.NewCmdKubectlGuard()
+	} else {
+		rootCmd = commands.NewRootCmd()
+	}
+
+	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
 	os.Exit(0)
@@ -0,0 +1,83 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package execcredential renders kubectl ExecCredential objects in any of
+// the client.authentication.k8s.io API versions, so the login providers share
+// one printer and newer kubectl versions are not forced onto the deprecated
+// v1alpha1 format.
+package execcredential
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const apiGroup = "client.authentication.k8s.io"
+
+// supportedVersions are the ExecCredential API versions guard can emit. The
+// object shape is identical across them; only the apiVersion differs.
+var supportedVersions = []string{"v1alpha1", "v1beta1", "v1"}
+
+// execCredential is the version-agnostic wire shape of an ExecCredential
+// holding only the status fields a credential plugin fills in.
+type execCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+type execCredentialStatus struct {
+	Token               string       `json:"token"`
+	ExpirationTimestamp *metav1.Time `json:"expirationTimestamp,omitempty"`
+}
+
+// APIVersion resolves an output version ("v1beta1" or the full
+// "client.authentication.k8s.io/v1beta1") to the apiVersion emitted.
+func APIVersion(output string) (string, error) {
+	for _, v := range supportedVersions {
+		if output == v || output == apiGroup+"/"+v {
+			return apiGroup + "/" + v, nil
+		}
+	}
+	return "", errors.Errorf("unsupported ExecCredential version %q, valid value is either v1alpha1, v1beta1, or v1", output)
+}
+
+// Print renders the token as an ExecCredential of the requested version. A
+// zero expiry omits the expirationTimestamp, making kubectl re-invoke the
+// plugin per request; otherwise kubectl caches the token until shortly
+// before it lapses.
+func Print(output, token string, expiry time.Time) (string, error) {
+	apiVersion, err := APIVersion(output)
+	if err != nil {
+		return "", err
+	}
+	cred := execCredential{
+		APIVersion: apiVersion,
+		Kind:       "ExecCredential",
+		Status: execCredentialStatus{
+			Token: token,
+		},
+	}
+	if !expiry.IsZero() {
+		expirationTimestamp := metav1.NewTime(expiry)
+		cred.Status.ExpirationTimestamp = &expirationTimestamp
+	}
+	ret, err := json.Marshal(cred)
+	return string(ret), err
+}
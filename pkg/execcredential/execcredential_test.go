@@ -0,0 +1,77 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package execcredential
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIVersion(t *testing.T) {
+	for _, v := range []string{"v1alpha1", "v1beta1", "v1"} {
+		apiVersion, err := APIVersion(v)
+		assert.Nil(t, err)
+		assert.Equal(t, "client.authentication.k8s.io/"+v, apiVersion)
+
+		// the full apiVersion is accepted as well
+		apiVersion, err = APIVersion("client.authentication.k8s.io/" + v)
+		assert.Nil(t, err)
+		assert.Equal(t, "client.authentication.k8s.io/"+v, apiVersion)
+	}
+
+	for _, v := range []string{"", "v2", "V1BETA1", "authentication.k8s.io/v1"} {
+		_, err := APIVersion(v)
+		assert.NotNil(t, err, "version %q should be rejected", v)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	expiry := time.Date(2021, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	out, err := Print("v1beta1", "secret-token", expiry)
+	assert.Nil(t, err)
+
+	var cred map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(out), &cred))
+	assert.Equal(t, "client.authentication.k8s.io/v1beta1", cred["apiVersion"])
+	assert.Equal(t, "ExecCredential", cred["kind"])
+
+	status := cred["status"].(map[string]interface{})
+	assert.Equal(t, "secret-token", status["token"])
+	assert.Equal(t, "2021-01-02T03:04:05Z", status["expirationTimestamp"])
+}
+
+func TestPrintWithoutExpiry(t *testing.T) {
+	out, err := Print("v1alpha1", "secret-token", time.Time{})
+	assert.Nil(t, err)
+
+	var cred map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(out), &cred))
+	assert.Equal(t, "client.authentication.k8s.io/v1alpha1", cred["apiVersion"])
+
+	status := cred["status"].(map[string]interface{})
+	_, found := status["expirationTimestamp"]
+	assert.False(t, found, "a zero expiry should omit expirationTimestamp")
+}
+
+func TestPrintUnsupportedVersion(t *testing.T) {
+	_, err := Print("v2", "secret-token", time.Time{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package k8sverbs maps Kubernetes request verbs to the provider-neutral
+// action names cloud authz providers check permissions against, so every
+// provider resolves verbs with identical semantics.
+package k8sverbs
+
+import (
+	"path"
+
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+// The coarse actions ordinary verbs collapse into.
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDelete = "delete"
+)
+
+// verbActions is the mapping table from Kubernetes request verbs to action
+// names. Ordinary verbs collapse into read/write/delete; the special verbs
+// keep their own "<verb>/action" name because granting the coarse action must
+// not imply them. The special verbs and their API group/resources are:
+//
+//	use:         policy/podsecuritypolicies
+//	bind:        rbac.authorization.k8s.io/roles,clusterroles
+//	escalate:    rbac.authorization.k8s.io/roles,clusterroles
+//	impersonate: core/users,groups,serviceaccounts
+//	impersonate: authentication.k8s.io/userextras
+//
+// https://kubernetes.io/docs/reference/access-authn-authz/authorization/#determine-the-request-verb
+var verbActions = map[string]string{
+	"get":   ActionRead,
+	"list":  ActionRead,
+	"watch": ActionRead,
+
+	"create": ActionWrite,
+	"update": ActionWrite,
+	"patch":  ActionWrite,
+
+	"delete":           ActionDelete,
+	"deletecollection": ActionDelete,
+
+	"bind":        "bind/action",
+	"escalate":    "escalate/action",
+	"use":         "use/action",
+	"impersonate": "impersonate/action",
+}
+
+// ActionName returns the action name of a Kubernetes request verb, or the
+// empty string for a verb outside the table.
+func ActionName(verb string) string {
+	return verbActions[verb]
+}
+
+// Mapper resolves verbs to action names with per-provider overrides layered
+// over the shared table. It is the extension point for providers whose
+// backend names an action differently; overrides cannot change the shared
+// table itself.
+type Mapper struct {
+	overrides map[string]string
+}
+
+// NewMapper returns a Mapper applying the given verb overrides before the
+// shared table. A nil map yields the shared semantics unchanged.
+func NewMapper(overrides map[string]string) Mapper {
+	return Mapper{overrides: overrides}
+}
+
+// ActionName returns the action name of a verb, preferring an override.
+func (m Mapper) ActionName(verb string) string {
+	if action, ok := m.overrides[verb]; ok {
+		return action
+	}
+	return ActionName(verb)
+}
+
+// ResourceActionID composes the action identifier of a resource request as
+// <prefix>/<apiGroup>/<resource>/<action>, omitting the empty core API group.
+func (m Mapper) ResourceActionID(prefix string, attr *authzv1beta1.ResourceAttributes) string {
+	id := prefix
+	if attr.Group != "" {
+		id = path.Join(id, attr.Group)
+	}
+	return path.Join(id, attr.Resource, m.ActionName(attr.Verb))
+}
+
+// NonResourceActionID composes the action identifier of a non-resource
+// request as <prefix>/<path>/<action>.
+func (m Mapper) NonResourceActionID(prefix string, attr *authzv1beta1.NonResourceAttributes) string {
+	return path.Join(prefix, attr.Path, m.ActionName(attr.Verb))
+}
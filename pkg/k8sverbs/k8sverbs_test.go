@@ -0,0 +1,91 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sverbs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+func TestActionName(t *testing.T) {
+	tests := []struct {
+		verb   string
+		action string
+	}{
+		{"get", ActionRead},
+		{"list", ActionRead},
+		{"watch", ActionRead},
+		{"create", ActionWrite},
+		{"update", ActionWrite},
+		{"patch", ActionWrite},
+		{"delete", ActionDelete},
+		{"deletecollection", ActionDelete},
+		{"bind", "bind/action"},
+		{"escalate", "escalate/action"},
+		{"use", "use/action"},
+		{"impersonate", "impersonate/action"},
+		{"proxy", ""},
+		{"", ""},
+		{"GET", ""}, // verbs are case sensitive
+	}
+	for _, tt := range tests {
+		t.Run(tt.verb, func(t *testing.T) {
+			assert.Equal(t, tt.action, ActionName(tt.verb))
+		})
+	}
+}
+
+func TestMapperOverrides(t *testing.T) {
+	m := NewMapper(map[string]string{
+		"get":   "fetch",
+		"proxy": "proxy/action",
+	})
+
+	assert.Equal(t, "fetch", m.ActionName("get"))
+	assert.Equal(t, "proxy/action", m.ActionName("proxy"))
+	// verbs outside the overrides fall through to the shared table
+	assert.Equal(t, ActionWrite, m.ActionName("create"))
+	assert.Equal(t, "", m.ActionName("unknown"))
+	// the shared table itself is untouched
+	assert.Equal(t, ActionRead, ActionName("get"))
+}
+
+func TestResourceActionID(t *testing.T) {
+	m := NewMapper(nil)
+	tests := []struct {
+		name string
+		attr authzv1beta1.ResourceAttributes
+		want string
+	}{
+		{"core group", authzv1beta1.ResourceAttributes{Resource: "pods", Verb: "get"}, "prefix/pods/read"},
+		{"named group", authzv1beta1.ResourceAttributes{Group: "apps", Resource: "deployments", Verb: "update"}, "prefix/apps/deployments/write"},
+		{"special verb", authzv1beta1.ResourceAttributes{Group: "rbac.authorization.k8s.io", Resource: "clusterroles", Verb: "bind"}, "prefix/rbac.authorization.k8s.io/clusterroles/bind/action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, m.ResourceActionID("prefix", &tt.attr))
+		})
+	}
+}
+
+func TestNonResourceActionID(t *testing.T) {
+	m := NewMapper(nil)
+	assert.Equal(t, "prefix/apis/read", m.NonResourceActionID("prefix", &authzv1beta1.NonResourceAttributes{Path: "/apis", Verb: "get"}))
+	assert.Equal(t, "prefix/healthz/read", m.NonResourceActionID("prefix", &authzv1beta1.NonResourceAttributes{Path: "/healthz", Verb: "list"}))
+}
@@ -0,0 +1,246 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+// correlationIDHeader carries the caller supplied correlation id. When absent
+// a random id is generated per request.
+const correlationIDHeader = "x-request-id"
+
+type AuditOptions struct {
+	// path of the audit log file. Empty disables audit logging, "-" logs to
+	// stdout
+	LogPath string
+	// url of a webhook sink that receives every audit event as JSON
+	WebhookURL string
+}
+
+func NewAuditOptions() AuditOptions {
+	return AuditOptions{}
+}
+
+func (o *AuditOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.LogPath, "audit-log-path", o.LogPath, "If set, all authn and authz decisions are logged to this file as JSON lines. Use - for stdout")
+	fs.StringVar(&o.WebhookURL, "audit-webhook-url", o.WebhookURL, "If set, all audit events are also posted to this webhook")
+}
+
+func (o *AuditOptions) Validate() []error {
+	var errs []error
+	if o.WebhookURL != "" {
+		if _, err := url.ParseRequestURI(o.WebhookURL); err != nil {
+			errs = append(errs, errors.Wrap(err, "invalid audit-webhook-url"))
+		}
+	}
+	return errs
+}
+
+func (o AuditOptions) Enabled() bool {
+	return o.LogPath != "" || o.WebhookURL != ""
+}
+
+// AuditEvent records a single authn or authz decision.
+type AuditEvent struct {
+	Timestamp     time.Time `json:"timestamp"`
+	CorrelationID string    `json:"correlationID"`
+	Kind          string    `json:"kind"`
+	Provider      string    `json:"provider,omitempty"`
+	User          string    `json:"user,omitempty"`
+	Groups        []string  `json:"groups,omitempty"`
+	Verb          string    `json:"verb,omitempty"`
+	Namespace     string    `json:"namespace,omitempty"`
+	Resource      string    `json:"resource,omitempty"`
+	Subresource   string    `json:"subresource,omitempty"`
+	Name          string    `json:"name,omitempty"`
+	Path          string    `json:"path,omitempty"`
+	Decision      string    `json:"decision"`
+	Reason        string    `json:"reason,omitempty"`
+	LatencyMilli  int64     `json:"latencyMilli"`
+	// PrevHash is the SHA-256 of the previous event line, so truncation or
+	// edits of the trail are detectable
+	PrevHash string `json:"prevHash"`
+}
+
+// AuditLogger writes audit events as JSON lines to the configured sinks.
+// Every event embeds the hash of the previous event, making the trail
+// tamper-evident. Audit failures are logged and never fail the request.
+type AuditLogger struct {
+	lock     sync.Mutex
+	out      io.Writer
+	prevHash string
+
+	webhookURL string
+	client     *http.Client
+}
+
+// NewAuditLogger returns a logger for the given options, or nil when audit
+// logging is disabled.
+func NewAuditLogger(opts AuditOptions) (*AuditLogger, error) {
+	if !opts.Enabled() {
+		return nil, nil
+	}
+
+	l := &AuditLogger{
+		webhookURL: opts.WebhookURL,
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}
+	switch opts.LogPath {
+	case "":
+	case "-":
+		l.out = os.Stdout
+	default:
+		f, err := os.OpenFile(opts.LogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to open audit log file")
+		}
+		l.out = f
+	}
+	return l, nil
+}
+
+func (l *AuditLogger) log(event AuditEvent) {
+	event.Timestamp = time.Now().UTC()
+
+	l.lock.Lock()
+	event.PrevHash = l.prevHash
+	line, err := json.Marshal(event)
+	if err != nil {
+		l.lock.Unlock()
+		glog.Errorf("failed to marshal audit event: %s", err)
+		return
+	}
+	hash := sha256.Sum256(line)
+	l.prevHash = hex.EncodeToString(hash[:])
+	if l.out != nil {
+		if _, err := l.out.Write(append(line, '\n')); err != nil {
+			glog.Errorf("failed to write audit event: %s", err)
+		}
+	}
+	l.lock.Unlock()
+
+	if l.webhookURL != "" {
+		go l.post(line)
+	}
+}
+
+func (l *AuditLogger) post(line []byte) {
+	resp, err := l.client.Post(l.webhookURL, "application/json", bytes.NewReader(line))
+	if err != nil {
+		glog.Errorf("failed to post audit event: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		glog.Errorf("audit webhook returned status code %d", resp.StatusCode)
+	}
+}
+
+// LogTokenReview records the outcome of a TokenReview request.
+func (l *AuditLogger) LogTokenReview(req *http.Request, provider string, info *authv1.UserInfo, err error, latency time.Duration) {
+	if l == nil {
+		return
+	}
+	event := AuditEvent{
+		CorrelationID: correlationID(req),
+		Kind:          "tokenreview",
+		Provider:      provider,
+		LatencyMilli:  latency.Nanoseconds() / int64(time.Millisecond),
+	}
+	if err != nil {
+		event.Decision = "denied"
+		event.Reason = err.Error()
+	} else {
+		event.Decision = "authenticated"
+		if info != nil {
+			event.User = info.Username
+			event.Groups = info.Groups
+		}
+	}
+	l.log(event)
+}
+
+// LogSubjectAccessReview records the outcome of a SubjectAccessReview request.
+func (l *AuditLogger) LogSubjectAccessReview(req *http.Request, provider string, spec *authzv1beta1.SubjectAccessReviewSpec, status *authzv1beta1.SubjectAccessReviewStatus, err error, latency time.Duration) {
+	if l == nil {
+		return
+	}
+	event := AuditEvent{
+		CorrelationID: correlationID(req),
+		Kind:          "subjectaccessreview",
+		Provider:      provider,
+		LatencyMilli:  latency.Nanoseconds() / int64(time.Millisecond),
+	}
+	if spec != nil {
+		event.User = spec.User
+		event.Groups = spec.Groups
+		if spec.ResourceAttributes != nil {
+			event.Verb = spec.ResourceAttributes.Verb
+			event.Namespace = spec.ResourceAttributes.Namespace
+			event.Resource = spec.ResourceAttributes.Resource
+			event.Subresource = spec.ResourceAttributes.Subresource
+			event.Name = spec.ResourceAttributes.Name
+		} else if spec.NonResourceAttributes != nil {
+			event.Verb = spec.NonResourceAttributes.Verb
+			event.Path = spec.NonResourceAttributes.Path
+		}
+	}
+	switch {
+	case err != nil:
+		event.Decision = "error"
+		event.Reason = err.Error()
+	case status == nil:
+		event.Decision = "denied"
+	case status.Allowed:
+		event.Decision = "allowed"
+		event.Reason = status.Reason
+	case status.Denied:
+		event.Decision = "denied"
+		event.Reason = status.Reason
+	default:
+		event.Decision = "no-opinion"
+		event.Reason = status.Reason
+	}
+	l.log(event)
+}
+
+func correlationID(req *http.Request) string {
+	if id := req.Header.Get(correlationIDHeader); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// AuthnCacheOptions configures the token review result cache. The cache is
+// opt-in: with a zero TTL every review hits the upstream provider, which is
+// the historical behavior.
+type AuthnCacheOptions struct {
+	// TTL is how long a successful review is served from cache; 0 disables
+	// the cache
+	TTL time.Duration
+	// MaxEntries caps the number of cached results
+	MaxEntries int
+}
+
+func NewAuthnCacheOptions() AuthnCacheOptions {
+	return AuthnCacheOptions{
+		MaxEntries: 4096,
+	}
+}
+
+func (o *AuthnCacheOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(&o.TTL, "authn-cache-ttl", o.TTL, "how long a successful token review result is served from cache, cutting upstream provider calls. 0 disables the cache. Revoked tokens keep authenticating for up to this long")
+	fs.IntVar(&o.MaxEntries, "authn-cache-max-entries", o.MaxEntries, "maximum number of token review results kept in the cache")
+}
+
+func (o *AuthnCacheOptions) Validate() []error {
+	var errs []error
+	if o.TTL < 0 {
+		errs = append(errs, errors.New("authn-cache-ttl must be non-negative"))
+	}
+	if o.MaxEntries <= 0 {
+		errs = append(errs, errors.New("authn-cache-max-entries must be positive"))
+	}
+	return errs
+}
+
+type authnCacheEntry struct {
+	info    *authv1.UserInfo
+	expires time.Time
+}
+
+// authnCache caches successful token reviews keyed by a hash of the provider
+// and the token, so repeated reviews of the same token skip the upstream
+// lookup. Only successes are cached: a failure must stay retriable, and a
+// rejected token is cheap for the provider to reject again. The token itself
+// is never stored.
+type authnCache struct {
+	lock       sync.Mutex
+	entries    map[string]authnCacheEntry
+	ttl        time.Duration
+	maxEntries int
+}
+
+func newAuthnCache(o AuthnCacheOptions) *authnCache {
+	return &authnCache{
+		entries:    map[string]authnCacheEntry{},
+		ttl:        o.TTL,
+		maxEntries: o.MaxEntries,
+	}
+}
+
+// cacheKey hashes the provider and the token. The provider is included so a
+// token accepted by one provider is never replayed against another.
+func (c *authnCache) cacheKey(provider, token string) string {
+	sum := sha256.Sum256([]byte(provider + "/" + token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns a copy of the cached review result for the token, if present
+// and not expired.
+func (c *authnCache) Get(provider, token string) (*authv1.UserInfo, bool) {
+	if c == nil || c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := c.cacheKey(provider, token)
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		authnCacheRequests.WithLabelValues(provider, "miss").Inc()
+		return nil, false
+	}
+	authnCacheRequests.WithLabelValues(provider, "hit").Inc()
+	return entry.info.DeepCopy(), true
+}
+
+// Set records a successful review result for the token.
+func (c *authnCache) Set(provider, token string, info *authv1.UserInfo) {
+	if c == nil || c.ttl <= 0 || info == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.entries) >= c.maxEntries {
+		c.evictLocked()
+	}
+	c.entries[c.cacheKey(provider, token)] = authnCacheEntry{
+		info:    info.DeepCopy(),
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+// evictLocked makes room for one entry: expired entries go first, and when
+// none have expired the entry closest to expiry is dropped.
+func (c *authnCache) evictLocked() {
+	now := time.Now()
+	oldestKey := ""
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.expires.Before(oldest) {
+			oldestKey = key
+			oldest = entry.expires
+		}
+	}
+	if len(c.entries) >= c.maxEntries && oldestKey != "" {
+		delete(c.entries, oldestKey)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+func TestAuthnCacheRoundTrip(t *testing.T) {
+	c := newAuthnCache(AuthnCacheOptions{TTL: time.Minute, MaxEntries: 8})
+	info := &authv1.UserInfo{Username: "jane", Groups: []string{"dev"}}
+
+	c.Set("github", "secret-token", info)
+
+	got, found := c.Get("github", "secret-token")
+	assert.True(t, found)
+	assert.Equal(t, info, got)
+
+	// the cached copy is isolated from the caller
+	got.Groups[0] = "mutated"
+	got, found = c.Get("github", "secret-token")
+	assert.True(t, found)
+	assert.Equal(t, []string{"dev"}, got.Groups)
+
+	// the provider is part of the key
+	_, found = c.Get("gitlab", "secret-token")
+	assert.False(t, found)
+}
+
+func TestAuthnCacheDisabledByDefault(t *testing.T) {
+	c := newAuthnCache(NewAuthnCacheOptions())
+	c.Set("github", "secret-token", &authv1.UserInfo{Username: "jane"})
+
+	_, found := c.Get("github", "secret-token")
+	assert.False(t, found)
+}
+
+func TestAuthnCacheNilSafe(t *testing.T) {
+	var c *authnCache
+	c.Set("github", "secret-token", &authv1.UserInfo{Username: "jane"})
+
+	_, found := c.Get("github", "secret-token")
+	assert.False(t, found)
+}
+
+func TestAuthnCacheExpiry(t *testing.T) {
+	c := newAuthnCache(AuthnCacheOptions{TTL: time.Minute, MaxEntries: 8})
+	c.Set("github", "secret-token", &authv1.UserInfo{Username: "jane"})
+
+	key := c.cacheKey("github", "secret-token")
+	entry := c.entries[key]
+	entry.expires = time.Now().Add(-time.Second)
+	c.entries[key] = entry
+
+	_, found := c.Get("github", "secret-token")
+	assert.False(t, found)
+	assert.Empty(t, c.entries, "expired entry should be removed")
+}
+
+func TestAuthnCacheEviction(t *testing.T) {
+	c := newAuthnCache(AuthnCacheOptions{TTL: time.Minute, MaxEntries: 2})
+	c.Set("github", "token-1", &authv1.UserInfo{Username: "u1"})
+	c.Set("github", "token-2", &authv1.UserInfo{Username: "u2"})
+
+	// token-1 is closest to expiry, so it makes room for token-3
+	key := c.cacheKey("github", "token-1")
+	entry := c.entries[key]
+	entry.expires = entry.expires.Add(-time.Second)
+	c.entries[key] = entry
+
+	c.Set("github", "token-3", &authv1.UserInfo{Username: "u3"})
+	assert.Len(t, c.entries, 2)
+
+	_, found := c.Get("github", "token-1")
+	assert.False(t, found)
+	_, found = c.Get("github", "token-2")
+	assert.True(t, found)
+	_, found = c.Get("github", "token-3")
+	assert.True(t, found)
+}
+
+func TestAuthnCacheOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts AuthnCacheOptions
+		errs int
+	}{
+		{"defaults", NewAuthnCacheOptions(), 0},
+		{"enabled", AuthnCacheOptions{TTL: time.Minute, MaxEntries: 10}, 0},
+		{"negative ttl", AuthnCacheOptions{TTL: -time.Second, MaxEntries: 10}, 1},
+		{"zero max entries", AuthnCacheOptions{TTL: time.Minute}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Len(t, test.opts.Validate(), test.errs)
+		})
+	}
+}
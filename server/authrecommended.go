@@ -18,57 +18,160 @@ package server
 
 import (
 	"github.com/appscode/guard/auth/providers"
+	"github.com/appscode/guard/auth/providers/aws"
 	"github.com/appscode/guard/auth/providers/azure"
 	"github.com/appscode/guard/auth/providers/github"
 	"github.com/appscode/guard/auth/providers/gitlab"
 	"github.com/appscode/guard/auth/providers/google"
+	"github.com/appscode/guard/auth/providers/keycloak"
 	"github.com/appscode/guard/auth/providers/ldap"
+	"github.com/appscode/guard/auth/providers/saml"
 	"github.com/appscode/guard/auth/providers/token"
+	"github.com/appscode/guard/util/featuregates"
+	"github.com/appscode/guard/util/flagutil"
+	"github.com/appscode/guard/util/httpclient"
+	"github.com/appscode/guard/util/logs"
+	"github.com/appscode/guard/util/tracing"
 
+	"github.com/golang/glog"
 	"github.com/spf13/pflag"
 )
 
 type AuthRecommendedOptions struct {
 	SecureServing SecureServingOptions
 	NTP           NTPOptions
-	Github        github.Options
-	Gitlab        gitlab.Options
-	Token         token.Options
-	Google        google.Options
-	Azure         azure.Options
-	LDAP          ldap.Options
-	AuthProvider  providers.AuthProviders
+	Audit         AuditOptions
+	Breaker       BreakerOptions
+	// Limits caps the size of the identity returned in token reviews
+	Limits LimitOptions
+	// AuthnCache caches successful token reviews to cut upstream calls
+	AuthnCache AuthnCacheOptions
+	// RateLimit sheds excess request load per client and globally
+	RateLimit RateLimitOptions
+	// Timeout bounds the handling of a single review request
+	Timeout TimeoutOptions
+	// FeatureGates toggles alpha/beta features
+	FeatureGates featuregates.Options
+	// Logging selects the log entry format
+	Logging logs.Options
+	// Tracing configures span export to an OpenTelemetry collector
+	Tracing tracing.Options
+	// Profiling serves pprof data for a continuous profiler agent
+	Profiling ProfilingOptions
+	// Proxy is the global outbound proxy configuration, used by every
+	// provider that has no per-provider proxy flags set.
+	Proxy httpclient.ProxyConfig
+	// CABundle is the global CA bundle trusted on outbound connections,
+	// used by every provider that has no per-provider bundle set.
+	CABundle httpclient.CABundleConfig
+	// UserAgent controls the User-Agent header sent on outbound provider
+	// calls, so upstream providers can attribute guard's traffic.
+	UserAgent httpclient.UserAgentConfig
+	// PopulateUID makes providers return the stable directory identifier
+	// of the user (AAD oid, LDAP entryUUID, Google subject) in user.uid.
+	PopulateUID  bool
+	Github       github.Options
+	Gitlab       gitlab.Options
+	Token        token.Options
+	Google       google.Options
+	Azure        azure.Options
+	Keycloak     keycloak.Options
+	LDAP         ldap.Options
+	SAML         saml.Options
+	AWS          aws.Options
+	AuthProvider providers.AuthProviders
 }
 
 func NewAuthRecommendedOptions() *AuthRecommendedOptions {
 	return &AuthRecommendedOptions{
 		SecureServing: NewSecureServingOptions(),
 		NTP:           NewNTPOptions(),
+		Audit:         NewAuditOptions(),
+		Breaker:       NewBreakerOptions(),
+		Limits:        NewLimitOptions(),
+		AuthnCache:    NewAuthnCacheOptions(),
+		RateLimit:     NewRateLimitOptions(),
+		Timeout:       NewTimeoutOptions(),
+		FeatureGates:  featuregates.NewOptions(),
+		Logging:       logs.NewOptions(),
+		Tracing:       tracing.NewOptions(),
+		Profiling:     NewProfilingOptions(),
 		Github:        github.NewOptions(),
 		Gitlab:        gitlab.NewOptions(),
 		Azure:         azure.NewOptions(),
 		Token:         token.NewOptions(),
 		Google:        google.NewOptions(),
+		Keycloak:      keycloak.NewOptions(),
 		LDAP:          ldap.NewOptions(),
+		SAML:          saml.NewOptions(),
+		AWS:           aws.NewOptions(),
 	}
 }
 
 func (o *AuthRecommendedOptions) AddFlags(fs *pflag.FlagSet) {
-	o.SecureServing.AddFlags(fs)
-	o.NTP.AddFlags(fs)
-	o.AuthProvider.AddFlags(fs)
-	o.Github.AddFlags(fs)
-	o.Gitlab.AddFlags(fs)
-	o.Token.AddFlags(fs)
-	o.Google.AddFlags(fs)
-	o.Azure.AddFlags(fs)
-	o.LDAP.AddFlags(fs)
+	groups := flagutil.NewGroups()
+	o.SecureServing.AddFlags(groups.Group("serving"))
+	o.NTP.AddFlags(groups.Group("ntp"))
+	o.Audit.AddFlags(groups.Group("audit"))
+	o.Breaker.AddFlags(groups.Group("breaker"))
+	o.Limits.AddFlags(groups.Group("response limits"))
+	o.AuthnCache.AddFlags(groups.Group("authn cache"))
+	o.RateLimit.AddFlags(groups.Group("rate limit"))
+	o.Timeout.AddFlags(groups.Group("timeouts"))
+	o.FeatureGates.AddFlags(groups.Group("feature gates"))
+	o.Logging.AddFlags(groups.Group("logging"))
+	o.Tracing.AddFlags(groups.Group("tracing"))
+	o.Profiling.AddFlags(groups.Group("profiling"))
+	outbound := groups.Group("outbound connection")
+	o.Proxy.AddFlags(outbound)
+	o.CABundle.AddFlags(outbound)
+	o.UserAgent.AddFlags(outbound)
+	authGroup := groups.Group("auth")
+	o.AuthProvider.AddFlags(authGroup)
+	authGroup.BoolVar(&o.PopulateUID, "populate-user-uid", o.PopulateUID, "If true, set user.uid in token review responses to the provider's stable user identifier (AAD oid, LDAP entryUUID, Google subject)")
+	o.Github.AddFlags(groups.Group("github auth"))
+	o.Gitlab.AddFlags(groups.Group("gitlab auth"))
+	o.Token.AddFlags(groups.Group("token auth"))
+	o.Google.AddFlags(groups.Group("google auth"))
+	o.Azure.AddFlags(groups.Group("azure auth"))
+	o.Keycloak.AddFlags(groups.Group("keycloak auth"))
+	o.LDAP.AddFlags(groups.Group("ldap auth"))
+	o.SAML.AddFlags(groups.Group("saml auth"))
+	o.AWS.AddFlags(groups.Group("aws auth"))
+	// a clash is a programming error in a provider; fail loudly at startup
+	// with the owning groups named instead of panicking inside pflag
+	if errs := groups.ApplyTo(fs); errs != nil {
+		glog.Fatal(errs)
+	}
 }
 
 func (o *AuthRecommendedOptions) Validate() []error {
 	var errs []error
 	errs = append(errs, o.SecureServing.Validate()...)
 	errs = append(errs, o.NTP.Validate()...)
+	errs = append(errs, o.Audit.Validate()...)
+	errs = append(errs, o.Breaker.Validate()...)
+	errs = append(errs, o.Limits.Validate()...)
+	errs = append(errs, o.AuthnCache.Validate()...)
+	errs = append(errs, o.RateLimit.Validate()...)
+	errs = append(errs, o.Timeout.Validate()...)
+	errs = append(errs, o.FeatureGates.Validate()...)
+	errs = append(errs, o.Logging.Validate()...)
+	errs = append(errs, o.Tracing.Validate()...)
+	errs = append(errs, o.Profiling.Validate()...)
+	errs = append(errs, o.Proxy.Validate("")...)
+	errs = append(errs, o.CABundle.Validate("")...)
+	errs = append(errs, o.ValidateProviders()...)
+
+	return errs
+}
+
+// ValidateProviders validates the provider selection and the options of each
+// enabled provider, without the serving related checks. Tenant configuration
+// blocks only override provider options, so this is the part a tenant is
+// validated against.
+func (o *AuthRecommendedOptions) ValidateProviders() []error {
+	var errs []error
 	errs = append(errs, o.AuthProvider.Validate()...)
 
 	if o.AuthProvider.Has(github.OrgType) {
@@ -86,9 +189,18 @@ func (o *AuthRecommendedOptions) Validate() []error {
 	if o.AuthProvider.Has(azure.OrgType) {
 		errs = append(errs, o.Azure.Validate()...)
 	}
+	if o.AuthProvider.Has(keycloak.OrgType) {
+		errs = append(errs, o.Keycloak.Validate()...)
+	}
 	if o.AuthProvider.Has(ldap.OrgType) {
 		errs = append(errs, o.LDAP.Validate()...)
 	}
+	if o.AuthProvider.Has(saml.OrgType) {
+		errs = append(errs, o.SAML.Validate()...)
+	}
+	if o.AuthProvider.Has(aws.OrgType) {
+		errs = append(errs, o.AWS.Validate()...)
+	}
 
 	return errs
 }
@@ -16,64 +16,218 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"net/http"
+	"runtime/pprof"
 	"strings"
+	"time"
 
 	"github.com/appscode/guard/authz"
+	"github.com/appscode/guard/authz/mutator"
 	"github.com/appscode/guard/authz/providers/azure"
+	"github.com/appscode/guard/authz/providers/casbin"
+	"github.com/appscode/guard/authz/providers/policyfile"
+	"github.com/appscode/guard/authz/providers/webhook"
+	"github.com/appscode/guard/util/logs"
 
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
 	authzv1beta1 "k8s.io/api/authorization/v1beta1"
 )
 
+// clusterNameHeader selects the cluster resource for a request when guard
+// runs centrally for a fleet of clusters.
+const clusterNameHeader = "x-guard-cluster-name"
+
+// cacheBypassHeader forces a fresh upstream check for a single request, so
+// admins can tell a stale cached verdict from a real role assignment gap. It
+// is honored only for clients allowlisted with
+// --authz-cache-bypass-allowed-clients.
+const cacheBypassHeader = "x-guard-cache-bypass"
+
 type Authzhandler struct {
 	AuthRecommendedOptions  *AuthRecommendedOptions
 	AuthzRecommendedOptions *AuthzRecommendedOptions
 	Store                   authz.Store
+	AuditLogger             *AuditLogger
+	// Mutator post-processes decisions before they are written; nil means no
+	// mutation is configured
+	Mutator mutator.Interface
+	// RateLimiter sheds excess load; it is shared with the tokenreviews
+	// handler, so the limits cover both endpoints
+	RateLimiter *rateLimiter
+	// Shadow re-evaluates each review against a secondary configuration and
+	// logs divergences; nil means shadow mode is off
+	Shadow *ShadowEvaluator
+	// Tenants routes requests to per-tenant provider configurations by the
+	// client certificate common name; nil serves every client from the
+	// global options
+	Tenants *TenantRouter
 }
 
 func (s *Authzhandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	ctx, reqID := correlate(w, req)
+	ctx, span := trace.StartSpan(ctx, "guard.subjectaccessreview")
+	defer span.End()
+
 	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
-		writeAuthzResponse(w, nil, nil, WithCode(errors.New("Missing client certificate"), http.StatusBadRequest))
+		s.writeWithAudit(w, req, "unknown", "unknown", start, nil, nil, WithCode(errors.New("Missing client certificate"), http.StatusBadRequest))
 		return
 	}
 	crt := req.TLS.PeerCertificates[0]
 	if len(crt.Subject.Organization) == 0 {
-		writeAuthzResponse(w, nil, nil, WithCode(errors.New("Client certificate is missing organization"), http.StatusBadRequest))
+		s.writeWithAudit(w, req, "unknown", crt.Subject.CommonName, start, nil, nil, WithCode(errors.New("Client certificate is missing organization"), http.StatusBadRequest))
 		return
 	}
 	org := crt.Subject.Organization[0]
-	glog.Infof("Received subject access review request for %s/%s", org, crt.Subject.CommonName)
+	span.AddAttributes(
+		trace.StringAttribute("provider", strings.ToLower(org)),
+		trace.StringAttribute("client", crt.Subject.CommonName),
+	)
+	logs.Info("received subject access review request", logs.Fields{
+		"request_id": reqID,
+		"provider":   org,
+		"client":     crt.Subject.CommonName,
+	})
+
+	// shed excess load before any parsing or provider work
+	if !s.RateLimiter.Allow(crt.Subject.CommonName) {
+		rateLimitedTotal.WithLabelValues("subjectaccessreviews", crt.Subject.CommonName).Inc()
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, start, nil, nil, WithCode(errors.New("request rate limit exceeded"), http.StatusTooManyRequests))
+		return
+	}
 
 	data := authzv1beta1.SubjectAccessReview{}
 	err := json.NewDecoder(req.Body).Decode(&data)
 	if err != nil {
-		writeAuthzResponse(w, nil, nil, WithCode(errors.Wrap(err, "Failed to parse request"), http.StatusBadRequest))
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, start, nil, nil, WithCode(errors.Wrap(err, "Failed to parse request"), http.StatusBadRequest))
 		return
 	}
 
 	glog.V(10).Infof("Authz req:%+v\n", data)
 
-	if !s.AuthzRecommendedOptions.AuthzProvider.Has(org) {
-		writeAuthzResponse(w, &data.Spec, nil, WithCode(errors.Errorf("guard does not provide service for %v", org), http.StatusBadRequest))
+	// a tenant block for the client selects its own provider configuration,
+	// clients and verdict cache
+	tenant := s.Tenants.Lookup(crt.Subject.CommonName)
+	authzOpts := s.AuthzRecommendedOptions
+	if tenant != nil {
+		authzOpts = tenant.AuthzOptions
+	}
+
+	if !authzOpts.AuthzProvider.Has(org) {
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, start, &data.Spec, nil, WithCode(errors.Errorf("guard does not provide service for %v", org), http.StatusBadRequest))
 		return
 	}
 
-	client, err := s.getAuthzProviderClient(org)
+	var client authz.Interface
+	store := s.Store
+	if tenant != nil {
+		client, err = tenant.authzClient(org)
+		store = tenant.store
+	} else {
+		client, err = s.getAuthzProviderClient(org)
+	}
 	if client == nil || err != nil {
-		writeAuthzResponse(w, &data.Spec, nil, err)
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, start, &data.Spec, nil, err)
 		return
 	}
 
-	resp, err := client.Check(&data.Spec, s.Store)
-	writeAuthzResponse(w, &data.Spec, resp, err)
+	reqInfo := authz.RequestInfo{
+		CommonName:    crt.Subject.CommonName,
+		ClusterHeader: req.Header.Get(clusterNameHeader),
+	}
+	if req.Header.Get(cacheBypassHeader) != "" {
+		if cacheBypassAllowed(s.AuthzRecommendedOptions.CacheBypassAllowedClients, crt.Subject.CommonName) {
+			reqInfo.CacheBypass = true
+			logs.Info("honoring cache bypass header", logs.Fields{
+				"request_id": reqID,
+				"client":     crt.Subject.CommonName,
+			})
+		} else {
+			glog.Warningf("ignoring %s header from client %q: not in authz-cache-bypass-allowed-clients", cacheBypassHeader, crt.Subject.CommonName)
+		}
+	}
+
+	checkCtx, checkSpan := trace.StartSpan(ctx, "guard.provider.check")
+	// a per-provider timeout tightens the deadline below the handler timeout,
+	// cancelling the upstream call instead of just abandoning it
+	checkCtx, cancelCheck := withProviderDeadline(checkCtx, s.AuthRecommendedOptions.Timeout, org)
+	defer cancelCheck()
+	var resp *authzv1beta1.SubjectAccessReviewStatus
+	// pprof labels tag the CPU samples of the provider work, so a continuous
+	// profiler can break guard's cost down per handler and provider
+	pprof.Do(checkCtx, pprof.Labels("handler", "subjectaccessreviews", "provider", strings.ToLower(org)), func(ctx context.Context) {
+		resp, err = client.Check(ctx, &data.Spec, store, reqInfo)
+	})
+	checkSpan.End()
+	if err != nil && resp == nil && authzOpts.ProviderErrorPolicy == ProviderErrorPolicyNoOpinion {
+		// surface the provider failure in evaluationError with no opinion, so
+		// the API server falls through to the next authorizer instead of
+		// hard-failing the request; the error still lands in the audit trail
+		resp = &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, EvaluationError: err.Error()}
+	}
+	// mutators run last, so they see the decision exactly as it would be
+	// returned, including the provider error policy above
+	if s.Mutator != nil && resp != nil {
+		s.Mutator.Mutate(&data.Spec, resp)
+	}
+	// the shadow comparison runs off the request path, so it can never delay
+	// or change the response
+	if s.Shadow != nil {
+		go s.Shadow.Compare(org, &data.Spec, reqInfo, resp, err)
+	}
+	s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, start, &data.Spec, resp, err)
+}
+
+// writeWithAudit records the decision in the audit trail and the per-client
+// decision metric before the response is written.
+func (s *Authzhandler) writeWithAudit(w http.ResponseWriter, req *http.Request, provider, client string, start time.Time, spec *authzv1beta1.SubjectAccessReviewSpec, status *authzv1beta1.SubjectAccessReviewStatus, err error) {
+	decisionCounterAuthz.WithLabelValues(client, authzDecision(status, err)).Inc()
+	s.AuditLogger.LogSubjectAccessReview(req, provider, spec, status, err, time.Since(start))
+	writeAuthzResponse(w, spec, status, err)
+}
+
+// cacheBypassAllowed reports whether the client CN may force a cache bypass.
+func cacheBypassAllowed(allowed []string, commonName string) bool {
+	for _, cn := range allowed {
+		if strings.EqualFold(cn, commonName) {
+			return true
+		}
+	}
+	return false
+}
+
+// authzDecision labels the outcome of a subject access review.
+func authzDecision(status *authzv1beta1.SubjectAccessReviewStatus, err error) string {
+	switch {
+	case err != nil:
+		return "error"
+	case status == nil:
+		return "denied"
+	case status.Allowed:
+		return "allowed"
+	case status.Denied:
+		return "denied"
+	default:
+		return "no-opinion"
+	}
 }
 
 func (s *Authzhandler) getAuthzProviderClient(org string) (authz.Interface, error) {
+	// a config reload must never hand out a half-configured options struct
+	providerConfigMu.RLock()
+	defer providerConfigMu.RUnlock()
 	switch strings.ToLower(org) {
 	case azure.OrgType:
 		return azure.New(s.AuthzRecommendedOptions.Azure, s.AuthRecommendedOptions.Azure)
+	case webhook.OrgType:
+		return webhook.New(s.AuthzRecommendedOptions.Webhook)
+	case policyfile.OrgType:
+		return policyfile.New(s.AuthzRecommendedOptions.PolicyFile)
+	case casbin.OrgType:
+		return casbin.New(s.AuthzRecommendedOptions.Casbin)
 	}
 
 	return nil, errors.Errorf("Client is using unknown organization %s", org)
@@ -16,27 +16,80 @@ limitations under the License.
 package server
 
 import (
+	"github.com/appscode/guard/authz/mutator"
 	authz "github.com/appscode/guard/authz/providers"
 	"github.com/appscode/guard/authz/providers/azure"
+	"github.com/appscode/guard/authz/providers/azure/data"
 	"github.com/appscode/guard/authz/providers/azure/options"
+	"github.com/appscode/guard/authz/providers/casbin"
+	"github.com/appscode/guard/authz/providers/policyfile"
+	"github.com/appscode/guard/authz/providers/webhook"
+	"github.com/appscode/guard/util/flagutil"
 
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 )
 
+const (
+	InMemoryCacheStore = "in-memory"
+	RedisCacheStore    = "redis"
+
+	ProviderErrorPolicyDeny      = "deny"
+	ProviderErrorPolicyNoOpinion = "no-opinion"
+)
+
 type AuthzRecommendedOptions struct {
 	Azure         options.Options
+	Webhook       webhook.Options
+	PolicyFile    policyfile.Options
+	Casbin        casbin.Options
 	AuthzProvider authz.AuthzProviders
+	CacheStore    string
+	Redis         data.RedisOptions
+	// Mutator holds the declarative post-processing rules applied to authz
+	// decisions before they are returned
+	Mutator mutator.Options
+	// ProviderErrorPolicy controls the verdict when the authz provider call
+	// itself fails (throttling, expired token): deny the request or return no
+	// opinion with EvaluationError populated
+	ProviderErrorPolicy string
+	// CacheBypassAllowedClients lists client certificate CNs that may send
+	// the cache bypass header; empty disables the header entirely
+	CacheBypassAllowedClients []string
 }
 
 func NewAuthzRecommendedOptions() *AuthzRecommendedOptions {
 	return &AuthzRecommendedOptions{
-		Azure: options.NewOptions(),
+		Azure:               options.NewOptions(),
+		Webhook:             webhook.NewOptions(),
+		PolicyFile:          policyfile.NewOptions(),
+		Casbin:              casbin.NewOptions(),
+		CacheStore:          InMemoryCacheStore,
+		Redis:               data.NewRedisOptions(),
+		Mutator:             mutator.NewOptions(),
+		ProviderErrorPolicy: ProviderErrorPolicyDeny,
 	}
 }
 
 func (o *AuthzRecommendedOptions) AddFlags(fs *pflag.FlagSet) {
-	o.Azure.AddFlags(fs)
-	o.AuthzProvider.AddFlags(fs)
+	groups := flagutil.NewGroups()
+	o.Azure.AddFlags(groups.Group("azure authz"))
+	o.Webhook.AddFlags(groups.Group("webhook authz"))
+	o.PolicyFile.AddFlags(groups.Group("policyfile authz"))
+	o.Casbin.AddFlags(groups.Group("casbin authz"))
+	authzGroup := groups.Group("authz")
+	o.AuthzProvider.AddFlags(authzGroup)
+	authzGroup.StringVar(&o.CacheStore, "authz-cache-store", o.CacheStore, "store used to cache authz verdicts, valid value is either in-memory or redis. A redis store lets multiple guard replicas share decisions")
+	o.Redis.AddFlags(authzGroup)
+	o.Mutator.AddFlags(authzGroup)
+	authzGroup.StringVar(&o.ProviderErrorPolicy, "authz-provider-error-policy", o.ProviderErrorPolicy, "verdict returned when the authz provider call fails, valid value is either deny or no-opinion. With no-opinion the error is reported in evaluationError so the API server can fall through to the next authorizer")
+	authzGroup.StringSliceVar(&o.CacheBypassAllowedClients, "authz-cache-bypass-allowed-clients", o.CacheBypassAllowedClients, "client certificate common names allowed to send the "+cacheBypassHeader+" header, which forces a fresh upstream check for a single request. Empty disables the header")
+	// a clash is a programming error in a provider; fail loudly at startup
+	// with the owning groups named instead of panicking inside pflag
+	if errs := groups.ApplyTo(fs); errs != nil {
+		glog.Fatal(errs)
+	}
 }
 
 func (o *AuthzRecommendedOptions) Validate(opts *AuthRecommendedOptions) []error {
@@ -49,5 +102,34 @@ func (o *AuthzRecommendedOptions) Validate(opts *AuthRecommendedOptions) []error
 		errs = append(errs, o.Azure.Validate(opts.Azure)...)
 	}
 
+	if o.AuthzProvider.Has(webhook.OrgType) {
+		errs = append(errs, o.Webhook.Validate()...)
+	}
+
+	if o.AuthzProvider.Has(policyfile.OrgType) {
+		errs = append(errs, o.PolicyFile.Validate()...)
+	}
+
+	if o.AuthzProvider.Has(casbin.OrgType) {
+		errs = append(errs, o.Casbin.Validate()...)
+	}
+
+	switch o.CacheStore {
+	case InMemoryCacheStore:
+	case RedisCacheStore:
+		errs = append(errs, o.Redis.Validate()...)
+	default:
+		errs = append(errs, errors.New("invalid authz-cache-store. valid value is either in-memory or redis"))
+	}
+
+	errs = append(errs, o.Mutator.Validate()...)
+
+	switch o.ProviderErrorPolicy {
+	case ProviderErrorPolicyDeny:
+	case ProviderErrorPolicyNoOpinion:
+	default:
+		errs = append(errs, errors.New("invalid authz-provider-error-policy. valid value is either deny or no-opinion"))
+	}
+
 	return errs
 }
@@ -0,0 +1,168 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+const (
+	defaultBreakerMinRequests = 10
+	defaultBreakerWindow      = time.Minute
+	defaultBreakerCooldown    = time.Minute
+)
+
+// BreakerOptions configures automatic disablement of failing auth providers.
+// When a provider's failure ratio stays above the threshold, guard answers
+// its tokenreviews with an explicit error instead of waiting on a dead IdP,
+// so one broken provider does not back up the whole webhook.
+type BreakerOptions struct {
+	// FailureThreshold is the failure ratio (0..1] at which a provider is
+	// disabled. 0 disables the breaker.
+	FailureThreshold float64
+	// MinRequests is the minimum number of observed requests in a window
+	// before the threshold is applied.
+	MinRequests int
+	// Window is the length of the observation window.
+	Window time.Duration
+	// Cooldown is how long a provider stays disabled before it is retried.
+	Cooldown time.Duration
+}
+
+func NewBreakerOptions() BreakerOptions {
+	return BreakerOptions{
+		MinRequests: defaultBreakerMinRequests,
+		Window:      defaultBreakerWindow,
+		Cooldown:    defaultBreakerCooldown,
+	}
+}
+
+func (o *BreakerOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.Float64Var(&o.FailureThreshold, "provider-failure-threshold", o.FailureThreshold, "failure ratio (0..1] at which an auth provider is temporarily disabled, 0 to never disable")
+	fs.IntVar(&o.MinRequests, "provider-failure-min-requests", o.MinRequests, "minimum number of requests in the observation window before the failure threshold applies")
+	fs.DurationVar(&o.Window, "provider-failure-window", o.Window, "length of the failure observation window")
+	fs.DurationVar(&o.Cooldown, "provider-disable-duration", o.Cooldown, "how long a disabled auth provider stays disabled before it is retried")
+}
+
+func (o *BreakerOptions) Validate() []error {
+	var errs []error
+	if o.FailureThreshold < 0 || o.FailureThreshold > 1 {
+		errs = append(errs, errors.New("provider-failure-threshold must be between 0 and 1"))
+	}
+	if o.FailureThreshold > 0 {
+		if o.MinRequests <= 0 {
+			errs = append(errs, errors.New("provider-failure-min-requests must be positive"))
+		}
+		if o.Window <= 0 {
+			errs = append(errs, errors.New("provider-failure-window must be positive"))
+		}
+		if o.Cooldown <= 0 {
+			errs = append(errs, errors.New("provider-disable-duration must be positive"))
+		}
+	}
+	return errs
+}
+
+// providerWindow accumulates request outcomes for one provider within the
+// current observation window.
+type providerWindow struct {
+	start    time.Time
+	total    int
+	failures int
+}
+
+// ProviderBreaker tracks provider failures and temporarily disables a
+// provider whose failure ratio exceeds the configured threshold. A nil
+// breaker (threshold 0) observes and disables nothing.
+type ProviderBreaker struct {
+	opts BreakerOptions
+
+	lock          sync.Mutex
+	windows       map[string]*providerWindow
+	disabledUntil map[string]time.Time
+}
+
+// NewProviderBreaker returns nil when the breaker is disabled.
+func NewProviderBreaker(opts BreakerOptions) *ProviderBreaker {
+	if opts.FailureThreshold <= 0 {
+		return nil
+	}
+	return &ProviderBreaker{
+		opts:          opts,
+		windows:       make(map[string]*providerWindow),
+		disabledUntil: make(map[string]time.Time),
+	}
+}
+
+// Disabled reports whether the provider is currently disabled. Once the
+// cooldown has passed the provider is re-enabled and gets a fresh window.
+func (b *ProviderBreaker) Disabled(provider string) bool {
+	if b == nil {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	until, ok := b.disabledUntil[provider]
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until) {
+		return true
+	}
+	delete(b.disabledUntil, provider)
+	delete(b.windows, provider)
+	providerDisabled.WithLabelValues(provider).Set(0)
+	glog.Warningf("auth provider %s re-enabled after cooldown", provider)
+	return false
+}
+
+// Observe records the outcome of one provider call and trips the breaker
+// when the failure ratio in the current window exceeds the threshold.
+func (b *ProviderBreaker) Observe(provider string, failed bool) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	w := b.windows[provider]
+	if w == nil || time.Since(w.start) > b.opts.Window {
+		w = &providerWindow{start: time.Now()}
+		b.windows[provider] = w
+	}
+	w.total++
+	if failed {
+		w.failures++
+	}
+
+	if w.total < b.opts.MinRequests {
+		return
+	}
+	if ratio := float64(w.failures) / float64(w.total); ratio >= b.opts.FailureThreshold {
+		b.disabledUntil[provider] = time.Now().Add(b.opts.Cooldown)
+		delete(b.windows, provider)
+		providerDisabled.WithLabelValues(provider).Set(1)
+		breakerTrips.WithLabelValues(provider).Inc()
+		glog.Errorf("auth provider %s disabled for %s: %d of %d requests failed in the last %s", provider, b.opts.Cooldown, w.failures, w.total, b.opts.Window)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderBreakerTripsAboveThreshold(t *testing.T) {
+	b := NewProviderBreaker(BreakerOptions{
+		FailureThreshold: 0.5,
+		MinRequests:      4,
+		Window:           time.Minute,
+		Cooldown:         time.Minute,
+	})
+
+	for i := 0; i < 3; i++ {
+		b.Observe("azure", true)
+		assert.False(t, b.Disabled("azure"))
+	}
+	b.Observe("azure", false)
+	assert.True(t, b.Disabled("azure"))
+
+	// other providers stay unaffected
+	assert.False(t, b.Disabled("github"))
+}
+
+func TestProviderBreakerIgnoresHealthyProvider(t *testing.T) {
+	b := NewProviderBreaker(BreakerOptions{
+		FailureThreshold: 0.5,
+		MinRequests:      4,
+		Window:           time.Minute,
+		Cooldown:         time.Minute,
+	})
+
+	for i := 0; i < 10; i++ {
+		b.Observe("azure", i%4 == 0)
+	}
+	assert.False(t, b.Disabled("azure"))
+}
+
+func TestProviderBreakerReenablesAfterCooldown(t *testing.T) {
+	b := NewProviderBreaker(BreakerOptions{
+		FailureThreshold: 0.5,
+		MinRequests:      1,
+		Window:           time.Minute,
+		Cooldown:         10 * time.Millisecond,
+	})
+
+	b.Observe("azure", true)
+	assert.True(t, b.Disabled("azure"))
+
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, b.Disabled("azure"))
+}
+
+func TestProviderBreakerDisabledByDefault(t *testing.T) {
+	b := NewProviderBreaker(NewBreakerOptions())
+	assert.Nil(t, b)
+	// a nil breaker observes and disables nothing
+	b.Observe("azure", true)
+	assert.False(t, b.Disabled("azure"))
+}
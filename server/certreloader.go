@@ -0,0 +1,106 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CertReloader keeps the server certificate and client CA pool in memory and
+// re-reads them from disk on demand, so certificates rotated by cert-manager
+// are picked up without restarting guard.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewCertReloader creates a CertReloader and performs the initial load of the
+// certificate, key and CA bundle.
+func NewCertReloader(opts SecureServingOptions) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: opts.CertFile,
+		keyFile:  opts.KeyFile,
+		caFile:   opts.CACertFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the certificate, key and CA bundle from disk. On any error
+// the previously loaded certificates remain in use.
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load server certificate")
+	}
+
+	caCert, err := ioutil.ReadFile(r.caFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read CA cert file")
+	}
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(caCert); !ok {
+		return errors.New("failed to add CA cert in CertPool for guard server")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.clientCAs = pool
+	return nil
+}
+
+// GetCertificate returns the current server certificate. It is meant to be
+// used as tls.Config.GetCertificate so rotated certificates take effect on
+// new connections.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// ClientCAs returns the current client CA pool.
+func (r *CertReloader) ClientCAs() *x509.CertPool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.clientCAs
+}
+
+// GetConfigForClient returns a tls.Config.GetConfigForClient callback that
+// serves a copy of base with the current client CA pool, so a rotated CA
+// bundle is used to verify new client connections.
+func (r *CertReloader) GetConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+		cfg := base.Clone()
+		cfg.ClientCAs = r.clientCAs
+		return cfg, nil
+	}
+}
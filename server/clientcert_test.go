@@ -0,0 +1,134 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCA returns a self-signed CA certificate and its key.
+func newTestCA(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt, key
+}
+
+// newTestClientCert returns a client certificate signed by the given CA with
+// the given validity window.
+func newTestClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, notBefore, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client", Organization: []string{"github"}},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crt
+}
+
+func TestWithClientCertVerification(t *testing.T) {
+	ca, caKey := newTestCA(t, "guard-ca")
+	otherCA, otherCAKey := newTestCA(t, "other-ca")
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	reloader := &CertReloader{clientCAs: pool}
+
+	now := time.Now()
+	tests := []struct {
+		name       string
+		cert       *x509.Certificate
+		wantStatus int
+		wantReason string
+	}{
+		{"valid cert", newTestClientCert(t, ca, caKey, now.Add(-time.Hour), now.Add(time.Hour)), http.StatusOK, ""},
+		{"no cert passes through", nil, http.StatusOK, ""},
+		{"expired cert", newTestClientCert(t, ca, caKey, now.Add(-2*time.Hour), now.Add(-time.Hour)), http.StatusUnauthorized, certRejectExpired},
+		{"not yet valid cert", newTestClientCert(t, ca, caKey, now.Add(time.Hour), now.Add(2*time.Hour)), http.StatusUnauthorized, certRejectNotYetValid},
+		{"unknown authority", newTestClientCert(t, otherCA, otherCAKey, now.Add(-time.Hour), now.Add(time.Hour)), http.StatusUnauthorized, certRejectUnknownAuthority},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := withClientCertVerification(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}), reloader)
+
+			req := httptest.NewRequest("POST", "http://guard.test/tokenreviews", nil)
+			if test.cert != nil {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.cert}}
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("expected status %d, actual %d", test.wantStatus, resp.StatusCode)
+			}
+			if test.wantReason != "" {
+				body := w.Body.String()
+				if !strings.Contains(body, test.wantReason) {
+					t.Errorf("expected reason %q in response body, actual %q", test.wantReason, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalConfiguration(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("ntp-server", "0.pool.ntp.org", "")
+	fs.String("azure.client-secret", "", "")
+	fs.String("ldap.bind-password", "", "")
+	assert.Nil(t, fs.Parse([]string{"--azure.client-secret=s3cr3t", "--ldap.bind-password=hunter2"}))
+
+	doc := canonicalConfiguration(fs)
+	assert.NotContains(t, doc, "s3cr3t")
+	assert.NotContains(t, doc, "hunter2")
+
+	lines := strings.Split(doc, "\n")
+	assert.Equal(t, []string{
+		"azure.client-secret=" + redactedValue,
+		"ldap.bind-password=" + redactedValue,
+		"ntp-server=0.pool.ntp.org",
+	}, lines)
+}
+
+func TestCanonicalConfigurationEmptySecretNotRedacted(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("azure.client-secret", "", "")
+
+	// an unset secret stays visibly empty, so operators can tell it apart
+	// from a configured one
+	assert.Equal(t, "azure.client-secret=", canonicalConfiguration(fs))
+}
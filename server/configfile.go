@@ -0,0 +1,222 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	configAPIVersion = "guard.appscode.com/v1alpha1"
+	configKind       = "GuardConfiguration"
+)
+
+// Config is the on-disk configuration accepted by `guard run --config`. It
+// carries the server flags keyed by flag name, so the Deployment args can
+// stay small. Flags given on the command line override the file.
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Flags maps flag names to their values. List values set the flag once
+	// per entry, matching repeated flag use on the command line.
+	Flags map[string]interface{} `json:"flags"`
+	// Tenants maps a tenant name, matched against the common name of the
+	// client certificate, to flag overrides applied on top of the global
+	// configuration, so one guard instance can serve clusters with e.g.
+	// different Azure tenants or GitHub organizations.
+	Tenants map[string]map[string]interface{} `json:"tenants,omitempty"`
+}
+
+// appliedConfigFlags records the flag names ApplyConfigFile actually set, so
+// a config file reload can re-apply exactly the flags the file governs while
+// the precedence of flags given on the command line holds.
+var (
+	appliedConfigFlagsMu sync.Mutex
+	appliedConfigFlags   = map[string]bool{}
+)
+
+// ApplyConfigFile loads the config file at the given path and applies its
+// values to the flag set. Flags already changed on the command line keep
+// their value. Unknown flag names are rejected, so typos surface at startup
+// instead of being silently ignored.
+func ApplyConfigFile(fs *pflag.FlagSet, path string) error {
+	cfg, names, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	applied := map[string]bool{}
+	for _, name := range names {
+		flag := fs.Lookup(name)
+		if flag == nil {
+			return errors.Errorf("config file sets unknown flag %q", name)
+		}
+		if flag.Changed {
+			// the command line takes precedence over the file
+			continue
+		}
+		if err := setFlagValues(fs, name, configFlagValues(cfg, name)); err != nil {
+			return err
+		}
+		applied[name] = true
+	}
+
+	appliedConfigFlagsMu.Lock()
+	appliedConfigFlags = applied
+	appliedConfigFlagsMu.Unlock()
+	return nil
+}
+
+// ReapplyConfigFile applies the config file again after it changed on disk.
+// Flags a previous application set are overridden even though they are
+// marked changed by that application; flags genuinely given on the command
+// line were never applied from the file, so their precedence holds. Flags
+// the file no longer sets are restored to their defaults.
+func ReapplyConfigFile(fs *pflag.FlagSet, path string) error {
+	cfg, names, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	appliedConfigFlagsMu.Lock()
+	previous := appliedConfigFlags
+	appliedConfigFlagsMu.Unlock()
+
+	applied := map[string]bool{}
+	for _, name := range names {
+		flag := fs.Lookup(name)
+		if flag == nil {
+			return errors.Errorf("config file sets unknown flag %q", name)
+		}
+		if flag.Changed && !previous[name] {
+			// the command line takes precedence over the file
+			continue
+		}
+		if err := setFlagValues(fs, name, configFlagValues(cfg, name)); err != nil {
+			return err
+		}
+		applied[name] = true
+	}
+
+	// restore flags dropped from the file to their defaults, so removing an
+	// entry behaves the same as never having set it
+	for name := range previous {
+		if applied[name] {
+			continue
+		}
+		flag := fs.Lookup(name)
+		if flag == nil {
+			continue
+		}
+		if err := setFlagValues(fs, name, defaultFlagValues(flag)); err != nil {
+			return err
+		}
+	}
+
+	appliedConfigFlagsMu.Lock()
+	appliedConfigFlags = applied
+	appliedConfigFlagsMu.Unlock()
+	return nil
+}
+
+// loadConfigFile reads and validates the config file, returning the flag
+// names in a stable order so errors are deterministic.
+func loadConfigFile(path string) (*Config, []string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to read config file")
+	}
+
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, nil, errors.Wrap(err, "unable to parse config file")
+	}
+	if cfg.APIVersion != configAPIVersion {
+		return nil, nil, errors.Errorf("unsupported config apiVersion %q, expected %q", cfg.APIVersion, configAPIVersion)
+	}
+	if cfg.Kind != configKind {
+		return nil, nil, errors.Errorf("unsupported config kind %q, expected %q", cfg.Kind, configKind)
+	}
+
+	names := make([]string, 0, len(cfg.Flags))
+	for name := range cfg.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &cfg, names, nil
+}
+
+// configFlagValues returns the values the config file holds for a flag.
+func configFlagValues(cfg *Config, name string) []string {
+	return flagValueList(cfg.Flags[name])
+}
+
+// flagValueList stringifies a config file value into the value list
+// setFlagValues accepts, normalizing a scalar entry to a single-element list.
+func flagValueList(value interface{}) []string {
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprintf("%v", v))
+	}
+	return strs
+}
+
+// setFlagValues sets a flag to the given values. Slice flags are replaced
+// wholesale instead of set repeatedly, since a second Set on an already
+// changed slice flag appends.
+func setFlagValues(fs *pflag.FlagSet, name string, values []string) error {
+	flag := fs.Lookup(name)
+	if sv, ok := flag.Value.(pflag.SliceValue); ok {
+		if err := sv.Replace(values); err != nil {
+			return errors.Wrapf(err, "invalid value for flag %q in config file", name)
+		}
+		flag.Changed = true
+		return nil
+	}
+	for _, value := range values {
+		if err := fs.Set(name, value); err != nil {
+			return errors.Wrapf(err, "invalid value for flag %q in config file", name)
+		}
+	}
+	return nil
+}
+
+// defaultFlagValues parses a flag's default back into the value list
+// setFlagValues accepts.
+func defaultFlagValues(flag *pflag.Flag) []string {
+	def := flag.DefValue
+	if _, ok := flag.Value.(pflag.SliceValue); ok {
+		def = strings.Trim(def, "[]")
+		if def == "" {
+			return nil
+		}
+		return strings.Split(def, ",")
+	}
+	return []string{def}
+}
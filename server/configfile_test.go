@@ -0,0 +1,145 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, data string) string {
+	f, err := ioutil.TempFile("", "guard-config-*.yaml")
+	assert.Nil(t, err)
+	_, err = f.WriteString(data)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	return f.Name()
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `apiVersion: guard.appscode.com/v1alpha1
+kind: GuardConfiguration
+flags:
+  ntp-server: 1.pool.ntp.org
+  secure-addr: ":9844"
+  auth-providers:
+    - token-auth
+    - github
+`)
+	defer os.Remove(path)
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	ntpServer := fs.String("ntp-server", "0.pool.ntp.org", "")
+	secureAddr := fs.String("secure-addr", ":8443", "")
+	authProviders := fs.StringSlice("auth-providers", nil, "")
+
+	// command line flags win over the config file
+	assert.Nil(t, fs.Parse([]string{"--secure-addr=:7443"}))
+	assert.Nil(t, ApplyConfigFile(fs, path))
+
+	assert.Equal(t, "1.pool.ntp.org", *ntpServer)
+	assert.Equal(t, ":7443", *secureAddr)
+	assert.Equal(t, []string{"token-auth", "github"}, *authProviders)
+}
+
+func TestReapplyConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `apiVersion: guard.appscode.com/v1alpha1
+kind: GuardConfiguration
+flags:
+  ntp-server: 1.pool.ntp.org
+  secure-addr: ":9844"
+  auth-providers:
+    - token-auth
+    - github
+`)
+	defer os.Remove(path)
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	ntpServer := fs.String("ntp-server", "0.pool.ntp.org", "")
+	secureAddr := fs.String("secure-addr", ":8443", "")
+	authProviders := fs.StringSlice("auth-providers", nil, "")
+
+	assert.Nil(t, fs.Parse([]string{"--secure-addr=:7443"}))
+	assert.Nil(t, ApplyConfigFile(fs, path))
+
+	// the edited file overrides its earlier values, replaces list values
+	// wholesale, and restores flags it no longer sets to their defaults
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`apiVersion: guard.appscode.com/v1alpha1
+kind: GuardConfiguration
+flags:
+  secure-addr: ":9944"
+  auth-providers:
+    - github
+`), 0600))
+	assert.Nil(t, ReapplyConfigFile(fs, path))
+
+	assert.Equal(t, "0.pool.ntp.org", *ntpServer)
+	assert.Equal(t, []string{"github"}, *authProviders)
+	// command line flags still win over the reloaded file
+	assert.Equal(t, ":7443", *secureAddr)
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	testdata := []struct {
+		testName string
+		config   string
+	}{
+		{
+			"unknown flag",
+			`apiVersion: guard.appscode.com/v1alpha1
+kind: GuardConfiguration
+flags:
+  no-such-flag: true
+`,
+		},
+		{
+			"wrong apiVersion",
+			`apiVersion: v1
+kind: GuardConfiguration
+`,
+		},
+		{
+			"wrong kind",
+			`apiVersion: guard.appscode.com/v1alpha1
+kind: Pod
+`,
+		},
+		{
+			"unknown field",
+			`apiVersion: guard.appscode.com/v1alpha1
+kind: GuardConfiguration
+args:
+  ntp-server: 1.pool.ntp.org
+`,
+		},
+	}
+
+	for _, test := range testdata {
+		t.Run(test.testName, func(t *testing.T) {
+			path := writeConfigFile(t, test.config)
+			defer os.Remove(path)
+
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			fs.String("ntp-server", "0.pool.ntp.org", "")
+			assert.NotNil(t, ApplyConfigFile(fs, path))
+		})
+	}
+}
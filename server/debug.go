@@ -0,0 +1,87 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// newDebugGate returns a wrapper applying the debug endpoint protections:
+// the client certificate chain is verified, then the identity is checked with
+// a delegated SubjectAccessReview (nonResourceURL) against the cluster, so
+// access to sensitive debug data follows cluster RBAC.
+func newDebugGate(certReloader *CertReloader) (func(http.Handler) http.Handler, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create in-cluster config")
+	}
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kubernetes client")
+	}
+	return func(next http.Handler) http.Handler {
+		return withClientCertVerification(&delegatedAuthzHandler{next: next, client: kc}, certReloader)
+	}, nil
+}
+
+type delegatedAuthzHandler struct {
+	next   http.Handler
+	client kubernetes.Interface
+}
+
+func (h *delegatedAuthzHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Missing client certificate", http.StatusUnauthorized)
+		return
+	}
+	crt := req.TLS.PeerCertificates[0]
+
+	sar := &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			User:   crt.Subject.CommonName,
+			Groups: crt.Subject.Organization,
+			NonResourceAttributes: &authzv1.NonResourceAttributes{
+				Path: req.URL.Path,
+				Verb: strings.ToLower(req.Method),
+			},
+		},
+	}
+
+	result, err := h.client.AuthorizationV1().SubjectAccessReviews().Create(context.TODO(), sar, metav1.CreateOptions{})
+	if err != nil {
+		glog.Errorf("Failed to perform subject access review for %s: %s", crt.Subject.CommonName, err)
+		http.Error(w, "Failed to perform subject access review", http.StatusInternalServerError)
+		return
+	}
+
+	if !result.Status.Allowed {
+		glog.V(3).Infof("user %s is not allowed to access %s: %s", crt.Subject.CommonName, req.URL.Path, result.Status.Reason)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	h.next.ServeHTTP(w, req)
+}
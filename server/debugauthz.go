@@ -0,0 +1,171 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/appscode/guard/authz"
+	"github.com/appscode/guard/authz/providers/azure"
+	"github.com/appscode/guard/authz/providers/azure/rbac"
+	guardclient "github.com/appscode/guard/client"
+	"github.com/appscode/guard/util/featuregates"
+
+	"github.com/golang/glog"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+// debugTraceHandler answers POST /debug/authz/trace with a decision trace
+// for the posted SubjectAccessReview: the decision, the provider evaluation
+// time and, for azure, the cache key the request maps to. It backs
+// `kubectl guard can-i`, so admins can debug guard from their workstation.
+func (s *Authzhandler) debugTraceHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !featuregates.Enabled(featuregates.DecisionTrace) {
+			http.Error(w, "decision traces are disabled by the DecisionTrace feature gate", http.StatusNotFound)
+			return
+		}
+		org, spec, ok := s.decodeDebugReview(w, req)
+		if !ok {
+			return
+		}
+
+		reqInfo := authz.RequestInfo{
+			CommonName:    req.TLS.PeerCertificates[0].Subject.CommonName,
+			ClusterHeader: req.Header.Get(clusterNameHeader),
+			// access to the debug endpoints is already gated by cluster RBAC,
+			// so the bypass allowlist that guards the webhook header does not
+			// apply here
+			CacheBypass: req.URL.Query().Get("bypass") != "",
+		}
+
+		client, err := s.getAuthzProviderClient(org)
+		if client == nil || err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		start := time.Now()
+		status, err := client.Check(req.Context(), spec, s.Store, reqInfo)
+		resp := guardclient.TraceResponse{
+			Provider:    strings.ToLower(org),
+			Decision:    authzDecision(status, err),
+			CacheBypass: reqInfo.CacheBypass,
+			Elapsed:     time.Since(start).String(),
+		}
+		if err != nil {
+			resp.EvaluationError = err.Error()
+		}
+		if status != nil {
+			resp.Reason = status.Reason
+			if status.EvaluationError != "" {
+				resp.EvaluationError = status.EvaluationError
+			}
+		}
+		if strings.ToLower(org) == azure.OrgType {
+			resp.CacheKey = rbac.ResultCacheKey(spec)
+		}
+		writeDebugJSON(w, resp)
+	})
+}
+
+// debugCacheHandler answers POST /debug/authz/cache with what the result
+// cache holds for the posted SubjectAccessReview, without evaluating it.
+// Only the azure provider caches verdicts per request.
+func (s *Authzhandler) debugCacheHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !featuregates.Enabled(featuregates.DecisionTrace) {
+			http.Error(w, "cache inspection is disabled by the DecisionTrace feature gate", http.StatusNotFound)
+			return
+		}
+		org, spec, ok := s.decodeDebugReview(w, req)
+		if !ok {
+			return
+		}
+		if strings.ToLower(org) != azure.OrgType {
+			http.Error(w, "cache inspection is only supported for the azure authz provider", http.StatusBadRequest)
+			return
+		}
+		if s.Store == nil {
+			http.Error(w, "no result cache is configured", http.StatusBadRequest)
+			return
+		}
+
+		client, err := s.getAuthzProviderClient(org)
+		if client == nil || err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		azureClient, ok := client.(*azure.Authorizer)
+		if !ok {
+			http.Error(w, "azure authz provider does not support cache inspection", http.StatusBadRequest)
+			return
+		}
+
+		v, err := azureClient.InspectCache(spec, s.Store, authz.RequestInfo{
+			CommonName:    req.TLS.PeerCertificates[0].Subject.CommonName,
+			ClusterHeader: req.Header.Get(clusterNameHeader),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeDebugJSON(w, guardclient.CacheInfoResponse{
+			CacheKey: v.Key,
+			Found:    v.Found,
+			Allowed:  v.Allowed,
+			Stale:    v.Stale,
+		})
+	})
+}
+
+// decodeDebugReview extracts the provider organization from the client
+// certificate and the review spec from the body, writing the error response
+// itself when either is unusable.
+func (s *Authzhandler) decodeDebugReview(w http.ResponseWriter, req *http.Request) (string, *authzv1beta1.SubjectAccessReviewSpec, bool) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		http.Error(w, "Missing client certificate", http.StatusBadRequest)
+		return "", nil, false
+	}
+	crt := req.TLS.PeerCertificates[0]
+	if len(crt.Subject.Organization) == 0 {
+		http.Error(w, "Client certificate is missing organization", http.StatusBadRequest)
+		return "", nil, false
+	}
+	org := crt.Subject.Organization[0]
+	if !s.AuthzRecommendedOptions.AuthzProvider.Has(org) {
+		http.Error(w, "guard does not provide service for "+org, http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	data := authzv1beta1.SubjectAccessReview{}
+	if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+		http.Error(w, "Failed to parse request: "+err.Error(), http.StatusBadRequest)
+		return "", nil, false
+	}
+	return org, &data.Spec, true
+}
+
+func writeDebugJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("x-content-type-options", "nosniff")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		glog.Errorf("Failed to write debug response: %s", err)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	guardclient "github.com/appscode/guard/client"
+
+	"github.com/stretchr/testify/assert"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+func debugReviewRequest(t *testing.T, path, org string, spec *authzv1beta1.SubjectAccessReviewSpec) *http.Request {
+	body := new(bytes.Buffer)
+	assert.Nil(t, json.NewEncoder(body).Encode(authzv1beta1.SubjectAccessReview{Spec: *spec}))
+
+	req := httptest.NewRequest("POST", "http://guard.test"+path, body)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{
+			{
+				Subject: pkix.Name{
+					CommonName:   "guard-admin",
+					Organization: []string{org},
+				},
+			},
+		},
+	}
+	return req
+}
+
+func TestDebugTraceHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guard-debug-authz")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.yaml")
+	policy := `rules:
+- users: ["alice"]
+  effect: deny
+`
+	if err := ioutil.WriteFile(policyFile, []byte(policy), 0600); err != nil {
+		t.Fatalf("failed to write policy file: %s", err)
+	}
+
+	authzOpts := NewAuthzRecommendedOptions()
+	authzOpts.AuthzProvider.Providers = []string{"policyfile"}
+	authzOpts.PolicyFile.PolicyFile = policyFile
+	handler := &Authzhandler{
+		AuthRecommendedOptions:  NewAuthRecommendedOptions(),
+		AuthzRecommendedOptions: authzOpts,
+	}
+
+	spec := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alice",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{
+			Verb:      "get",
+			Resource:  "pods",
+			Namespace: "default",
+		},
+	}
+
+	w := httptest.NewRecorder()
+	handler.debugTraceHandler().ServeHTTP(w, debugReviewRequest(t, "/debug/authz/trace", "policyfile", spec))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	trace := guardclient.TraceResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &trace))
+	assert.Equal(t, "policyfile", trace.Provider)
+	assert.Equal(t, "denied", trace.Decision)
+	assert.NotEmpty(t, trace.Elapsed)
+	assert.False(t, trace.CacheBypass)
+
+	// a provider the server is not configured for is rejected
+	w = httptest.NewRecorder()
+	handler.debugTraceHandler().ServeHTTP(w, debugReviewRequest(t, "/debug/authz/trace", "azure", spec))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// a request without a client certificate is rejected
+	w = httptest.NewRecorder()
+	req := debugReviewRequest(t, "/debug/authz/trace", "policyfile", spec)
+	req.TLS = nil
+	handler.debugTraceHandler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDebugCacheHandlerRequiresAzure(t *testing.T) {
+	authzOpts := NewAuthzRecommendedOptions()
+	authzOpts.AuthzProvider.Providers = []string{"policyfile"}
+	handler := &Authzhandler{
+		AuthRecommendedOptions:  NewAuthRecommendedOptions(),
+		AuthzRecommendedOptions: authzOpts,
+	}
+
+	spec := &authzv1beta1.SubjectAccessReviewSpec{
+		User:               "alice",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{Verb: "get", Resource: "pods"},
+	}
+
+	w := httptest.NewRecorder()
+	handler.debugCacheHandler().ServeHTTP(w, debugReviewRequest(t, "/debug/authz/cache", "policyfile", spec))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "only supported for the azure")
+}
@@ -0,0 +1,85 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/appscode/guard/auth/providers/azure/graph"
+)
+
+// error codes reported in tokenreview status.error and the per-code error
+// metric. They classify a failure without requiring log correlation.
+const (
+	ErrorCodeBadRequest        = "bad_request"
+	ErrorCodeInvalidToken      = "invalid_token"
+	ErrorCodeProviderError     = "provider_error"
+	ErrorCodeProviderThrottled = "provider_throttled"
+	ErrorCodeConsentRequired   = "consent_required"
+	ErrorCodeUnknownProvider   = "unknown_provider"
+	ErrorCodeProviderDisabled  = "provider_disabled"
+	ErrorCodeTooManyRequests   = "too_many_requests"
+)
+
+// classifyAuthError maps a provider check error to an error code and a
+// retriable hint. Throttling, consent problems and network failures of the
+// directory service are reported distinctly, so operators can tell guard
+// misconfiguration and transient outages apart from genuinely bad tokens.
+func classifyAuthError(err error) (errorCode string, retriable bool) {
+	switch {
+	case graph.IsThrottled(err):
+		return ErrorCodeProviderThrottled, true
+	case graph.IsConsentError(err):
+		return ErrorCodeConsentRequired, false
+	case graph.IsNetworkError(err):
+		return ErrorCodeProviderError, true
+	}
+	return ErrorCodeInvalidToken, false
+}
+
+// AuthFailure annotates a provider error with the provider name, an error
+// code and a retriable hint. Its message is returned as the structured
+// status.error of a failed tokenreview.
+type AuthFailure struct {
+	Provider  string
+	ErrorCode string
+	Retriable bool
+	cause     error
+	httpCode  int
+}
+
+// WithAuthFailure wraps err with provider/code/retriable details and an HTTP
+// status code. If err is nil, WithAuthFailure returns nil.
+func WithAuthFailure(err error, provider, errorCode string, retriable bool, httpCode int) error {
+	if err == nil {
+		return nil
+	}
+	return &AuthFailure{
+		Provider:  provider,
+		ErrorCode: errorCode,
+		Retriable: retriable,
+		cause:     err,
+		httpCode:  httpCode,
+	}
+}
+
+func (e *AuthFailure) Error() string {
+	return fmt.Sprintf("provider=%s code=%s retriable=%t: %s", e.Provider, e.ErrorCode, e.Retriable, e.cause.Error())
+}
+
+func (e *AuthFailure) Cause() error { return e.cause }
+func (e *AuthFailure) Code() int    { return e.httpCode }
@@ -17,77 +17,190 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"net/http"
+	"runtime/pprof"
 	"strings"
+	"time"
 
 	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/auth/providers/aws"
 	"github.com/appscode/guard/auth/providers/azure"
 	"github.com/appscode/guard/auth/providers/github"
 	"github.com/appscode/guard/auth/providers/gitlab"
 	"github.com/appscode/guard/auth/providers/google"
+	"github.com/appscode/guard/auth/providers/keycloak"
 	"github.com/appscode/guard/auth/providers/ldap"
+	"github.com/appscode/guard/auth/providers/saml"
 	"github.com/appscode/guard/auth/providers/token"
+	"github.com/appscode/guard/util/logs"
 
-	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
 	authv1 "k8s.io/api/authentication/v1"
 )
 
+// requestIDHeader carries the correlation id of a webhook request. An id sent
+// by the caller is honored, so guard log entries can be correlated with API
+// server audit logs.
+const requestIDHeader = "X-Request-Id"
+
+// correlate returns a context carrying the request's correlation id, which is
+// also echoed in the response header.
+func correlate(w http.ResponseWriter, req *http.Request) (context.Context, string) {
+	id := req.Header.Get(requestIDHeader)
+	if id == "" {
+		id = logs.NewRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return logs.WithRequestID(req.Context(), id), id
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	ctx, reqID := correlate(w, req)
+	ctx, span := trace.StartSpan(ctx, "guard.tokenreview")
+	defer span.End()
+
 	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
-		write(w, nil, WithCode(errors.New("Missing client certificate"), http.StatusBadRequest))
+		s.writeWithAudit(w, req, "unknown", "unknown", "", start, nil, WithAuthFailure(errors.New("Missing client certificate"), "unknown", ErrorCodeBadRequest, false, http.StatusBadRequest))
 		return
 	}
 	crt := req.TLS.PeerCertificates[0]
 	if len(crt.Subject.Organization) == 0 {
-		write(w, nil, WithCode(errors.New("Client certificate is missing organization"), http.StatusBadRequest))
+		s.writeWithAudit(w, req, "unknown", crt.Subject.CommonName, "", start, nil, WithAuthFailure(errors.New("Client certificate is missing organization"), "unknown", ErrorCodeBadRequest, false, http.StatusBadRequest))
 		return
 	}
 	org := crt.Subject.Organization[0]
-	glog.Infof("Received token review request for %s/%s", org, crt.Subject.CommonName)
+	span.AddAttributes(
+		trace.StringAttribute("provider", strings.ToLower(org)),
+		trace.StringAttribute("client", crt.Subject.CommonName),
+	)
+	logs.Info("received token review request", logs.Fields{
+		"request_id": reqID,
+		"provider":   org,
+		"client":     crt.Subject.CommonName,
+	})
+
+	// shed excess load before any parsing or provider work
+	if !s.RateLimiter.Allow(crt.Subject.CommonName) {
+		rateLimitedTotal.WithLabelValues("tokenreviews", crt.Subject.CommonName).Inc()
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, "", start, nil, WithAuthFailure(errors.New("request rate limit exceeded"), strings.ToLower(org), ErrorCodeTooManyRequests, true, http.StatusTooManyRequests))
+		return
+	}
 
+	// the v1 and v1beta1 TokenReview wire formats are identical for the
+	// fields guard reads, so both versions decode into the v1 struct
 	data := authv1.TokenReview{}
 	err := json.NewDecoder(req.Body).Decode(&data)
 	if err != nil {
-		write(w, nil, WithCode(errors.Wrap(err, "Failed to parse request"), http.StatusBadRequest))
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, "", start, nil, WithAuthFailure(errors.Wrap(err, "Failed to parse request"), strings.ToLower(org), ErrorCodeBadRequest, false, http.StatusBadRequest))
 		return
 	}
 
-	if !s.AuthRecommendedOptions.AuthProvider.Has(org) {
-		write(w, nil, WithCode(errors.Errorf("guard does not provide service for %v", org), http.StatusBadRequest))
+	// a tenant block for the client selects its own provider configuration
+	authOpts := s.AuthRecommendedOptions
+	if tenant := s.Tenants.Lookup(crt.Subject.CommonName); tenant != nil {
+		authOpts = tenant.AuthOptions
+	}
+
+	if !authOpts.AuthProvider.Has(org) {
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, data.APIVersion, start, nil, WithAuthFailure(errors.Errorf("guard does not provide service for %v", org), strings.ToLower(org), ErrorCodeUnknownProvider, false, http.StatusBadRequest))
 		return
 	}
 
-	if s.AuthRecommendedOptions.AuthProvider.Has(token.OrgType) && s.TokenAuthenticator != nil {
-		resp, err := s.TokenAuthenticator.Check(data.Spec.Token)
+	if authOpts.AuthProvider.Has(token.OrgType) && s.TokenAuthenticator != nil {
+		resp, err := s.TokenAuthenticator.Check(ctx, data.Spec.Token)
 		if err == nil {
-			write(w, resp, err)
+			s.writeWithAudit(w, req, token.OrgType, crt.Subject.CommonName, data.APIVersion, start, resp, err)
 			return
 		}
 	}
 
+	// a cached result skips the upstream provider entirely; only successful
+	// reviews are cached, so failures stay retriable
+	if info, found := s.AuthnCache.Get(strings.ToLower(org), data.Spec.Token); found {
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, data.APIVersion, start, info, nil)
+		return
+	}
+
+	// answer for a tripped provider immediately instead of waiting on a dead
+	// IdP, so one broken provider does not back up the whole webhook
+	if s.Breaker.Disabled(strings.ToLower(org)) {
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, data.APIVersion, start, nil, WithAuthFailure(errors.Errorf("auth provider %s is temporarily disabled due to sustained failures", org), strings.ToLower(org), ErrorCodeProviderDisabled, true, http.StatusUnauthorized))
+		return
+	}
+
 	client, err := s.getAuthProviderClient(org, crt.Subject.CommonName)
 	if err != nil {
-		write(w, nil, err)
+		s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, data.APIVersion, start, nil, WithAuthFailure(err, strings.ToLower(org), ErrorCodeProviderError, true, http.StatusUnauthorized))
 		return
 	}
 
-	resp, err := client.Check(data.Spec.Token)
-	write(w, resp, err)
+	checkCtx, checkSpan := trace.StartSpan(ctx, "guard.provider.check")
+	// a per-provider timeout tightens the deadline below the handler timeout,
+	// cancelling the upstream call instead of just abandoning it
+	checkCtx, cancelCheck := withProviderDeadline(checkCtx, authOpts.Timeout, org)
+	defer cancelCheck()
+	var resp *authv1.UserInfo
+	// pprof labels tag the CPU samples of the provider work, so a continuous
+	// profiler can break guard's cost down per handler and provider
+	pprof.Do(checkCtx, pprof.Labels("handler", "tokenreviews", "provider", strings.ToLower(org)), func(ctx context.Context) {
+		resp, err = client.Check(ctx, data.Spec.Token)
+	})
+	checkSpan.End()
+	errorCode, retriable := classifyAuthError(err)
+	// only provider-side failures count against the breaker; a rejected
+	// token is a healthy provider doing its job
+	s.Breaker.Observe(strings.ToLower(org), err != nil && retriable)
+	if err == nil {
+		s.AuthnCache.Set(strings.ToLower(org), data.Spec.Token, resp)
+	}
+	s.writeWithAudit(w, req, strings.ToLower(org), crt.Subject.CommonName, data.APIVersion, start, resp, WithAuthFailure(err, strings.ToLower(org), errorCode, retriable, http.StatusUnauthorized))
+}
+
+// writeWithAudit records the decision in the audit trail and the per-client
+// decision metric before the response is written.
+func (s *Server) writeWithAudit(w http.ResponseWriter, req *http.Request, provider, client, apiVersion string, start time.Time, info *authv1.UserInfo, err error) {
+	decision := "authenticated"
+	if err != nil {
+		decision = "denied"
+	}
+	// cap the identity before auditing, so the audit trail records what was
+	// actually returned
+	if err == nil {
+		info = s.AuthRecommendedOptions.Limits.truncate(info)
+	}
+	decisionCounter.WithLabelValues(client, decision).Inc()
+	s.AuditLogger.LogTokenReview(req, provider, info, err, time.Since(start))
+	write(w, apiVersion, info, err)
 }
 
 func (s *Server) getAuthProviderClient(org, commonName string) (auth.Interface, error) {
+	// a config reload must never hand out a half-configured options struct
+	providerConfigMu.RLock()
+	defer providerConfigMu.RUnlock()
+	opts := s.AuthRecommendedOptions
+	if tenant := s.Tenants.Lookup(commonName); tenant != nil {
+		opts = tenant.AuthOptions
+	}
 	switch strings.ToLower(org) {
 	case github.OrgType:
-		return github.New(s.AuthRecommendedOptions.Github, commonName), nil
+		return github.New(opts.Github, commonName), nil
 	case google.OrgType:
-		return google.New(s.AuthRecommendedOptions.Google, commonName)
+		return google.New(opts.Google, commonName)
 	case gitlab.OrgType:
-		return gitlab.New(s.AuthRecommendedOptions.Gitlab), nil
+		return gitlab.New(opts.Gitlab), nil
 	case azure.OrgType:
-		return azure.New(s.AuthRecommendedOptions.Azure)
+		return azure.New(opts.Azure)
+	case keycloak.OrgType:
+		return keycloak.New(opts.Keycloak)
 	case ldap.OrgType:
-		return ldap.New(s.AuthRecommendedOptions.LDAP), nil
+		return ldap.New(opts.LDAP), nil
+	case saml.OrgType:
+		return saml.New(opts.SAML)
+	case aws.OrgType:
+		return aws.New(opts.AWS)
 	}
 
 	return nil, errors.Errorf("Client is using unknown organization %s", org)
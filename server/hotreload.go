@@ -0,0 +1,135 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/appscode/guard/auth/providers/google"
+	"github.com/appscode/guard/auth/providers/ldap"
+	azureauthz "github.com/appscode/guard/authz/providers/azure"
+
+	"github.com/pkg/errors"
+	"kmodules.xyz/client-go/tools/fsnotify"
+)
+
+// providerConfigMu serializes provider reconfiguration against the
+// per-request provider client construction, so a reload never hands a
+// half-configured options struct to a request.
+var providerConfigMu sync.RWMutex
+
+// watchProviderConfig extends hot reload beyond the token auth file: every
+// credential file a provider reads at configure time is watched, and the
+// provider's configure step re-runs when it changes. When the server was
+// started with --config, the config file itself is watched as well and
+// re-applied, covering options that are not file-backed, like the azure
+// skip-authz lists. The policy file authorizers reload their own files on
+// change, so they need no watcher here.
+func (s *Server) watchProviderConfig(reloads *reloadTracker, stopCh <-chan struct{}) error {
+	type watched struct {
+		source string
+		files  []string
+		reload func() error
+	}
+	var items []watched
+
+	if s.AuthRecommendedOptions.AuthProvider.Has(ldap.OrgType) {
+		items = append(items, watched{
+			source: "ldap-config",
+			files: []string{
+				s.AuthRecommendedOptions.LDAP.CaCertFile,
+				s.AuthRecommendedOptions.LDAP.KeytabFile,
+				s.AuthRecommendedOptions.LDAP.ClientCert.CertFile,
+				s.AuthRecommendedOptions.LDAP.ClientCert.KeyFile,
+			},
+			reload: func() error {
+				providerConfigMu.Lock()
+				defer providerConfigMu.Unlock()
+				return s.AuthRecommendedOptions.LDAP.Configure()
+			},
+		})
+	}
+	if s.AuthRecommendedOptions.AuthProvider.Has(google.OrgType) {
+		items = append(items, watched{
+			source: "google-sa-json",
+			files:  []string{s.AuthRecommendedOptions.Google.ServiceAccountJsonFile},
+			reload: func() error {
+				providerConfigMu.Lock()
+				defer providerConfigMu.Unlock()
+				return s.AuthRecommendedOptions.Google.Configure()
+			},
+		})
+	}
+	if s.ConfigPath != "" && s.ConfigFlags != nil {
+		items = append(items, watched{
+			source: "config-file",
+			files:  []string{s.ConfigPath},
+			reload: s.reloadConfigFile,
+		})
+	}
+
+	for _, item := range items {
+		dirs := map[string]struct{}{}
+		for _, file := range item.files {
+			if file != "" {
+				dirs[filepath.Dir(file)] = struct{}{}
+			}
+		}
+		if len(dirs) == 0 {
+			continue
+		}
+		reload := reloads.wrap(item.source, item.reload, nil)
+		for dir := range dirs {
+			w := fsnotify.Watcher{
+				WatchDir: dir,
+				Reload:   reload,
+			}
+			if err := w.Run(stopCh); err != nil {
+				return errors.Wrapf(err, "failed to watch %s for %s", dir, item.source)
+			}
+		}
+	}
+	return nil
+}
+
+// reloadConfigFile re-applies the --config file and re-runs the provider
+// configuration steps that depend on it, rebuilding the azure authz client
+// atomically so a changed skip-authz list or credential takes effect without
+// a restart.
+func (s *Server) reloadConfigFile() error {
+	if err := ReapplyConfigFile(s.ConfigFlags, s.ConfigPath); err != nil {
+		return err
+	}
+
+	providerConfigMu.Lock()
+	err := s.AuthRecommendedOptions.LDAP.Configure()
+	if err == nil {
+		err = s.AuthRecommendedOptions.Google.Configure()
+	}
+	providerConfigMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if s.AuthzRecommendedOptions != nil && s.AuthzRecommendedOptions.AuthzProvider.Has(azureauthz.OrgType) {
+		if err := azureauthz.Refresh(s.AuthzRecommendedOptions.Azure, s.AuthRecommendedOptions.Azure); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// truncationWarningKey is the extra key carrying the truncation warnings, so
+// a capped identity is visible in the API server audit log.
+const truncationWarningKey = "guard.appscode.com/warning"
+
+// LimitOptions caps the size of the identity returned in token reviews. A
+// user in tens of thousands of directory groups can produce a response the
+// API server rejects or chokes on; capping keeps the response bounded while
+// the warning makes the truncation visible.
+type LimitOptions struct {
+	// MaxGroups is the maximum number of groups returned per user; 0 means
+	// unlimited
+	MaxGroups int
+	// MaxExtraValues is the maximum number of values returned per extra key;
+	// 0 means unlimited
+	MaxExtraValues int
+}
+
+func NewLimitOptions() LimitOptions {
+	return LimitOptions{}
+}
+
+func (o *LimitOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&o.MaxGroups, "max-groups", o.MaxGroups, "maximum number of groups returned per user in a token review; groups are sorted and truncated deterministically and a warning is attached. 0 means unlimited")
+	fs.IntVar(&o.MaxExtraValues, "max-extra-values", o.MaxExtraValues, "maximum number of values returned per extra key in a token review; values are sorted and truncated deterministically and a warning is attached. 0 means unlimited")
+}
+
+func (o *LimitOptions) Validate() []error {
+	var errs []error
+	if o.MaxGroups < 0 {
+		errs = append(errs, errors.New("max-groups must be non-negative"))
+	}
+	if o.MaxExtraValues < 0 {
+		errs = append(errs, errors.New("max-extra-values must be non-negative"))
+	}
+	return errs
+}
+
+// truncate returns info with the configured caps applied. Entries are sorted
+// before truncation, so the same identity is always truncated to the same
+// result regardless of provider ordering. The original is never modified; a
+// copy is only made when something is actually over a cap.
+func (o LimitOptions) truncate(info *authv1.UserInfo) *authv1.UserInfo {
+	if info == nil || !o.exceeded(info) {
+		return info
+	}
+
+	out := info.DeepCopy()
+	var warnings []string
+	if o.MaxGroups > 0 && len(out.Groups) > o.MaxGroups {
+		sort.Strings(out.Groups)
+		out.Groups = out.Groups[:o.MaxGroups]
+		warnings = append(warnings, fmt.Sprintf("groups truncated from %d to %d by max-groups", len(info.Groups), o.MaxGroups))
+	}
+	if o.MaxExtraValues > 0 {
+		keys := make([]string, 0, len(out.Extra))
+		for key := range out.Extra {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			values := out.Extra[key]
+			if len(values) <= o.MaxExtraValues {
+				continue
+			}
+			sort.Strings(values)
+			out.Extra[key] = values[:o.MaxExtraValues]
+			warnings = append(warnings, fmt.Sprintf("extra values for %s truncated from %d to %d by max-extra-values", key, len(info.Extra[key]), o.MaxExtraValues))
+		}
+	}
+
+	if out.Extra == nil {
+		out.Extra = map[string]authv1.ExtraValue{}
+	}
+	out.Extra[truncationWarningKey] = warnings
+	glog.Warningf("identity of user %s truncated: %v", info.Username, warnings)
+	return out
+}
+
+// exceeded reports whether any cap applies to info.
+func (o LimitOptions) exceeded(info *authv1.UserInfo) bool {
+	if o.MaxGroups > 0 && len(info.Groups) > o.MaxGroups {
+		return true
+	}
+	if o.MaxExtraValues > 0 {
+		for _, values := range info.Extra {
+			if len(values) > o.MaxExtraValues {
+				return true
+			}
+		}
+	}
+	return false
+}
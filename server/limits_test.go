@@ -0,0 +1,93 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+func TestTruncateGroups(t *testing.T) {
+	o := LimitOptions{MaxGroups: 2}
+	info := &authv1.UserInfo{
+		Username: "jane",
+		Groups:   []string{"ops", "dev", "audit"},
+	}
+
+	out := o.truncate(info)
+	assert.Equal(t, []string{"audit", "dev"}, out.Groups)
+	if assert.Contains(t, out.Extra, truncationWarningKey) {
+		assert.Contains(t, out.Extra[truncationWarningKey][0], "groups truncated from 3 to 2")
+	}
+	// the provider's UserInfo must not be modified
+	assert.Equal(t, []string{"ops", "dev", "audit"}, info.Groups)
+	assert.NotContains(t, info.Extra, truncationWarningKey)
+}
+
+func TestTruncateExtraValues(t *testing.T) {
+	o := LimitOptions{MaxExtraValues: 1}
+	info := &authv1.UserInfo{
+		Username: "jane",
+		Extra: map[string]authv1.ExtraValue{
+			"scopes": {"write", "read"},
+			"arn":    {"arn:aws:iam::0:user/jane"},
+		},
+	}
+
+	out := o.truncate(info)
+	assert.Equal(t, authv1.ExtraValue{"read"}, out.Extra["scopes"])
+	assert.Equal(t, authv1.ExtraValue{"arn:aws:iam::0:user/jane"}, out.Extra["arn"])
+	if assert.Contains(t, out.Extra, truncationWarningKey) {
+		assert.Contains(t, out.Extra[truncationWarningKey][0], "extra values for scopes truncated from 2 to 1")
+	}
+}
+
+func TestTruncateDeterministic(t *testing.T) {
+	o := LimitOptions{MaxGroups: 2}
+	a := o.truncate(&authv1.UserInfo{Groups: []string{"c", "a", "b"}})
+	b := o.truncate(&authv1.UserInfo{Groups: []string{"b", "c", "a"}})
+	assert.Equal(t, a.Groups, b.Groups)
+}
+
+func TestTruncateUnderLimits(t *testing.T) {
+	o := LimitOptions{MaxGroups: 5, MaxExtraValues: 5}
+	info := &authv1.UserInfo{
+		Username: "jane",
+		Groups:   []string{"ops", "dev"},
+		Extra:    map[string]authv1.ExtraValue{"scopes": {"read"}},
+	}
+
+	out := o.truncate(info)
+	// no copy is made and nothing changes when every cap holds
+	assert.Same(t, info, out)
+	assert.NotContains(t, out.Extra, truncationWarningKey)
+}
+
+func TestTruncateUnlimitedByDefault(t *testing.T) {
+	o := NewLimitOptions()
+	info := &authv1.UserInfo{Groups: make([]string, 1000)}
+	assert.Same(t, info, o.truncate(info))
+}
+
+func TestLimitOptionsValidate(t *testing.T) {
+	o := LimitOptions{MaxGroups: -1, MaxExtraValues: -2}
+	assert.Len(t, o.Validate(), 2)
+	o = LimitOptions{MaxGroups: 10}
+	assert.Empty(t, o.Validate())
+}
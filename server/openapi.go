@@ -0,0 +1,220 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	v "github.com/appscode/go/version"
+	"github.com/go-openapi/spec"
+	"github.com/golang/glog"
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// openAPIHandler serves the OpenAPI document describing guard's webhook
+// endpoints, so integrators can codegen clients and validate payloads. The
+// document is generated from the Go types once, on first request.
+func openAPIHandler() http.HandlerFunc {
+	var (
+		once sync.Once
+		doc  []byte
+		err  error
+	)
+	return func(w http.ResponseWriter, req *http.Request) {
+		once.Do(func() {
+			doc, err = json.Marshal(buildOpenAPIDocument())
+		})
+		if err != nil {
+			glog.Errorf("failed to build openapi document: %s", err)
+			http.Error(w, "failed to build openapi document", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-content-type-options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(doc)
+	}
+}
+
+// buildOpenAPIDocument assembles the swagger document for the webhook
+// endpoints, with the request and response schemas reflected from the Go
+// types guard actually decodes and encodes.
+func buildOpenAPIDocument() *spec.Swagger {
+	defs := make(map[string]spec.Schema)
+	tokenReview := defineSchema(reflect.TypeOf(authv1.TokenReview{}), defs)
+	subjectAccessReview := defineSchema(reflect.TypeOf(authzv1beta1.SubjectAccessReview{}), defs)
+
+	okResponse := func(schema spec.Schema, description string) spec.Response {
+		return spec.Response{ResponseProps: spec.ResponseProps{Description: description, Schema: &schema}}
+	}
+	bodyParameter := func(schema spec.Schema) spec.Parameter {
+		return spec.Parameter{ParamProps: spec.ParamProps{Name: "body", In: "body", Required: true, Schema: &schema}}
+	}
+
+	paths := map[string]spec.PathItem{
+		"/tokenreviews": {PathItemProps: spec.PathItemProps{
+			Post: &spec.Operation{OperationProps: spec.OperationProps{
+				ID:          "createTokenReview",
+				Summary:     "Authenticate a bearer token",
+				Description: "Accepts an authentication.k8s.io/v1 or v1beta1 TokenReview; both versions share the wire format guard reads. The response echoes the requested apiVersion. Errors are reported as a TokenReview with status.authenticated=false and the cause in status.error.",
+				Consumes:    []string{"application/json"},
+				Produces:    []string{"application/json"},
+				Responses: &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+					http.StatusOK:           okResponse(tokenReview, "token accepted; status.user identifies the caller"),
+					http.StatusBadRequest:   okResponse(tokenReview, "malformed request; cause in status.error"),
+					http.StatusUnauthorized: okResponse(tokenReview, "token rejected or provider failure; cause in status.error"),
+				}}},
+				Parameters: []spec.Parameter{bodyParameter(tokenReview)},
+			}},
+		}},
+		"/subjectaccessreviews": {PathItemProps: spec.PathItemProps{
+			Post: &spec.Operation{OperationProps: spec.OperationProps{
+				ID:          "createSubjectAccessReview",
+				Summary:     "Authorize a subject access review",
+				Description: "Accepts an authorization.k8s.io/v1beta1 SubjectAccessReview and answers with status.allowed/denied set per the configured authz provider, or neither for no opinion. Provider failures are reported in status.evaluationError or status.reason.",
+				Consumes:    []string{"application/json"},
+				Produces:    []string{"application/json"},
+				Responses: &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+					http.StatusOK:         okResponse(subjectAccessReview, "review evaluated; verdict in status"),
+					http.StatusBadRequest: okResponse(subjectAccessReview, "malformed request or unknown provider; cause in status.reason"),
+				}}},
+				Parameters: []spec.Parameter{bodyParameter(subjectAccessReview)},
+			}},
+		}},
+		"/healthz": {PathItemProps: spec.PathItemProps{
+			Get: &spec.Operation{OperationProps: spec.OperationProps{
+				ID:       "getHealthz",
+				Summary:  "Liveness probe, answers the running version",
+				Produces: []string{"application/json"},
+				Responses: &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+					http.StatusOK: {ResponseProps: spec.ResponseProps{Description: "guard is serving"}},
+				}}},
+			}},
+		}},
+		"/readyz": {PathItemProps: spec.PathItemProps{
+			Get: &spec.Operation{OperationProps: spec.OperationProps{
+				ID:       "getReadyz",
+				Summary:  "Readiness probe, reports per-dependency status",
+				Produces: []string{"application/json"},
+				Responses: &spec.Responses{ResponsesProps: spec.ResponsesProps{StatusCodeResponses: map[int]spec.Response{
+					http.StatusOK:                 {ResponseProps: spec.ResponseProps{Description: "all dependencies ready"}},
+					http.StatusServiceUnavailable: {ResponseProps: spec.ResponseProps{Description: "one or more dependencies not ready"}},
+				}}},
+			}},
+		}},
+	}
+
+	return &spec.Swagger{SwaggerProps: spec.SwaggerProps{
+		Swagger: "2.0",
+		Info: &spec.Info{InfoProps: spec.InfoProps{
+			Title:       "guard",
+			Description: "Kubernetes authentication and authorization webhook server",
+			Version:     v.Version.Version,
+		}},
+		Schemes:     []string{"https"},
+		Paths:       &spec.Paths{Paths: paths},
+		Definitions: defs,
+	}}
+}
+
+// typeName returns the kube-style definition name for t, e.g. v1.TokenReview.
+func typeName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	return pkg[strings.LastIndex(pkg, "/")+1:] + "." + t.Name()
+}
+
+// defineSchema adds the schema for a named struct type to the definitions and
+// returns a reference to it, reflecting nested types recursively.
+func defineSchema(t reflect.Type, defs map[string]spec.Schema) spec.Schema {
+	name := typeName(t)
+	if _, found := defs[name]; !found {
+		// reserve the slot first, so self-referential types terminate
+		defs[name] = spec.Schema{}
+		schema := spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}}}
+		schema.Properties = structProperties(t, defs)
+		defs[name] = schema
+	}
+	return spec.Schema{SchemaProps: spec.SchemaProps{Ref: spec.MustCreateRef("#/definitions/" + name)}}
+}
+
+// structProperties reflects the json-visible fields of a struct type,
+// flattening inline embedded structs the way encoding/json does.
+func structProperties(t reflect.Type, defs map[string]spec.Schema) map[string]spec.Schema {
+	properties := make(map[string]spec.Schema)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			if field.Anonymous {
+				for k, schema := range structProperties(field.Type, defs) {
+					properties[k] = schema
+				}
+				continue
+			}
+			name = field.Name
+		}
+		properties[name] = schemaForType(field.Type, defs)
+	}
+	return properties
+}
+
+// schemaForType maps a Go type onto an OpenAPI schema.
+func schemaForType(t reflect.Type, defs map[string]spec.Schema) spec.Schema {
+	simple := func(typ, format string) spec.Schema {
+		return spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{typ}, Format: format}}
+	}
+
+	switch t {
+	case reflect.TypeOf(metav1.Time{}), reflect.TypeOf(metav1.MicroTime{}), reflect.TypeOf(time.Time{}):
+		return simple("string", "date-time")
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaForType(t.Elem(), defs)
+	case reflect.Struct:
+		return defineSchema(t, defs)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return simple("string", "byte")
+		}
+		items := schemaForType(t.Elem(), defs)
+		return spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"array"}, Items: &spec.SchemaOrArray{Schema: &items}}}
+	case reflect.Map:
+		values := schemaForType(t.Elem(), defs)
+		return spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}, AdditionalProperties: &spec.SchemaOrBool{Schema: &values}}}
+	case reflect.Bool:
+		return simple("boolean", "")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return simple("integer", "")
+	case reflect.Float32, reflect.Float64:
+		return simple("number", "")
+	default:
+		return simple("string", "")
+	}
+}
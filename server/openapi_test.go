@@ -0,0 +1,69 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildOpenAPIDocument(t *testing.T) {
+	doc := buildOpenAPIDocument()
+
+	for _, path := range []string{"/tokenreviews", "/subjectaccessreviews", "/healthz", "/readyz"} {
+		if _, found := doc.Paths.Paths[path]; !found {
+			t.Errorf("expected path %s in openapi document", path)
+		}
+	}
+
+	for _, definition := range []string{"v1.TokenReview", "v1beta1.SubjectAccessReview", "v1.TokenReviewStatus", "v1beta1.SubjectAccessReviewStatus"} {
+		if _, found := doc.Definitions[definition]; !found {
+			t.Errorf("expected definition %s in openapi document", definition)
+		}
+	}
+
+	status := doc.Definitions["v1.TokenReviewStatus"]
+	for _, property := range []string{"authenticated", "user", "error"} {
+		if _, found := status.Properties[property]; !found {
+			t.Errorf("expected property %s on v1.TokenReviewStatus", property)
+		}
+	}
+
+	// TypeMeta is embedded inline; its fields must be flattened
+	review := doc.Definitions["v1.TokenReview"]
+	for _, property := range []string{"kind", "apiVersion", "spec", "status"} {
+		if _, found := review.Properties[property]; !found {
+			t.Errorf("expected property %s on v1.TokenReview", property)
+		}
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	openAPIHandler()(rec, httptest.NewRequest(http.MethodGet, "/openapi", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var doc map[string]interface{}
+	err := json.Unmarshal(rec.Body.Bytes(), &doc)
+	assert.Nil(t, err)
+	assert.Equal(t, "2.0", doc["swagger"])
+}
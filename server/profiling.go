@@ -0,0 +1,85 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"runtime/pprof"
+	"time"
+
+	stringz "github.com/appscode/go/strings"
+	v "github.com/appscode/go/version"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// ProfilingOptions configures the plain HTTP pprof listener scraped by a
+// continuous profiler agent (pyroscope, parca). It is separate from the
+// delegated-authz gated /debug/pprof/ on the serving port, because scraping
+// agents speak neither TLS client certificates nor SubjectAccessReviews. The
+// listener must stay cluster-internal.
+type ProfilingOptions struct {
+	// Addr is the address (host:port) serving /debug/pprof; empty disables
+	// the listener
+	Addr string
+}
+
+func NewProfilingOptions() ProfilingOptions {
+	return ProfilingOptions{}
+}
+
+func (o *ProfilingOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Addr, "profiling-addr", o.Addr, "Address (host:port) serving /debug/pprof over plain HTTP for a continuous profiler agent. Empty disables the listener, which must not be exposed outside the cluster")
+}
+
+func (o *ProfilingOptions) Validate() []error {
+	var errs []error
+	if o.Addr != "" {
+		if _, _, err := net.SplitHostPort(o.Addr); err != nil {
+			errs = append(errs, errors.Wrap(err, "profiling-addr is invalid"))
+		}
+	}
+	return errs
+}
+
+// buildMetadataLabels labels the current goroutine (and everything it spawns)
+// with the service name and release version, so profiles collected by a
+// continuous profiler can be compared across releases.
+func buildMetadataLabels() {
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels(
+		"service", "guard",
+		"version", stringz.Val(v.Version.Version, "canary"),
+	))
+	pprof.SetGoroutineLabels(ctx)
+}
+
+// listenAndServeProfiling serves the pprof handlers registered on the default
+// mux by net/http/pprof. The generous write timeout accommodates CPU profile
+// requests, which stream for the requested number of seconds.
+func (s Server) listenAndServeProfiling() {
+	srv := &http.Server{
+		Addr:         s.AuthRecommendedOptions.Profiling.Addr,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 2 * time.Minute,
+		Handler:      http.DefaultServeMux,
+	}
+	glog.Fatalln(srv.ListenAndServe())
+}
@@ -14,25 +14,27 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package eks
+package server
 
 import (
-	"encoding/json"
+	"testing"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	auth "k8s.io/client-go/pkg/apis/clientauthentication/v1alpha1"
+	"github.com/stretchr/testify/assert"
 )
 
-func PrintToken(token string) (string, error) {
-	execInput := &auth.ExecCredential{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "client.authentication.k8s.io/v1alpha1",
-			Kind:       "ExecCredential",
-		},
-		Status: &auth.ExecCredentialStatus{
-			Token: token,
-		},
+func TestProfilingOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ProfilingOptions
+		errs int
+	}{
+		{"disabled", NewProfilingOptions(), 0},
+		{"valid addr", ProfilingOptions{Addr: "127.0.0.1:6060"}, 0},
+		{"missing port", ProfilingOptions{Addr: "127.0.0.1"}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Len(t, test.opts.Validate(), test.errs)
+		})
 	}
-	ret, err := json.Marshal(execInput)
-	return string(ret), err
 }
@@ -66,6 +66,14 @@ var (
 		[]string{"handler"},
 	)
 
+	errorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tokenreviews_handler_errors_total",
+			Help: "A counter of failed tokenreviews partitioned by provider and error code.",
+		},
+		[]string{"provider", "code"},
+	)
+
 	inFlightGaugeAuthz = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "subjectaccessreviews_handler_requests_in_flight",
 		Help: "A gauge of requests currently being served by the tokenreviews handler.",
@@ -78,9 +86,126 @@ var (
 		},
 		[]string{"code", "method"},
 	)
+
+	// decision counters are partitioned by the calling client identity (the
+	// certificate common name), so each tenant of a shared guard can be
+	// observed in isolation.
+	decisionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tokenreviews_decisions_total",
+			Help: "A counter of tokenreview decisions partitioned by client identity and decision.",
+		},
+		[]string{"client", "decision"},
+	)
+
+	decisionCounterAuthz = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "subjectaccessreviews_decisions_total",
+			Help: "A counter of subjectaccessreview decisions partitioned by client identity and decision.",
+		},
+		[]string{"client", "decision"},
+	)
+
+	// providerDisabled reports whether an auth provider is currently disabled
+	// by the failure breaker; breakerTrips counts how often that happened.
+	providerDisabled = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "auth_provider_disabled",
+			Help: "Whether the auth provider is currently disabled by the failure breaker (1 disabled, 0 enabled).",
+		},
+		[]string{"provider"},
+	)
+
+	breakerTrips = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_provider_breaker_trips_total",
+			Help: "A counter of auth provider disablements by the failure breaker.",
+		},
+		[]string{"provider"},
+	)
+
+	// reload metrics surface configuration reload outcomes, so a token file
+	// or certificate rotation that silently fails to load can be alerted on.
+	reloadTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "A counter of configuration reload attempts partitioned by source and result.",
+		},
+		[]string{"source", "result"},
+	)
+
+	reloadLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "config_reload_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful configuration reload per source.",
+		},
+		[]string{"source"},
+	)
+
+	// authnCacheRequests counts token review cache lookups, so the hit rate
+	// and the saved upstream calls can be observed per provider.
+	authnCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "authn_cache_requests_total",
+			Help: "A counter of token review cache lookups partitioned by provider and result (hit or miss).",
+		},
+		[]string{"provider", "result"},
+	)
+
+	// timedOutTotal counts requests answered with a timeout error because the
+	// handler exceeded the per-request deadline.
+	timedOutTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "requests_timed_out_total",
+			Help: "A counter of requests answered with a timeout error partitioned by handler.",
+		},
+		[]string{"handler"},
+	)
+
+	// rateLimitedTotal counts requests shed by the rate limiter, partitioned
+	// by handler and client, so the flooding client is directly visible.
+	rateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "requests_rate_limited_total",
+			Help: "A counter of requests rejected by the rate limiter partitioned by handler and client identity.",
+		},
+		[]string{"handler", "client"},
+	)
+
+	// clientCertRejectedTotal counts requests rejected for an invalid client
+	// certificate, partitioned by cause, so expired certificates and broken
+	// chains can be told apart from real authorization problems.
+	clientCertRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "client_cert_rejected_total",
+			Help: "A counter of requests rejected for an invalid client certificate partitioned by reason.",
+		},
+		[]string{"reason"},
+	)
+
+	// shadowDecisions counts subject access reviews re-evaluated against the
+	// secondary configuration, partitioned by result (match, diverged or
+	// error), so a candidate configuration can be judged before rollout.
+	shadowDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shadow_decisions_total",
+			Help: "A counter of shadow evaluations against the secondary configuration partitioned by result.",
+		},
+		[]string{"result"},
+	)
+
+	// configHash carries the checksum of the redacted canonical configuration
+	// as a label, so operators can confirm which configuration a replica runs.
+	configHash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "config_hash",
+			Help: "A gauge set to 1, labeled with the SHA-256 of the redacted canonical configuration.",
+		},
+		[]string{"hash"},
+	)
 )
 
 func init() {
 	// Register all of the metrics in the standard registry.
-	prometheus.MustRegister(version, inFlightGauge, counter, duration, responseSize, inFlightGaugeAuthz, counterAuthz)
+	prometheus.MustRegister(version, inFlightGauge, counter, duration, responseSize, errorCounter, inFlightGaugeAuthz, counterAuthz, decisionCounter, decisionCounterAuthz, providerDisabled, breakerTrips, reloadTotal, reloadLastSuccess, authnCacheRequests, timedOutTotal, rateLimitedTotal, clientCertRejectedTotal, shadowDecisions, configHash)
 }
@@ -0,0 +1,119 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitOptions configures request rate limits on the webhook endpoints.
+// Limits are opt-in: a zero QPS leaves the corresponding limit off.
+type RateLimitOptions struct {
+	// QPS caps the total request rate across all clients; 0 disables it
+	QPS float64
+	// Burst is the number of requests allowed to exceed the total rate
+	// momentarily
+	Burst int
+	// ClientQPS caps the request rate of a single client certificate common
+	// name; 0 disables it
+	ClientQPS float64
+	// ClientBurst is the per-client burst allowance
+	ClientBurst int
+}
+
+func NewRateLimitOptions() RateLimitOptions {
+	return RateLimitOptions{
+		Burst:       20,
+		ClientBurst: 10,
+	}
+}
+
+func (o *RateLimitOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.Float64Var(&o.QPS, "rate-limit-qps", o.QPS, "maximum requests per second across all clients on the tokenreviews and subjectaccessreviews endpoints. 0 disables the limit")
+	fs.IntVar(&o.Burst, "rate-limit-burst", o.Burst, "number of requests allowed to momentarily exceed rate-limit-qps")
+	fs.Float64Var(&o.ClientQPS, "rate-limit-client-qps", o.ClientQPS, "maximum requests per second per client certificate common name. 0 disables the limit")
+	fs.IntVar(&o.ClientBurst, "rate-limit-client-burst", o.ClientBurst, "number of requests allowed to momentarily exceed rate-limit-client-qps")
+}
+
+func (o *RateLimitOptions) Validate() []error {
+	var errs []error
+	if o.QPS < 0 {
+		errs = append(errs, errors.New("rate-limit-qps must be non-negative"))
+	}
+	if o.QPS > 0 && o.Burst <= 0 {
+		errs = append(errs, errors.New("rate-limit-burst must be positive when rate-limit-qps is set"))
+	}
+	if o.ClientQPS < 0 {
+		errs = append(errs, errors.New("rate-limit-client-qps must be non-negative"))
+	}
+	if o.ClientQPS > 0 && o.ClientBurst <= 0 {
+		errs = append(errs, errors.New("rate-limit-client-burst must be positive when rate-limit-client-qps is set"))
+	}
+	return errs
+}
+
+// rateLimiter enforces a global and a per-client token bucket over the
+// webhook endpoints, so one flooding controller cannot starve legitimate API
+// server traffic. Clients are keyed by certificate common name, which the
+// guard CA issued, so the key space is bounded.
+type rateLimiter struct {
+	global *rate.Limiter
+
+	lock        sync.Mutex
+	clients     map[string]*rate.Limiter
+	clientQPS   rate.Limit
+	clientBurst int
+}
+
+func newRateLimiter(o RateLimitOptions) *rateLimiter {
+	l := &rateLimiter{
+		clients:     map[string]*rate.Limiter{},
+		clientQPS:   rate.Limit(o.ClientQPS),
+		clientBurst: o.ClientBurst,
+	}
+	if o.QPS > 0 {
+		l.global = rate.NewLimiter(rate.Limit(o.QPS), o.Burst)
+	}
+	return l
+}
+
+// Allow reports whether a request of the named client may proceed. The
+// per-client limit is consumed first, so a client rejected for flooding does
+// not also drain the global budget of the others.
+func (l *rateLimiter) Allow(client string) bool {
+	if l == nil {
+		return true
+	}
+	if l.clientQPS > 0 {
+		l.lock.Lock()
+		lim, ok := l.clients[client]
+		if !ok {
+			lim = rate.NewLimiter(l.clientQPS, l.clientBurst)
+			l.clients[client] = lim
+		}
+		l.lock.Unlock()
+
+		if !lim.Allow() {
+			return false
+		}
+	}
+	return l.global == nil || l.global.Allow()
+}
@@ -0,0 +1,79 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterDisabled(t *testing.T) {
+	var nilLimiter *rateLimiter
+	assert.True(t, nilLimiter.Allow("kube-apiserver"))
+
+	l := newRateLimiter(NewRateLimitOptions())
+	for i := 0; i < 100; i++ {
+		assert.True(t, l.Allow("kube-apiserver"))
+	}
+}
+
+func TestRateLimiterGlobal(t *testing.T) {
+	// a tiny refill rate makes the bucket effectively fixed for the test
+	l := newRateLimiter(RateLimitOptions{QPS: 0.001, Burst: 2})
+
+	assert.True(t, l.Allow("client-a"))
+	assert.True(t, l.Allow("client-b"))
+	assert.False(t, l.Allow("client-c"), "global burst is exhausted")
+}
+
+func TestRateLimiterPerClient(t *testing.T) {
+	l := newRateLimiter(RateLimitOptions{ClientQPS: 0.001, ClientBurst: 1})
+
+	assert.True(t, l.Allow("flooder"))
+	assert.False(t, l.Allow("flooder"), "per-client burst is exhausted")
+	assert.True(t, l.Allow("kube-apiserver"), "other clients keep their own budget")
+}
+
+func TestRateLimiterClientDoesNotDrainGlobal(t *testing.T) {
+	l := newRateLimiter(RateLimitOptions{QPS: 0.001, Burst: 2, ClientQPS: 0.001, ClientBurst: 1})
+
+	assert.True(t, l.Allow("flooder"))
+	assert.False(t, l.Allow("flooder"))
+	assert.False(t, l.Allow("flooder"))
+	// only one global token was spent by the flooder
+	assert.True(t, l.Allow("kube-apiserver"))
+}
+
+func TestRateLimitOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RateLimitOptions
+		errs int
+	}{
+		{"defaults", NewRateLimitOptions(), 0},
+		{"enabled", RateLimitOptions{QPS: 100, Burst: 20, ClientQPS: 10, ClientBurst: 5}, 0},
+		{"negative qps", RateLimitOptions{QPS: -1, Burst: 20}, 1},
+		{"qps without burst", RateLimitOptions{QPS: 100}, 1},
+		{"client qps without burst", RateLimitOptions{ClientQPS: 10}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Len(t, test.opts.Validate(), test.errs)
+		})
+	}
+}
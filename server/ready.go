@@ -0,0 +1,141 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/appscode/guard/auth/providers/github"
+	"github.com/appscode/guard/auth/providers/gitlab"
+	"github.com/appscode/guard/auth/providers/google"
+	"github.com/appscode/guard/auth/providers/keycloak"
+	"github.com/appscode/guard/auth/providers/ldap"
+	azureauthz "github.com/appscode/guard/authz/providers/azure"
+
+	"github.com/pkg/errors"
+)
+
+const readyCheckTimeout = 5 * time.Second
+
+// readinessCheck is one live probe of an upstream dependency.
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// readinessChecker backs /readyz. Unlike /healthz, which only reports that
+// the process is up, it verifies that guard can actually answer reviews:
+// upstream provider endpoints are reachable and long-lived state (tokens,
+// caches) is initialized.
+type readinessChecker struct {
+	checks []readinessCheck
+}
+
+func (c *readinessChecker) register(name string, check func() error) {
+	c.checks = append(c.checks, readinessCheck{name: name, check: check})
+}
+
+func (c *readinessChecker) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		status := make(map[string]string, len(c.checks))
+		ready := true
+		for _, rc := range c.checks {
+			if err := rc.check(); err != nil {
+				status[rc.name] = err.Error()
+				ready = false
+			} else {
+				status[rc.name] = "ok"
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-content-type-options", "nosniff")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	}
+}
+
+// newReadinessChecker registers live checks for every configured provider.
+func (s *Server) newReadinessChecker() *readinessChecker {
+	ready := &readinessChecker{}
+	authOpts := s.AuthRecommendedOptions
+
+	if authOpts.AuthProvider.Has(github.OrgType) {
+		ready.register(github.OrgType, reachableCheck(urlOrDefault(authOpts.Github.BaseUrl, "https://api.github.com")))
+	}
+	if authOpts.AuthProvider.Has(gitlab.OrgType) {
+		ready.register(gitlab.OrgType, reachableCheck(urlOrDefault(authOpts.Gitlab.BaseUrl, "https://gitlab.com")))
+	}
+	if authOpts.AuthProvider.Has(google.OrgType) {
+		ready.register(google.OrgType, reachableCheck("https://www.googleapis.com/oauth2/v1/certs"))
+	}
+	if authOpts.AuthProvider.Has(keycloak.OrgType) {
+		ready.register(keycloak.OrgType, reachableCheck(strings.TrimSuffix(authOpts.Keycloak.BaseUrl, "/")+"/realms/"+authOpts.Keycloak.Realm))
+	}
+	if authOpts.AuthProvider.Has(ldap.OrgType) {
+		addr := net.JoinHostPort(authOpts.LDAP.ServerAddress, authOpts.LDAP.ServerPort)
+		ready.register(ldap.OrgType, func() error {
+			conn, err := net.DialTimeout("tcp", addr, readyCheckTimeout)
+			if err != nil {
+				return errors.Wrap(err, "ldap server unreachable")
+			}
+			return conn.Close()
+		})
+	}
+
+	if s.AuthzRecommendedOptions.AuthzProvider.Has(azureauthz.OrgType) {
+		ready.register("azure-arm-token", func() error {
+			client, err := azureauthz.New(s.AuthzRecommendedOptions.Azure, authOpts.Azure)
+			if err != nil {
+				return err
+			}
+			if h, ok := client.(interface{ Healthy() error }); ok {
+				return h.Healthy()
+			}
+			return nil
+		})
+	}
+
+	return ready
+}
+
+// reachableCheck reports the endpoint reachable when it answers any HTTP
+// status; provider-level auth failures are not readiness failures.
+func reachableCheck(url string) func() error {
+	client := &http.Client{Timeout: readyCheckTimeout}
+	return func() error {
+		resp, err := client.Get(url)
+		if err != nil {
+			return errors.Wrapf(err, "%s unreachable", url)
+		}
+		return resp.Body.Close()
+	}
+}
+
+func urlOrDefault(url, def string) string {
+	if url != "" {
+		return url
+	}
+	return def
+}
@@ -0,0 +1,54 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	ready := &readinessChecker{}
+	ready.register("up", func() error { return nil })
+
+	rec := httptest.NewRecorder()
+	ready.handler()(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	status := map[string]string{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, "ok", status["up"])
+}
+
+func TestReadinessHandlerFailingCheck(t *testing.T) {
+	ready := &readinessChecker{}
+	ready.register("up", func() error { return nil })
+	ready.register("down", func() error { return errors.New("idp unreachable") })
+
+	rec := httptest.NewRecorder()
+	ready.handler()(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	status := map[string]string{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, "ok", status["up"])
+	assert.Equal(t, "idp unreachable", status["down"])
+}
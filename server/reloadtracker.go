@@ -0,0 +1,155 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// reloadHistoryLimit bounds the number of reload events kept in memory.
+const reloadHistoryLimit = 32
+
+// ReloadEvent records one attempt to reload a configuration source.
+type ReloadEvent struct {
+	// Source names the configuration that was reloaded, e.g. "token-auth-file".
+	Source  string    `json:"source"`
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	// Diff is a redacted summary of what changed, e.g. the user names added
+	// to or removed from the token file. It never contains secret material.
+	Diff string `json:"diff,omitempty"`
+}
+
+// reloadTracker keeps the most recent reload events so operators can see when
+// a rotated token file or certificate failed to load, instead of discovering
+// it from stale authentication decisions.
+type reloadTracker struct {
+	lock   sync.RWMutex
+	events []ReloadEvent
+}
+
+func newReloadTracker() *reloadTracker {
+	return &reloadTracker{}
+}
+
+// Record stores the outcome of a reload attempt and updates the reload
+// metrics. diff may be empty when the source has no redacted summary.
+func (t *reloadTracker) Record(source, diff string, err error) {
+	e := ReloadEvent{
+		Source:  source,
+		Time:    time.Now().UTC(),
+		Success: err == nil,
+		Diff:    diff,
+	}
+	if err != nil {
+		e.Error = err.Error()
+		reloadTotal.WithLabelValues(source, "failure").Inc()
+		glog.Errorf("Reload of %s failed, previous configuration stays in effect: %s", source, err)
+	} else {
+		reloadTotal.WithLabelValues(source, "success").Inc()
+		reloadLastSuccess.WithLabelValues(source).SetToCurrentTime()
+		glog.Infof("Reloaded %s: %s", source, diff)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, e)
+	if len(t.events) > reloadHistoryLimit {
+		t.events = t.events[len(t.events)-reloadHistoryLimit:]
+	}
+}
+
+// wrap decorates a reload function so every attempt is recorded. snapshot, if
+// non-nil, returns a redacted view of the source (e.g. user names) and is
+// taken before and after the reload to produce the diff.
+func (t *reloadTracker) wrap(source string, reload func() error, snapshot func() []string) func() error {
+	return func() error {
+		var before []string
+		if snapshot != nil {
+			before = snapshot()
+		}
+		err := reload()
+		diff := ""
+		if err == nil && snapshot != nil {
+			diff = redactedDiff(before, snapshot())
+		}
+		t.Record(source, diff, err)
+		return err
+	}
+}
+
+// Events returns the recorded reload events, newest first.
+func (t *reloadTracker) Events() []ReloadEvent {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	out := make([]ReloadEvent, len(t.events))
+	for i, e := range t.events {
+		out[len(t.events)-1-i] = e
+	}
+	return out
+}
+
+// handler serves the recorded reload events as JSON.
+func (t *reloadTracker) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-content-type-options", "nosniff")
+		if err := json.NewEncoder(w).Encode(t.Events()); err != nil {
+			glog.Errorf("Failed to write reload events: %s", err)
+		}
+	}
+}
+
+// redactedDiff summarizes the change between two snapshots of a source as the
+// entries added and removed, e.g. "+alice, -bob (4 entries)".
+func redactedDiff(before, after []string) string {
+	old := map[string]struct{}{}
+	for _, e := range before {
+		old[e] = struct{}{}
+	}
+	cur := map[string]struct{}{}
+	for _, e := range after {
+		cur[e] = struct{}{}
+	}
+
+	var changes []string
+	for _, e := range after {
+		if _, found := old[e]; !found {
+			changes = append(changes, "+"+e)
+		}
+	}
+	for _, e := range before {
+		if _, found := cur[e]; !found {
+			changes = append(changes, "-"+e)
+		}
+	}
+	sort.Strings(changes)
+
+	if len(changes) == 0 {
+		return fmt.Sprintf("no change (%d entries)", len(cur))
+	}
+	return fmt.Sprintf("%s (%d entries)", strings.Join(changes, ", "), len(cur))
+}
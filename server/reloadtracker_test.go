@@ -0,0 +1,122 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadTrackerWrap(t *testing.T) {
+	tracker := newReloadTracker()
+	entries := []string{"alice", "bob"}
+
+	reload := tracker.wrap("test-source", func() error {
+		entries = []string{"alice", "carol"}
+		return nil
+	}, func() []string { return entries })
+
+	err := reload()
+	assert.NoError(t, err)
+
+	events := tracker.Events()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "test-source", events[0].Source)
+		assert.True(t, events[0].Success)
+		assert.Equal(t, "+carol, -bob (2 entries)", events[0].Diff)
+		assert.Empty(t, events[0].Error)
+	}
+}
+
+func TestReloadTrackerWrapFailure(t *testing.T) {
+	tracker := newReloadTracker()
+
+	reload := tracker.wrap("test-source", func() error {
+		return errors.New("file is ill formatted")
+	}, func() []string { return []string{"alice"} })
+
+	err := reload()
+	assert.Error(t, err)
+
+	events := tracker.Events()
+	if assert.Len(t, events, 1) {
+		assert.False(t, events[0].Success)
+		assert.Contains(t, events[0].Error, "ill formatted")
+		// no diff is recorded for a failed reload; the old config stays
+		assert.Empty(t, events[0].Diff)
+	}
+}
+
+func TestReloadTrackerHistoryLimit(t *testing.T) {
+	tracker := newReloadTracker()
+	for i := 0; i < reloadHistoryLimit+5; i++ {
+		tracker.Record(fmt.Sprintf("source-%d", i), "", nil)
+	}
+
+	events := tracker.Events()
+	assert.Len(t, events, reloadHistoryLimit)
+	// newest first, oldest entries dropped
+	assert.Equal(t, fmt.Sprintf("source-%d", reloadHistoryLimit+4), events[0].Source)
+	assert.Equal(t, "source-5", events[len(events)-1].Source)
+}
+
+func TestReloadTrackerHandler(t *testing.T) {
+	tracker := newReloadTracker()
+	tracker.Record("token-auth-file", "+alice (1 entries)", nil)
+	tracker.Record("serving-certs", "", errors.New("failed to load server certificate"))
+
+	req := httptest.NewRequest(http.MethodGet, "/reloadz", nil)
+	w := httptest.NewRecorder()
+	tracker.handler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var events []ReloadEvent
+	err := json.NewDecoder(w.Body).Decode(&events)
+	if assert.NoError(t, err) && assert.Len(t, events, 2) {
+		assert.Equal(t, "serving-certs", events[0].Source)
+		assert.False(t, events[0].Success)
+		assert.Equal(t, "token-auth-file", events[1].Source)
+		assert.True(t, events[1].Success)
+	}
+}
+
+func TestRedactedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   []string
+		after    []string
+		expected string
+	}{
+		{"no change", []string{"a", "b"}, []string{"a", "b"}, "no change (2 entries)"},
+		{"added", []string{"a"}, []string{"a", "b"}, "+b (2 entries)"},
+		{"removed", []string{"a", "b"}, []string{"a"}, "-b (1 entries)"},
+		{"replaced", []string{"a"}, []string{"b"}, "+b, -a (1 entries)"},
+		{"from empty", nil, []string{"a"}, "+a (1 entries)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, redactedDiff(test.before, test.after))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/appscode/guard/authz"
+
+	"github.com/golang/glog"
+)
+
+// credentialFingerprintKey is the store key holding the fingerprint of the
+// identity-bearing configuration the cached decisions were made under.
+const credentialFingerprintKey = "credential-fingerprint"
+
+type storedFingerprint struct {
+	Hash string `json:"hash"`
+}
+
+// credentialFingerprint hashes the configuration that determines which
+// identity guard acts as against ARM/Graph. Cached decisions are only valid
+// under the identity that produced them.
+func (s *Server) credentialFingerprint() string {
+	authAzure := s.AuthRecommendedOptions.Azure
+	authzAzure := s.AuthzRecommendedOptions.Azure
+	parts := []string{
+		authAzure.Environment,
+		authAzure.TenantID,
+		authAzure.ClientID,
+		authAzure.ClientSecret,
+		authAzure.AuthMode,
+		authAzure.AKSTokenURL,
+		authzAzure.AuthzMode,
+		authzAzure.ResourceId,
+		strings.Join(authzAzure.ResourceIds, ","),
+		authzAzure.AKSAuthzTokenURL,
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyCredentialFingerprint compares the current credential fingerprint
+// with the one stored next to the cached decisions. On a mismatch — a tenant
+// or credential rotation — the cache is flushed, so decisions made under the
+// old identity cannot persist; token state is per-process and minted fresh.
+func (s *Server) verifyCredentialFingerprint(store authz.Store) {
+	current := s.credentialFingerprint()
+
+	var stored storedFingerprint
+	found, err := store.Get(credentialFingerprintKey, &stored)
+	if err == nil && found && stored.Hash != current {
+		glog.Warningf("credential/tenant configuration changed since the cached decisions were made; clearing authz cache")
+		if flusher, ok := store.(interface{ Flush() error }); ok {
+			if err := flusher.Flush(); err != nil {
+				glog.Fatalf("Failed to clear authz cache after credential rotation. Error:%s", err)
+			}
+		}
+	}
+
+	if err := store.Set(credentialFingerprintKey, storedFingerprint{Hash: current}); err != nil {
+		glog.Errorf("Failed to store credential fingerprint: %s", err)
+	}
+}
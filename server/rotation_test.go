@@ -0,0 +1,64 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/appscode/guard/authz"
+	"github.com/appscode/guard/authz/providers/azure/data"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAuthzStore(t *testing.T) authz.Store {
+	store, err := data.NewDataStore(data.Options{
+		HardMaxCacheSize:   1,
+		Shards:             1,
+		LifeWindow:         1 * time.Minute,
+		CleanWindow:        1 * time.Minute,
+		MaxEntriesInWindow: 10,
+		MaxEntrySize:       5,
+	})
+	assert.Nil(t, err)
+	return store
+}
+
+func TestVerifyCredentialFingerprintFlushesOnRotation(t *testing.T) {
+	s := &Server{
+		AuthRecommendedOptions:  NewAuthRecommendedOptions(),
+		AuthzRecommendedOptions: NewAuthzRecommendedOptions(),
+	}
+	s.AuthRecommendedOptions.Azure.TenantID = "tenant-a"
+
+	store := newTestAuthzStore(t)
+	s.verifyCredentialFingerprint(store)
+	assert.Nil(t, store.Set("some-verdict", true))
+
+	// same identity: cached entries survive
+	s.verifyCredentialFingerprint(store)
+	var cached bool
+	found, _ := store.Get("some-verdict", &cached)
+	assert.True(t, found)
+
+	// rotated tenant: cache is cleared
+	s.AuthRecommendedOptions.Azure.TenantID = "tenant-b"
+	s.verifyCredentialFingerprint(store)
+	found, _ = store.Get("some-verdict", &cached)
+	assert.False(t, found)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// secretRefPattern matches $(SECRET_REF:namespace/name/key) placeholders in
+// flag values.
+var secretRefPattern = regexp.MustCompile(`\$\(SECRET_REF:([^)]*)\)`)
+
+// secretKeyGetter returns the value of one key of a Kubernetes Secret. It is
+// a narrow seam over the in-cluster client, so tests can resolve placeholders
+// without a cluster.
+type secretKeyGetter func(namespace, name, key string) (string, error)
+
+// ResolveSecretRefs replaces $(SECRET_REF:namespace/name/key) placeholders in
+// flag values with the named key of that Kubernetes Secret, read via the
+// in-cluster client. This lets the installer pass credentials by reference
+// instead of templating them into args or mounted files. The client is only
+// created when a placeholder is present, so out-of-cluster runs without
+// references are unaffected.
+func ResolveSecretRefs(fs *pflag.FlagSet) error {
+	var getter secretKeyGetter
+	return resolveSecretRefs(fs, func(namespace, name, key string) (string, error) {
+		if getter == nil {
+			cfg, err := rest.InClusterConfig()
+			if err != nil {
+				return "", errors.Wrap(err, "failed to create in-cluster config to resolve secret references")
+			}
+			kc, err := kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return "", errors.Wrap(err, "failed to create kubernetes client to resolve secret references")
+			}
+			getter = clusterSecretKeyGetter(kc)
+		}
+		return getter(namespace, name, key)
+	})
+}
+
+func resolveSecretRefs(fs *pflag.FlagSet, getter secretKeyGetter) error {
+	var retErr error
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if retErr != nil {
+			return
+		}
+		if sv, ok := flag.Value.(pflag.SliceValue); ok {
+			values := sv.GetSlice()
+			changed := false
+			for i, value := range values {
+				resolved, err := resolveSecretRefValue(value, getter)
+				if err != nil {
+					retErr = errors.Wrapf(err, "flag %q", flag.Name)
+					return
+				}
+				if resolved != value {
+					values[i] = resolved
+					changed = true
+				}
+			}
+			if changed {
+				if err := sv.Replace(values); err != nil {
+					retErr = errors.Wrapf(err, "flag %q", flag.Name)
+				}
+			}
+			return
+		}
+		value := flag.Value.String()
+		resolved, err := resolveSecretRefValue(value, getter)
+		if err != nil {
+			retErr = errors.Wrapf(err, "flag %q", flag.Name)
+			return
+		}
+		if resolved != value {
+			if err := flag.Value.Set(resolved); err != nil {
+				retErr = errors.Wrapf(err, "flag %q", flag.Name)
+			}
+		}
+	})
+	return retErr
+}
+
+// resolveSecretRefValue replaces every placeholder in a single flag value.
+// Regexp replacement cannot propagate errors, so matches are walked manually.
+func resolveSecretRefValue(value string, getter secretKeyGetter) (string, error) {
+	matches := secretRefPattern.FindAllStringSubmatchIndex(value, -1)
+	if len(matches) == 0 {
+		return value, nil
+	}
+	var out strings.Builder
+	last := 0
+	for _, m := range matches {
+		ref := value[m[2]:m[3]]
+		parts := strings.SplitN(ref, "/", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return "", errors.Errorf("invalid secret reference %q, expected namespace/name/key", ref)
+		}
+		secretValue, err := getter(parts[0], parts[1], parts[2])
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value[last:m[0]])
+		out.WriteString(secretValue)
+		last = m[1]
+	}
+	out.WriteString(value[last:])
+	return out.String(), nil
+}
+
+// clusterSecretKeyGetter reads secret keys from the cluster, caching each
+// secret so several placeholders against the same secret cost one GET.
+func clusterSecretKeyGetter(kc kubernetes.Interface) secretKeyGetter {
+	type cacheKey struct {
+		namespace, name string
+	}
+	cache := map[cacheKey]map[string][]byte{}
+	return func(namespace, name, key string) (string, error) {
+		data, found := cache[cacheKey{namespace, name}]
+		if !found {
+			secret, err := kc.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to read secret %s/%s", namespace, name)
+			}
+			data = secret.Data
+			cache[cacheKey{namespace, name}] = data
+		}
+		secretValue, found := data[key]
+		if !found {
+			return "", errors.Errorf("secret %s/%s has no key %q", namespace, name, key)
+		}
+		return string(secretValue), nil
+	}
+}
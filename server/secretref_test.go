@@ -0,0 +1,100 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeSecretKeyGetter(secrets map[string]map[string]string) secretKeyGetter {
+	return func(namespace, name, key string) (string, error) {
+		data, found := secrets[namespace+"/"+name]
+		if !found {
+			return "", fmt.Errorf("secret %s/%s not found", namespace, name)
+		}
+		value, found := data[key]
+		if !found {
+			return "", fmt.Errorf("secret %s/%s has no key %q", namespace, name, key)
+		}
+		return value, nil
+	}
+}
+
+func TestResolveSecretRefs(t *testing.T) {
+	getter := fakeSecretKeyGetter(map[string]map[string]string{
+		"kube-system/guard-github-auth": {"token": "s3cr3t"},
+		"kube-system/guard-azure-auth":  {"client-id": "id-123", "client-secret": "pa55"},
+	})
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	token := fs.String("github.token", "", "")
+	clientID := fs.String("azure.client-id", "", "")
+	plain := fs.String("azure.tenant-id", "tenant-abc", "")
+	assert.Nil(t, fs.Parse([]string{
+		"--github.token=$(SECRET_REF:kube-system/guard-github-auth/token)",
+		"--azure.client-id=prefix-$(SECRET_REF:kube-system/guard-azure-auth/client-id)",
+	}))
+
+	assert.Nil(t, resolveSecretRefs(fs, getter))
+	assert.Equal(t, "s3cr3t", *token)
+	assert.Equal(t, "prefix-id-123", *clientID)
+	// values without a placeholder stay untouched
+	assert.Equal(t, "tenant-abc", *plain)
+}
+
+func TestResolveSecretRefsSlice(t *testing.T) {
+	getter := fakeSecretKeyGetter(map[string]map[string]string{
+		"kube-system/guard-ldap-auth": {"bind-password": "hunter2"},
+	})
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	values := fs.StringSlice("some-list", nil, "")
+	assert.Nil(t, fs.Parse([]string{
+		"--some-list=plain",
+		"--some-list=$(SECRET_REF:kube-system/guard-ldap-auth/bind-password)",
+	}))
+
+	assert.Nil(t, resolveSecretRefs(fs, getter))
+	assert.Equal(t, []string{"plain", "hunter2"}, *values)
+}
+
+func TestResolveSecretRefsErrors(t *testing.T) {
+	getter := fakeSecretKeyGetter(map[string]map[string]string{
+		"kube-system/guard-github-auth": {"token": "s3cr3t"},
+	})
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"malformed reference", "$(SECRET_REF:missing-parts)"},
+		{"missing secret", "$(SECRET_REF:kube-system/no-such-secret/token)"},
+		{"missing key", "$(SECRET_REF:kube-system/guard-github-auth/no-such-key)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			fs.String("github.token", "", "")
+			assert.Nil(t, fs.Set("github.token", test.value))
+			assert.NotNil(t, resolveSecretRefs(fs, getter))
+		})
+	}
+}
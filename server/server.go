@@ -18,8 +18,6 @@ package server
 
 import (
 	"crypto/tls"
-	"crypto/x509"
-	"io/ioutil"
 	"net/http"
 	_ "net/http/pprof"
 	"path/filepath"
@@ -28,12 +26,20 @@ import (
 	"github.com/appscode/go/ntp"
 	"github.com/appscode/go/signals"
 	v "github.com/appscode/go/version"
+	"github.com/appscode/guard/auth"
 	"github.com/appscode/guard/auth/providers/token"
+	"github.com/appscode/guard/authz/mutator"
 	"github.com/appscode/guard/authz/providers/azure"
 	"github.com/appscode/guard/authz/providers/azure/data"
+	authzwebhook "github.com/appscode/guard/authz/providers/webhook"
+	"github.com/appscode/guard/util/featuregates"
+	"github.com/appscode/guard/util/httpclient"
+	"github.com/appscode/guard/util/logs"
+	"github.com/appscode/guard/util/tracing"
 	"github.com/appscode/pat"
 
 	"github.com/golang/glog"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/pflag"
@@ -45,6 +51,24 @@ type Server struct {
 	AuthRecommendedOptions  *AuthRecommendedOptions
 	AuthzRecommendedOptions *AuthzRecommendedOptions
 	TokenAuthenticator      *token.Authenticator
+	AuditLogger             *AuditLogger
+	Breaker                 *ProviderBreaker
+	AuthnCache              *authnCache
+	RateLimiter             *rateLimiter
+	// ConfigPath and ConfigFlags point at the --config file and the flag set
+	// it was applied to, so the server can re-apply the file when it changes
+	// on disk. An empty path disables config file reload.
+	ConfigPath  string
+	ConfigFlags *pflag.FlagSet
+	// Tenants routes requests to per-tenant provider configurations by the
+	// client certificate common name; nil serves every client from the
+	// global options. Built from the tenants section of the config file.
+	Tenants *TenantRouter
+	// ShadowAuthOptions and ShadowAuthzOptions hold a secondary configuration
+	// every subject access review is re-evaluated against in shadow; nil
+	// disables shadow mode. Set by `guard shadow`.
+	ShadowAuthOptions  *AuthRecommendedOptions
+	ShadowAuthzOptions *AuthzRecommendedOptions
 }
 
 func (s *Server) AddFlags(fs *pflag.FlagSet) {
@@ -61,6 +85,23 @@ func (s Server) ListenAndServe() {
 		glog.Fatal(errs)
 	}
 
+	logs.Init(s.AuthRecommendedOptions.Logging)
+	if err := tracing.Init(s.AuthRecommendedOptions.Tracing); err != nil {
+		glog.Fatal(err)
+	}
+	if err := featuregates.Init(s.AuthRecommendedOptions.FeatureGates); err != nil {
+		glog.Fatal(err)
+	}
+	buildMetadataLabels()
+
+	// install the global outbound proxy and CA bundle before any provider
+	// client is built
+	httpclient.SetDefaultProxy(s.AuthRecommendedOptions.Proxy)
+	httpclient.SetDefaultCABundle(s.AuthRecommendedOptions.CABundle)
+	auth.SetPopulateUID(s.AuthRecommendedOptions.PopulateUID)
+
+	stopCh := signals.SetupSignalHandler()
+
 	if s.AuthRecommendedOptions.NTP.Enabled() {
 		ticker := time.NewTicker(s.AuthRecommendedOptions.NTP.Interval)
 		go func() {
@@ -72,28 +113,38 @@ func (s Server) ListenAndServe() {
 		}()
 	}
 
+	// every token file and certificate reload goes through the tracker, so
+	// failed reloads show up in metrics and /reloadz instead of only in logs
+	reloads := newReloadTracker()
+
 	if s.AuthRecommendedOptions.Token.AuthFile != "" {
 		s.TokenAuthenticator = token.New(s.AuthRecommendedOptions.Token)
 
-		err := s.TokenAuthenticator.Configure()
-		if err != nil {
+		reloadTokenFile := reloads.wrap("token-auth-file", s.TokenAuthenticator.Configure, s.TokenAuthenticator.Users)
+		if err := reloadTokenFile(); err != nil {
 			glog.Fatalln(err)
 		}
 		if meta.PossiblyInCluster() {
 			w := fsnotify.Watcher{
 				WatchDir: filepath.Dir(s.AuthRecommendedOptions.Token.AuthFile),
-				Reload: func() error {
-					return s.TokenAuthenticator.Configure()
-				},
+				Reload:   reloadTokenFile,
 			}
-			stopCh := signals.SetupSignalHandler()
-			err = w.Run(stopCh)
+			err := w.Run(stopCh)
 			if err != nil {
 				glog.Fatal(err)
 			}
 		}
 	}
 
+	auditLogger, err := NewAuditLogger(s.AuthRecommendedOptions.Audit)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	s.AuditLogger = auditLogger
+	s.Breaker = NewProviderBreaker(s.AuthRecommendedOptions.Breaker)
+	s.AuthnCache = newAuthnCache(s.AuthRecommendedOptions.AuthnCache)
+	s.RateLimiter = newRateLimiter(s.AuthRecommendedOptions.RateLimit)
+
 	// loading file read related data
 	if err := s.AuthRecommendedOptions.LDAP.Configure(); err != nil {
 		glog.Fatal(err)
@@ -101,6 +152,13 @@ func (s Server) ListenAndServe() {
 	if err := s.AuthRecommendedOptions.Google.Configure(); err != nil {
 		glog.Fatal(err)
 	}
+	// re-run the configure steps above when the files they read change, so
+	// rotated credentials and config file edits take effect without a restart
+	if meta.PossiblyInCluster() {
+		if err := s.watchProviderConfig(reloads, stopCh); err != nil {
+			glog.Fatal(err)
+		}
+	}
 
 	/*
 		Ref:
@@ -109,14 +167,25 @@ func (s Server) ListenAndServe() {
 		 - http://www.bite-code.com/2015/06/25/tls-mutual-auth-in-golang/
 		 - http://www.hydrogen18.com/blog/your-own-pki-tls-golang.html
 	*/
-	caCert, err := ioutil.ReadFile(s.AuthRecommendedOptions.SecureServing.CACertFile)
+	certReloader, err := NewCertReloader(s.AuthRecommendedOptions.SecureServing)
 	if err != nil {
 		glog.Fatal(err)
 	}
-	caCertPool := x509.NewCertPool()
-	ok := caCertPool.AppendCertsFromPEM(caCert)
-	if !ok {
-		glog.Fatal("Failed to add CA cert in CertPool for guard server")
+	if meta.PossiblyInCluster() {
+		watchDirs := map[string]struct{}{
+			filepath.Dir(s.AuthRecommendedOptions.SecureServing.CertFile):   {},
+			filepath.Dir(s.AuthRecommendedOptions.SecureServing.CACertFile): {},
+		}
+		reloadCerts := reloads.wrap("serving-certs", certReloader.Reload, nil)
+		for dir := range watchDirs {
+			w := fsnotify.Watcher{
+				WatchDir: dir,
+				Reload:   reloadCerts,
+			}
+			if err := w.Run(stopCh); err != nil {
+				glog.Fatal(err)
+			}
+		}
 	}
 
 	tlsConfig := &tls.Config{
@@ -131,11 +200,18 @@ func (s Server) ListenAndServe() {
 			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 		},
-		// ClientAuth: tls.VerifyClientCertIfGiven needed to pass healthz check
-		ClientAuth: tls.VerifyClientCertIfGiven,
-		ClientCAs:  caCertPool,
-		NextProtos: []string{"h2", "http/1.1"},
+		// certificates are requested but not verified during the handshake;
+		// withClientCertVerification checks the chain per request instead, so
+		// an expired certificate or broken chain gets a distinct 401 reason
+		// and counter rather than a generic handshake failure. This also lets
+		// the healthz check pass without a certificate.
+		ClientAuth:     tls.RequestClientCert,
+		GetCertificate: certReloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
 	}
+	// serve new connections with the latest client CA pool, so CA bundle
+	// rotation takes effect without a restart
+	tlsConfig.GetConfigForClient = certReloader.GetConfigForClient(tlsConfig)
 
 	m := pat.New()
 
@@ -143,56 +219,160 @@ func (s Server) ListenAndServe() {
 	// ref:
 	// - https://godoc.org/github.com/prometheus/client_golang/prometheus/promhttp#example-InstrumentHandlerDuration
 	// - https://github.com/brancz/prometheus-example-app/blob/master/main.go#L44:28
+	// the handler timeout sits innermost, so a timed out request still shows
+	// up in the duration and counter metrics with its real status code
+	authnHandler := withClientCertVerification(withHandlerTimeout(&s, s.AuthRecommendedOptions.Timeout.HandlerTimeout, "tokenreviews", timeoutTokenReview), certReloader)
 	handler := promhttp.InstrumentHandlerInFlight(inFlightGauge,
 		promhttp.InstrumentHandlerDuration(duration.MustCurryWith(prometheus.Labels{"handler": "tokenreviews"}),
 			promhttp.InstrumentHandlerCounter(counter,
-				promhttp.InstrumentHandlerResponseSize(responseSize.MustCurryWith(prometheus.Labels{"handler": "tokenreviews"}), &s),
+				promhttp.InstrumentHandlerResponseSize(responseSize.MustCurryWith(prometheus.Labels{"handler": "tokenreviews"}), authnHandler),
 			),
 		),
 	)
 
 	m.Post("/tokenreviews", handler)
 	m.Get("/metrics", promhttp.Handler())
-	m.Get("/healthz", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		w.WriteHeader(200)
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("x-content-type-options", "nosniff")
-		err := json.NewEncoder(w).Encode(v.Version)
+	m.Get("/healthz", healthzHandler())
+	m.Get("/openapi", openAPIHandler())
+	// recent reload history; diffs are redacted to user names, so no token
+	// material crosses this endpoint
+	m.Get("/reloadz", reloads.handler())
+
+	// debug endpoints expose sensitive data; gate them behind cluster RBAC
+	// with a delegated SubjectAccessReview for the calling identity
+	var debugGate func(http.Handler) http.Handler
+	if meta.PossiblyInCluster() {
+		gate, err := newDebugGate(certReloader)
 		if err != nil {
-			glog.Fatal(err)
+			glog.Errorf("Skipping debug endpoints: %s", err)
+		} else {
+			debugGate = gate
+			m.Get("/debug/pprof/", gate(http.DefaultServeMux))
 		}
-	}))
+	}
 
 	glog.Infoln("setting up authz providers")
+	ready := s.newReadinessChecker()
 	if len(s.AuthzRecommendedOptions.AuthzProvider.Providers) > 0 {
 		authzhandler := Authzhandler{
 			AuthRecommendedOptions:  s.AuthRecommendedOptions,
-			AuthzRecommendedOptions: s.AuthzRecommendedOptions}
+			AuthzRecommendedOptions: s.AuthzRecommendedOptions,
+			AuditLogger:             s.AuditLogger,
+			RateLimiter:             s.RateLimiter,
+			Tenants:                 s.Tenants}
+		if s.AuthzRecommendedOptions.Mutator.MutatorFile != "" {
+			authzhandler.Mutator, err = mutator.New(s.AuthzRecommendedOptions.Mutator)
+			if err != nil {
+				glog.Fatal(err)
+			}
+		}
+		if s.ShadowAuthOptions != nil && s.ShadowAuthzOptions != nil {
+			authzhandler.Shadow, err = NewShadowEvaluator(s.ShadowAuthOptions, s.ShadowAuthzOptions)
+			if err != nil {
+				glog.Fatal(err)
+			}
+			glog.Infoln("shadow mode enabled; divergent decisions will be logged")
+		}
+		authzTimeoutHandler := withClientCertVerification(withHandlerTimeout(&authzhandler, s.AuthRecommendedOptions.Timeout.HandlerTimeout, "subjectaccessreviews", timeoutSubjectAccessReview), certReloader)
 		authzPromHandler := promhttp.InstrumentHandlerInFlight(inFlightGaugeAuthz,
 			promhttp.InstrumentHandlerDuration(duration.MustCurryWith(prometheus.Labels{"handler": "subjectaccessreviews"}),
 				promhttp.InstrumentHandlerCounter(counterAuthz,
-					promhttp.InstrumentHandlerResponseSize(responseSize.MustCurryWith(prometheus.Labels{"handler": "subjectaccessreview"}), &authzhandler),
+					promhttp.InstrumentHandlerResponseSize(responseSize.MustCurryWith(prometheus.Labels{"handler": "subjectaccessreview"}), authzTimeoutHandler),
 				),
 			),
 		)
 
 		m.Post("/subjectaccessreviews", authzPromHandler)
 
-		if s.AuthzRecommendedOptions.AuthzProvider.Has(azure.OrgType) {
-			options := data.DefaultOptions
-			authzhandler.Store, err = data.NewDataStore(options)
+		if s.AuthzRecommendedOptions.AuthzProvider.Has(azure.OrgType) || s.AuthzRecommendedOptions.AuthzProvider.Has(authzwebhook.OrgType) {
+			switch s.AuthzRecommendedOptions.CacheStore {
+			case RedisCacheStore:
+				authzhandler.Store, err = data.NewRedisStore(s.AuthzRecommendedOptions.Redis)
+			default:
+				authzhandler.Store, err = data.NewDataStore(data.DefaultOptions)
+			}
 			if authzhandler.Store == nil || err != nil {
 				glog.Fatalf("Error in initalizing cache. Error:%s", err.Error())
 			}
+			// cache writes run behind a bounded queue, so a slow cache shard
+			// never adds latency to an authorization response
+			authzhandler.Store = data.NewWriteBehindStore(authzhandler.Store)
+			// decisions cached by a previous identity must not survive a
+			// credential or tenant rotation
+			s.verifyCredentialFingerprint(authzhandler.Store)
+			ready.register("authz-cache", func() error {
+				if authzhandler.Store == nil {
+					return errors.New("authz cache is not initialized")
+				}
+				return nil
+			})
+		}
+
+		// decision tracing and cache inspection for kubectl-guard, behind the
+		// same gate as the pprof endpoints
+		if debugGate != nil {
+			m.Post("/debug/authz/trace", debugGate(authzhandler.debugTraceHandler()))
+			m.Post("/debug/authz/cache", debugGate(authzhandler.debugCacheHandler()))
 		}
 	}
 
+	m.Get("/readyz", ready.handler())
+
+	// serve metrics and health probes on a separate listener without client
+	// certificate requirements, so the monitoring stack can scrape /metrics
+	// without holding guard client credentials
+	if s.AuthRecommendedOptions.SecureServing.StatusAddr != "" {
+		go s.listenAndServeStatus(certReloader, ready, reloads)
+	}
+	if s.AuthRecommendedOptions.Profiling.Addr != "" {
+		go s.listenAndServeProfiling()
+	}
+
 	srv := &http.Server{
 		Addr:         s.AuthRecommendedOptions.SecureServing.SecureAddr,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		ReadTimeout:  serverReadTimeout,
+		WriteTimeout: serverWriteTimeout,
 		Handler:      m,
 		TLSConfig:    tlsConfig,
 	}
-	glog.Fatalln(srv.ListenAndServeTLS(s.AuthRecommendedOptions.SecureServing.CertFile, s.AuthRecommendedOptions.SecureServing.KeyFile))
+	// cert and key are served through the reloader via tls.Config.GetCertificate
+	glog.Fatalln(srv.ListenAndServeTLS("", ""))
+}
+
+// listenAndServeStatus serves only /metrics, /healthz, /readyz and /reloadz on
+// the status address, over plain HTTP or one-way TLS with the server
+// certificate.
+func (s Server) listenAndServeStatus(certReloader *CertReloader, ready *readinessChecker, reloads *reloadTracker) {
+	m := pat.New()
+	m.Get("/metrics", promhttp.Handler())
+	m.Get("/healthz", healthzHandler())
+	m.Get("/readyz", ready.handler())
+	m.Get("/reloadz", reloads.handler())
+
+	srv := &http.Server{
+		Addr:         s.AuthRecommendedOptions.SecureServing.StatusAddr,
+		ReadTimeout:  serverReadTimeout,
+		WriteTimeout: serverWriteTimeout,
+		Handler:      m,
+	}
+	if s.AuthRecommendedOptions.SecureServing.StatusUseTLS {
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certReloader.GetCertificate,
+		}
+		glog.Fatalln(srv.ListenAndServeTLS("", ""))
+	}
+	glog.Fatalln(srv.ListenAndServe())
+}
+
+func healthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(200)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-content-type-options", "nosniff")
+		err := json.NewEncoder(w).Encode(v.Version)
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
 }
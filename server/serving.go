@@ -42,6 +42,14 @@ type SecureServingOptions struct {
 	CertFile   string
 	KeyFile    string
 
+	// StatusAddr serves only /metrics, /healthz and /readyz on a separate
+	// listener, so the monitoring stack does not need client certificates
+	// for the mTLS port. Empty disables the listener.
+	StatusAddr string
+	// StatusUseTLS serves the status listener with one-way TLS using the
+	// server certificate; otherwise it is plain HTTP.
+	StatusUseTLS bool
+
 	pkiDir string
 }
 
@@ -62,6 +70,8 @@ func (o *SecureServingOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.CACertFile, "tls-ca-file", o.CACertFile, "File containing CA certificate")
 	fs.StringVar(&o.CertFile, "tls-cert-file", o.CertFile, "File container server TLS certificate")
 	fs.StringVar(&o.KeyFile, "tls-private-key-file", o.KeyFile, "File containing server TLS private key")
+	fs.StringVar(&o.StatusAddr, "status-addr", o.StatusAddr, "host:port of a separate listener serving only /metrics, /healthz and /readyz, keep empty to disable")
+	fs.BoolVar(&o.StatusUseTLS, "status-use-tls", o.StatusUseTLS, "If true, the status listener serves one-way TLS using the server certificate instead of plain HTTP")
 }
 
 func (o SecureServingOptions) UseTLS() bool {
@@ -82,6 +92,9 @@ func (o *SecureServingOptions) Validate() []error {
 	if o.KeyFile == "" {
 		errs = append(errs, errors.New("server key is empty"))
 	}
+	if o.StatusAddr != "" && o.StatusAddr == o.SecureAddr {
+		errs = append(errs, errors.New("status-addr must differ from secure-addr"))
+	}
 	return errs
 }
 
@@ -94,7 +107,14 @@ func (o SecureServingOptions) Apply(d *apps.Deployment) (extraObjs []runtime.Obj
 	if !store.PairExists("ca") {
 		return nil, errors.Errorf("CA certificates not found in %s. Run `guard init ca`", store.Location())
 	}
-	if !store.PairExists("server") {
+	// a per-component pair (e.g. guard-authn-server) lets split deployments
+	// rotate their certificates independently; the shared server pair is the
+	// fallback
+	pair := "server"
+	if d.Name != "guard" && store.PairExists(d.Name+"-server") {
+		pair = d.Name + "-server"
+	}
+	if !store.PairExists(pair) {
 		return nil, errors.Errorf("Server certificate not found in %s. Run `guard init server`", store.Location())
 	}
 
@@ -102,14 +122,14 @@ func (o SecureServingOptions) Apply(d *apps.Deployment) (extraObjs []runtime.Obj
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load ca certificate.")
 	}
-	serverCert, serverKey, err := store.ReadBytes("server")
+	serverCert, serverKey, err := store.ReadBytes(pair)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to load ca certificate.")
 	}
 
 	authSecret := &core.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "guard-pki",
+			Name:      d.Name + "-pki",
 			Namespace: d.Namespace,
 			Labels:    d.Labels,
 		},
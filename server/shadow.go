@@ -0,0 +1,115 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/appscode/guard/authz"
+	"github.com/appscode/guard/util/logs"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+// ShadowEvaluator re-evaluates every subject access review against a
+// secondary configuration and logs divergent decisions. It never influences
+// the response the primary configuration produced, so a new configuration
+// (e.g. changed skip rules or a provider upgrade) can be observed against
+// live traffic before it is rolled out.
+type ShadowEvaluator struct {
+	// clients are built from the secondary configuration at construction
+	// time, bypassing the process-wide provider singletons the primary
+	// handler uses, so the two configurations never share a client.
+	clients map[string]authz.Interface
+	// store is a private in-memory cache, so cached verdicts never leak
+	// between the two configurations.
+	store authz.Store
+}
+
+// NewShadowEvaluator builds the provider clients for the secondary
+// configuration. A provider that cannot be constructed fails the evaluator,
+// so a broken secondary configuration surfaces at startup instead of as a
+// stream of shadow errors.
+func NewShadowEvaluator(authOpts *AuthRecommendedOptions, authzOpts *AuthzRecommendedOptions) (*ShadowEvaluator, error) {
+	if len(authzOpts.AuthzProvider.Providers) == 0 {
+		return nil, errors.New("secondary configuration enables no authorization provider")
+	}
+	clients, store, err := newAuthzClientSet(authOpts, authzOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "secondary configuration")
+	}
+	return &ShadowEvaluator{clients: clients, store: store}, nil
+}
+
+// Check evaluates the review against this evaluator's configuration and
+// returns the raw provider decision. Besides backing Compare it is used by
+// `guard test authz` to simulate reviews against a configuration.
+func (e *ShadowEvaluator) Check(ctx context.Context, org string, spec *authzv1beta1.SubjectAccessReviewSpec, reqInfo authz.RequestInfo) (*authzv1beta1.SubjectAccessReviewStatus, error) {
+	client, found := e.clients[strings.ToLower(org)]
+	if !found {
+		return nil, errors.Errorf("configuration does not provide service for %v", org)
+	}
+	return client.Check(ctx, spec, e.store, reqInfo)
+}
+
+// Compare evaluates the review against the secondary configuration and
+// records whether the decision matches the primary one. Divergences are
+// logged with both decisions; errors on the shadow path are logged and
+// counted but otherwise ignored.
+func (e *ShadowEvaluator) Compare(org string, spec *authzv1beta1.SubjectAccessReviewSpec, reqInfo authz.RequestInfo, primary *authzv1beta1.SubjectAccessReviewStatus, primaryErr error) {
+	if _, found := e.clients[strings.ToLower(org)]; !found {
+		shadowDecisions.WithLabelValues("error").Inc()
+		glog.V(5).Infof("shadow configuration does not provide service for %v", org)
+		return
+	}
+
+	resp, err := e.Check(context.Background(), org, spec, reqInfo)
+
+	primaryDecision := authzDecision(primary, primaryErr)
+	shadowDecision := authzDecision(resp, err)
+	if primaryDecision == shadowDecision {
+		shadowDecisions.WithLabelValues("match").Inc()
+		return
+	}
+
+	shadowDecisions.WithLabelValues("diverged").Inc()
+	fields := logs.Fields{
+		"provider":         org,
+		"user":             spec.User,
+		"primary_decision": primaryDecision,
+		"shadow_decision":  shadowDecision,
+	}
+	if spec.ResourceAttributes != nil {
+		fields["namespace"] = spec.ResourceAttributes.Namespace
+		fields["resource"] = spec.ResourceAttributes.Resource
+		fields["verb"] = spec.ResourceAttributes.Verb
+	}
+	if spec.NonResourceAttributes != nil {
+		fields["path"] = spec.NonResourceAttributes.Path
+		fields["verb"] = spec.NonResourceAttributes.Verb
+	}
+	if err != nil {
+		fields["shadow_error"] = err.Error()
+	}
+	if primaryErr != nil {
+		fields["primary_error"] = primaryErr.Error()
+	}
+	logs.Info("shadow decision diverged", fields)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appscode/guard/authz"
+	"github.com/stretchr/testify/assert"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+func TestNewShadowEvaluatorNoProviders(t *testing.T) {
+	_, err := NewShadowEvaluator(NewAuthRecommendedOptions(), NewAuthzRecommendedOptions())
+	assert.NotNil(t, err)
+}
+
+func TestShadowEvaluatorCompare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guard-shadow")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.yaml")
+	policy := `rules:
+- users: ["alice"]
+  effect: deny
+`
+	if err := ioutil.WriteFile(policyFile, []byte(policy), 0600); err != nil {
+		t.Fatalf("failed to write policy file: %s", err)
+	}
+
+	authzOpts := NewAuthzRecommendedOptions()
+	authzOpts.AuthzProvider.Providers = []string{"policyfile"}
+	authzOpts.PolicyFile.PolicyFile = policyFile
+
+	e, err := NewShadowEvaluator(NewAuthRecommendedOptions(), authzOpts)
+	assert.Nil(t, err)
+
+	spec := &authzv1beta1.SubjectAccessReviewSpec{
+		User: "alice",
+		ResourceAttributes: &authzv1beta1.ResourceAttributes{
+			Verb:      "get",
+			Resource:  "pods",
+			Namespace: "default",
+		},
+	}
+	allowed := &authzv1beta1.SubjectAccessReviewStatus{Allowed: true}
+	denied := &authzv1beta1.SubjectAccessReviewStatus{Allowed: false, Denied: true}
+
+	// divergence and match paths both only log and count; they must not panic
+	e.Compare("policyfile", spec, authz.RequestInfo{}, allowed, nil)
+	e.Compare("policyfile", spec, authz.RequestInfo{}, denied, nil)
+	// an org the secondary configuration does not serve is counted as an error
+	e.Compare("github", spec, authz.RequestInfo{}, allowed, nil)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/appscode/guard/authz"
+	"github.com/appscode/guard/authz/providers/azure"
+	"github.com/appscode/guard/authz/providers/azure/data"
+	"github.com/appscode/guard/authz/providers/casbin"
+	"github.com/appscode/guard/authz/providers/policyfile"
+	authzwebhook "github.com/appscode/guard/authz/providers/webhook"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// Tenant holds the effective configuration guard serves one tenant with. A
+// tenant is identified by the common name of the client certificate, so each
+// cluster's webhook credential selects its own provider configuration.
+type Tenant struct {
+	AuthOptions  *AuthRecommendedOptions
+	AuthzOptions *AuthzRecommendedOptions
+	// authzClients are fresh per-tenant provider clients, bypassing the
+	// process-wide singletons, so tenants never share credentials.
+	authzClients map[string]authz.Interface
+	// store is the tenant's private verdict cache, so one tenant's cached
+	// decision is never served to another.
+	store authz.Store
+}
+
+// authzClient returns the tenant's client for the provider organization.
+func (t *Tenant) authzClient(org string) (authz.Interface, error) {
+	client, found := t.authzClients[strings.ToLower(org)]
+	if !found {
+		return nil, errors.Errorf("tenant configuration does not provide service for %v", org)
+	}
+	return client, nil
+}
+
+// TenantRouter maps client certificate common names to per-tenant
+// configurations. Requests from common names without a tenant block use the
+// global options unchanged.
+type TenantRouter struct {
+	tenants map[string]*Tenant
+}
+
+// Lookup returns the tenant configuration for the common name, or nil when
+// none is configured. It is safe to call on a nil router.
+func (r *TenantRouter) Lookup(commonName string) *Tenant {
+	if r == nil {
+		return nil
+	}
+	return r.tenants[strings.ToLower(commonName)]
+}
+
+// NewTenantRouterFromConfig builds the router from the tenants section of
+// the config file at path; base is the flag set holding the effective global
+// configuration each tenant block overrides. Tenant blocks are read once at
+// startup; changing them requires a restart.
+func NewTenantRouterFromConfig(base *pflag.FlagSet, path string) (*TenantRouter, error) {
+	cfg, _, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTenantRouter(base, cfg.Tenants)
+}
+
+// NewTenantRouter builds per-tenant configurations by starting from the
+// effective base flags and applying each tenant's override block. It returns
+// nil when no tenant is configured, so the handlers fall back to the global
+// options.
+func NewTenantRouter(base *pflag.FlagSet, tenants map[string]map[string]interface{}) (*TenantRouter, error) {
+	if len(tenants) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r := &TenantRouter{tenants: map[string]*Tenant{}}
+	for _, name := range names {
+		t, err := newTenant(base, tenants[name])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid configuration for tenant %q", name)
+		}
+		r.tenants[strings.ToLower(name)] = t
+	}
+	return r, nil
+}
+
+func newTenant(base *pflag.FlagSet, overrides map[string]interface{}) (*Tenant, error) {
+	authOpts := NewAuthRecommendedOptions()
+	authzOpts := NewAuthzRecommendedOptions()
+	fs := pflag.NewFlagSet("tenant", pflag.ContinueOnError)
+	authOpts.AddFlags(fs)
+	authzOpts.AddFlags(fs)
+
+	// start from the effective global configuration; only changed flags need
+	// copying, the rest already hold their defaults
+	var copyErr error
+	base.Visit(func(f *pflag.Flag) {
+		target := fs.Lookup(f.Name)
+		if target == nil || copyErr != nil {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			copyErr = target.Value.(pflag.SliceValue).Replace(sv.GetSlice())
+		} else {
+			copyErr = target.Value.Set(f.Value.String())
+		}
+	})
+	if copyErr != nil {
+		return nil, copyErr
+	}
+
+	// apply the tenant's overrides on top
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return nil, errors.Errorf("tenant block sets unknown flag %q", name)
+		}
+		if err := setFlagValues(fs, name, flagValueList(overrides[name])); err != nil {
+			return nil, err
+		}
+	}
+	// tenant blocks may reference secrets the same way flags do
+	if err := ResolveSecretRefs(fs); err != nil {
+		return nil, err
+	}
+
+	// serving, audit and similar server-wide options stay global; only the
+	// provider configuration is tenant-specific, so only that is validated.
+	// A tenant serving authorization only may leave auth-providers empty.
+	if len(authOpts.AuthProvider.Providers) > 0 {
+		if errs := authOpts.ValidateProviders(); errs != nil {
+			return nil, errors.Errorf("%v", errs)
+		}
+	}
+	if errs := authzOpts.Validate(authOpts); errs != nil {
+		return nil, errors.Errorf("%v", errs)
+	}
+
+	// per-tenant credential files are loaded once at construction
+	if err := authOpts.LDAP.Configure(); err != nil {
+		return nil, err
+	}
+	if err := authOpts.Google.Configure(); err != nil {
+		return nil, err
+	}
+
+	t := &Tenant{AuthOptions: authOpts, AuthzOptions: authzOpts}
+	var err error
+	t.authzClients, t.store, err = newAuthzClientSet(authOpts, authzOpts)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// newAuthzClientSet builds fresh authz provider clients for a configuration,
+// bypassing the process-wide singletons, plus a private verdict cache when a
+// provider needs one. It returns an empty set when the configuration enables
+// no authorization provider.
+func newAuthzClientSet(authOpts *AuthRecommendedOptions, authzOpts *AuthzRecommendedOptions) (map[string]authz.Interface, authz.Store, error) {
+	clients := map[string]authz.Interface{}
+	for _, org := range authzOpts.AuthzProvider.Providers {
+		var client authz.Interface
+		var err error
+		switch strings.ToLower(org) {
+		case azure.OrgType:
+			client, err = azure.NewAuthorizer(authzOpts.Azure, authOpts.Azure)
+		case authzwebhook.OrgType:
+			client, err = authzwebhook.New(authzOpts.Webhook)
+		case policyfile.OrgType:
+			client, err = policyfile.NewAuthorizer(authzOpts.PolicyFile)
+		case casbin.OrgType:
+			client, err = casbin.NewAuthorizer(authzOpts.Casbin)
+		default:
+			return nil, nil, errors.Errorf("unknown authorization provider %s", org)
+		}
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to create authz client for %s", org)
+		}
+		clients[strings.ToLower(org)] = client
+	}
+
+	var store authz.Store
+	_, hasAzure := clients[azure.OrgType]
+	_, hasWebhook := clients[authzwebhook.OrgType]
+	if hasAzure || hasWebhook {
+		s, err := data.NewDataStore(data.DefaultOptions)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to initialize authz cache store")
+		}
+		// same write-behind policy as the global store, so per-tenant and
+		// shadow caches never block a request either
+		store = data.NewWriteBehindStore(s)
+	}
+	return clients, store, nil
+}
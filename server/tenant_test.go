@@ -0,0 +1,124 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTenantBaseFlags builds a flag set the way `guard run` does, so tenant
+// construction starts from the same shape of configuration.
+func newTenantBaseFlags() (*AuthRecommendedOptions, *AuthzRecommendedOptions, *pflag.FlagSet) {
+	authOpts := NewAuthRecommendedOptions()
+	authzOpts := NewAuthzRecommendedOptions()
+	fs := pflag.NewFlagSet("base", pflag.ContinueOnError)
+	authOpts.AddFlags(fs)
+	authzOpts.AddFlags(fs)
+	return authOpts, authzOpts, fs
+}
+
+func TestNewTenantRouterEmpty(t *testing.T) {
+	_, _, fs := newTenantBaseFlags()
+	r, err := NewTenantRouter(fs, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, r)
+	// lookups on a nil router fall back to the global options
+	assert.Nil(t, r.Lookup("team-a.corp.example"))
+}
+
+func TestNewTenantRouter(t *testing.T) {
+	_, _, fs := newTenantBaseFlags()
+	assert.Nil(t, fs.Parse([]string{
+		"--auth-providers=github",
+		"--github.base-url=https://ghe.corp.example/api/v3/",
+	}))
+
+	r, err := NewTenantRouter(fs, map[string]map[string]interface{}{
+		"Team-B.corp.example": {
+			"github.base-url": "https://ghe.team-b.example/api/v3/",
+		},
+	})
+	assert.Nil(t, err)
+
+	tenant := r.Lookup("team-b.corp.example")
+	if !assert.NotNil(t, tenant) {
+		return
+	}
+	// the override applies on top of the inherited base configuration
+	assert.Equal(t, "https://ghe.team-b.example/api/v3/", tenant.AuthOptions.Github.BaseUrl)
+	assert.Equal(t, []string{"github"}, tenant.AuthOptions.AuthProvider.Providers)
+
+	// lookups are case-insensitive, matching provider org handling
+	assert.NotNil(t, r.Lookup("Team-B.corp.example"))
+	// clients without a tenant block get no tenant
+	assert.Nil(t, r.Lookup("team-a.corp.example"))
+}
+
+func TestNewTenantRouterAuthzClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "guard-tenant")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.yaml")
+	policy := `rules:
+- users: ["alice"]
+  effect: deny
+`
+	if err := ioutil.WriteFile(policyFile, []byte(policy), 0600); err != nil {
+		t.Fatalf("failed to write policy file: %s", err)
+	}
+
+	_, _, fs := newTenantBaseFlags()
+	r, err := NewTenantRouter(fs, map[string]map[string]interface{}{
+		"team-b.corp.example": {
+			"authz-providers": []interface{}{"policyfile"},
+			"policyfile.path": policyFile,
+		},
+	})
+	assert.Nil(t, err)
+
+	tenant := r.Lookup("team-b.corp.example")
+	if !assert.NotNil(t, tenant) {
+		return
+	}
+	client, err := tenant.authzClient("policyfile")
+	assert.Nil(t, err)
+	assert.NotNil(t, client)
+	_, err = tenant.authzClient("azure")
+	assert.NotNil(t, err)
+}
+
+func TestNewTenantRouterErrors(t *testing.T) {
+	_, _, fs := newTenantBaseFlags()
+	_, err := NewTenantRouter(fs, map[string]map[string]interface{}{
+		"team-b.corp.example": {
+			"no-such-flag": "x",
+		},
+	})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unknown flag")
+		assert.Contains(t, err.Error(), "team-b.corp.example")
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+const (
+	serverReadTimeout  = 5 * time.Second
+	serverWriteTimeout = 10 * time.Second
+)
+
+type TimeoutOptions struct {
+	// HandlerTimeout bounds the handling of a single review request. It must
+	// stay below the server write timeout: hitting the write timeout cuts the
+	// connection mid-response and leaves the API server with a truncated
+	// body, while the handler timeout answers with a well-formed review
+	// carrying a timeout error. 0 disables it.
+	HandlerTimeout time.Duration
+	// ProviderTimeouts tightens the deadline for individual providers, as
+	// <provider>=<duration> pairs. The deadline is carried on the request
+	// context, so upstream HTTP and LDAP calls are cancelled when it passes
+	// instead of holding the connection until the handler timeout.
+	ProviderTimeouts []string
+}
+
+func NewTimeoutOptions() TimeoutOptions {
+	return TimeoutOptions{
+		HandlerTimeout: 8 * time.Second,
+	}
+}
+
+func (o *TimeoutOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(&o.HandlerTimeout, "handler-timeout", o.HandlerTimeout, "Deadline for handling a single review request, answered with a well-formed timeout error when exceeded. Must stay below the server write timeout, 0 disables")
+	fs.StringSliceVar(&o.ProviderTimeouts, "provider-timeout", o.ProviderTimeouts, "list of <provider>=<duration> pairs tightening the review deadline per provider (e.g. ldap=3s,azure=5s). Providers without an entry use the handler timeout")
+}
+
+// ParseProviderTimeouts parses the <provider>=<duration> pairs into a map
+// keyed by lowercased provider name.
+func ParseProviderTimeouts(providerTimeouts []string) (map[string]time.Duration, error) {
+	parsed := make(map[string]time.Duration, len(providerTimeouts))
+	for _, pair := range providerTimeouts {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("provider-timeout entry %s is not in <provider>=<duration> format", pair)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil || d <= 0 {
+			return nil, errors.Errorf("provider-timeout entry %s must carry a positive duration", pair)
+		}
+		parsed[strings.ToLower(parts[0])] = d
+	}
+	return parsed, nil
+}
+
+// ForProvider returns the configured deadline for the provider, or zero when
+// none is set. Malformed entries are rejected by Validate at startup.
+func (o TimeoutOptions) ForProvider(org string) time.Duration {
+	parsed, err := ParseProviderTimeouts(o.ProviderTimeouts)
+	if err != nil {
+		return 0
+	}
+	return parsed[strings.ToLower(org)]
+}
+
+// withProviderDeadline tightens ctx to the provider's configured deadline.
+// The returned cancel func must always be called.
+func withProviderDeadline(ctx context.Context, opts TimeoutOptions, org string) (context.Context, context.CancelFunc) {
+	if d := opts.ForProvider(org); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return ctx, func() {}
+}
+
+func (o *TimeoutOptions) Validate() []error {
+	var errs []error
+	if o.HandlerTimeout < 0 {
+		errs = append(errs, errors.New("handler-timeout must not be negative"))
+	}
+	if o.HandlerTimeout >= serverWriteTimeout {
+		errs = append(errs, errors.Errorf("handler-timeout must stay below the %s server write timeout, or responses get truncated before the timeout fires", serverWriteTimeout))
+	}
+	if _, err := ParseProviderTimeouts(o.ProviderTimeouts); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// withHandlerTimeout bounds h with the given deadline. The handler runs
+// against a buffered response writer, so nothing reaches the wire until it
+// finishes; when the deadline passes first, onTimeout writes the response
+// instead and whatever the handler produces later is discarded.
+func withHandlerTimeout(h http.Handler, timeout time.Duration, handlerName string, onTimeout func(http.ResponseWriter)) http.Handler {
+	if timeout <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					glog.Errorf("handler %s panicked: %v", handlerName, p)
+				}
+				close(done)
+			}()
+			h.ServeHTTP(tw, r)
+		}()
+
+		select {
+		case <-done:
+			tw.copyTo(w)
+		case <-ctx.Done():
+			tw.markTimedOut()
+			timedOutTotal.WithLabelValues(handlerName).Inc()
+			glog.Errorf("handler %s exceeded the %s handler timeout", handlerName, timeout)
+			onTimeout(w)
+		}
+	})
+}
+
+// timeoutTokenReview answers a timed out token review with a well-formed
+// unauthenticated TokenReview carrying a timeout error.
+func timeoutTokenReview(w http.ResponseWriter) {
+	write(w, "", nil, WithCode(errors.New("guard timed out processing the token review"), http.StatusGatewayTimeout))
+}
+
+// timeoutSubjectAccessReview answers a timed out subject access review with a
+// well-formed denied SubjectAccessReview carrying a timeout reason.
+func timeoutSubjectAccessReview(w http.ResponseWriter) {
+	writeAuthzResponse(w, nil, nil, errors.New("guard timed out processing the subject access review"))
+}
+
+// timeoutWriter buffers the response of a handler running under a deadline.
+// After the deadline fires, writes are silently dropped.
+type timeoutWriter struct {
+	lock     sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut || w.code != 0 {
+		return
+	}
+	w.code = code
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
+
+func (w *timeoutWriter) markTimedOut() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.timedOut = true
+}
+
+// copyTo replays the buffered response onto the real writer.
+func (w *timeoutWriter) copyTo(dst http.ResponseWriter) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for k, v := range w.header {
+		dst.Header()[k] = v
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	dst.WriteHeader(w.code)
+	if _, err := dst.Write(w.buf.Bytes()); err != nil {
+		glog.Errorln(err)
+	}
+}
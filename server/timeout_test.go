@@ -0,0 +1,110 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1beta1 "k8s.io/api/authorization/v1beta1"
+)
+
+func TestHandlerTimeoutPassesThrough(t *testing.T) {
+	h := withHandlerTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "yes")
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("body"))
+	}), time.Second, "tokenreviews", timeoutTokenReview)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tokenreviews", nil))
+
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Equal(t, "yes", rec.Header().Get("X-Test"))
+	assert.Equal(t, "body", rec.Body.String())
+}
+
+func TestHandlerTimeoutTokenReview(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	h := withHandlerTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}), 10*time.Millisecond, "tokenreviews", timeoutTokenReview)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tokenreviews", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	var review authv1.TokenReview
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&review))
+	assert.False(t, review.Status.Authenticated)
+	assert.Contains(t, review.Status.Error, "timed out")
+}
+
+func TestHandlerTimeoutSubjectAccessReview(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	h := withHandlerTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}), 10*time.Millisecond, "subjectaccessreviews", timeoutSubjectAccessReview)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/subjectaccessreviews", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var review authzv1beta1.SubjectAccessReview
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&review))
+	assert.True(t, review.Status.Denied)
+	assert.Contains(t, review.Status.Reason, "timed out")
+}
+
+func TestTimeoutOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    TimeoutOptions
+		wantErr bool
+	}{
+		{"default", NewTimeoutOptions(), false},
+		{"disabled", TimeoutOptions{}, false},
+		{"negative", TimeoutOptions{HandlerTimeout: -time.Second}, true},
+		{"above write timeout", TimeoutOptions{HandlerTimeout: serverWriteTimeout}, true},
+		{"provider timeouts", TimeoutOptions{ProviderTimeouts: []string{"ldap=3s", "azure=5s"}}, false},
+		{"malformed provider timeout", TimeoutOptions{ProviderTimeouts: []string{"ldap"}}, true},
+		{"non-positive provider timeout", TimeoutOptions{ProviderTimeouts: []string{"ldap=0s"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.opts.Validate()
+			if tt.wantErr {
+				assert.NotEmpty(t, errs)
+			} else {
+				assert.Empty(t, errs)
+			}
+		})
+	}
+}
+
+func TestProviderTimeouts(t *testing.T) {
+	opts := TimeoutOptions{ProviderTimeouts: []string{"ldap=3s", "Azure=5s"}}
+	assert.Equal(t, 3*time.Second, opts.ForProvider("ldap"))
+	assert.Equal(t, 5*time.Second, opts.ForProvider("azure"), "provider names should match case-insensitively")
+	assert.Equal(t, time.Duration(0), opts.ForProvider("github"), "providers without an entry get no extra deadline")
+}
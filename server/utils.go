@@ -21,10 +21,13 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/appscode/guard/util/debuglog"
+
 	"github.com/golang/glog"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/pkg/errors"
 	auth "k8s.io/api/authentication/v1"
+	authv1beta1 "k8s.io/api/authentication/v1beta1"
 	authzv1beta1 "k8s.io/api/authorization/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -33,14 +36,15 @@ var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
 // write replies to the request with the specified TokenReview object and HTTP code.
 // It does not otherwise end the request; the caller should ensure no further
-// writes are done to w.
-func write(w http.ResponseWriter, info *auth.UserInfo, err error) {
+// writes are done to w. The response carries the given apiVersion, so guard
+// answers in the version the API server spoke.
+func write(w http.ResponseWriter, apiVersion string, info *auth.UserInfo, err error) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("x-content-type-options", "nosniff")
 
 	resp := auth.TokenReview{
 		TypeMeta: metav1.TypeMeta{
-			APIVersion: auth.SchemeGroupVersion.String(),
+			APIVersion: respondAPIVersion(apiVersion),
 			Kind:       "TokenReview",
 		},
 	}
@@ -50,6 +54,11 @@ func write(w http.ResponseWriter, info *auth.UserInfo, err error) {
 		if v, ok := err.(httpStatusCode); ok {
 			code = v.Code()
 		}
+		provider, errorCode := "unknown", "unknown"
+		if failure, ok := err.(*AuthFailure); ok {
+			provider, errorCode = failure.Provider, failure.ErrorCode
+		}
+		errorCounter.WithLabelValues(provider, errorCode).Inc()
 		printStackTrace(err)
 		w.WriteHeader(code)
 		resp.Status = auth.TokenReviewStatus{
@@ -64,10 +73,7 @@ func write(w http.ResponseWriter, info *auth.UserInfo, err error) {
 		}
 	}
 
-	if glog.V(10) {
-		data, _ := json.MarshalIndent(resp, "", "  ")
-		glog.V(10).Infoln(string(data))
-	}
+	glog.V(10).Infof("%s", debuglog.JSON(resp))
 
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
@@ -105,10 +111,7 @@ func writeAuthzResponse(w http.ResponseWriter, spec *authzv1beta1.SubjectAccessR
 	}
 
 	w.WriteHeader(http.StatusOK)
-	if glog.V(10) {
-		data, _ := json.MarshalIndent(resp, "", "  ")
-		glog.V(10).Infof("final data:%s", string(data))
-	}
+	glog.V(10).Infof("final data:%s", debuglog.JSON(resp))
 
 	err = json.NewEncoder(w).Encode(resp)
 	if err != nil {
@@ -116,6 +119,16 @@ func writeAuthzResponse(w http.ResponseWriter, spec *authzv1beta1.SubjectAccessR
 	}
 }
 
+// respondAPIVersion returns the TokenReview apiVersion to respond with. The
+// v1 and v1beta1 wire formats are identical for the fields guard sets, so the
+// requested version is echoed when it is a known one and v1 is used otherwise.
+func respondAPIVersion(requested string) string {
+	if requested == authv1beta1.SchemeGroupVersion.String() {
+		return requested
+	}
+	return auth.SchemeGroupVersion.String()
+}
+
 type stackTracer interface {
 	StackTrace() errors.StackTrace
 }
@@ -0,0 +1,57 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	auth "k8s.io/api/authentication/v1"
+)
+
+func TestRespondAPIVersion(t *testing.T) {
+	assert.Equal(t, "authentication.k8s.io/v1", respondAPIVersion("authentication.k8s.io/v1"))
+	assert.Equal(t, "authentication.k8s.io/v1beta1", respondAPIVersion("authentication.k8s.io/v1beta1"))
+	// unknown or absent versions fall back to v1
+	assert.Equal(t, "authentication.k8s.io/v1", respondAPIVersion(""))
+	assert.Equal(t, "authentication.k8s.io/v1", respondAPIVersion("authentication.k8s.io/v2"))
+}
+
+func TestWriteEchoesRequestedAPIVersion(t *testing.T) {
+	testdata := []struct {
+		requested string
+		expected  string
+	}{
+		{"authentication.k8s.io/v1beta1", "authentication.k8s.io/v1beta1"},
+		{"authentication.k8s.io/v1", "authentication.k8s.io/v1"},
+		{"", "authentication.k8s.io/v1"},
+	}
+
+	for _, test := range testdata {
+		t.Run(test.requested, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			write(rec, test.requested, &auth.UserInfo{Username: "alice"}, nil)
+
+			resp := auth.TokenReview{}
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, test.expected, resp.APIVersion)
+			assert.Equal(t, "TokenReview", resp.Kind)
+			assert.True(t, resp.Status.Authenticated)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/appscode/guard/auth"
+	"github.com/appscode/guard/auth/providers/token"
+	"github.com/appscode/guard/util/httpclient"
+
+	"github.com/pkg/errors"
+	authv1 "k8s.io/api/authentication/v1"
+)
+
+// VerifyToken runs a bearer token through the same authenticator code path
+// the webhook handler uses and returns the resulting UserInfo, including the
+// identity size limits applied to real reviews. commonName stands in for the
+// client certificate common name, which providers like github and google
+// read as the organization or domain to check membership against. It backs
+// `guard verify token`, so "Unauthorized" reports can be debugged without
+// tailing server logs.
+func (s *Server) VerifyToken(ctx context.Context, org, commonName, bearer string) (*authv1.UserInfo, error) {
+	// install the global outbound proxy and CA bundle exactly like
+	// ListenAndServe, so the provider client behaves as it would in-cluster
+	httpclient.SetDefaultProxy(s.AuthRecommendedOptions.Proxy)
+	httpclient.SetDefaultCABundle(s.AuthRecommendedOptions.CABundle)
+	auth.SetPopulateUID(s.AuthRecommendedOptions.PopulateUID)
+
+	if err := s.AuthRecommendedOptions.LDAP.Configure(); err != nil {
+		return nil, err
+	}
+	if err := s.AuthRecommendedOptions.Google.Configure(); err != nil {
+		return nil, err
+	}
+
+	var resp *authv1.UserInfo
+	var err error
+	if strings.ToLower(org) == token.OrgType {
+		if s.AuthRecommendedOptions.Token.AuthFile == "" {
+			return nil, errors.New("token auth file is not set; use --token-auth-file")
+		}
+		authenticator := token.New(s.AuthRecommendedOptions.Token)
+		if err := authenticator.Configure(); err != nil {
+			return nil, err
+		}
+		resp, err = authenticator.Check(ctx, bearer)
+	} else {
+		client, cerr := s.getAuthProviderClient(org, commonName)
+		if cerr != nil {
+			return nil, cerr
+		}
+		resp, err = client.Check(ctx, bearer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.AuthRecommendedOptions.Limits.truncate(resp), nil
+}
@@ -128,13 +128,13 @@ var _ = Describe("Installer test", func() {
 	)
 
 	var (
-		setupGuard = func(authopts installer.AuthOptions, authzopts installer.AuthzOptions) {
+		setupGuard = func(authopts installer.AuthOptions, authzopts installer.AuthzOptions, secretopts installer.SecretOptions) {
 			By("Validate installer flag options")
 			errs := authopts.Validate()
 			Expect(errors.NewAggregate(errs)).NotTo(HaveOccurred())
 
 			By("Generating installer yaml")
-			data, err := installer.Generate(authopts, authzopts)
+			data, err := installer.Generate(authopts, authzopts, secretopts)
 			Expect(err).NotTo(HaveOccurred())
 
 			glog.Info(string(data))
@@ -295,6 +295,7 @@ var _ = Describe("Installer test", func() {
 			secretName string
 			authopts   installer.AuthOptions
 			authzopts  installer.AuthzOptions
+			secretopts installer.SecretOptions
 		)
 
 		BeforeEach(func() {
@@ -307,6 +308,7 @@ var _ = Describe("Installer test", func() {
 			}
 
 			authzopts = installer.AuthzOptions{}
+			secretopts = installer.NewSecretOptions()
 
 			secretName = pkiSecret
 
@@ -333,7 +335,7 @@ var _ = Describe("Installer test", func() {
 			})
 
 			It("Set up guard for github should be successful", func() {
-				setupGuard(authopts, authzopts)
+				setupGuard(authopts, authzopts, secretopts)
 
 				checkServiceCreated()
 				checkClusterRoleCreated()
@@ -346,7 +348,7 @@ var _ = Describe("Installer test", func() {
 			It("Set up guard for github should be successful, provided base url", func() {
 				authopts.Github = githubOpts
 
-				setupGuard(authopts, authzopts)
+				setupGuard(authopts, authzopts, secretopts)
 
 				checkServiceCreated()
 				checkClusterRoleCreated()
@@ -363,7 +365,7 @@ var _ = Describe("Installer test", func() {
 			})
 
 			It("Set up guard for gitlab should be successful", func() {
-				setupGuard(authopts, authzopts)
+				setupGuard(authopts, authzopts, secretopts)
 
 				checkServiceCreated()
 				checkClusterRoleCreated()
@@ -376,7 +378,7 @@ var _ = Describe("Installer test", func() {
 			It("Set up guard for gitlab should be successful, provided base url", func() {
 				authopts.Gitlab = gitlabOpts
 
-				setupGuard(authopts, authzopts)
+				setupGuard(authopts, authzopts, secretopts)
 
 				checkServiceCreated()
 				checkClusterRoleCreated()
@@ -400,7 +402,7 @@ var _ = Describe("Installer test", func() {
 			})
 
 			It("Set up guard for azure should be successful", func() {
-				setupGuard(authopts, authzopts)
+				setupGuard(authopts, authzopts, secretopts)
 
 				checkServiceCreated()
 				checkClusterRoleCreated()
@@ -426,7 +428,7 @@ var _ = Describe("Installer test", func() {
 			})
 
 			It("Set up guard for LDAP should be successful", func() {
-				setupGuard(authopts, authzopts)
+				setupGuard(authopts, authzopts, secretopts)
 
 				checkServiceCreated()
 				checkClusterRoleCreated()
@@ -459,7 +461,7 @@ var _ = Describe("Installer test", func() {
 			})
 
 			It("Set up guard for token auth should be successful", func() {
-				setupGuard(authopts, authzopts)
+				setupGuard(authopts, authzopts, secretopts)
 
 				checkServiceCreated()
 				checkClusterRoleCreated()
@@ -493,7 +495,7 @@ var _ = Describe("Installer test", func() {
 			})
 
 			It("Set up guard for google should be successful", func() {
-				setupGuard(authopts, authzopts)
+				setupGuard(authopts, authzopts, secretopts)
 
 				checkServiceCreated()
 				checkClusterRoleCreated()
@@ -513,6 +515,7 @@ var _ = Describe("Installer test", func() {
 			secretNames []string
 			authopts    installer.AuthOptions
 			authzopts   installer.AuthzOptions
+			secretopts  installer.SecretOptions
 		)
 
 		BeforeEach(func() {
@@ -538,6 +541,8 @@ var _ = Describe("Installer test", func() {
 			},
 			}
 
+			secretopts = installer.NewSecretOptions()
+
 			secretNames = []string{
 				pkiSecret,
 				azureSecret,
@@ -579,7 +584,7 @@ var _ = Describe("Installer test", func() {
 		})
 
 		It("Set up guard for all providers should be successful", func() {
-			setupGuard(authopts, authzopts)
+			setupGuard(authopts, authzopts, secretopts)
 
 			checkServiceCreated()
 			checkClusterRoleCreated()
@@ -0,0 +1,50 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debuglog
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// maxBytes bounds how much of a serialized request/response body ends up in
+// a debug log line. Bodies beyond the budget are truncated, not dropped.
+const maxBytes = 8 << 10 // 8 KiB
+
+// lazyJSON defers marshaling to String, so passing it to glog.V(n).Infof
+// costs nothing unless the line is actually emitted.
+type lazyJSON struct {
+	v interface{}
+}
+
+// JSON wraps v for debug logging. The value is marshaled only when the log
+// line is emitted, and very large bodies are truncated to a fixed budget, so
+// V(10) serialization stays off the hot path.
+func JSON(v interface{}) fmt.Stringer {
+	return lazyJSON{v: v}
+}
+
+func (l lazyJSON) String() string {
+	data, err := json.MarshalIndent(l.v, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("<unserializable: %v>", err)
+	}
+	if len(data) > maxBytes {
+		return fmt.Sprintf("%s... (%d bytes truncated)", data[:maxBytes], len(data)-maxBytes)
+	}
+	return string(data)
+}
@@ -0,0 +1,40 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debuglog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSON(t *testing.T) {
+	s := JSON(map[string]string{"user": "nahid"}).String()
+	assert.Contains(t, s, `"user": "nahid"`)
+}
+
+func TestJSONTruncatesLargeBodies(t *testing.T) {
+	s := JSON(map[string]string{"blob": strings.Repeat("x", 2*maxBytes)}).String()
+	assert.True(t, len(s) < 2*maxBytes)
+	assert.Contains(t, s, "bytes truncated")
+}
+
+func TestJSONUnserializableValue(t *testing.T) {
+	s := JSON(func() {}).String()
+	assert.Contains(t, s, "<unserializable")
+}
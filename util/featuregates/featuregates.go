@@ -0,0 +1,194 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package featuregates holds the registry of guard's alpha and beta feature
+// gates. Larger new subsystems consult their gate at runtime, so operators
+// can roll them out gradually with --feature-gates and switch one off without
+// a rebuild when it misbehaves. Gate state is exported as a metric, so what a
+// given guard actually runs with is visible in monitoring.
+package featuregates
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/pflag"
+)
+
+// Feature names a feature gate.
+type Feature string
+
+// Stage is the maturity of a gated feature. Alpha features default to off,
+// beta features default to on; flipping a gate's default is how a feature
+// graduates.
+type Stage string
+
+const (
+	Alpha Stage = "ALPHA"
+	Beta  Stage = "BETA"
+)
+
+const (
+	// BatchSAR coalesces subject access reviews of the same subject and scope
+	// into one ARM checkaccess call. The azure.checkaccess-batch-window flag
+	// sets the window; the gate lets operators kill batching independently.
+	BatchSAR Feature = "BatchSAR"
+
+	// DecisionTrace serves decision traces on the /debug/authz endpoints,
+	// backing `kubectl guard can-i`.
+	DecisionTrace Feature = "DecisionTrace"
+
+	// SoftTTLCache answers from a stale cached verdict when a checkaccess
+	// call exceeds the azure.checkaccess-soft-deadline, bounding webhook
+	// latency for the API server.
+	SoftTTLCache Feature = "SoftTTLCache"
+)
+
+// gateSpec describes a registered gate.
+type gateSpec struct {
+	stage   Stage
+	enabled bool
+}
+
+// knownGates is the central gate registry. The gated subsystems shipped with
+// their own enabling flags, so they all start at beta: the gate defaults to
+// on and only exists to switch the subsystem off.
+var knownGates = map[Feature]gateSpec{
+	BatchSAR:      {stage: Beta, enabled: true},
+	DecisionTrace: {stage: Beta, enabled: true},
+	SoftTTLCache:  {stage: Beta, enabled: true},
+}
+
+var gateEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "guard_feature_gate_enabled",
+	Help: "Whether a feature gate is enabled (1) or disabled (0).",
+}, []string{"name", "stage"})
+
+var (
+	lock    sync.RWMutex
+	enabled = defaults()
+)
+
+func init() {
+	prometheus.MustRegister(gateEnabled)
+	exportGates(enabled)
+}
+
+func defaults() map[Feature]bool {
+	m := make(map[Feature]bool, len(knownGates))
+	for f, spec := range knownGates {
+		m[f] = spec.enabled
+	}
+	return m
+}
+
+func exportGates(gates map[Feature]bool) {
+	for f, on := range gates {
+		v := 0.0
+		if on {
+			v = 1
+		}
+		gateEnabled.WithLabelValues(string(f), string(knownGates[f].stage)).Set(v)
+	}
+}
+
+// Enabled reports whether the named gate is on. Unknown gates are off.
+func Enabled(f Feature) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return enabled[f]
+}
+
+// Known returns the registered gate names in a stable order, for help and
+// error text.
+func Known() []string {
+	names := make([]string, 0, len(knownGates))
+	for f := range knownGates {
+		names = append(names, string(f))
+	}
+	sort.Strings(names)
+	return names
+}
+
+type Options struct {
+	// FeatureGates overrides gate defaults, as Name=true|false pairs
+	FeatureGates []string
+}
+
+func NewOptions() Options {
+	return Options{}
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringSliceVar(&o.FeatureGates, "feature-gates", o.FeatureGates, "list of Name=true|false pairs toggling alpha/beta features. Known gates: "+strings.Join(Known(), ", "))
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	if _, err := Parse(o.FeatureGates); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Parse parses the Name=true|false pairs, rejecting unknown gate names.
+func Parse(pairs []string) (map[Feature]bool, error) {
+	parsed := make(map[Feature]bool, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("feature-gates entry %q is not in Name=true|false format", pair)
+		}
+		f := Feature(parts[0])
+		if _, known := knownGates[f]; !known {
+			return nil, errors.Errorf("unknown feature gate %q, known gates are %s", parts[0], strings.Join(Known(), ", "))
+		}
+		on, err := strconv.ParseBool(parts[1])
+		if err != nil {
+			return nil, errors.Errorf("feature gate %q must be set to true or false, got %q", parts[0], parts[1])
+		}
+		parsed[f] = on
+	}
+	return parsed, nil
+}
+
+// Init applies the configured overrides on top of the gate defaults. It is
+// meant to be called once at startup, before the gated subsystems are built.
+func Init(o Options) error {
+	overrides, err := Parse(o.FeatureGates)
+	if err != nil {
+		return err
+	}
+
+	gates := defaults()
+	for f, on := range overrides {
+		if gates[f] != on {
+			glog.Infof("feature gate %s=%t overrides the %s default", f, on, strings.ToLower(string(knownGates[f].stage)))
+		}
+		gates[f] = on
+	}
+
+	lock.Lock()
+	enabled = gates
+	lock.Unlock()
+	exportGates(gates)
+	return nil
+}
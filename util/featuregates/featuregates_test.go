@@ -0,0 +1,64 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package featuregates
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		pairs   []string
+		wantErr bool
+	}{
+		{"empty", nil, false},
+		{"valid", []string{"BatchSAR=false", "SoftTTLCache=true"}, false},
+		{"missing value", []string{"BatchSAR"}, true},
+		{"unknown gate", []string{"WarpDrive=true"}, true},
+		{"bad bool", []string{"BatchSAR=maybe"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.pairs)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestInitOverridesDefaults(t *testing.T) {
+	// restore the defaults however the test ends
+	defer func() { assert.NoError(t, Init(Options{})) }()
+
+	assert.True(t, Enabled(BatchSAR), "beta gates default to on")
+	assert.NoError(t, Init(Options{FeatureGates: []string{"BatchSAR=false"}}))
+	assert.False(t, Enabled(BatchSAR))
+	assert.True(t, Enabled(SoftTTLCache), "untouched gates keep their default")
+
+	assert.NoError(t, Init(Options{}))
+	assert.True(t, Enabled(BatchSAR), "init without overrides restores the defaults")
+}
+
+func TestEnabledUnknownGate(t *testing.T) {
+	assert.False(t, Enabled(Feature("WarpDrive")))
+}
@@ -0,0 +1,131 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package flagutil collects provider flags in named groups, so duplicate
+// registrations are caught as clear errors instead of a pflag panic and
+// --help output is organized per provider.
+package flagutil
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// groupAnnotation marks every merged flag with the group it was registered
+// under, so grouped help can be rendered from any flag set.
+const groupAnnotation = "guard_flag_group"
+
+// Groups collects flag sets under named groups before they are merged into a
+// command's flag set.
+type Groups struct {
+	order  []string
+	groups map[string]*pflag.FlagSet
+}
+
+func NewGroups() *Groups {
+	return &Groups{groups: make(map[string]*pflag.FlagSet)}
+}
+
+// Group returns the flag set for the named group, creating it on first use.
+func (g *Groups) Group(name string) *pflag.FlagSet {
+	if fs, found := g.groups[name]; found {
+		return fs
+	}
+	fs := pflag.NewFlagSet(name, pflag.ExitOnError)
+	g.groups[name] = fs
+	g.order = append(g.order, name)
+	return fs
+}
+
+// ApplyTo merges all groups into fs. A flag registered by two groups, or
+// already present on fs, is reported as an error naming both owners instead
+// of panicking inside pflag.
+func (g *Groups) ApplyTo(fs *pflag.FlagSet) []error {
+	var errs []error
+	owner := make(map[string]string)
+	fs.VisitAll(func(f *pflag.Flag) {
+		owner[f.Name] = "the command"
+	})
+
+	for _, name := range g.order {
+		group := g.groups[name]
+		group.VisitAll(func(f *pflag.Flag) {
+			if prev, found := owner[f.Name]; found {
+				errs = append(errs, errors.Errorf("flag --%s from group %q is already registered by %s", f.Name, name, prev))
+				return
+			}
+			owner[f.Name] = fmt.Sprintf("group %q", name)
+			if f.Annotations == nil {
+				f.Annotations = make(map[string][]string)
+			}
+			f.Annotations[groupAnnotation] = []string{name}
+			fs.AddFlag(f)
+		})
+	}
+	return errs
+}
+
+// GroupedFlagUsages renders the flags of fs grouped by the group they were
+// registered under; flags without a group come first.
+func GroupedFlagUsages(fs *pflag.FlagSet) string {
+	sections := make(map[string]*pflag.FlagSet)
+	var order []string
+	section := func(name string) *pflag.FlagSet {
+		if s, found := sections[name]; found {
+			return s
+		}
+		s := pflag.NewFlagSet(name, pflag.ContinueOnError)
+		sections[name] = s
+		order = append(order, name)
+		return s
+	}
+
+	fs.VisitAll(func(f *pflag.Flag) {
+		name := ""
+		if groups, found := f.Annotations[groupAnnotation]; found && len(groups) > 0 {
+			name = groups[0]
+		}
+		section(name).AddFlag(f)
+	})
+
+	buf := new(bytes.Buffer)
+	if s, found := sections[""]; found {
+		buf.WriteString(s.FlagUsages())
+		buf.WriteString("\n")
+	}
+	for _, name := range order {
+		if name == "" {
+			continue
+		}
+		fmt.Fprintf(buf, "%s flags:\n\n", name)
+		buf.WriteString(sections[name].FlagUsages())
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// SetGroupedUsage replaces the command's usage output with one that prints
+// the flags per group.
+func SetGroupedUsage(cmd *cobra.Command) {
+	cmd.SetUsageFunc(func(c *cobra.Command) error {
+		_, err := fmt.Fprintf(c.OutOrStderr(), "Usage:\n  %s\n\n%s", c.UseLine(), GroupedFlagUsages(c.Flags()))
+		return err
+	})
+}
@@ -0,0 +1,106 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flagutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestGroupIdempotent(t *testing.T) {
+	g := NewGroups()
+	if g.Group("auth") != g.Group("auth") {
+		t.Error("expected the same flag set for repeated group lookups")
+	}
+}
+
+func TestApplyTo(t *testing.T) {
+	g := NewGroups()
+	var s string
+	g.Group("auth").StringVar(&s, "auth-addr", "", "")
+	g.Group("authz").StringVar(&s, "authz-addr", "", "")
+
+	fs := pflag.NewFlagSet("cmd", pflag.ContinueOnError)
+	if errs := g.ApplyTo(fs); errs != nil {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	for _, name := range []string{"auth-addr", "authz-addr"} {
+		if fs.Lookup(name) == nil {
+			t.Errorf("expected flag --%s on merged flag set", name)
+		}
+	}
+	if got := fs.Lookup("auth-addr").Annotations[groupAnnotation]; len(got) != 1 || got[0] != "auth" {
+		t.Errorf("expected group annotation [auth], got %v", got)
+	}
+}
+
+func TestApplyToDuplicateAcrossGroups(t *testing.T) {
+	g := NewGroups()
+	var s string
+	g.Group("github auth").StringVar(&s, "base-url", "", "")
+	g.Group("gitlab auth").StringVar(&s, "base-url", "", "")
+
+	fs := pflag.NewFlagSet("cmd", pflag.ContinueOnError)
+	errs := g.ApplyTo(fs)
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if msg := errs[0].Error(); !strings.Contains(msg, "gitlab auth") || !strings.Contains(msg, "github auth") {
+		t.Errorf("expected error to name both groups, got %q", msg)
+	}
+}
+
+func TestApplyToDuplicateWithCommand(t *testing.T) {
+	g := NewGroups()
+	var s string
+	g.Group("auth").StringVar(&s, "config", "", "")
+
+	fs := pflag.NewFlagSet("cmd", pflag.ContinueOnError)
+	fs.StringVar(&s, "config", "", "")
+	errs := g.ApplyTo(fs)
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if msg := errs[0].Error(); !strings.Contains(msg, "the command") {
+		t.Errorf("expected error to name the command as owner, got %q", msg)
+	}
+}
+
+func TestGroupedFlagUsages(t *testing.T) {
+	g := NewGroups()
+	var s string
+	g.Group("auth").StringVar(&s, "auth-addr", "", "")
+	g.Group("authz").StringVar(&s, "authz-addr", "", "")
+
+	fs := pflag.NewFlagSet("cmd", pflag.ContinueOnError)
+	fs.StringVar(&s, "config", "", "")
+	if errs := g.ApplyTo(fs); errs != nil {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	out := GroupedFlagUsages(fs)
+	authSection := strings.Index(out, "auth flags:")
+	authzSection := strings.Index(out, "authz flags:")
+	if authSection < 0 || authzSection < 0 {
+		t.Fatalf("expected section headers per group, got:\n%s", out)
+	}
+	if !strings.Contains(out, "--config") || strings.Index(out, "--config") > authSection {
+		t.Errorf("expected ungrouped flags before grouped sections, got:\n%s", out)
+	}
+}
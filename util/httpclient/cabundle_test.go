@@ -0,0 +1,115 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opencensus.io/plugin/ochttp"
+)
+
+// writeServerCertBundle writes the certificate presented by the test server
+// to a PEM file, so clients trusting that bundle can verify the server.
+func writeServerCertBundle(t *testing.T, dir string, ts *httptest.Server) string {
+	t.Helper()
+	bundleFile := filepath.Join(dir, "bundle.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: ts.TLS.Certificates[0].Certificate[0]}
+	if err := ioutil.WriteFile(bundleFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("failed to write bundle file: %s", err)
+	}
+	return bundleFile
+}
+
+func TestCABundleNewClient(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "guard-ca-bundle")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	bundleFile := writeServerCertBundle(t, dir, ts)
+
+	client, err := NewClient(ProxyConfig{}, CABundleConfig{BundleFile: bundleFile})
+	if err != nil {
+		t.Fatalf("NewClient should not return error: %s", err)
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("request with trusted bundle should not return error: %s", err)
+	}
+	resp.Body.Close()
+
+	client, err = NewClient(ProxyConfig{}, CABundleConfig{})
+	if err != nil {
+		t.Fatalf("NewClient should not return error: %s", err)
+	}
+	if tr, ok := client.Transport.(*ochttp.Transport); !ok {
+		t.Error("expected a traced transport when no proxy or CA bundle is configured")
+	} else if ua, ok := tr.Base.(userAgentTransport); !ok || ua.base != nil {
+		t.Error("expected the default transport stamped with the user agent")
+	}
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Error("request without the bundle should fail certificate verification")
+	}
+}
+
+func TestCABundleGlobalDefault(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "guard-ca-bundle")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	SetDefaultCABundle(CABundleConfig{BundleFile: writeServerCertBundle(t, dir, ts)})
+	defer SetDefaultCABundle(CABundleConfig{})
+
+	// the zero config falls back to the global bundle
+	client, err := NewClient(ProxyConfig{}, CABundleConfig{})
+	if err != nil {
+		t.Fatalf("NewClient should not return error: %s", err)
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("request with global bundle should not return error: %s", err)
+	}
+	resp.Body.Close()
+}
+
+func TestCABundleValidate(t *testing.T) {
+	cfg := CABundleConfig{BundleFile: "/does/not/exist.pem"}
+	if errs := cfg.Validate("github."); len(errs) != 1 {
+		t.Errorf("expected 1 error for missing bundle file, actual: %v", errs)
+	}
+	if errs := (&CABundleConfig{}).Validate("github."); len(errs) != 0 {
+		t.Errorf("expected no error for empty config, actual: %v", errs)
+	}
+}
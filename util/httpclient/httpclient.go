@@ -0,0 +1,144 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// ClientCertConfig configures an optional client certificate presented on
+// outbound connections to a single upstream endpoint (private IdP, ARM or
+// Graph private endpoint). Each endpoint gets its own config via a flag
+// prefix, so different endpoints can use different certificates.
+type ClientCertConfig struct {
+	CertFile string
+	KeyFile  string
+	// proxy used for outbound connections to this endpoint, overriding the
+	// global proxy settings
+	Proxy ProxyConfig
+	// CA bundle trusted on outbound connections to this endpoint, overriding
+	// the global bundle
+	CABundle CABundleConfig
+}
+
+// AddFlagsWithPrefix registers the client certificate flags as
+// <prefix>client-cert-file and <prefix>client-key-file, the proxy flags as
+// <prefix>proxy-url and <prefix>no-proxy, and the CA bundle flag as
+// <prefix>ca-bundle-file.
+func (o *ClientCertConfig) AddFlagsWithPrefix(fs *pflag.FlagSet, prefix string) {
+	fs.StringVar(&o.CertFile, prefix+"client-cert-file", o.CertFile, "certificate file presented as client certificate on outbound connections to this endpoint")
+	fs.StringVar(&o.KeyFile, prefix+"client-key-file", o.KeyFile, "private key file for the outbound client certificate")
+	o.Proxy.AddFlagsWithPrefix(fs, prefix)
+	o.CABundle.AddFlagsWithPrefix(fs, prefix)
+}
+
+// Enabled reports whether a client certificate is configured.
+func (o ClientCertConfig) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != ""
+}
+
+func (o *ClientCertConfig) Validate(prefix string) []error {
+	var errs []error
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		errs = append(errs, errors.Errorf("%sclient-cert-file and %sclient-key-file must be used together", prefix, prefix))
+	}
+	errs = append(errs, o.Proxy.Validate(prefix)...)
+	errs = append(errs, o.CABundle.Validate(prefix)...)
+	return errs
+}
+
+// Certificate loads the configured certificate/key pair.
+func (o ClientCertConfig) Certificate() (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return cert, errors.Wrap(err, "unable to load outbound client certificate")
+	}
+	return cert, nil
+}
+
+// NewClientWithCA returns an http client that trusts the CA certificates in
+// caCertFile, for endpoints signed by a private CA (e.g. self-hosted SCMs).
+// When caCertFile is empty it returns http.DefaultClient, so callers can use
+// it unconditionally.
+func NewClientWithCA(caCertFile string) (*http.Client, error) {
+	if caCertFile == "" {
+		return NewClient(ProxyConfig{}, CABundleConfig{})
+	}
+	pem, err := ioutil.ReadFile(caCertFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read ca certificate file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificates found in %s", caCertFile)
+	}
+	return &http.Client{
+		Transport: WithUserAgent(newTransport(ProxyConfig{}, pool)),
+	}, nil
+}
+
+// newTransport builds the outbound transport shared by all provider clients.
+// A nil pool falls back to the system roots.
+func newTransport(proxy ProxyConfig, pool *x509.CertPool) *http.Transport {
+	t := &http.Transport{
+		Proxy: proxy.ProxyFunc(),
+		TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    pool,
+		},
+	}
+	// http.Transport cannot run the multi-leg NTLM handshake on its CONNECT
+	// requests, so the tunnel is established by our own dialer instead
+	if proxy.orDefault().AuthScheme == ProxyAuthNTLM {
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return proxy.Dial(network, addr, 30*time.Second)
+		}
+	}
+	return t
+}
+
+// NewHTTPClient returns an http client that presents the configured client
+// certificate. When no certificate is configured it returns
+// http.DefaultClient, so callers can use it unconditionally.
+func (o ClientCertConfig) NewHTTPClient() (*http.Client, error) {
+	if !o.Enabled() && !o.Proxy.Applies() && !o.CABundle.Applies() {
+		return &http.Client{Transport: WithUserAgent(nil)}, nil
+	}
+	pool, err := o.CABundle.Pool()
+	if err != nil {
+		return nil, err
+	}
+	transport := newTransport(o.Proxy, pool)
+	if o.Enabled() {
+		cert, err := o.Certificate()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: WithUserAgent(transport)}, nil
+}
@@ -0,0 +1,233 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/md4"
+)
+
+// NTLMSSP message framing per MS-NLMP. Only the NTLMv2 response is ever
+// sent; the weaker LM and NTLMv1 responses are not implemented.
+const (
+	ntlmSignature = "NTLMSSP\x00"
+
+	ntlmTypeNegotiate    = 1
+	ntlmTypeChallenge    = 2
+	ntlmTypeAuthenticate = 3
+
+	ntlmNegotiateUnicode          = 0x00000001
+	ntlmRequestTarget             = 0x00000004
+	ntlmNegotiateNTLM             = 0x00000200
+	ntlmNegotiateAlwaysSign       = 0x00008000
+	ntlmNegotiateExtendedSecurity = 0x00080000
+)
+
+// connectNTLM establishes a CONNECT tunnel to addr, performing the three-leg
+// NTLM handshake with the proxy over the single connection.
+func connectNTLM(conn net.Conn, addr string, user *url.Userinfo) error {
+	if user == nil {
+		return errors.New("ntlm proxy authentication requires credentials in the proxy url")
+	}
+	password, _ := user.Password()
+	domain, username := splitNTLMUser(user.Username())
+
+	header := make(http.Header)
+	header.Set("Proxy-Connection", "Keep-Alive")
+	header.Set("Proxy-Authorization", "NTLM "+base64.StdEncoding.EncodeToString(ntlmNegotiate()))
+	resp, err := writeConnect(conn, addr, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		resp.Body.Close()
+		return nil
+	}
+
+	challenge, err := ntlmChallengeFromResponse(resp)
+	if err != nil {
+		return err
+	}
+	authenticate, err := ntlmAuthenticate(username, domain, password, challenge)
+	if err != nil {
+		return err
+	}
+
+	header = make(http.Header)
+	header.Set("Proxy-Connection", "Keep-Alive")
+	header.Set("Proxy-Authorization", "NTLM "+base64.StdEncoding.EncodeToString(authenticate))
+	resp, err = writeConnect(conn, addr, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("proxy refused NTLM CONNECT to %s with status code: %d", addr, resp.StatusCode)
+	}
+	return nil
+}
+
+// ntlmChallengeFromResponse extracts the NTLM challenge the proxy sent with
+// its 407 answer, draining the body so the connection can carry the next leg.
+func ntlmChallengeFromResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		return nil, errors.Errorf("proxy answered NTLM negotiate with status code: %d", resp.StatusCode)
+	}
+	for _, value := range resp.Header["Proxy-Authenticate"] {
+		if strings.HasPrefix(value, "NTLM ") {
+			challenge, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "NTLM "))
+			return challenge, errors.Wrap(err, "proxy sent a malformed NTLM challenge")
+		}
+	}
+	return nil, errors.New("proxy did not offer an NTLM challenge")
+}
+
+// splitNTLMUser splits credentials given as DOMAIN\user or user@domain.
+func splitNTLMUser(user string) (domain, username string) {
+	if i := strings.Index(user, `\`); i >= 0 {
+		return user[:i], user[i+1:]
+	}
+	if i := strings.Index(user, "@"); i >= 0 {
+		return user[i+1:], user[:i]
+	}
+	return "", user
+}
+
+// utf16le encodes s as UTF-16 little endian, the encoding NTLM uses for all
+// strings.
+func utf16le(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(encoded))
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return b
+}
+
+func hmacMD5(key, data []byte) []byte {
+	h := hmac.New(md5.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// ntlmNegotiate builds the type 1 negotiate message. The domain and
+// workstation security buffers stay empty.
+func ntlmNegotiate() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], ntlmTypeNegotiate)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateUnicode|ntlmRequestTarget|ntlmNegotiateNTLM|ntlmNegotiateAlwaysSign|ntlmNegotiateExtendedSecurity)
+	return msg
+}
+
+// ntlmParseChallenge extracts the server challenge and target info from a
+// type 2 challenge message.
+func ntlmParseChallenge(msg []byte) (serverChallenge, targetInfo []byte, err error) {
+	if len(msg) < 48 || string(msg[:8]) != ntlmSignature || binary.LittleEndian.Uint32(msg[8:]) != ntlmTypeChallenge {
+		return nil, nil, errors.New("proxy sent a malformed NTLM challenge")
+	}
+	serverChallenge = msg[24:32]
+	infoLen := binary.LittleEndian.Uint16(msg[40:])
+	infoOffset := binary.LittleEndian.Uint32(msg[44:])
+	if infoLen > 0 {
+		if int(infoOffset)+int(infoLen) > len(msg) {
+			return nil, nil, errors.New("proxy sent a malformed NTLM challenge")
+		}
+		targetInfo = msg[infoOffset : infoOffset+uint32(infoLen)]
+	}
+	return serverChallenge, targetInfo, nil
+}
+
+// ntlmV2Response computes the NTLMv2 response: the HMAC proof over the server
+// challenge and a blob carrying the timestamp, client challenge and the
+// server's target info.
+func ntlmV2Response(username, domain, password string, serverChallenge, clientChallenge, targetInfo []byte, now time.Time) []byte {
+	ntHash := md4.New()
+	ntHash.Write(utf16le(password))
+	v2Hash := hmacMD5(ntHash.Sum(nil), utf16le(strings.ToUpper(username)+domain))
+
+	// 100ns intervals since January 1, 1601
+	timestamp := make([]byte, 8)
+	binary.LittleEndian.PutUint64(timestamp, uint64(now.UnixNano()/100+116444736000000000))
+
+	var blob bytes.Buffer
+	blob.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	blob.Write(timestamp)
+	blob.Write(clientChallenge)
+	blob.Write([]byte{0, 0, 0, 0})
+	blob.Write(targetInfo)
+	blob.Write([]byte{0, 0, 0, 0})
+
+	proof := hmacMD5(v2Hash, append(append([]byte{}, serverChallenge...), blob.Bytes()...))
+	return append(proof, blob.Bytes()...)
+}
+
+// ntlmAuthenticate builds the type 3 authenticate message answering the given
+// challenge with an NTLMv2 response.
+func ntlmAuthenticate(username, domain, password string, challenge []byte) ([]byte, error) {
+	serverChallenge, targetInfo, err := ntlmParseChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, errors.Wrap(err, "unable to generate NTLM client challenge")
+	}
+	ntResponse := ntlmV2Response(username, domain, password, serverChallenge, clientChallenge, targetInfo, time.Now())
+
+	const headerLen = 64
+	domainBytes := utf16le(domain)
+	userBytes := utf16le(username)
+	msg := make([]byte, headerLen, headerLen+len(domainBytes)+len(userBytes)+len(ntResponse))
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], ntlmTypeAuthenticate)
+
+	offset := headerLen
+	writeBuffer := func(fieldOffset int, data []byte) {
+		binary.LittleEndian.PutUint16(msg[fieldOffset:], uint16(len(data)))
+		binary.LittleEndian.PutUint16(msg[fieldOffset+2:], uint16(len(data)))
+		binary.LittleEndian.PutUint32(msg[fieldOffset+4:], uint32(offset))
+		msg = append(msg, data...)
+		offset += len(data)
+	}
+	writeBuffer(28, domainBytes)
+	writeBuffer(36, userBytes)
+	writeBuffer(44, nil) // workstation
+	writeBuffer(12, nil) // LM response, not sent with NTLMv2
+	writeBuffer(20, ntResponse)
+	writeBuffer(52, nil) // session key
+	binary.LittleEndian.PutUint32(msg[60:], ntlmNegotiateUnicode|ntlmNegotiateNTLM|ntlmNegotiateAlwaysSign|ntlmNegotiateExtendedSecurity)
+	return msg, nil
+}
@@ -0,0 +1,251 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// authentication schemes for the outbound proxy
+const (
+	ProxyAuthBasic = "basic"
+	ProxyAuthNTLM  = "ntlm"
+)
+
+// ProxyConfig configures an explicit outbound proxy for provider calls,
+// instead of relying on ambient proxy environment variables in every code
+// path. A per-provider config overrides the global one installed with
+// SetDefaultProxy; without either, the process environment applies as before.
+type ProxyConfig struct {
+	ProxyURL string
+	NoProxy  string
+	// AuthScheme selects how the credentials in the proxy URL are presented
+	// to the proxy: basic or ntlm
+	AuthScheme string
+}
+
+// defaultProxy is the global proxy configuration applied to every outbound
+// provider call that has no per-provider override.
+var defaultProxy ProxyConfig
+
+// SetDefaultProxy installs the global proxy configuration.
+func SetDefaultProxy(cfg ProxyConfig) {
+	defaultProxy = cfg
+}
+
+// AddFlags registers the global outbound proxy flags.
+func (o *ProxyConfig) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ProxyURL, "outbound-proxy", o.ProxyURL, "URL of the HTTP(S) proxy used for all outbound provider connections, with credentials in the URL userinfo. Keep empty to use the proxy environment variables")
+	fs.StringVar(&o.NoProxy, "outbound-no-proxy", o.NoProxy, "comma separated list of hosts, domain suffixes or CIDRs that bypass the outbound proxy")
+	fs.StringVar(&o.AuthScheme, "outbound-proxy-auth", o.AuthScheme, "authentication scheme for the outbound proxy, valid value is either basic or ntlm. Credentials are taken from the proxy URL, for ntlm as DOMAIN\\user or user@domain")
+}
+
+// AddFlagsWithPrefix registers the proxy flags as <prefix>proxy-url,
+// <prefix>no-proxy and <prefix>proxy-auth.
+func (o *ProxyConfig) AddFlagsWithPrefix(fs *pflag.FlagSet, prefix string) {
+	fs.StringVar(&o.ProxyURL, prefix+"proxy-url", o.ProxyURL, "URL of the HTTP(S) proxy used for outbound connections to this endpoint. Keep empty to use the global proxy settings")
+	fs.StringVar(&o.NoProxy, prefix+"no-proxy", o.NoProxy, "comma separated list of hosts, domain suffixes or CIDRs that bypass the proxy")
+	fs.StringVar(&o.AuthScheme, prefix+"proxy-auth", o.AuthScheme, "authentication scheme for this proxy, valid value is either basic or ntlm. Credentials are taken from the proxy URL, for ntlm as DOMAIN\\user or user@domain")
+}
+
+// Enabled reports whether this config sets an explicit proxy.
+func (o ProxyConfig) Enabled() bool {
+	return o.ProxyURL != ""
+}
+
+// Applies reports whether any proxy — per-endpoint or global — applies.
+func (o ProxyConfig) Applies() bool {
+	return o.orDefault().Enabled()
+}
+
+// orDefault returns the per-endpoint config when set, else the global one.
+func (o ProxyConfig) orDefault() ProxyConfig {
+	if o.Enabled() {
+		return o
+	}
+	return defaultProxy
+}
+
+func (o *ProxyConfig) Validate(prefix string) []error {
+	var errs []error
+	proxyURL, err := url.Parse(o.ProxyURL)
+	if o.ProxyURL != "" && err != nil {
+		errs = append(errs, errors.Wrapf(err, "invalid %sproxy-url", prefix))
+	}
+	if o.NoProxy != "" && o.ProxyURL == "" {
+		errs = append(errs, errors.Errorf("%sno-proxy must be used with %sproxy-url", prefix, prefix))
+	}
+	switch o.AuthScheme {
+	case "":
+	case ProxyAuthBasic:
+	case ProxyAuthNTLM:
+		if err == nil && o.ProxyURL != "" {
+			hasPassword := false
+			if proxyURL.User != nil {
+				_, hasPassword = proxyURL.User.Password()
+			}
+			if !hasPassword {
+				errs = append(errs, errors.Errorf("%sproxy-url must carry a username and password for ntlm proxy authentication", prefix))
+			}
+		}
+	default:
+		errs = append(errs, errors.Errorf("invalid %sproxy-auth. valid value is either basic or ntlm", prefix))
+	}
+	return errs
+}
+
+// bypass reports whether host matches the no-proxy list: "*", exact hosts,
+// domain suffixes (with or without a leading dot) or CIDRs.
+func (o ProxyConfig) bypass(host string) bool {
+	for _, entry := range strings.Split(o.NoProxy, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip := net.ParseIP(host); ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.ToLower(strings.TrimPrefix(entry, "."))
+		if strings.EqualFold(host, entry) || strings.HasSuffix(strings.ToLower(host), "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyFunc returns the proxy selection function for an http.Transport.
+func (o ProxyConfig) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	cfg := o.orDefault()
+	if !cfg.Enabled() {
+		return http.ProxyFromEnvironment
+	}
+	proxyURL, err := url.Parse(cfg.ProxyURL)
+	return func(req *http.Request) (*url.URL, error) {
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid proxy url")
+		}
+		if cfg.bypass(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+}
+
+// NewClient returns an http client routed through the configured proxy. When
+// no proxy applies it returns http.DefaultClient, so callers can use it
+// unconditionally.
+func (o ProxyConfig) NewClient() *http.Client {
+	if !o.Applies() {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Transport: newTransport(o, nil),
+	}
+}
+
+// Dial opens a TCP connection to addr, tunneling through the configured
+// proxy with an HTTP CONNECT request when one applies. Non-HTTP protocols
+// such as LDAP use this instead of Transport.Proxy.
+func (o ProxyConfig) Dial(network, addr string, timeout time.Duration) (net.Conn, error) {
+	cfg := o.orDefault()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if !cfg.Enabled() || cfg.bypass(host) {
+		return net.DialTimeout(network, addr, timeout)
+	}
+
+	proxyURL, err := url.Parse(cfg.ProxyURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid proxy url")
+	}
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "3128")
+	}
+
+	conn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to proxy %s", proxyAddr)
+	}
+
+	// bound the whole CONNECT handshake, which for NTLM spans several legs
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if err := cfg.connect(conn, addr, proxyURL); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connect establishes a CONNECT tunnel to addr over conn, authenticating to
+// the proxy with the configured scheme.
+func (o ProxyConfig) connect(conn net.Conn, addr string, proxyURL *url.URL) error {
+	if o.AuthScheme == ProxyAuthNTLM {
+		return connectNTLM(conn, addr, proxyURL.User)
+	}
+
+	header := make(http.Header)
+	if proxyURL.User != nil {
+		header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(proxyURL.User.String())))
+	}
+	resp, err := writeConnect(conn, addr, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("proxy refused CONNECT to %s with status code: %d", addr, resp.StatusCode)
+	}
+	return nil
+}
+
+// writeConnect sends a CONNECT request for addr over conn and reads the
+// proxy's response.
+func writeConnect(conn net.Conn, addr string, header http.Header) (*http.Response, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: header,
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, errors.Wrap(err, "failed to write CONNECT request to proxy")
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read CONNECT response from proxy")
+	}
+	return resp, nil
+}
@@ -0,0 +1,183 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProxyBypass(t *testing.T) {
+	tests := []struct {
+		noProxy string
+		host    string
+		bypass  bool
+	}{
+		{"", "example.com", false},
+		{"*", "example.com", true},
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.COM", true},
+		{"example.com", "sub.example.com", true},
+		{"example.com", "notexample.com", false},
+		{".example.com", "sub.example.com", true},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "192.168.1.1", false},
+		{"foo.com, bar.com", "bar.com", true},
+	}
+	for _, tc := range tests {
+		cfg := ProxyConfig{ProxyURL: "http://proxy:3128", NoProxy: tc.noProxy}
+		if got := cfg.bypass(tc.host); got != tc.bypass {
+			t.Errorf("bypass(%q) with no-proxy %q = %v, expected %v", tc.host, tc.noProxy, got, tc.bypass)
+		}
+	}
+}
+
+func TestProxyFunc(t *testing.T) {
+	cfg := ProxyConfig{ProxyURL: "http://proxy.corp:3128", NoProxy: "internal.corp"}
+	proxyFunc := cfg.ProxyFunc()
+
+	req, _ := http.NewRequest(http.MethodGet, "https://graph.microsoft.com/v1.0/me", nil)
+	u, err := proxyFunc(req)
+	if err != nil {
+		t.Fatalf("proxy func should not return error: %s", err)
+	}
+	if u == nil || u.Host != "proxy.corp:3128" {
+		t.Errorf("expected proxy proxy.corp:3128, actual: %v", u)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "https://internal.corp/api", nil)
+	u, err = proxyFunc(req)
+	if err != nil {
+		t.Fatalf("proxy func should not return error: %s", err)
+	}
+	if u != nil {
+		t.Errorf("expected no-proxy host to bypass the proxy, actual: %v", u)
+	}
+}
+
+func TestProxyValidate(t *testing.T) {
+	cfg := ProxyConfig{NoProxy: "internal.corp"}
+	if errs := cfg.Validate("azure.graph-"); len(errs) != 1 {
+		t.Errorf("expected 1 error for no-proxy without proxy-url, actual: %v", errs)
+	}
+	cfg = ProxyConfig{ProxyURL: "http://proxy:3128", NoProxy: "internal.corp"}
+	if errs := cfg.Validate("azure.graph-"); len(errs) != 0 {
+		t.Errorf("expected no error, actual: %v", errs)
+	}
+}
+
+func TestProxyNewClient(t *testing.T) {
+	proxied := false
+	// a plain HTTP proxy receives the absolute request URI
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Host == "upstream.test" {
+			proxied = true
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	client := ProxyConfig{ProxyURL: proxy.URL}.NewClient()
+	resp, err := client.Get("http://upstream.test/")
+	if err != nil {
+		t.Fatalf("request through proxy should not return error: %s", err)
+	}
+	resp.Body.Close()
+	if !proxied {
+		t.Error("request should have been routed through the proxy")
+	}
+
+	if client := (ProxyConfig{}).NewClient(); client != http.DefaultClient {
+		t.Error("expected http.DefaultClient when no proxy is configured")
+	}
+}
+
+func TestProxyDialConnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer ln.Close()
+
+	connectDone := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect {
+			return
+		}
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		connectDone <- req.Host
+	}()
+
+	cfg := ProxyConfig{ProxyURL: "http://" + ln.Addr().String()}
+	conn, err := cfg.Dial("tcp", "ldap.corp:636", 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial through proxy should not return error: %s", err)
+	}
+	conn.Close()
+
+	select {
+	case host := <-connectDone:
+		if host != "ldap.corp:636" {
+			t.Errorf("expected CONNECT to ldap.corp:636, actual: %s", host)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("proxy never received a CONNECT request")
+	}
+}
+
+func TestProxyDialBypass(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer ln.Close()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		accepted <- struct{}{}
+	}()
+
+	// the target itself is on the no-proxy list, so Dial must go direct
+	cfg := ProxyConfig{ProxyURL: "http://127.0.0.2:1", NoProxy: "127.0.0.1"}
+	conn, err := cfg.Dial("tcp", ln.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("direct dial should not return error: %s", err)
+	}
+	conn.Close()
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Error("target never received a direct connection")
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resetUserAgent() {
+	UserAgentConfig{}.Apply()
+	SetUserAgentSuffix("")
+}
+
+func TestUserAgent(t *testing.T) {
+	defer resetUserAgent()
+
+	resetUserAgent()
+	assert.True(t, strings.HasPrefix(UserAgent(), "guard/"))
+
+	SetUserAgentSuffix("config-abc")
+	assert.Contains(t, UserAgent(), "(config-abc)")
+
+	UserAgentConfig{UserAgent: "custom/1.0"}.Apply()
+	assert.Equal(t, "custom/1.0", UserAgent())
+
+	UserAgentConfig{Disable: true}.Apply()
+	assert.Equal(t, "", UserAgent())
+}
+
+func TestUserAgentTransport(t *testing.T) {
+	defer resetUserAgent()
+	resetUserAgent()
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: WithUserAgent(nil)}
+	_, err := client.Get(srv.URL)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(got, "guard/"))
+
+	// a caller-provided User-Agent wins
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("User-Agent", "caller/1.0")
+	_, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "caller/1.0", got)
+
+	// opting out sends no header at all
+	UserAgentConfig{Disable: true}.Apply()
+	req2, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req2.Header["User-Agent"] = nil
+	_, err = client.Do(req2)
+	assert.NoError(t, err)
+	assert.Equal(t, "", got)
+}
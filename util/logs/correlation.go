@@ -0,0 +1,50 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+type requestIDKey struct{}
+
+// NewRequestID returns a fresh correlation id for one webhook request.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// the id only correlates log lines; an unreadable random source must
+		// not fail the request
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context carrying the correlation id, so provider
+// calls can tag their log entries with the request they serve.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the correlation id carried by ctx, or the empty string.
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logs provides structured logging on top of glog. In text format
+// entries are rendered as a message followed by key=value pairs and pass
+// through glog, keeping the operator experience unchanged; in json format
+// each entry is written as one JSON object per line, so logs can be ingested
+// without parsing the glog header.
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type Options struct {
+	// Format is either text or json
+	Format string
+}
+
+func NewOptions() Options {
+	return Options{Format: FormatText}
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Format, "log-format", o.Format, "log entry format, valid value is either text or json. With json each entry is one JSON object per line. Levels stay controlled by -v")
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	switch o.Format {
+	case FormatText, FormatJSON:
+	default:
+		errs = append(errs, errors.New("invalid log-format. valid value is either text or json"))
+	}
+	return errs
+}
+
+// Fields carries the structured context of a log entry.
+type Fields map[string]interface{}
+
+var (
+	mu     sync.Mutex
+	format           = FormatText
+	out    io.Writer = os.Stderr
+)
+
+// Init installs the configured format. It is meant to be called once at
+// startup, before any structured entry is written.
+func Init(o Options) {
+	mu.Lock()
+	defer mu.Unlock()
+	format = o.Format
+}
+
+// SetOutput redirects json entries, for tests.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+func Info(msg string, fields Fields) {
+	write("info", msg, fields, func(s string) { glog.InfoDepth(2, s) })
+}
+
+func Warning(msg string, fields Fields) {
+	write("warning", msg, fields, func(s string) { glog.WarningDepth(2, s) })
+}
+
+func Error(msg string, fields Fields) {
+	write("error", msg, fields, func(s string) { glog.ErrorDepth(2, s) })
+}
+
+// V gates an info entry on the glog verbosity level, mirroring glog.V.
+func V(level glog.Level, msg string, fields Fields) {
+	if !bool(glog.V(level)) {
+		return
+	}
+	write("info", msg, fields, func(s string) { glog.InfoDepth(2, s) })
+}
+
+func write(level, msg string, fields Fields, text func(string)) {
+	mu.Lock()
+	f, w := format, out
+	mu.Unlock()
+
+	if f == FormatJSON {
+		entry := make(map[string]interface{}, len(fields)+3)
+		for k, v := range fields {
+			entry[k] = v
+		}
+		entry["ts"] = time.Now().UTC().Format(time.RFC3339Nano)
+		entry["level"] = level
+		entry["msg"] = msg
+		mu.Lock()
+		defer mu.Unlock()
+		if err := json.NewEncoder(w).Encode(entry); err != nil {
+			glog.Errorf("failed to write log entry: %s", err)
+		}
+		return
+	}
+	text(msg + renderFields(fields))
+}
+
+// renderFields renders fields as sorted key=value pairs, so text entries are
+// deterministic and grep-able.
+func renderFields(fields Fields) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := ""
+	for _, k := range keys {
+		s += fmt.Sprintf(" %s=%v", k, fields[k])
+	}
+	return s
+}
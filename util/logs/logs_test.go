@@ -0,0 +1,73 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	SetOutput(buf)
+	Init(Options{Format: FormatJSON})
+	t.Cleanup(func() {
+		Init(NewOptions())
+		SetOutput(os.Stderr)
+	})
+
+	Info("received token review request", Fields{"request_id": "abc123", "provider": "github"})
+
+	entry := map[string]interface{}{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "info", entry["level"])
+		assert.Equal(t, "received token review request", entry["msg"])
+		assert.Equal(t, "abc123", entry["request_id"])
+		assert.Equal(t, "github", entry["provider"])
+		assert.NotEmpty(t, entry["ts"])
+	}
+}
+
+func TestRenderFieldsDeterministic(t *testing.T) {
+	fields := Fields{"b": 2, "a": "x", "c": true}
+	assert.Equal(t, " a=x b=2 c=true", renderFields(fields))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	o := Options{Format: "xml"}
+	assert.Len(t, o.Validate(), 1)
+	o = NewOptions()
+	assert.Empty(t, o.Validate())
+	o = Options{Format: FormatJSON}
+	assert.Empty(t, o.Validate())
+}
+
+func TestRequestIDRoundTrip(t *testing.T) {
+	id := NewRequestID()
+	assert.Len(t, id, 16)
+	assert.NotEqual(t, id, NewRequestID())
+
+	ctx := WithRequestID(context.Background(), id)
+	assert.Equal(t, id, RequestID(ctx))
+	assert.Equal(t, "", RequestID(context.Background()))
+}
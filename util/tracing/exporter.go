@@ -0,0 +1,195 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+const (
+	// tracesPath is the OTLP/HTTP endpoint path for trace data.
+	tracesPath = "/v1/traces"
+	// batchSize triggers an immediate flush; flushInterval bounds how long a
+	// span waits in the buffer otherwise.
+	batchSize     = 256
+	flushInterval = 5 * time.Second
+	exportTimeout = 10 * time.Second
+)
+
+// exporter buffers finished spans and ships them to the collector in the
+// OTLP/HTTP JSON format. A failed export drops the batch after logging; spans
+// are diagnostics and must never block or fail a review.
+type exporter struct {
+	url    string
+	client *http.Client
+
+	lock  sync.Mutex
+	spans []*trace.SpanData
+}
+
+func newExporter(endpoint string) (*exporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid trace-otlp-endpoint")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("trace-otlp-endpoint must use http or https")
+	}
+	e := &exporter{
+		url:    endpoint + tracesPath,
+		client: &http.Client{Timeout: exportTimeout},
+	}
+	go e.run()
+	return e, nil
+}
+
+// ExportSpan implements trace.Exporter.
+func (e *exporter) ExportSpan(s *trace.SpanData) {
+	e.lock.Lock()
+	e.spans = append(e.spans, s)
+	full := len(e.spans) >= batchSize
+	e.lock.Unlock()
+	if full {
+		e.flush()
+	}
+}
+
+func (e *exporter) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		e.flush()
+	}
+}
+
+func (e *exporter) flush() {
+	e.lock.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.lock.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		glog.Errorf("failed to encode trace batch: %s", err)
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		glog.Errorf("failed to export %d spans: %s", len(spans), err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		glog.Errorf("trace collector returned status %d for %d spans", resp.StatusCode, len(spans))
+	}
+}
+
+// otlpRequest converts a batch of spans to the OTLP/JSON wire format.
+func otlpRequest(spans []*trace.SpanData) map[string]interface{} {
+	otlpSpans := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		otlpSpans = append(otlpSpans, otlpSpan(s))
+	}
+	return map[string]interface{}{
+		"resourceSpans": []map[string]interface{}{{
+			"resource": map[string]interface{}{
+				"attributes": []map[string]interface{}{
+					attribute("service.name", "guard"),
+				},
+			},
+			"scopeSpans": []map[string]interface{}{{
+				"scope": map[string]interface{}{"name": "guard"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+}
+
+func otlpSpan(s *trace.SpanData) map[string]interface{} {
+	span := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.TraceID[:]),
+		"spanId":            hex.EncodeToString(s.SpanID[:]),
+		"name":              s.Name,
+		"kind":              otlpKind(s.SpanKind),
+		"startTimeUnixNano": fmt.Sprintf("%d", s.StartTime.UnixNano()),
+		"endTimeUnixNano":   fmt.Sprintf("%d", s.EndTime.UnixNano()),
+		"status":            map[string]interface{}{"code": otlpStatusCode(s.Status.Code)},
+	}
+	if s.ParentSpanID != (trace.SpanID{}) {
+		span["parentSpanId"] = hex.EncodeToString(s.ParentSpanID[:])
+	}
+	if len(s.Attributes) > 0 {
+		attrs := make([]map[string]interface{}, 0, len(s.Attributes))
+		for k, v := range s.Attributes {
+			attrs = append(attrs, attribute(k, v))
+		}
+		span["attributes"] = attrs
+	}
+	return span
+}
+
+// attribute renders one OTLP key-value pair; OpenCensus attribute values are
+// string, bool or int64.
+func attribute(key string, value interface{}) map[string]interface{} {
+	var typed map[string]interface{}
+	switch v := value.(type) {
+	case string:
+		typed = map[string]interface{}{"stringValue": v}
+	case bool:
+		typed = map[string]interface{}{"boolValue": v}
+	case int64:
+		typed = map[string]interface{}{"intValue": fmt.Sprintf("%d", v)}
+	default:
+		typed = map[string]interface{}{"stringValue": fmt.Sprintf("%v", v)}
+	}
+	return map[string]interface{}{"key": key, "value": typed}
+}
+
+// otlpKind maps the OpenCensus span kind to the OTLP enum.
+func otlpKind(kind int) int {
+	switch kind {
+	case trace.SpanKindServer:
+		return 2
+	case trace.SpanKindClient:
+		return 3
+	default:
+		return 1 // internal
+	}
+}
+
+// otlpStatusCode maps an OpenCensus status to the OTLP enum, where any
+// non-zero census code means an error.
+func otlpStatusCode(code int32) int {
+	if code == 0 {
+		return 0 // unset
+	}
+	return 2 // error
+}
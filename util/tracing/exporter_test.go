@@ -0,0 +1,118 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/trace"
+)
+
+func testSpan() *trace.SpanData {
+	return &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID: trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			SpanID:  trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		},
+		ParentSpanID: trace.SpanID{8, 7, 6, 5, 4, 3, 2, 1},
+		SpanKind:     trace.SpanKindServer,
+		Name:         "guard.tokenreview",
+		StartTime:    time.Unix(100, 0),
+		EndTime:      time.Unix(101, 0),
+		Attributes:   map[string]interface{}{"provider": "github"},
+	}
+}
+
+func TestExporterFlush(t *testing.T) {
+	var body []byte
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	e, err := newExporter(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ExportSpan(testSpan())
+	e.flush()
+
+	assert.Equal(t, tracesPath, path)
+
+	payload := struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []map[string]interface{} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, payload.ResourceSpans, 1) && assert.Len(t, payload.ResourceSpans[0].ScopeSpans, 1) {
+		spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+		if assert.Len(t, spans, 1) {
+			assert.Equal(t, "guard.tokenreview", spans[0]["name"])
+			assert.Equal(t, "0102030405060708090a0b0c0d0e0f10", spans[0]["traceId"])
+			assert.Equal(t, "0102030405060708", spans[0]["spanId"])
+			assert.Equal(t, "0807060504030201", spans[0]["parentSpanId"])
+			assert.Equal(t, float64(2), spans[0]["kind"])
+			assert.Equal(t, "100000000000", spans[0]["startTimeUnixNano"])
+		}
+	}
+}
+
+func TestExporterFlushEmpty(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	e, err := newExporter(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.flush()
+	assert.False(t, called)
+}
+
+func TestAttributeTypes(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{"stringValue": "x"}, attribute("k", "x")["value"])
+	assert.Equal(t, map[string]interface{}{"boolValue": true}, attribute("k", true)["value"])
+	assert.Equal(t, map[string]interface{}{"intValue": "7"}, attribute("k", int64(7))["value"])
+}
+
+func TestNewExporterValidation(t *testing.T) {
+	_, err := newExporter("otel-collector:4318")
+	assert.Error(t, err)
+}
+
+func TestOptionsValidate(t *testing.T) {
+	o := Options{SampleRate: 1.5}
+	assert.Len(t, o.Validate(), 1)
+	o = NewOptions()
+	assert.Empty(t, o.Validate())
+}
@@ -0,0 +1,67 @@
+/*
+Copyright The Guard Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing exports request spans to an OpenTelemetry collector, so
+// operators can see where token review latency goes. Spans are recorded with
+// the vendored OpenCensus library and shipped in the OTLP/HTTP JSON format,
+// which every OTel collector accepts; no OTel SDK is needed.
+package tracing
+
+import (
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"go.opencensus.io/trace"
+)
+
+type Options struct {
+	// Endpoint is the base URL of the OTLP/HTTP collector, e.g.
+	// http://otel-collector:4318; empty disables tracing
+	Endpoint string
+	// SampleRate is the fraction of requests traced, in [0, 1]
+	SampleRate float64
+}
+
+func NewOptions() Options {
+	return Options{SampleRate: 1}
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Endpoint, "trace-otlp-endpoint", o.Endpoint, "base URL of the OpenTelemetry collector OTLP/HTTP endpoint, e.g. http://otel-collector:4318. Empty disables tracing")
+	fs.Float64Var(&o.SampleRate, "trace-sample-rate", o.SampleRate, "fraction of requests traced, between 0 and 1")
+}
+
+func (o *Options) Validate() []error {
+	var errs []error
+	if o.SampleRate < 0 || o.SampleRate > 1 {
+		errs = append(errs, errors.New("trace-sample-rate must be between 0 and 1"))
+	}
+	return errs
+}
+
+// Init registers the OTLP exporter and the configured sampler. It is meant to
+// be called once at startup; with an empty endpoint it is a no-op.
+func Init(o Options) error {
+	if o.Endpoint == "" {
+		return nil
+	}
+	exporter, err := newExporter(o.Endpoint)
+	if err != nil {
+		return err
+	}
+	trace.RegisterExporter(exporter)
+	trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(o.SampleRate)})
+	return nil
+}